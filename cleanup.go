@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"agcp/pkg/core"
+)
+
+// interruptSignals end a running operation early
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// addKeepPartialFlag registers the flag keeping partial output of a failed or
+// interrupted run
+func addKeepPartialFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("keep-partial", false, "keep the partial output of a failed or interrupted run, e.g. to resume it")
+}
+
+// cleanupOnInterrupt removes the temporary files and partial outputs of the
+// running operation when the process is interrupted, then exits. Partial
+// outputs are kept if keepPartial is set. Call the returned function once the
+// operation has returned.
+func cleanupOnInterrupt(keepPartial bool) func() {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, interruptSignals...)
+	go func() {
+		select {
+		case s := <-sig:
+			removed := core.Cleanup(keepPartial)
+			fmt.Fprintf(os.Stderr, "\nInterrupted (%v); removed %d temporary file(s)\n", s, len(removed))
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
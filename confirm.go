@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// previewNames is how many top-level names the confirmation prompt lists
+const previewNames = 8
+
+// errNotConfirmed is returned when the user declines an extraction
+var errNotConfirmed = errors.New("extraction cancelled; nothing was written")
+
+// confirmExtraction asks before extracting into a non-empty directory, or over
+// an existing file, showing what the archive holds and how many existing files
+// it would overwrite. It asks only when stdin is a terminal, and not for
+// archives it cannot preview, such as encrypted ones.
+func confirmExtraction(input, decompressedName string, opts core.Options) error {
+	if !stdinIsTerminal() {
+		return nil
+	}
+	preview, err := core.PreviewExtraction(input, decompressedName, opts)
+	if err != nil || !preview.NonEmpty {
+		return nil // The extraction reports any error itself
+	}
+
+	f := progress.CurrentFormat()
+	fmt.Printf("%s is not empty. The archive holds %d entries, %s, under:\n", f.Name(preview.Dest), preview.Entries, f.Size(preview.TotalSize))
+	for i, name := range preview.TopLevel {
+		if i == previewNames {
+			fmt.Printf("  ... and %d more\n", len(preview.TopLevel)-previewNames)
+			break
+		}
+		fmt.Printf("  %s\n", f.Name(name))
+	}
+	if preview.Overwrites > 0 {
+		fmt.Printf("%d existing files would be overwritten.\n", preview.Overwrites)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	fmt.Print("Extract? [y/N] ")
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return errNotConfirmed
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errNotConfirmed
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// addDeadlineFlags registers the time limit flags on fs and returns a function
+// that, once flags are parsed, gives the deadline they set: the earlier of
+// --max-duration from now and the next --stop-at time, or the zero time if
+// neither is given
+func addDeadlineFlags(fs *flag.FlagSet) func() (time.Time, error) {
+	maxDuration := fs.Duration("max-duration", 0, "stop gracefully after this long, keeping the work done so far to continue later, e.g. 2h")
+	stopAt := fs.String("stop-at", "", "stop gracefully at this local time of day (HH:MM), keeping the work done so far to continue later, e.g. 06:00")
+	return func() (time.Time, error) {
+		now := time.Now()
+		var deadline time.Time
+		if *maxDuration < 0 {
+			return time.Time{}, fmt.Errorf("--max-duration %v must not be negative", *maxDuration)
+		}
+		if *maxDuration > 0 {
+			deadline = now.Add(*maxDuration)
+		}
+		if *stopAt != "" {
+			at, err := nextTimeOfDay(*stopAt, now)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if deadline.IsZero() || at.Before(deadline) {
+				deadline = at
+			}
+		}
+		return deadline, nil
+	}
+}
+
+// nextTimeOfDay returns the first time after now at the local time of day
+// clock, given as HH:MM
+func nextTimeOfDay(clock string, now time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --stop-at %q: want a time of day as HH:MM, e.g. 06:00", clock)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// explainDeadline adds to an error from an operation stopped at its deadline
+// how to continue the work; other errors are returned unchanged
+func explainDeadline(err error, howToContinue string) error {
+	if !errors.Is(err, core.ErrDeadline) {
+		return err
+	}
+	return fmt.Errorf("%w; %s", err, howToContinue)
+}
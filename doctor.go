@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// handleDoctor runs the self-check and prints its findings, failing if any
+// check failed
+func handleDoctor() error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the findings as JSON, for attaching to a bug report")
+	tempDir := addTempDirFlag(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		fmt.Println("Usage: ./agcp doctor [--json] [--tmpdir dir]")
+		os.Exit(1)
+	}
+	dir, err := tempDir()
+	if err != nil {
+		return err
+	}
+
+	// The checks compress and extract; only their findings are of interest
+	progress.SetQuiet(true)
+	report := core.Doctor(core.Options{TempDir: dir})
+	progress.SetQuiet(false)
+	report.Findings = append(report.Findings, terminalFindings()...)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report.Findings); err != nil {
+			return fmt.Errorf("write findings: %w", err)
+		}
+	} else {
+		width := 0
+		for _, f := range report.Findings {
+			width = max(width, len(f.Check))
+		}
+		counts := make(map[core.FindingStatus]int)
+		for _, f := range report.Findings {
+			counts[f.Status]++
+			fmt.Printf("%-4s  %-*s  %s\n", f.Status, width, f.Check, f.Detail)
+			if f.Advice != "" {
+				fmt.Printf("      %-*s  → %s\n", width, "", f.Advice)
+			}
+		}
+		fmt.Printf("%d checks: %d ok, %d warnings, %d failed\n", len(report.Findings), counts[core.FindingOK], counts[core.FindingWarn], counts[core.FindingFail])
+	}
+	if report.Worst() == core.FindingFail {
+		return fmt.Errorf("self-check failed")
+	}
+	return nil
+}
+
+// terminalFindings describes the terminal agcp is running in: whether it can
+// ask questions, and whether progress bars and names will display properly
+func terminalFindings() []core.Finding {
+	input := core.Finding{Check: "terminal-input", Status: core.FindingOK, Detail: "stdin is a terminal; agcp asks before overwriting"}
+	if !stdinIsTerminal() {
+		input.Detail = "stdin is not a terminal; agcp will not ask before extracting over files or resuming partial archives"
+		input.Advice = "in scripts, say what to do with --yes and --on-partial"
+	}
+
+	output := core.Finding{Check: "terminal-output", Status: core.FindingOK}
+	info, err := os.Stdout.Stat()
+	switch {
+	case err != nil || info.Mode()&os.ModeCharDevice == 0:
+		output.Detail = "stdout is not a terminal; progress is printed one line per update"
+	case runtime.GOOS != "windows" && !utf8Locale():
+		output.Status = core.FindingWarn
+		output.Detail = "the locale is not UTF-8; progress bars and non-ASCII file names may display garbled"
+		output.Advice = "set LANG to a UTF-8 locale, such as en_US.UTF-8"
+	default:
+		output.Detail = "stdout is a UTF-8 terminal"
+	}
+	return []core.Finding{input, output}
+}
+
+// utf8Locale reports whether the locale named by LC_ALL, LC_CTYPE or LANG,
+// in that order of precedence, uses UTF-8
+func utf8Locale() bool {
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := strings.ToLower(os.Getenv(key)); locale != "" {
+			return strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8")
+		}
+	}
+	return false
+}
@@ -1,5 +1,8 @@
-module agcp
-
-go 1.21
-
-require github.com/pierrec/lz4/v4 v4.1.22
\ No newline at end of file
+module agcp
+
+go 1.21
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.22
+)
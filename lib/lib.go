@@ -1,49 +1,135 @@
-// Package lib provides compression and decompression functions for the AGCP format.
-// This package re-exports the functionality from the core package for backward compatibility.
-package lib
-
-import (
-	"agcp/pkg/core"
-	"agcp/pkg/progress"
-)
-
-// Constants for archive format re-exported from core
-const (
-	Magic   = core.Magic   // Magic number to identify the archive
-	Version = core.Version // Archive format version
-)
-
-// ArchiveType re-exported from core
-type ArchiveType = core.ArchiveType
-
-// Re-export archive types
-const (
-	ArchiveFile = core.ArchiveFile
-	ArchiveDir  = core.ArchiveDir
-)
-
-// Entry re-exported from core
-type Entry = core.Entry
-
-// DecompressTask re-exported from core
-type DecompressTask = core.DecompressTask
-
-// InitProgress initializes the progress tracking system
-func InitProgress() {
-	progress.Init(0)
-}
-
-// StopProgress stops the progress tracking system
-func StopProgress() {
-	progress.Stop()
-}
-
-// Compress is a wrapper around core.Compress
-func Compress(input, output string) error {
-	return core.Compress(input, output)
-}
-
-// Decompress is a wrapper around core.Decompress
-func Decompress(input, decompressedName string) error {
-	return core.Decompress(input, decompressedName)
-}
+// Package lib provides compression and decompression functions for the AGCP format.
+// This package re-exports the functionality from the core package for backward compatibility.
+//
+// Its Compress and Decompress keep their path-only signatures. The options-based
+// API of core.Compress and core.Decompress is re-exported as CompressContext and
+// DecompressContext, and without a context as CompressWithOptions and
+// DecompressWithOptions, along with Options, codecs and progress callbacks, so
+// existing importers can adopt it without changing import paths. New code
+// should import agcp/pkg/core, which has the full API; lib will be deprecated
+// once importers have moved.
+//
+// Like the core package, all functions are safe to call concurrently from multiple goroutines.
+package lib
+
+import (
+	"context"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// Constants for archive format re-exported from core
+const (
+	Magic            = core.Magic            // Magic number to identify the archive
+	Version          = core.Version          // Archive format version
+	MinReaderVersion = core.MinReaderVersion // Oldest format version that reads new archives
+)
+
+// ArchiveType re-exported from core
+type ArchiveType = core.ArchiveType
+
+// Re-export archive types
+const (
+	ArchiveFile = core.ArchiveFile
+	ArchiveDir  = core.ArchiveDir
+)
+
+// Entry re-exported from core.
+//
+// Deprecated: Entry is only used inside core; no function takes or returns one.
+type Entry = core.Entry
+
+// DecompressTask re-exported from core.
+//
+// Deprecated: DecompressTask is only used inside core; no function takes or returns one.
+type DecompressTask = core.DecompressTask
+
+// EntryError re-exported from core
+type EntryError = core.EntryError
+
+// Options re-exported from core
+type Options = core.Options
+
+// Codec re-exported from core
+type Codec = core.Codec
+
+// Re-export codecs
+const (
+	CodecDefault = core.CodecDefault
+	CodecLZ4     = core.CodecLZ4
+	CodecGzip    = core.CodecGzip
+	CodecZstd    = core.CodecZstd
+)
+
+// CustomCodec re-exported from core
+type CustomCodec = core.CustomCodec
+
+// EntryResult re-exported from core, passed to Options.OnEntry
+type EntryResult = core.EntryResult
+
+// Warning re-exported from core, passed to Options.Warn
+type Warning = core.Warning
+
+// WarningLog re-exported from core
+type WarningLog = core.WarningLog
+
+// Event re-exported from progress, sent on Options.Events
+type Event = progress.Event
+
+// ProgressReporter re-exported from progress, set in Options.Progress
+type ProgressReporter = progress.Reporter
+
+// InitProgress initializes the package-level progress tracker.
+//
+// Deprecated: Compress and Decompress track their own progress; calling this is no longer needed.
+func InitProgress() {
+	progress.Init(0)
+}
+
+// StopProgress stops the package-level progress tracker.
+//
+// Deprecated: Compress and Decompress track their own progress; calling this is no longer needed.
+func StopProgress() {
+	progress.Stop()
+}
+
+// Compress is a wrapper around core.Compress with the default options
+func Compress(input, output string) error {
+	return core.Compress(context.Background(), input, output, Options{})
+}
+
+// Decompress is a wrapper around core.Decompress with the default options
+func Decompress(input, decompressedName string) error {
+	return core.Decompress(context.Background(), input, decompressedName, Options{})
+}
+
+// CompressWithOptions is a wrapper around core.Compress without a context
+func CompressWithOptions(input, output string, opts Options) error {
+	return core.Compress(context.Background(), input, output, opts)
+}
+
+// DecompressWithOptions is a wrapper around core.Decompress without a context
+func DecompressWithOptions(input, decompressedName string, opts Options) error {
+	return core.Decompress(context.Background(), input, decompressedName, opts)
+}
+
+// CompressContext is a wrapper around core.Compress
+func CompressContext(ctx context.Context, input, output string, opts Options) error {
+	return core.Compress(ctx, input, output, opts)
+}
+
+// DecompressContext is a wrapper around core.Decompress
+func DecompressContext(ctx context.Context, input, decompressedName string, opts Options) error {
+	return core.Decompress(ctx, input, decompressedName, opts)
+}
+
+// ParseCodec is a wrapper around core.ParseCodec
+func ParseCodec(name string) (Codec, error) {
+	return core.ParseCodec(name)
+}
+
+// RegisterCodec is a wrapper around core.RegisterCodec
+func RegisterCodec(c CustomCodec) (Codec, error) {
+	return core.RegisterCodec(c)
+}
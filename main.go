@@ -1,99 +1,1753 @@
-package main
-
-import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"runtime"
-
-	"agcp/pkg/core"
-	"agcp/pkg/progress"
-)
-
-func main() {
-	if len(os.Args) < 3 {
-		printUsage()
-		os.Exit(1)
-	}
-
-	fmt.Printf("Available CPU cores: %d\n", runtime.NumCPU())
-
-	operation := os.Args[1]
-	switch operation {
-	case "compress":
-		if err := handleCompress(); err != nil {
-			fmt.Println("Error:", err)
-			os.Exit(1)
-		}
-	case "decompress":
-		if err := handleDecompress(); err != nil {
-			fmt.Println("Error:", err)
-			os.Exit(1)
-		}
-	default:
-		fmt.Println("Invalid operation:", operation)
-		printUsage()
-		os.Exit(1)
-	}
-}
-
-// printUsage prints the command-line usage information
-func printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  ./agcp compress input [output.agcp]")
-	fmt.Println("  ./agcp decompress input.agcp [decompressed_name]")
-}
-
-// handleCompress handles the compression operation
-func handleCompress() error {
-	if len(os.Args) != 3 && len(os.Args) != 4 {
-		fmt.Println("Usage: ./agcp compress input [output.agcp]")
-		os.Exit(1)
-	}
-
-	input := os.Args[2]
-	output := determineOutputPath(input)
-
-	// Initialize progress tracking
-	progress.Init(0) // Size will be calculated in Compress
-	defer progress.Stop()
-
-	return core.Compress(input, output)
-}
-
-// determineOutputPath determines the output path for compression
-func determineOutputPath(input string) string {
-	// If output is provided as an argument, use it
-	if len(os.Args) == 4 {
-		return os.Args[3]
-	}
-
-	// Otherwise, use input name + .agcp extension
-	autoName := filepath.Base(input) + ".agcp"
-	if _, err := os.Stat(autoName); os.IsNotExist(err) {
-		return autoName
-	}
-
-	// Default fallback
-	return "output.agcp"
-}
-
-// handleDecompress handles the decompression operation
-func handleDecompress() error {
-	if len(os.Args) < 3 || len(os.Args) > 4 {
-		fmt.Println("Usage: ./agcp decompress input.agcp [decompressed_name]")
-		os.Exit(1)
-	}
-
-	input := os.Args[2]
-	decompressedName := ""
-	if len(os.Args) == 4 {
-		decompressedName = os.Args[3]
-	}
-
-	// Initialize progress tracking
-	progress.Init(0) // Size will be calculated in Decompress
-	defer progress.Stop()
-
-	return core.Decompress(input, decompressedName)
-}
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+	"agcp/pkg/sfx"
+)
+
+// quietCommands are the commands whose output is a listing or report, kept
+// clean of the CPU core banner for scripts that parse it
+var quietCommands = map[string]bool{
+	"manifest": true, "head": true, "list": true, "info": true, "doctor": true,
+	"grep": true, "dedupe-report": true,
+}
+
+func main() {
+	// A self-extracting archive is this binary carrying a payload; extract it
+	// instead of parsing commands. Probing reads only the footer.
+	if exe, err := os.Executable(); err == nil {
+		if _, _, ok, _ := sfx.Payload(exe); ok {
+			if err := runSelfExtract(exe); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if len(os.Args) < 3 && !(len(os.Args) == 2 && os.Args[1] == "doctor") { // The only command without arguments
+		printUsage()
+		os.Exit(1)
+	}
+
+	operation := os.Args[1]
+	if (operation == "compress" || operation == "decompress") && usesStdio(os.Args[2:]) {
+		os.Stdout = os.Stderr // Keep messages out of the data, which goes to stdout
+	}
+	if !quietCommands[operation] {
+		fmt.Printf("Available CPU cores: %d\n", runtime.NumCPU())
+	}
+
+	switch operation {
+	case "compress":
+		if err := handleCompress(); errors.Is(err, errUnchanged) {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "decompress":
+		if err := handleDecompress(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "update":
+		if err := handleUpdate(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "verify":
+		if err := handleVerify(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "health":
+		if err := handleHealth(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "prune":
+		if err := handlePrune(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "check":
+		if err := handleCheck(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "grep":
+		// Like grep: 1 when nothing matches, 2 on errors
+		if err := handleGrep(); errors.Is(err, errNoMatch) {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+	case "list":
+		if err := handleList(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "info":
+		if err := handleInfo(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "manifest":
+		if err := handleManifest(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	case "head":
+		if err := handleHead(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	case "dedupe-report":
+		if err := handleDedupeReport(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "sfx":
+		if err := handleSfx(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "doctor":
+		if err := handleDoctor(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("Invalid operation:", operation)
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// printUsage prints the command-line usage information
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  ./agcp compress input [output.agcp]")
+	fmt.Printf("  ./agcp compress input --split-by-top-level out-%%s.agcp\n")
+	fmt.Println("  ./agcp compress input --split-threshold 100MB out-small.agcp out-large.agcp")
+	fmt.Println("  ./agcp compress --each input1 input2...")
+	fmt.Println("  ./agcp compress input --tape device [--blocking-factor n] [--volume-size size]")
+	fmt.Println("  ./agcp compress - [output.agcp] < input   (input or output '-' is stdin/stdout)")
+	fmt.Println("  ./agcp decompress input.agcp [decompressed_name] [--update]")
+	fmt.Println("  ./agcp decompress --tape device [decompressed_name]")
+	fmt.Println("  ./agcp decompress - - < file.agcp > file   (archive from stdin, content to stdout)")
+	fmt.Println("  ./agcp decompress disk.agcp /dev/device --to-device [--skip-zeros]")
+	fmt.Println("  ./agcp update archive.agcp input")
+	fmt.Println("  ./agcp verify input.agcp [--fast]")
+	fmt.Println("  ./agcp verify --tape device")
+	fmt.Println("  ./agcp health backups/ [--sample 10] [--max-age-days 30]")
+	fmt.Println("  ./agcp prune backups/ [--keep-daily 7] [--keep-weekly 4] [--keep-monthly 12] [--keep-yearly n] [--keep-last n] [--dry-run]")
+	fmt.Println("  ./agcp check input.agcp [--target windows|linux|macos]")
+	fmt.Println("  ./agcp grep input.agcp pattern [--include glob]...")
+	fmt.Println("  ./agcp list input.agcp [--filter tag:key[:value]]... [--long [--utc]] [--sizes]")
+	fmt.Println("  ./agcp info input.agcp")
+	fmt.Println("  ./agcp manifest input.agcp [--format json|csv]")
+	fmt.Println("  ./agcp head input.agcp path [--bytes 4K] [--hex]")
+	fmt.Println("  ./agcp dedupe-report a.agcp b.agcp")
+	fmt.Println("  ./agcp sfx input.agcp output[.exe] [--target-os os/arch] [--stub agcp-binary]")
+	fmt.Println("  ./agcp doctor [--json] [--tmpdir dir]")
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+// String implements flag.Value
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// sizeValue is a flag.Value accepting byte sizes such as 4096, 64K, 256MB or 1GiB
+type sizeValue int64
+
+// String implements flag.Value
+func (v *sizeValue) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+// Set implements flag.Value
+func (v *sizeValue) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*v = sizeValue(n)
+	return nil
+}
+
+// parseSize parses a byte size with an optional K/M/G/T suffix. Suffixes are
+// 1024-based; an optional trailing "B" or "iB" is accepted.
+func parseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+
+	multiplier := int64(1)
+	if str != "" {
+		switch str[len(str)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			str = str[:len(str)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseArgs parses flags that may appear anywhere among the positional arguments
+// and returns the positional arguments in order
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// addChdirFlag registers -C (--chdir) on fs: the directory input paths are
+// relative to when compressing, or output paths when extracting, like tar -C
+func addChdirFlag(fs *flag.FlagSet) *string {
+	dir := fs.String("C", "", "resolve input paths (compress) or write output (decompress) relative to this directory")
+	fs.StringVar(dir, "chdir", "", "same as -C")
+	return dir
+}
+
+// addTempDirFlag registers the scratch directory flag on fs and returns a
+// function returning the directory, checked to exist, once flags are parsed
+func addTempDirFlag(fs *flag.FlagSet) func() (string, error) {
+	dir := fs.String("tmpdir", "", "directory for temporary files, e.g. on a fast or spacious volume (default: the OS temporary directory)")
+	return func() (string, error) {
+		if *dir == "" {
+			return "", nil
+		}
+		info, err := os.Stat(*dir)
+		if err != nil {
+			return "", fmt.Errorf("invalid --tmpdir: %w", err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("invalid --tmpdir %s: not a directory", *dir)
+		}
+		return *dir, nil
+	}
+}
+
+// addProvenanceFlag registers the provenance flag on fs and returns a function
+// returning, once flags are parsed, the provenance to record, if any
+func addProvenanceFlag(fs *flag.FlagSet) func() *core.Provenance {
+	record := fs.Bool("record-provenance", false, "record the command line, agcp version, host name and OS in the archive, shown by info")
+	return func() *core.Provenance {
+		if !*record {
+			return nil
+		}
+		return core.CurrentProvenance()
+	}
+}
+
+// addCacheDirFlag registers the compression cache flag on fs
+func addCacheDirFlag(fs *flag.FlagSet) *string {
+	return fs.String("cache-dir", "", "keep compressed file data in this directory and copy it for files unchanged since, instead of compressing them again")
+}
+
+// addFsyncFlag registers the sync policy flag on fs
+func addFsyncFlag(fs *flag.FlagSet) *string {
+	return fs.String("fsync", "none", "when to sync written data to disk: none, per-file or per-archive")
+}
+
+// addSystemPathFlags registers the system path safety flags on fs and returns
+// a function reporting, once flags are parsed, whether extraction must refuse
+// system paths. Refusing is the default when running as root.
+func addSystemPathFlags(fs *flag.FlagSet) func() bool {
+	refuse := fs.Bool("refuse-system-paths", os.Geteuid() == 0, "refuse to extract into /, /etc, /usr, C:\\Windows and other system directories (default on as root)")
+	override := fs.Bool("i-know-what-im-doing", false, "extract into system directories despite --refuse-system-paths")
+	return func() bool {
+		return *refuse && !*override
+	}
+}
+
+// inDir resolves a relative path against dir, as if the process had changed
+// into dir; an empty dir leaves path unchanged
+func inDir(dir, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// addFormatFlags registers the size/rate/time formatting flags on fs and returns a
+// function that applies them to the progress output once flags are parsed
+func addFormatFlags(fs *flag.FlagSet) func() {
+	si := fs.Bool("si", false, "show sizes in 1000-based SI units (kB, MB) instead of KiB, MiB")
+	rawBytes := fs.Bool("bytes", false, "show raw byte counts and seconds for machine consumption")
+	decimalSep := fs.String("decimal-separator", "", "decimal separator for numbers (default from LC_NUMERIC/LANG)")
+	utc := fs.Bool("utc", false, "show times in UTC instead of the local time zone")
+	rawNames := fs.Bool("raw-names", false, "print entry names as stored instead of escaping control characters")
+
+	return func() {
+		f := progress.DefaultFormat
+		if *si {
+			f.Units = progress.UnitsSI
+		}
+		if *rawBytes {
+			f.Units = progress.UnitsBytes
+		}
+		f.DecimalSeparator = progress.LocaleDecimalSeparator()
+		if *decimalSep != "" {
+			f.DecimalSeparator = *decimalSep
+		}
+		f.UTC = *utc
+		f.RawNames = *rawNames
+		progress.SetFormat(f)
+	}
+}
+
+// addRetryFlags registers the transient-error retry flags on fs and returns a
+// function building the retry policy once flags are parsed
+func addRetryFlags(fs *flag.FlagSet) func() core.RetryPolicy {
+	retries := fs.Int("retries", 3, "retries per file for transient read errors (EIO, ESTALE); 0 disables")
+	backoff := fs.Duration("retry-backoff", 200*time.Millisecond, "initial delay between retries, doubled on each attempt")
+	maxBackoff := fs.Duration("retry-max-backoff", 10*time.Second, "maximum delay between retries")
+
+	return func() core.RetryPolicy {
+		return core.RetryPolicy{
+			MaxRetries:     *retries,
+			InitialBackoff: *backoff,
+			MaxBackoff:     *maxBackoff,
+			Log:            &core.RetryLog{},
+		}
+	}
+}
+
+// addTapeFlags registers the sequential-device flags on fs and returns the device
+// and a function building the tape options once flags are parsed
+func addTapeFlags(fs *flag.FlagSet) (*string, func() core.TapeOptions) {
+	device := fs.String("tape", "", "read or write the archive on a tape drive or pipe ('-' for stdin/stdout)")
+	blockingFactor := fs.Int("blocking-factor", 20, "512-byte records per device block")
+	var volumeSize sizeValue
+	fs.Var(&volumeSize, "volume-size", "bytes per volume, e.g. 800G (default: until the device is full)")
+
+	return device, func() core.TapeOptions {
+		stdin := bufio.NewReader(os.Stdin)
+		return core.TapeOptions{
+			BlockingFactor: *blockingFactor,
+			VolumeSize:     int64(volumeSize),
+			NextVolume: func(volume int) error {
+				fmt.Printf("Insert volume %d into %s and press Enter...", volume, *device)
+				if _, err := stdin.ReadString('\n'); err != nil {
+					return fmt.Errorf("wait for volume %d: %w", volume, err)
+				}
+				return nil
+			},
+		}
+	}
+}
+
+// stageFile returns the path of a new temporary file in dir (the OS default if
+// empty) for staging an archive between a sequential device or standard input
+// and the seekable form compress/decompress need, and the function that
+// removes it
+func stageFile(dir string) (string, func(), error) {
+	f, err := os.CreateTemp(dir, "agcp-tape-*.agcp")
+	if err != nil {
+		return "", nil, fmt.Errorf("create staging file: %w", err)
+	}
+	f.Close()
+	release := core.RegisterTemp(f.Name())
+	return f.Name(), func() {
+		os.Remove(f.Name())
+		release()
+	}, nil
+}
+
+// printWarning prints a non-fatal warning from the CLI itself
+func printWarning(msg string) {
+	fmt.Println("Warning:", msg)
+}
+
+// printWarningSummary prints the warnings collected during an operation
+func printWarningSummary(log *core.WarningLog) {
+	f := progress.CurrentFormat()
+	escaped := &core.WarningLog{}
+	for _, w := range log.Warnings() {
+		w.Path = f.Name(w.Path)
+		escaped.Add(w)
+	}
+	if summary := escaped.Summary(); summary != "" {
+		fmt.Print(summary)
+	}
+}
+
+// printRetrySummary prints the retried reads recorded during an operation
+func printRetrySummary(policy core.RetryPolicy) {
+	if summary := policy.Log.Summary(); summary != "" {
+		fmt.Print(summary)
+	}
+}
+
+// errUnchanged is returned by handleCompress when --if-changed finds nothing to
+// do; agcp exits with status 2
+var errUnchanged = errors.New("input unchanged")
+
+// handleCompress handles the compression operation
+func handleCompress() (err error) {
+	fs := flag.NewFlagSet("compress", flag.ExitOnError)
+	splitByTopLevel := fs.Bool("split-by-top-level", false, "write one archive per top-level subdirectory; output must contain %s")
+	each := fs.Bool("each", false, "compress each input into its own input.agcp, all at once")
+	var splitThreshold sizeValue
+	fs.Var(&splitThreshold, "split-threshold", "write files smaller than this to the first output, each compressed on its own at level 9 (not solid), and the rest to the second, e.g. 100MB")
+	level := fs.Int("level", 0, "compression level: 0 is fastest (default), 1-9 compress harder")
+	codecName := fs.String("codec", "", "compression codec: lz4 (default), zstd or gzip")
+	var inlineMax sizeValue
+	fs.Var(&inlineMax, "inline", "store files of up to this size in the entry table instead of compressing them, e.g. 512 (at most 32KB)")
+	policyFile := fs.String("policy", "", "file mapping glob patterns to a codec, level or store, e.g. '*.mp4: store'")
+	noExt := fs.Bool("no-ext", false, "don't append .agcp to output names without an extension")
+	force := fs.Bool("force", false, "compress the input even if it is already an agcp archive")
+	workers := fs.Int("workers", 0, "entries to compress concurrently (default one per CPU)")
+	reproducible := fs.Bool("reproducible", false, "leave out file ownership and times so the same tree always gives a byte-identical archive")
+	recordProvenance := addProvenanceFlag(fs)
+	cacheDir := addCacheDirFlag(fs)
+	oneFileSystem := fs.Bool("one-file-system", false, "don't descend into directories on other file systems (mount points)")
+	skipHidden := fs.Bool("skip-hidden", false, "skip hidden files and directories (dotfiles; also the hidden attribute on Windows and macOS)")
+	var exclude stringList
+	fs.Var(&exclude, "exclude", "leave out files and directories matching a glob, e.g. 'node_modules' or 'logs/**/*.tmp' (repeatable)")
+	sidecar := fs.Bool("sidecar", false, "write the archive's SHA-256 to archive.agcp.sha256, checked by decompress and verify")
+	var align sizeValue
+	fs.Var(&align, "align", "start each entry's data on a multiple of this many bytes, e.g. 4096")
+	var recipients stringList
+	fs.Var(&recipients, "recipient", "encrypt the archive to an age public key or GPG key ID/email (repeatable)")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag entries matching a glob, e.g. 'logs/**=retention:30d' (repeatable)")
+	minRatio := fs.Float64("min-ratio", 0, "abort if the sampled data compresses to more than this fraction of its size, e.g. 0.95")
+	var ratioSample sizeValue
+	fs.Var(&ratioSample, "ratio-sample", "input to sample before checking --min-ratio (default 64MB)")
+	storeIncompressible := fs.Bool("store-incompressible", false, "with --min-ratio, store the remaining data uncompressed instead of aborting")
+	ifChanged := fs.String("if-changed", "", "write nothing and exit with status 2 if the input has the same files and content as this archive")
+	snapshotName := fs.String("snapshot", "none", "compress from a file system snapshot of the input: none, auto, btrfs, zfs, lvm or vss")
+	onPartial := fs.String("on-partial", "ask", "what to do with a partial archive left by an interrupted run: ask, resume, overwrite or abort")
+	applyFormat := addFormatFlags(fs)
+	retryPolicy := addRetryFlags(fs)
+	tapeDevice, tapeOptions := addTapeFlags(fs)
+	statusFile := addStatusFlags(fs)
+	startPublish := addPublishFlags(fs)
+	startReport := addReportFlags(fs)
+	lowerPriority := addPriorityFlags(fs)
+	deadline := addDeadlineFlags(fs)
+	schedule := addScheduleFlags(fs)
+	chdir := addChdirFlag(fs)
+	tempDir := addTempDirFlag(fs)
+	keepPartial := addKeepPartialFlag(fs)
+	fsyncName := addFsyncFlag(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	applyFormat()
+	if err := lowerPriority(); err != nil {
+		return err
+	}
+	if (len(args) != 1 && len(args) != 2) && !(splitThreshold > 0 && len(args) == 3) && !(*each && len(args) > 0) {
+		fmt.Println("Usage: ./agcp compress input [output.agcp]")
+		os.Exit(1)
+	}
+
+	codec, err := core.ParseCodec(*codecName)
+	if err != nil {
+		return err
+	}
+	fsync, err := core.ParseFsyncPolicy(*fsyncName)
+	if err != nil {
+		return err
+	}
+	snapshot, err := core.ParseSnapshotMode(*snapshotName)
+	if err != nil {
+		return err
+	}
+	stopAt, err := deadline()
+	if err != nil {
+		return err
+	}
+	scheduled, closeSchedule, err := schedule()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeSchedule(); err == nil {
+			err = closeErr
+		}
+	}()
+	if scheduled != nil && (*each || *splitByTopLevel || splitThreshold > 0) {
+		return fmt.Errorf("--deterministic-scheduling runs a single archive; it cannot be combined with --each or splitting")
+	}
+
+	var policy *core.Policy
+	if *policyFile != "" {
+		if policy, err = core.LoadPolicy(*policyFile); err != nil {
+			return err
+		}
+	}
+
+	var recipientKeys []core.Recipient
+	for _, r := range recipients {
+		recipient, err := core.ParseRecipient(r)
+		if err != nil {
+			return err
+		}
+		recipientKeys = append(recipientKeys, recipient)
+	}
+
+	var tagRules []core.TagRule
+	for _, tag := range tags {
+		rule, err := core.ParseTagRule(tag)
+		if err != nil {
+			return err
+		}
+		tagRules = append(tagRules, rule)
+	}
+
+	// Inputs are relative to -C; outputs stay relative to the working directory
+	inputs := args[:1]
+	if *each {
+		inputs = args
+	}
+	for i := range inputs {
+		if inputs[i] == "-" {
+			continue // Standard input
+		}
+		inputs[i] = inDir(*chdir, inputs[i])
+		if !*force && isArchive(inputs[i]) {
+			return fmt.Errorf("%s is already an agcp archive; did you mean ./agcp decompress %s? Use --force to compress it anyway", inputs[i], inputs[i])
+		}
+	}
+	input := args[0]
+	withExt := func(output string) string {
+		if *noExt {
+			return output
+		}
+		return withArchiveExt(output)
+	}
+
+	warnings := &core.WarningLog{}
+	opts := core.Options{
+		Retry:               retryPolicy(),
+		Level:               *level,
+		Codec:               codec,
+		InlineMax:           int64(inlineMax),
+		Policy:              policy,
+		Tags:                tagRules,
+		Recipients:          recipientKeys,
+		Workers:             *workers,
+		Reproducible:        *reproducible,
+		Provenance:          recordProvenance(),
+		CacheDir:            *cacheDir,
+		OneFileSystem:       *oneFileSystem,
+		SkipHidden:          *skipHidden,
+		Exclude:             exclude,
+		Sidecar:             *sidecar,
+		Align:               int64(align),
+		MinRatio:            *minRatio,
+		RatioSample:         int64(ratioSample),
+		StoreIncompressible: *storeIncompressible,
+		KeepPartial:         *keepPartial,
+		Snapshot:            snapshot,
+		Fsync:               fsync,
+		Deadline:            stopAt,
+		Schedule:            scheduled,
+		Warn:                warnings.Add,
+	}
+	if opts.TempDir, err = tempDir(); err != nil {
+		return err
+	}
+	defer printWarningSummary(warnings)
+	defer printRetrySummary(opts.Retry)
+	if *ifChanged != "" {
+		if *each || *splitByTopLevel || splitThreshold > 0 {
+			return fmt.Errorf("--if-changed compares the input with a single archive; it cannot be combined with --each or splitting")
+		}
+		unchanged, err := core.InputUnchanged(input, *ifChanged, opts)
+		if err != nil {
+			return fmt.Errorf("compare with %s: %w", *ifChanged, err)
+		}
+		if unchanged {
+			fmt.Printf("%s is unchanged since %s; no archive written\n", input, *ifChanged)
+			return errUnchanged
+		}
+	}
+	stopStatus := startStatusReporter(*statusFile, &opts)
+	defer stopStatus()
+	stopPublish, err := startPublish("compress", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { stopPublish(err) }()
+	finishReport, err := startReport("compress", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishReport(err) }()
+	defer cleanupOnInterrupt(*keepPartial)()
+
+	if *each {
+		if opts.Partial, err = partialAction(*onPartial, ""); err != nil {
+			return err
+		}
+		progress.SetOperationName(fmt.Sprintf("Compressing %d inputs", len(args)))
+		return explainDeadline(core.CompressEach(args, opts), "partial archives are kept; run the same command with --on-partial resume to continue")
+	}
+
+	if *splitByTopLevel {
+		if len(args) != 2 {
+			fmt.Printf("Usage: ./agcp compress input --split-by-top-level out-%%s.agcp\n")
+			os.Exit(1)
+		}
+		pattern, err := core.ExpandOutputTemplate(args[1], input, time.Now())
+		if err != nil {
+			return err
+		}
+		if opts.Partial, err = partialAction(*onPartial, ""); err != nil {
+			return err
+		}
+		labelOperation("Compressing", input, withExt(pattern))
+		return explainDeadline(core.CompressSplit(input, withExt(pattern), opts), "partial archives are kept; run the same command with --on-partial resume to continue")
+	}
+
+	if splitThreshold > 0 {
+		if len(args) != 3 {
+			fmt.Println("Usage: ./agcp compress input --split-threshold 100MB out-small.agcp out-large.agcp")
+			os.Exit(1)
+		}
+		var outputs [2]string
+		for i, arg := range args[1:] {
+			if outputs[i], err = core.ExpandOutputTemplate(arg, input, time.Now()); err != nil {
+				return err
+			}
+		}
+		if opts.Partial, err = partialAction(*onPartial, ""); err != nil {
+			return err
+		}
+		labelOperation("Compressing", input, withExt(outputs[0]), withExt(outputs[1]))
+		return explainDeadline(core.CompressBySize(input, int64(splitThreshold), withExt(outputs[0]), withExt(outputs[1]), opts), "partial archives are kept; run the same command with --on-partial resume to continue")
+	}
+
+	if *tapeDevice != "" {
+		if len(args) != 1 {
+			fmt.Println("Usage: ./agcp compress input --tape device")
+			os.Exit(1)
+		}
+		if *sidecar {
+			return fmt.Errorf("--sidecar writes next to the archive; it cannot be combined with --tape")
+		}
+		if !opts.Deadline.IsZero() {
+			return fmt.Errorf("a tape write stopped at --max-duration or --stop-at cannot be resumed; they cannot be combined with --tape")
+		}
+		staged, removeStaged, err := stageFile(opts.TempDir)
+		if err != nil {
+			return err
+		}
+		defer removeStaged()
+		labelOperation("Compressing", input, *tapeDevice)
+		if err := core.Compress(context.Background(), input, staged, opts); err != nil {
+			return err
+		}
+		return core.WriteTape(staged, *tapeDevice, tapeOptions())
+	}
+
+	if input == "-" || (len(args) == 2 && args[1] == "-") {
+		output := "-"
+		if len(args) == 2 && args[1] != "-" {
+			output = withExt(args[1])
+		}
+		return compressStdio(input, output, opts)
+	}
+
+	output, err := determineOutputPath(input, args)
+	if err != nil {
+		return err
+	}
+	output = withExt(output)
+	if opts.Partial, err = partialAction(*onPartial, output); err != nil {
+		return err
+	}
+
+	labelOperation("Compressing", input, output)
+	err = core.Compress(context.Background(), input, output, opts)
+	return explainDeadline(err, fmt.Sprintf("the partial archive %s is kept; run the same command with --on-partial resume to continue", core.PartialPath(output)))
+}
+
+// partialAction resolves --on-partial. For "ask" it prompts, if a partial archive
+// for output exists and stdin is a terminal; otherwise "ask" aborts.
+func partialAction(mode, output string) (core.PartialAction, error) {
+	switch mode {
+	case "resume":
+		return core.PartialResume, nil
+	case "overwrite":
+		return core.PartialOverwrite, nil
+	case "abort":
+		return core.PartialAbort, nil
+	case "ask":
+	default:
+		return core.PartialAbort, fmt.Errorf("invalid --on-partial %q: want ask, resume, overwrite or abort", mode)
+	}
+
+	if output == "" {
+		return core.PartialAbort, nil
+	}
+	tmp := core.PartialPath(output)
+	if _, err := os.Stat(tmp); err != nil {
+		return core.PartialAbort, nil
+	}
+	// A partial archive another run is still writing is not ours to resume
+	if err := core.CheckLock(output); err != nil {
+		return core.PartialAbort, err
+	}
+	if !stdinIsTerminal() {
+		return core.PartialAbort, nil
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("%s was left by an interrupted run. [r]esume, [o]verwrite or [a]bort? ", tmp)
+		answer, err := stdin.ReadString('\n')
+		if err != nil {
+			return core.PartialAbort, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "r", "resume":
+			return core.PartialResume, nil
+		case "o", "overwrite":
+			return core.PartialOverwrite, nil
+		case "a", "abort":
+			return core.PartialAbort, nil
+		}
+	}
+}
+
+// stdinIsTerminal reports whether stdin is a terminal, so questions can be asked
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// The null device is a character device too, but nobody is there to answer
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// withArchiveExt appends .agcp to an output name without an extension
+func withArchiveExt(output string) string {
+	if filepath.Ext(output) == "" {
+		return output + ".agcp"
+	}
+	return output
+}
+
+// labelOperation names the operation in progress output, such as "Compressing
+// photos/ → photos.agcp" or "Extracting backup.agcp", so the output of scripts
+// running several agcp steps can be told apart. Directories get a trailing slash.
+func labelOperation(verb, input string, outputs ...string) {
+	if info, err := os.Stat(input); err == nil && info.IsDir() && !os.IsPathSeparator(input[len(input)-1]) {
+		input += "/"
+	}
+	label := verb + " " + input
+	if len(outputs) > 0 {
+		label += " → " + strings.Join(outputs, ", ")
+	}
+	progress.SetOperationName(label)
+}
+
+// isArchive reports whether path is a file starting with the archive magic
+func isArchive(path string) bool {
+	// Reading the magic from a pipe would consume it
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(core.Magic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == core.Magic
+}
+
+// resolveArchiveInput finds the archive an input argument refers to: the path
+// itself, or the path with .agcp appended when that is the archive (so
+// "backup" finds "backup.agcp", even next to an extracted "backup" directory)
+func resolveArchiveInput(path string) (string, error) {
+	if isArchive(path) {
+		return path, nil
+	}
+	if withExt := path + ".agcp"; filepath.Ext(path) != ".agcp" && isArchive(withExt) {
+		return withExt, nil
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return "", fmt.Errorf("%s is a directory, not an archive; did you mean ./agcp compress %s?", path, path)
+	}
+	return path, nil // Let the operation report the error
+}
+
+// determineOutputPath determines the output path for compression, expanding
+// any template tokens in a given output name
+func determineOutputPath(input string, args []string) (string, error) {
+	// If output is provided as an argument, use it
+	if len(args) == 2 {
+		return core.ExpandOutputTemplate(args[1], input, time.Now())
+	}
+
+	// A pipe's name, like 63 for /dev/fd/63, would make a meaningless one
+	if info, err := os.Stat(input); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		return "", fmt.Errorf("%s is a pipe; name the output archive, e.g. ./agcp compress %s out.agcp", input, input)
+	}
+
+	// Otherwise, use input name + .agcp extension
+	autoName := filepath.Base(input) + ".agcp"
+	if _, err := os.Stat(autoName); os.IsNotExist(err) {
+		return autoName, nil
+	}
+
+	// Default fallback
+	return "output.agcp", nil
+}
+
+// handleDecompress handles the decompression operation
+func handleDecompress() (err error) {
+	fs := flag.NewFlagSet("decompress", flag.ExitOnError)
+	applyFormat := addFormatFlags(fs)
+	retryPolicy := addRetryFlags(fs)
+	var ioBudget sizeValue
+	fs.Var(&ioBudget, "io-budget", "bound written-but-unflushed data across extraction workers, e.g. 256MB (default unbounded)")
+	var maxWriteRate sizeValue
+	fs.Var(&maxWriteRate, "max-write-rate", "limit extraction to writing this many bytes per second across all workers, e.g. 100MB (default unlimited)")
+	update := fs.Bool("update", false, "update an existing tree: skip entries whose file on disk is unchanged")
+	yes := fs.Bool("yes", false, "extract into a non-empty directory without asking for confirmation")
+	recursive := fs.Bool("recursive", false, "unpack nested .agcp, .zip, .tar and .tar.gz archives in place")
+	maxDepth := fs.Int("max-depth", 5, "with --recursive, how many levels of nested archives to unpack")
+	noPerms := fs.Bool("no-perms", false, "extract files with default permissions instead of the archived ones")
+	toDevice := fs.Bool("to-device", false, "write a disk image over the existing block device or file given as output, in place")
+	skipZeros := fs.Bool("skip-zeros", false, "seek past zeros instead of writing them; with --to-device, only for targets that already read as zeros")
+	ownerMap := fs.String("owner-map", "", "translate archived owners when restoring, e.g. 'uid:0=1000,gid:0=1000'")
+	textConvert := fs.String("text-convert", "none", "convert the line endings of text files: none, lf or crlf")
+	escapeNames := fs.String("escape-names", "hex", "on Windows, how to restore names that are not valid UTF-8: hex (%XX), replace (U+FFFD) or fail")
+	var identities stringList
+	fs.Var(&identities, "identity", "age identity file for decrypting an age-encrypted archive (repeatable)")
+	tapeDevice, tapeOptions := addTapeFlags(fs)
+	statusFile := addStatusFlags(fs)
+	startPublish := addPublishFlags(fs)
+	startReport := addReportFlags(fs)
+	lowerPriority := addPriorityFlags(fs)
+	deadline := addDeadlineFlags(fs)
+	schedule := addScheduleFlags(fs)
+	chdir := addChdirFlag(fs)
+	tempDir := addTempDirFlag(fs)
+	keepPartial := addKeepPartialFlag(fs)
+	fsyncName := addFsyncFlag(fs)
+	refuseSystemPaths := addSystemPathFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	applyFormat()
+	if err := lowerPriority(); err != nil {
+		return err
+	}
+	fsync, err := core.ParseFsyncPolicy(*fsyncName)
+	if err != nil {
+		return err
+	}
+	warnings := &core.WarningLog{}
+	opts := core.Options{Retry: retryPolicy(), IOBudget: int64(ioBudget), MaxWriteRate: int64(maxWriteRate), NoPerms: *noPerms, ToDevice: *toDevice, SkipZeros: *skipZeros, Identities: identities, Dir: *chdir, KeepPartial: *keepPartial, Fsync: fsync, Update: *update, RefuseSystemPaths: refuseSystemPaths(), Warn: warnings.Add}
+	if opts.Deadline, err = deadline(); err != nil {
+		return err
+	}
+	scheduled, closeSchedule, err := schedule()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeSchedule(); err == nil {
+			err = closeErr
+		}
+	}()
+	opts.Schedule = scheduled
+	if opts.TempDir, err = tempDir(); err != nil {
+		return err
+	}
+	if *recursive {
+		opts.Recursive = *maxDepth
+	}
+	if *ownerMap != "" {
+		if opts.OwnerMap, err = core.ParseOwnerMap(*ownerMap); err != nil {
+			return err
+		}
+	}
+	if opts.NameEscape, err = core.ParseNameEscape(*escapeNames); err != nil {
+		return err
+	}
+	lineEnding, err := core.ParseLineEnding(*textConvert)
+	if err != nil {
+		return err
+	}
+	if lineEnding != core.LineEndingKeep {
+		opts.Transform = core.ConvertLineEndings(lineEnding)
+	}
+	defer printWarningSummary(warnings)
+	defer printRetrySummary(opts.Retry)
+	stopStatus := startStatusReporter(*statusFile, &opts)
+	defer stopStatus()
+	stopPublish, err := startPublish("decompress", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { stopPublish(err) }()
+	finishReport, err := startReport("decompress", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishReport(err) }()
+	defer cleanupOnInterrupt(*keepPartial)()
+
+	if *tapeDevice != "" {
+		if len(args) > 1 {
+			fmt.Println("Usage: ./agcp decompress --tape device [decompressed_name]")
+			os.Exit(1)
+		}
+		staged, removeStaged, err := stageFile(opts.TempDir)
+		if err != nil {
+			return err
+		}
+		defer removeStaged()
+		if err := core.ReadTape(*tapeDevice, staged, tapeOptions()); err != nil {
+			return err
+		}
+		args = append([]string{staged}, args...)
+	}
+	fromStdin := len(args) > 0 && args[0] == "-"
+	if fromStdin {
+		staged, removeStaged, err := stageStdin(opts.TempDir)
+		if err != nil {
+			return err
+		}
+		defer removeStaged()
+		args[0] = staged
+	}
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Usage: ./agcp decompress input.agcp [decompressed_name]")
+		os.Exit(1)
+	}
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	decompressedName := ""
+	if len(args) == 2 {
+		decompressedName = args[1]
+	}
+	source := input
+	if *tapeDevice != "" {
+		source = *tapeDevice
+	} else if fromStdin {
+		source = "stdin"
+	}
+	if decompressedName == "-" {
+		labelOperation("Extracting", source, "stdout")
+		return core.DecompressToWriter(input, stdout, opts)
+	}
+	if decompressedName != "" {
+		labelOperation("Extracting", source, decompressedName)
+	} else {
+		labelOperation("Extracting", source)
+	}
+
+	if !*yes && !*update {
+		if err := confirmExtraction(input, decompressedName, opts); err != nil {
+			return err
+		}
+	}
+	err = core.Decompress(context.Background(), input, decompressedName, opts)
+	if errors.Is(err, core.ErrSystemPath) {
+		return fmt.Errorf("%w; pass --i-know-what-im-doing if this is intended", err)
+	}
+	return explainDeadline(err, "the files extracted so far are kept; run the same command with --update to continue")
+}
+
+// handleUpdate brings an archive up to date with the directory or file it was made from
+func handleUpdate() (err error) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	level := fs.Int("level", 0, "compression level for new and changed files: 0 is fastest (default), 1-9 compress harder")
+	codecName := fs.String("codec", "", "compression codec for new and changed files: lz4 (default), zstd or gzip")
+	var inlineMax sizeValue
+	fs.Var(&inlineMax, "inline", "store new and changed files of up to this size in the entry table, e.g. 512 (at most 32KB)")
+	workers := fs.Int("workers", 0, "entries to compress concurrently (default one per CPU)")
+	oneFileSystem := fs.Bool("one-file-system", false, "don't descend into directories on other file systems (mount points)")
+	skipHidden := fs.Bool("skip-hidden", false, "skip hidden files and directories (dotfiles; also the hidden attribute on Windows and macOS)")
+	var exclude stringList
+	fs.Var(&exclude, "exclude", "leave out files and directories matching a glob, e.g. 'node_modules' or 'logs/**/*.tmp' (repeatable)")
+	sidecar := fs.Bool("sidecar", false, "write the archive's SHA-256 to archive.agcp.sha256, checked by decompress and verify")
+	recordProvenance := addProvenanceFlag(fs)
+	cacheDir := addCacheDirFlag(fs)
+	applyFormat := addFormatFlags(fs)
+	retryPolicy := addRetryFlags(fs)
+	tempDir := addTempDirFlag(fs)
+	keepPartial := addKeepPartialFlag(fs)
+	fsyncName := addFsyncFlag(fs)
+	startReport := addReportFlags(fs)
+	lowerPriority := addPriorityFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: ./agcp update archive.agcp input")
+		os.Exit(1)
+	}
+	applyFormat()
+	if err := lowerPriority(); err != nil {
+		return err
+	}
+
+	codec, err := core.ParseCodec(*codecName)
+	if err != nil {
+		return err
+	}
+	fsync, err := core.ParseFsyncPolicy(*fsyncName)
+	if err != nil {
+		return err
+	}
+	archive, input := args[0], args[1]
+	warnings := &core.WarningLog{}
+	opts := core.Options{
+		Retry:         retryPolicy(),
+		Level:         *level,
+		Codec:         codec,
+		InlineMax:     int64(inlineMax),
+		Workers:       *workers,
+		OneFileSystem: *oneFileSystem,
+		SkipHidden:    *skipHidden,
+		Exclude:       exclude,
+		Sidecar:       *sidecar,
+		Provenance:    recordProvenance(),
+		CacheDir:      *cacheDir,
+		KeepPartial:   *keepPartial,
+		Fsync:         fsync,
+		Warn:          warnings.Add,
+	}
+	if opts.TempDir, err = tempDir(); err != nil {
+		return err
+	}
+	defer printWarningSummary(warnings)
+	defer printRetrySummary(opts.Retry)
+	finishReport, err := startReport("update", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishReport(err) }()
+	defer cleanupOnInterrupt(*keepPartial)()
+
+	labelOperation("Updating", input, archive)
+	report, err := core.UpdateArchive(archive, input, opts)
+	if err != nil {
+		return err
+	}
+	if !report.Rewritten {
+		fmt.Printf("%s is up to date: %d entries unchanged\n", archive, report.Unchanged)
+		return nil
+	}
+	fmt.Printf("Updated %s: %d added, %d changed, %d removed, %d unchanged\n", archive, report.Added, report.Changed, report.Removed, report.Unchanged)
+	return nil
+}
+
+// handleSfx builds a self-extracting executable from an archive
+func handleSfx() error {
+	fs := flag.NewFlagSet("sfx", flag.ExitOnError)
+	target := fs.String("target-os", runtime.GOOS+"/"+runtime.GOARCH, "platform of the generated executable, as os/arch")
+	stub := fs.String("stub", "", "agcp executable built for the target platform (default: located automatically)")
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: ./agcp sfx input.agcp output[.exe] [--target-os os/arch] [--stub agcp-binary]")
+		os.Exit(1)
+	}
+
+	stubPath := *stub
+	if stubPath == "" {
+		if stubPath, err = sfx.StubFor(*target); err != nil {
+			return err
+		}
+	}
+	if err := sfx.Build(stubPath, args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Created self-extracting archive %s for %s\n", args[1], *target)
+	return nil
+}
+
+// runSelfExtract extracts the archive embedded in this executable.
+// The optional first argument names the destination. It takes the safety
+// flags of decompress, so a self-extracting archive is no less careful.
+func runSelfExtract(exe string) error {
+	fs := flag.NewFlagSet(filepath.Base(exe), flag.ExitOnError)
+	retryPolicy := addRetryFlags(fs)
+	refuseSystemPaths := addSystemPathFlags(fs)
+	yes := fs.Bool("yes", false, "extract into a non-empty directory without asking for confirmation")
+	args, err := parseArgs(fs, os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		fmt.Printf("Usage: %s [destination] [--yes]\n", filepath.Base(exe))
+		os.Exit(1)
+	}
+
+	archive, err := sfx.ExtractPayload(exe, "")
+	if err != nil {
+		return err
+	}
+	defer core.RegisterTemp(archive)()
+	defer os.Remove(archive)
+	defer cleanupOnInterrupt(false)()
+
+	warnings := &core.WarningLog{}
+	opts := core.Options{Retry: retryPolicy(), RefuseSystemPaths: refuseSystemPaths(), Warn: warnings.Add}
+	defer printWarningSummary(warnings)
+	defer printRetrySummary(opts.Retry)
+
+	decompressedName := ""
+	if len(args) == 1 {
+		decompressedName = args[0]
+	}
+	labelOperation("Extracting", filepath.Base(exe))
+	if !*yes {
+		if err := confirmExtraction(archive, decompressedName, opts); err != nil {
+			return err
+		}
+	}
+	return core.Decompress(context.Background(), archive, decompressedName, opts)
+}
+
+// errNoMatch is returned by handleGrep when no entry matches; agcp exits with
+// status 1
+var errNoMatch = errors.New("no match")
+
+// handleGrep searches entry contents inside an archive without extracting it
+func handleGrep() error {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	applyFormat := addFormatFlags(fs)
+	var include stringList
+	fs.Var(&include, "include", "only search entries whose path or name matches this glob (repeatable)")
+	ignoreCase := fs.Bool("i", false, "case-insensitive matching")
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: ./agcp grep input.agcp pattern [--include glob]... [-i] [--raw-names]")
+		os.Exit(2)
+	}
+	applyFormat()
+
+	pattern := args[1]
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	f := progress.CurrentFormat()
+	matched := false
+	err = core.Grep(input, re, include, func(matches []core.GrepMatch) {
+		matched = true
+		for _, m := range matches {
+			if m.Binary {
+				fmt.Printf("Binary entry %s matches\n", f.Name(m.Path))
+				continue
+			}
+			fmt.Printf("%s:%d:%s\n", f.Name(m.Path), m.Line, f.Name(m.Text))
+		}
+	})
+	if err == nil && !matched {
+		return errNoMatch
+	}
+	return err
+}
+
+// handleList prints the entries of an archive and their tags
+func handleList() error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var filters stringList
+	fs.Var(&filters, "filter", "only list entries with this tag, as tag:key or tag:key:value (repeatable; all must match)")
+	long := fs.Bool("long", false, "also show each entry's mode, size and modification time, and when the archive was created")
+	print0 := fs.Bool("print0", false, "end each entry with a NUL byte instead of a newline and print names as stored, for xargs -0")
+	offsets := fs.Bool("offsets", false, "also show the byte offset, length and codec of each entry's data in the archive, for range requests")
+	sizes := fs.Bool("sizes", false, "also show each entry's original and compressed size and compression ratio, and the totals")
+	applyFormat := addFormatFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp list input.agcp [--filter tag:key[:value]]... [--long [--utc]] [--offsets] [--sizes] [--print0 | --raw-names]")
+		os.Exit(1)
+	}
+	applyFormat()
+
+	type tagFilter struct {
+		key, value string
+		hasValue   bool
+	}
+	var tagFilters []tagFilter
+	for _, filter := range filters {
+		tag, ok := strings.CutPrefix(filter, "tag:")
+		if !ok || tag == "" {
+			return fmt.Errorf("invalid filter %q: want tag:key or tag:key:value", filter)
+		}
+		key, value, hasValue := strings.Cut(tag, ":")
+		tagFilters = append(tagFilters, tagFilter{key: key, value: value, hasValue: hasValue})
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	a, err := core.OpenArchive(input, core.Options{})
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	f := progress.CurrentFormat()
+	end := "\n"
+	if *print0 {
+		f.RawNames, end = true, "\x00"
+	}
+	if *long && !*print0 {
+		fmt.Printf("Created: %s\n", f.Time(a.Created()))
+	}
+	var total core.EntryInfo
+	for _, entry := range a.Entries() {
+		matched := true
+		for _, filter := range tagFilters {
+			value, ok := entry.Tags[filter.key]
+			if !ok || (filter.hasValue && value != filter.value) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		line := f.Name(entry.Path)
+		if *long {
+			line = fmt.Sprintf("%s  %10s  %s  %s", entry.Mode, f.Size(entry.OriginalSize), f.Time(entry.ModTime), line)
+		}
+		if *sizes {
+			line = fmt.Sprintf("%10s  %10s  %6s  %s", f.Size(entry.OriginalSize), f.Size(entry.CompressedSize), formatRatio(f, entry), line)
+			total.OriginalSize += entry.OriginalSize
+			total.CompressedSize += entry.CompressedSize
+		}
+		if *offsets {
+			// Exact byte counts, whatever the format flags, to paste into a range request
+			line = fmt.Sprintf("%12d  %12d  %-6s  %s", entry.Offset, entry.CompressedSize, entry.Codec, line)
+		}
+		keys := make([]string, 0, len(entry.Tags))
+		for key := range entry.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			line += fmt.Sprintf("  %s:%s", f.Name(key), f.Name(entry.Tags[key]))
+		}
+		if *long && entry.Type != "regular" {
+			line += fmt.Sprintf("  (%s, not extracted)", entry.Type)
+		}
+		fmt.Print(line + end)
+	}
+	if *sizes && !*print0 {
+		fmt.Printf("%10s  %10s  %6s  total\n", f.Size(total.OriginalSize), f.Size(total.CompressedSize), formatRatio(f, total))
+	}
+	return nil
+}
+
+// formatRatio formats an entry's compressed size as a percentage of its
+// original size, or "-" for an empty entry
+func formatRatio(f progress.Format, entry core.EntryInfo) string {
+	if entry.OriginalSize == 0 {
+		return "-"
+	}
+	return f.Number(entry.Ratio()*100, 1) + "%"
+}
+
+// handleInfo prints an archive's format, totals and, if recorded, how it was
+// produced
+func handleInfo() error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	applyFormat := addFormatFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp info input.agcp")
+		os.Exit(1)
+	}
+	applyFormat()
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	a, err := core.OpenArchive(input, core.Options{})
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	f := progress.CurrentFormat()
+	fmt.Printf("Archive: %s\n", f.Name(input))
+	fmt.Printf("Format:  v%d\n", a.Version())
+	for _, feature := range a.Features() {
+		fmt.Printf("Feature: %s\n", feature)
+	}
+	if created := a.Created(); !created.IsZero() {
+		fmt.Printf("Created: %s\n", f.Time(created))
+	}
+	var total core.EntryInfo
+	entries := a.Entries()
+	for _, entry := range entries {
+		total.OriginalSize += entry.OriginalSize
+		total.CompressedSize += entry.CompressedSize
+	}
+	fmt.Printf("Entries: %d, %s compressed to %s (%s)\n", len(entries), f.Size(total.OriginalSize), f.Size(total.CompressedSize), formatRatio(f, total))
+
+	prov := a.Provenance()
+	if prov == nil {
+		fmt.Println("Provenance: not recorded (compress with --record-provenance to record it)")
+		return nil
+	}
+	fmt.Printf("Command: %s\n", quoteCommand(prov.Command))
+	fmt.Printf("Version: %s\n", prov.Version)
+	fmt.Printf("Host:    %s\n", prov.Hostname)
+	fmt.Printf("OS:      %s\n", prov.OS)
+	return nil
+}
+
+// quoteCommand joins a command line for display, quoting the arguments a
+// shell would split or expand
+func quoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]{}()<>|&;#~") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// handleManifest writes the bill of materials of an archive to stdout
+func handleManifest() error {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	format := fs.String("format", "json", "manifest format: json or csv")
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./agcp manifest input.agcp [--format json|csv]")
+		os.Exit(1)
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown manifest format %q: want json or csv", *format)
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	manifest, err := core.BuildManifest(input)
+	if err != nil {
+		return err
+	}
+	if *format == "csv" {
+		return manifest.WriteCSV(os.Stdout)
+	}
+	return manifest.WriteJSON(os.Stdout)
+}
+
+// handleHead writes the first bytes of an entry to stdout
+func handleHead() error {
+	fs := flag.NewFlagSet("head", flag.ExitOnError)
+	count := sizeValue(4 << 10)
+	fs.Var(&count, "bytes", "how much of the entry to print, e.g. 512 or 4K")
+	hexDump := fs.Bool("hex", false, "print a hex dump instead of the raw bytes")
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: ./agcp head input.agcp path [--bytes 4K] [--hex]")
+		os.Exit(1)
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	a, err := core.OpenArchive(input, core.Options{})
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	data, err := a.HeadEntry(args[1], int64(count))
+	if err != nil {
+		return err
+	}
+	if *hexDump {
+		dumper := hex.Dumper(os.Stdout)
+		if _, err := dumper.Write(data); err != nil {
+			return err
+		}
+		return dumper.Close()
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// handleDedupeReport reports how much content two archives share
+func handleDedupeReport() error {
+	fs := flag.NewFlagSet("dedupe-report", flag.ExitOnError)
+	applyFormat := addFormatFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: ./agcp dedupe-report a.agcp b.agcp")
+		os.Exit(1)
+	}
+	applyFormat()
+
+	report, err := core.CompareContent(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	f := progress.CurrentFormat()
+	percent := func(part, whole uint64) string {
+		if whole == 0 {
+			return f.Number(0, 1)
+		}
+		return f.Number(float64(part)/float64(whole)*100, 1)
+	}
+	fmt.Printf("%s: %d entries, %s; %d shared entries, %s (%s%%)\n",
+		args[0], report.EntriesA, f.Size(report.BytesA),
+		report.SharedEntriesA, f.Size(report.SharedBytesA), percent(report.SharedBytesA, report.BytesA))
+	fmt.Printf("%s: %d entries, %s; %d shared entries, %s (%s%%)\n",
+		args[1], report.EntriesB, f.Size(report.BytesB),
+		report.SharedEntriesB, f.Size(report.SharedBytesB), percent(report.SharedBytesB, report.BytesB))
+	fmt.Printf("Distinct shared contents: %d (hashed %d candidate entries)\n",
+		report.SharedContents, report.HashedEntries)
+	return nil
+}
+
+// handleVerify checks an archive's structure and, unless --fast, every entry's data
+func handleVerify() (err error) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fast := fs.Bool("fast", false, "check only sizes, offsets and structure without decompressing entries")
+	applyFormat := addFormatFlags(fs)
+	retryPolicy := addRetryFlags(fs)
+	tapeDevice, tapeOptions := addTapeFlags(fs)
+	startReport := addReportFlags(fs)
+	lowerPriority := addPriorityFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if *tapeDevice != "" {
+		if len(args) != 0 {
+			fmt.Println("Usage: ./agcp verify --tape device")
+			os.Exit(1)
+		}
+		return verifyTape(*tapeDevice, tapeOptions())
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp verify input.agcp [--fast]")
+		os.Exit(1)
+	}
+	applyFormat()
+	if err := lowerPriority(); err != nil {
+		return err
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	warnings := &core.WarningLog{}
+	opts := core.Options{Retry: retryPolicy(), Warn: warnings.Add}
+	defer printWarningSummary(warnings)
+	defer printRetrySummary(opts.Retry)
+	finishReport, err := startReport("verify", &opts)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishReport(err) }()
+	labelOperation("Verifying", input)
+	report, err := core.Verify(input, *fast, opts)
+	if err != nil {
+		return err
+	}
+
+	f := progress.CurrentFormat()
+	for _, failure := range report.Failures {
+		fmt.Println("FAILED:", &core.EntryError{Path: f.Name(failure.Path), Op: failure.Op, Err: failure.Err})
+	}
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("%d of %d entries failed verification", len(report.Failures), report.Entries)
+	}
+	if report.Sidecar {
+		fmt.Printf("OK: archive matches %s\n", core.SidecarPath(input))
+	}
+	if *fast {
+		fmt.Printf("OK: %d entries, structure verified\n", report.Entries)
+	} else {
+		fmt.Printf("OK: %d entries, %s verified\n", report.Entries, f.Size(report.Bytes))
+	}
+	return nil
+}
+
+// verifyTape checks the volumes of an archive on a sequential device against
+// their recorded digests
+func verifyTape(device string, opts core.TapeOptions) error {
+	report, err := core.VerifyTape(device, opts)
+	if err != nil {
+		return err
+	}
+	if !report.Digests {
+		fmt.Printf("OK: %d volumes read; they record no digests to check them against\n", report.Volumes)
+		return nil
+	}
+	for _, volume := range report.Mismatched {
+		fmt.Printf("FAILED: volume %d does not match its recorded digest\n", volume)
+	}
+	if len(report.Mismatched) > 0 {
+		return fmt.Errorf("%d of %d volumes failed verification", len(report.Mismatched), report.Volumes)
+	}
+	fmt.Printf("OK: %d volumes match their recorded digests\n", report.Volumes)
+	return nil
+}
+
+// handleHealth scores the health of every archive in a directory
+func handleHealth() error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	sample := fs.Float64("sample", 10, "percentage of each archive's entries to decode; 100 is a full verification")
+	maxAge := fs.Int("max-age-days", 30, "recommend re-verifying archives not fully verified for this many days")
+	applyFormat := addFormatFlags(fs)
+	retryPolicy := addRetryFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp health backups/ [--sample 10] [--max-age-days 30]")
+		os.Exit(1)
+	}
+	applyFormat()
+	if *sample < 0 || *sample > 100 {
+		return fmt.Errorf("invalid --sample %g: want a percentage from 0 to 100", *sample)
+	}
+	if *maxAge <= 0 {
+		return fmt.Errorf("invalid --max-age-days %d: want a positive number of days", *maxAge)
+	}
+
+	opts := core.Options{Retry: retryPolicy()}
+	defer printRetrySummary(opts.Retry)
+	labelOperation("Checking", args[0])
+	report, err := core.CheckHealth(args[0], *sample/100, time.Duration(*maxAge)*24*time.Hour, opts)
+	if err != nil {
+		return err
+	}
+
+	f := progress.CurrentFormat()
+	unhealthy := 0
+	for _, h := range report.Archives {
+		if !h.Healthy() {
+			unhealthy++
+		}
+		if h.Err != nil {
+			fmt.Printf("%3d  %s\n", h.Score, f.Name(h.Path))
+		} else {
+			details := fmt.Sprintf("%d entries, %d decoded, %s", h.Entries, h.Decoded, f.Size(uint64(h.Size)))
+			if !h.Created.IsZero() {
+				details += fmt.Sprintf(", %d days old", int(time.Since(h.Created).Hours()/24))
+			}
+			if h.LastVerified.IsZero() {
+				details += ", never fully verified"
+			} else {
+				details += fmt.Sprintf(", fully verified %s", h.LastVerified.Format(time.DateOnly))
+			}
+			fmt.Printf("%3d  %s (%s)\n", h.Score, f.Name(h.Path), details)
+		}
+		for _, failure := range h.Failures {
+			fmt.Println("     FAILED:", &core.EntryError{Path: f.Name(failure.Path), Op: failure.Op, Err: failure.Err})
+		}
+		for _, r := range h.Recommendations {
+			fmt.Printf("     %s: %s\n", r.Action, r.Reason)
+		}
+	}
+	if unhealthy > 0 {
+		return fmt.Errorf("%d of %d archives are damaged", unhealthy, len(report.Archives))
+	}
+	fmt.Printf("%d archives checked\n", len(report.Archives))
+	return nil
+}
+
+// handlePrune removes the archives of a backup directory that a retention
+// policy no longer keeps
+func handlePrune() error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	var policy core.RetentionPolicy
+	fs.IntVar(&policy.Daily, "keep-daily", 0, "keep the newest archive of each of the last n days that have one")
+	fs.IntVar(&policy.Weekly, "keep-weekly", 0, "keep the newest archive of each of the last n ISO weeks that have one")
+	fs.IntVar(&policy.Monthly, "keep-monthly", 0, "keep the newest archive of each of the last n months that have one")
+	fs.IntVar(&policy.Yearly, "keep-yearly", 0, "keep the newest archive of each of the last n years that have one")
+	fs.IntVar(&policy.Last, "keep-last", 0, "keep the n newest archives whatever their dates")
+	dryRun := fs.Bool("dry-run", false, "show what would be removed without removing anything")
+	applyFormat := addFormatFlags(fs)
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp prune backups/ [--keep-daily 7] [--keep-weekly 4] [--keep-monthly 12] [--keep-yearly n] [--keep-last n] [--dry-run]")
+		os.Exit(1)
+	}
+	applyFormat()
+
+	labelOperation("Pruning", args[0])
+	report, err := core.Prune(args[0], policy, *dryRun)
+	if report == nil {
+		return err
+	}
+	f := progress.CurrentFormat()
+	for _, a := range report.Archives {
+		action := "keep  "
+		if !a.Keep {
+			action = "remove"
+		}
+		details := f.Time(a.Time)
+		if len(a.Reasons) > 0 {
+			details += "; " + strings.Join(a.Reasons, ", ")
+		}
+		fmt.Printf("%s  %s (%s)\n", action, f.Name(a.Path), details)
+		if a.Err != nil {
+			fmt.Println("        FAILED:", a.Err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d of %d archives, freeing %s\n", verb, report.Removed, len(report.Archives), f.Size(uint64(report.Freed)))
+	return nil
+}
+
+// handleCheck reports entry paths that cannot be restored on a target OS
+func handleCheck() error {
+	defaultTarget := core.TargetLinux
+	if target, err := core.ParseTarget(runtime.GOOS); err == nil {
+		defaultTarget = target
+	}
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	applyFormat := addFormatFlags(fs)
+	targetName := fs.String("target", defaultTarget.String(), "OS the archive will be restored on: windows, linux or macos")
+	args, err := parseArgs(fs, os.Args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: ./agcp check input.agcp [--target windows|linux|macos] [--raw-names]")
+		os.Exit(1)
+	}
+	applyFormat()
+	target, err := core.ParseTarget(*targetName)
+	if err != nil {
+		return err
+	}
+
+	input, err := resolveArchiveInput(args[0])
+	if err != nil {
+		return err
+	}
+	report, err := core.CheckPaths(input, target)
+	if err != nil {
+		return err
+	}
+	f := progress.CurrentFormat()
+	for _, issue := range report.Issues {
+		fmt.Println("PROBLEM:", f.Name(issue.Path)+":", issue.Err) // Names in the problem are quoted
+	}
+	if len(report.Issues) > 0 {
+		return fmt.Errorf("%d of %d entries cannot be restored as-is on %s", len(report.Issues), report.Entries, target)
+	}
+	fmt.Printf("OK: %d entries can be restored on %s\n", report.Entries, target)
+	return nil
+}
@@ -1,29 +1,186 @@
-package core
-
-// Constants for archive format
-const (
-	Magic   = "AGCP" // Magic number to identify the archive
-	Version = 1      // Archive format version
-)
-
-// ArchiveType distinguishes between file and directory archives
-type ArchiveType byte
-
-const (
-	ArchiveFile ArchiveType = 0 // Single file archive
-	ArchiveDir  ArchiveType = 1 // Directory archive
-)
-
-// Entry holds file information for compression
-type Entry struct {
-	RelPath  string // Relative path within the archive
-	FilePath string // Full file path on disk
-}
-
-// DecompressTask defines a decompression job
-type DecompressTask struct {
-	RelPath        string // Relative path within the archive
-	OriginalSize   uint64 // Original uncompressed file size
-	CompressedSize uint64 // Compressed size in the archive
-	DestPath       string // Destination path for extraction
-}
+// Package core implements the AGCP archive format.
+//
+// Compress, Decompress and the other exported operations are safe to call
+// concurrently from multiple goroutines: each call keeps its own state and
+// progress tracker, and the only shared state is the read-mostly progress
+// configuration (test mode, operation name, output format). Concurrent calls
+// must not target the same output path.
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// Constants for archive format
+const (
+	Magic   = "AGCP" // Magic number to identify the archive
+	Version = 9      // Archive format version
+
+	TrailerMagic = "PCGA" // End-of-archive marker written after the entry data (v2+)
+	trailerSize  = 16     // headerLen(8) + headerCRC(4) + TrailerMagic(4)
+)
+
+// Format limits. Since v5 the entry count and path lengths are stored as
+// varints, so archives are bounded by these maxima rather than by the width of
+// a field; v1-v4 archives held at most 4294967295 entries with paths of up to
+// 65535 bytes. Compressing input beyond a limit fails with ErrFormatLimit.
+const (
+	MaxEntries  = math.MaxInt64        // Entries in one archive
+	MaxPathLen  = 1 << 20              // Bytes in an entry path or root name
+	MaxAttrsLen = math.MaxUint16       // Bytes in an entry's encoded attributes
+	MaxAlign    = math.MaxUint32/2 + 1 // Largest data alignment, 2 GiB
+	MaxInline   = 32 << 10             // Largest file stored inline in its entry record (Options.InlineMax)
+)
+
+// ErrFormatLimit is returned when input exceeds a limit of the archive format
+var ErrFormatLimit = errors.New("exceeds archive format limit")
+
+// ArchiveType distinguishes between file and directory archives
+type ArchiveType byte
+
+const (
+	ArchiveFile ArchiveType = 0 // Single file archive
+	ArchiveDir  ArchiveType = 1 // Directory archive
+)
+
+// Entry holds file information for compression
+type Entry struct {
+	RelPath  string // Relative path within the archive
+	FilePath string // Full file path on disk
+	Size     int64  // Size of the file when it was collected
+
+	attrs  entryAttrs // Attributes recorded in the entry table (v3+)
+	level  int        // Compression level, from Options.Level or the policy
+	kept   *keptData  // Data copied from an existing archive instead of compressing FilePath
+	stream bool       // FilePath is a pipe, whose size is known only once it has been read
+	device bool       // FilePath is a block device, whose size stat does not report
+	cache  *cacheSlot // Where to cache the compressed data, under Options.CacheDir
+	reader io.Reader  // Read instead of opening FilePath, for content given as a stream
+}
+
+// newEntry creates an entry for a file from the info gathered while collecting
+// it, so later stages need not stat it again
+func newEntry(relPath, filePath string, info os.FileInfo) Entry {
+	return Entry{RelPath: relPath, FilePath: filePath, Size: info.Size(), attrs: fileAttrs(info)}
+}
+
+// newStreamEntry creates the entry for a pipe given as input, such as the
+// /dev/fd path of a shell process substitution. Its size is filled in once the
+// pipe has been read to the end, and it is recorded as a regular file.
+func newStreamEntry(filePath string, info os.FileInfo) Entry {
+	entry := newEntry("", filePath, info)
+	entry.Size, entry.stream = 0, true
+	entry.attrs.mode = info.Mode().Perm()
+	return entry
+}
+
+// newReaderEntry creates the entry for content read from r, such as standard
+// input. Like a pipe's, its size is filled in once r has been read to the end.
+func newReaderEntry(r io.Reader) Entry {
+	attrs := entryAttrs{hasMode: true, mode: 0644, hasMtime: true, mtime: time.Now().UnixNano()}
+	return Entry{FilePath: "-", attrs: attrs, stream: true, reader: r}
+}
+
+// isStream reports whether info describes a pipe, whose content can be read
+// only once and whose size is unknown until then
+func isStream(info os.FileInfo) bool {
+	return info.Mode()&os.ModeNamedPipe != 0
+}
+
+// name returns the entry's path within the archive: the relative path, or the
+// root name for the single entry of a file archive
+func (e Entry) name(rootName string) string {
+	if e.RelPath == "" {
+		return rootName
+	}
+	return e.RelPath
+}
+
+// DecompressTask defines a decompression job
+type DecompressTask struct {
+	RelPath        string // Relative path within the archive
+	OriginalSize   uint64 // Original uncompressed file size
+	CompressedSize uint64 // Compressed size in the archive
+	DestPath       string // Destination path for extraction
+
+	name      string        // Entry path within the archive, for errors
+	attrs     entryAttrs    // Attributes recorded in the entry table (v3+)
+	offset    int64         // Offset of the compressed data in the archive
+	scan      ScanFunc      // Scan to pass the content through, if any
+	transform TransformFunc // Transform to rewrite the content with, if any
+
+	keepPartial bool        // Keep the file if its extraction fails
+	fsync       FsyncPolicy // Sync the file once written under FsyncPerFile
+	perms       bool        // Restore the archived permission bits
+	toDevice    bool        // Write over the existing device at DestPath in place
+	skipZeros   bool        // Seek past all-zero data instead of writing it
+
+	data         *io.SectionReader // Compressed data, for decoding blocks in parallel
+	blockWorkers int               // Goroutines to decode the entry's blocks on
+	stopped      func() error      // Stops decoding blocks once it returns an error, if set
+	pacer        *progress.Pacer   // Limits the rate the file is written at, if set
+}
+
+// checkFormatLimits checks that an archive of entries fits the format
+func checkFormatLimits(rootName string, entries []Entry, align int64) error {
+	if uint64(len(entries)) > MaxEntries {
+		return fmt.Errorf("%w: %d entries, the limit is %d", ErrFormatLimit, len(entries), uint64(MaxEntries))
+	}
+	if len(rootName) > MaxPathLen {
+		return fmt.Errorf("%w: root name is %d bytes, the limit is %d", ErrFormatLimit, len(rootName), MaxPathLen)
+	}
+	if align < 0 || align > MaxAlign || align&(align-1) != 0 {
+		return fmt.Errorf("alignment %d is not a power of two up to %d", align, uint64(MaxAlign))
+	}
+	for _, entry := range entries {
+		if len(entry.RelPath) > MaxPathLen {
+			return fmt.Errorf("%w: path of %s is %d bytes, the limit is %d", ErrFormatLimit, entry.RelPath, len(entry.RelPath), MaxPathLen)
+		}
+		if n := len(entry.attrs.encode()); n > MaxAttrsLen {
+			return fmt.Errorf("%w: attributes of %s are %d bytes, the limit is %d", ErrFormatLimit, entry.name(rootName), n, MaxAttrsLen)
+		}
+	}
+	return nil
+}
+
+// uvarintLen returns the encoded length of x as a varint
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// alignOffset rounds offset up to a multiple of align. An alignment of 0 or 1
+// leaves it unchanged.
+func alignOffset(offset int64, align uint32) int64 {
+	if align <= 1 {
+		return offset
+	}
+	a := int64(align)
+	return (offset + a - 1) / a * a
+}
+
+// padToAlignment writes zeros at the end of f until its size is a multiple of
+// align, leaving f positioned at the end
+func padToAlignment(f *os.File, align uint32) error {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("seek end: %w", err)
+	}
+	if pad := alignOffset(end, align) - end; pad > 0 {
+		if _, err := f.Write(make([]byte, pad)); err != nil {
+			return fmt.Errorf("write alignment padding: %w", err)
+		}
+	}
+	return nil
+}
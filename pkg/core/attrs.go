@@ -0,0 +1,279 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// attrTag identifies one attribute in an entry's attribute block (v3+).
+// Each attribute is stored as tag(1) + length(2) + value; readers skip tags
+// they don't know, so new attributes don't need a format version bump.
+type attrTag uint8
+
+const (
+	attrOwner  attrTag = 1 // uid(4) + gid(4) of the file when it was archived
+	attrCodec  attrTag = 2 // codec(1) the entry's data is encoded with
+	attrTags   attrTag = 3 // Repeated keyLen(2) + key + valueLen(2) + value user tags
+	attrMode   attrTag = 4 // mode(4): the file's os.FileMode bits
+	attrMtime  attrTag = 5 // mtime(8): modification time in nanoseconds since the Unix epoch
+	attrHash   attrTag = 6 // sha256(32) of the entry's uncompressed content
+	attrInline attrTag = 7 // The whole content of an entry stored inline (codec inline)
+	attrRaw    attrTag = 8 // Empty; the entry's name is not valid UTF-8 but the raw bytes of the original file name
+	attrType   attrTag = 9 // type(1): the entry's entryType; absent for regular files
+)
+
+// entryType identifies what kind of file an entry holds. Readers skip entries of
+// types they cannot restore, stepping over their data by its recorded size, so
+// later versions can archive new kinds of files without breaking older readers.
+type entryType uint8
+
+const (
+	typeRegular  entryType = 0 // Regular file; the default when no type attribute is present
+	typeSymlink  entryType = 1 // Symbolic link
+	typeDir      entryType = 2 // Directory
+	typeHardlink entryType = 3 // Hard link to another entry
+	typeSpecial  entryType = 4 // Device, named pipe or socket
+	// Types 5 and up are reserved. Content stored inline is a codec
+	// (codecInline), not an entry type.
+)
+
+// String returns the entry type name
+func (t entryType) String() string {
+	switch t {
+	case typeRegular:
+		return "regular"
+	case typeSymlink:
+		return "symlink"
+	case typeDir:
+		return "dir"
+	case typeHardlink:
+		return "hardlink"
+	case typeSpecial:
+		return "special"
+	}
+	return fmt.Sprintf("type %d", uint8(t))
+}
+
+// ErrEntryType is returned when reading an entry whose type this agcp cannot
+// restore, such as one written by a newer version. Extraction skips such
+// entries with a skipped-entry-type warning instead.
+var ErrEntryType = errors.New("entry type not supported")
+
+// codec identifies how an entry's data is encoded
+type codec uint8
+
+const (
+	codecLZ4    codec = 0 // LZ4 frame; the default when no codec attribute is present
+	codecStore  codec = 1 // Stored uncompressed
+	codecGzip   codec = 2 // Gzip member (compress/gzip from the standard library)
+	codecInline codec = 3 // Stored uncompressed in the inline attribute, with no data in the data area
+	codecZstd   codec = 4 // Zstandard frame with a content checksum (v8+)
+)
+
+// String returns the codec name
+func (c codec) String() string {
+	switch c {
+	case codecLZ4:
+		return "lz4"
+	case codecStore:
+		return "store"
+	case codecGzip:
+		return "gzip"
+	case codecInline:
+		return "inline"
+	case codecZstd:
+		return "zstd"
+	}
+	if custom := lookupCodec(c); custom != nil {
+		return custom.Name()
+	}
+	return fmt.Sprintf("codec %d", uint8(c))
+}
+
+// entryAttrs holds the optional per-entry attributes
+type entryAttrs struct {
+	hasOwner bool
+	uid, gid uint32
+
+	hasCodec bool
+	codec    codec
+
+	tags map[string]string // User tags from TagRules; nil if none
+
+	hasMode bool
+	mode    os.FileMode
+
+	hasMtime bool
+	mtime    int64 // Nanoseconds since the Unix epoch
+
+	hasHash bool
+	hash    [sha256.Size]byte
+
+	inline []byte // Content of an entry stored inline; nil otherwise
+
+	rawName bool // The entry's name is raw bytes that are not valid UTF-8
+
+	kind entryType // What kind of file the entry holds
+}
+
+// regular reports whether the entry is a regular file, the only type this
+// version reads the content of
+func (a entryAttrs) regular() bool {
+	return a.kind == typeRegular
+}
+
+// fileAttrs returns the attributes recorded for a file when it is archived:
+// its owner, if the platform has one, mode and modification time
+func fileAttrs(info os.FileInfo) entryAttrs {
+	a := fileOwner(info)
+	a.hasMode, a.mode = true, info.Mode()
+	a.hasMtime, a.mtime = true, info.ModTime().UnixNano()
+	return a
+}
+
+// encode serializes the attributes into an attribute block
+func (a entryAttrs) encode() []byte {
+	var buf []byte
+	if a.hasOwner {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint32(value[0:4], a.uid)
+		binary.BigEndian.PutUint32(value[4:8], a.gid)
+		buf = appendAttr(buf, attrOwner, value)
+	}
+	if a.hasCodec {
+		buf = appendAttr(buf, attrCodec, []byte{byte(a.codec)})
+	}
+	if len(a.tags) > 0 {
+		keys := make([]string, 0, len(a.tags))
+		for key := range a.tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var value []byte
+		for _, key := range keys {
+			value = binary.BigEndian.AppendUint16(value, uint16(len(key)))
+			value = append(value, key...)
+			value = binary.BigEndian.AppendUint16(value, uint16(len(a.tags[key])))
+			value = append(value, a.tags[key]...)
+		}
+		buf = appendAttr(buf, attrTags, value)
+	}
+	if a.hasMode {
+		buf = appendAttr(buf, attrMode, binary.BigEndian.AppendUint32(nil, uint32(a.mode)))
+	}
+	if a.hasMtime {
+		buf = appendAttr(buf, attrMtime, binary.BigEndian.AppendUint64(nil, uint64(a.mtime)))
+	}
+	if a.hasHash {
+		buf = appendAttr(buf, attrHash, a.hash[:])
+	}
+	if a.inline != nil {
+		buf = appendAttr(buf, attrInline, a.inline)
+	}
+	if a.rawName {
+		buf = appendAttr(buf, attrRaw, nil)
+	}
+	if a.kind != typeRegular {
+		buf = appendAttr(buf, attrType, []byte{byte(a.kind)})
+	}
+	return buf
+}
+
+// appendAttr appends one tag-length-value attribute to buf
+func appendAttr(buf []byte, tag attrTag, value []byte) []byte {
+	buf = append(buf, byte(tag))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
+
+// decodeAttrs parses an attribute block, ignoring unknown tags
+func decodeAttrs(b []byte) (entryAttrs, error) {
+	var a entryAttrs
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return a, fmt.Errorf("truncated attribute header")
+		}
+		tag := attrTag(b[0])
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return a, fmt.Errorf("attribute %d: length %d exceeds block", tag, n)
+		}
+		value := b[3 : 3+n]
+		b = b[3+n:]
+
+		switch tag {
+		case attrOwner:
+			if n != 8 {
+				return a, fmt.Errorf("owner attribute: length %d, want 8", n)
+			}
+			a.hasOwner = true
+			a.uid = binary.BigEndian.Uint32(value[0:4])
+			a.gid = binary.BigEndian.Uint32(value[4:8])
+		case attrCodec:
+			if n != 1 {
+				return a, fmt.Errorf("codec attribute: length %d, want 1", n)
+			}
+			a.hasCodec = true
+			a.codec = codec(value[0]) // Unknown codecs are reported when the entry is decoded
+		case attrTags:
+			tags, err := decodeTags(value)
+			if err != nil {
+				return a, fmt.Errorf("tags attribute: %w", err)
+			}
+			a.tags = tags
+		case attrMode:
+			if n != 4 {
+				return a, fmt.Errorf("mode attribute: length %d, want 4", n)
+			}
+			a.hasMode = true
+			a.mode = os.FileMode(binary.BigEndian.Uint32(value))
+		case attrMtime:
+			if n != 8 {
+				return a, fmt.Errorf("mtime attribute: length %d, want 8", n)
+			}
+			a.hasMtime = true
+			a.mtime = int64(binary.BigEndian.Uint64(value))
+		case attrHash:
+			if n != sha256.Size {
+				return a, fmt.Errorf("hash attribute: length %d, want %d", n, sha256.Size)
+			}
+			a.hasHash = true
+			copy(a.hash[:], value)
+		case attrInline:
+			a.inline = value
+		case attrRaw:
+			a.rawName = true
+		case attrType:
+			if n != 1 {
+				return a, fmt.Errorf("type attribute: length %d, want 1", n)
+			}
+			a.kind = entryType(value[0]) // Unknown types are skipped by readers
+		}
+	}
+	return a, nil
+}
+
+// decodeTags parses the value of a tags attribute
+func decodeTags(b []byte) (map[string]string, error) {
+	tags := make(map[string]string)
+	for len(b) > 0 {
+		var fields [2]string
+		for i := range fields {
+			if len(b) < 2 {
+				return nil, fmt.Errorf("truncated length")
+			}
+			n := int(binary.BigEndian.Uint16(b[0:2]))
+			if len(b) < 2+n {
+				return nil, fmt.Errorf("length %d exceeds attribute", n)
+			}
+			fields[i] = string(b[2 : 2+n])
+			b = b[2+n:]
+		}
+		tags[fields[0]] = fields[1]
+	}
+	return tags, nil
+}
@@ -0,0 +1,267 @@
+//go:build !nolz4
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pierrec/lz4/v4"
+
+	"agcp/pkg/progress"
+)
+
+// errBlockChecksum is returned for an LZ4 block whose checksum does not match
+var errBlockChecksum = errors.New("lz4: invalid block checksum")
+
+// lz4Block is one block of an LZ4 frame
+type lz4Block struct {
+	offset int64 // Offset of the block data in the entry's compressed data
+	size   int   // Stored size of the block data
+	stored bool  // The block data is stored uncompressed
+}
+
+// extractBlocks decodes an LZ4 entry's blocks on task.blockWorkers goroutines,
+// writing each into f at its offset with WriteAt, so a single large entry is
+// extracted on several cores. The blocks of frames written by agcp are
+// independent and carry their own checksums; it reports false, having written
+// nothing, for frames that lack either, which must be decoded as a stream.
+//
+// A block's offset in the file is only known once the blocks before it are
+// decoded, since a flushed frame may hold short blocks, so each worker waits
+// for its predecessors' sizes before writing; decoding itself overlaps freely.
+// The frame's content checksum is not checked: it covers the content in order.
+func extractBlocks(f *os.File, task DecompressTask, tracker *progress.Tracker) (bool, error) {
+	blockMax, blocks, err := lz4Blocks(task)
+	if err != nil || len(blocks) < 2 {
+		return false, err
+	}
+
+	workers := min(task.blockWorkers, len(blocks))
+	var (
+		next    atomic.Int64
+		mu      sync.Mutex
+		known   = sync.NewCond(&mu)
+		ends    = make([]int64, len(blocks)) // End offset of each decoded block
+		decoded = 0                          // Blocks whose end offset is known
+		failed  error
+		wg      sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if failed == nil {
+			failed = err
+		}
+		known.Broadcast()
+		mu.Unlock()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := make([]byte, blockMax+4) // Block data and its checksum
+			dst := make([]byte, blockMax)
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(blocks) {
+					return
+				}
+				if task.stopped != nil {
+					if err := task.stopped(); err != nil {
+						fail(err)
+						return
+					}
+				}
+				n, err := decodeLZ4Block(task, blocks[i], src, dst)
+				if err != nil {
+					fail(fmt.Errorf("copy %s: block %d of %d: %w", task.DestPath, i+1, len(blocks), err))
+					return
+				}
+				tracker.AddRead(uint64(blocks[i].size) + 8) // Block size and checksum
+
+				mu.Lock()
+				for decoded < i && failed == nil {
+					known.Wait()
+				}
+				if failed != nil {
+					mu.Unlock()
+					return
+				}
+				var start int64
+				if i > 0 {
+					start = ends[i-1]
+				}
+				ends[i] = start + int64(n)
+				decoded++
+				known.Broadcast()
+				mu.Unlock()
+
+				if uint64(ends[i]) > task.OriginalSize {
+					fail(fmt.Errorf("copy %s: expected %d bytes, got more", task.DestPath, task.OriginalSize))
+					return
+				}
+				if !task.skipZeros || !allZero(dst[:n]) {
+					if _, err := f.WriteAt(dst[:n], start); err != nil {
+						fail(fmt.Errorf("write %s: %w", task.DestPath, err))
+						return
+					}
+				}
+				tracker.AddBytes(uint64(n))
+				task.pacer.Wait(n)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed != nil {
+		return true, failed
+	}
+	if n := ends[len(ends)-1]; uint64(n) != task.OriginalSize {
+		return true, fmt.Errorf("copy %s: expected %d bytes, got %d", task.DestPath, task.OriginalSize, n)
+	}
+
+	// Count the frame header and end mark as read, and leave the data consumed
+	// as the stream decoder would
+	framing := task.data.Size()
+	for _, block := range blocks {
+		framing -= int64(block.size) + 8
+	}
+	tracker.AddRead(uint64(framing))
+	_, err = task.data.Seek(0, io.SeekEnd)
+	return true, err
+}
+
+// lz4Blocks reads the header of an LZ4 entry's frame and the size of each
+// block, returning the frame's maximum block size and its blocks. It returns
+// no blocks for frames whose blocks cannot be decoded on their own.
+func lz4Blocks(task DecompressTask) (int, []lz4Block, error) {
+	var hdr [15]byte
+	n, err := task.data.ReadAt(hdr[:], 0)
+	if n < 7 {
+		return 0, nil, nil // Too short to split; the stream decoder reports why
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != 0x184D2204 {
+		return 0, nil, nil
+	}
+	flags, bd := hdr[4], hdr[5]
+	version, independent, checksums := flags>>6, flags&0x20 != 0, flags&0x10 != 0
+	hasSize, hasDict := flags&0x08 != 0, flags&0x01 != 0
+	if version != 1 || !independent || !checksums || hasDict {
+		return 0, nil, nil
+	}
+	var blockMax int
+	switch bd >> 4 & 7 {
+	case 4:
+		blockMax = 64 << 10
+	case 5:
+		blockMax = 256 << 10
+	case 6:
+		blockMax = 1 << 20
+	case 7:
+		blockMax = 4 << 20
+	default:
+		return 0, nil, nil
+	}
+	descLen := 2
+	if hasSize {
+		descLen += 8
+	}
+	if n < 4+descLen+1 {
+		return 0, nil, nil
+	}
+	if hdr[4+descLen] != byte(xxh32(hdr[4:4+descLen])>>8) {
+		return 0, nil, fmt.Errorf("decode %s: lz4: invalid frame header checksum", task.DestPath)
+	}
+
+	var blocks []lz4Block
+	pos := int64(4 + descLen + 1)
+	size := task.data.Size()
+	for {
+		var word [4]byte
+		if _, err = task.data.ReadAt(word[:], pos); err != nil {
+			return 0, nil, fmt.Errorf("decode %s: read block size: %w", task.DestPath, err)
+		}
+		v := binary.LittleEndian.Uint32(word[:])
+		if v == 0 {
+			return blockMax, blocks, nil // End mark
+		}
+		block := lz4Block{offset: pos + 4, size: int(v &^ (1 << 31)), stored: v&(1<<31) != 0}
+		if block.size > blockMax || block.offset+int64(block.size)+4 > size {
+			return 0, nil, fmt.Errorf("decode %s: lz4: block %d of %d bytes overruns the frame", task.DestPath, len(blocks)+1, block.size)
+		}
+		blocks = append(blocks, block)
+		pos = block.offset + int64(block.size) + 4
+	}
+}
+
+// decodeLZ4Block checks one block's checksum and decodes it into dst, using
+// src to read it, and returns the decoded size
+func decodeLZ4Block(task DecompressTask, block lz4Block, src, dst []byte) (int, error) {
+	buf := src[:block.size+4]
+	if _, err := task.data.ReadAt(buf, block.offset); err != nil {
+		return 0, fmt.Errorf("read block: %w", err)
+	}
+	data := buf[:block.size]
+	if xxh32(data) != binary.LittleEndian.Uint32(buf[block.size:]) {
+		return 0, errBlockChecksum
+	}
+	if block.stored {
+		return copy(dst, data), nil
+	}
+	return lz4.UncompressBlock(data, dst)
+}
+
+// xxHash32 primes, for the checksums in LZ4 frames
+const (
+	xxhPrime1 = 2654435761
+	xxhPrime2 = 2246822519
+	xxhPrime3 = 3266489917
+	xxhPrime4 = 668265263
+	xxhPrime5 = 374761393
+)
+
+// xxh32 returns the xxHash32 of b with seed 0, the checksum LZ4 frames use
+func xxh32(b []byte) uint32 {
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		p1, p2 := uint32(xxhPrime1), uint32(xxhPrime2)
+		v1, v2, v3, v4 := p1+p2, p2, uint32(0), -p1
+		for len(b) >= 16 {
+			v1 = xxh32Round(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxh32Round(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxh32Round(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxh32Round(v4, binary.LittleEndian.Uint32(b[12:]))
+			b = b[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = xxhPrime5
+	}
+	h += uint32(n)
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+// xxh32Round mixes four bytes of input into an xxHash32 accumulator
+func xxh32Round(acc, input uint32) uint32 {
+	acc += input * xxhPrime2
+	return bits.RotateLeft32(acc, 13) * xxhPrime1
+}
@@ -0,0 +1,14 @@
+//go:build nolz4
+
+package core
+
+import (
+	"os"
+
+	"agcp/pkg/progress"
+)
+
+// extractBlocks reports false: without LZ4 support no entry is split into blocks
+func extractBlocks(f *os.File, task DecompressTask, tracker *progress.Tracker) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"os"
+	"sync"
+)
+
+// ioBudget bounds the bytes that extraction workers may have in flight
+// (written but not yet flushed to disk) across all workers
+type ioBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	avail int64
+}
+
+// newIOBudget creates a budget of n bytes, or nil when n <= 0 (unbounded)
+func newIOBudget(n int64) *ioBudget {
+	if n <= 0 {
+		return nil
+	}
+	b := &ioBudget{total: n, avail: n}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// tryAcquire takes n bytes of budget if they are available right now
+func (b *ioBudget) tryAcquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.avail < n {
+		return false
+	}
+	b.avail -= n
+	return true
+}
+
+// acquire blocks until n bytes of budget are available and takes them
+func (b *ioBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.avail < n {
+		b.cond.Wait()
+	}
+	b.avail -= n
+}
+
+// release returns n bytes to the budget
+func (b *ioBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.avail += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// budgetWriter writes to a file while holding budget for every byte that has
+// not been flushed yet. Before blocking on the budget it flushes its own data and
+// releases what it holds, so workers can never deadlock waiting on each other.
+type budgetWriter struct {
+	f      *os.File
+	budget *ioBudget
+	held   int64
+}
+
+// Write implements io.Writer
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if int64(len(chunk)) > bw.budget.total {
+			chunk = chunk[:bw.budget.total]
+		}
+		n := int64(len(chunk))
+
+		if !bw.budget.tryAcquire(n) {
+			if err := bw.flush(); err != nil {
+				return written, err
+			}
+			bw.budget.acquire(n)
+		}
+		bw.held += n
+
+		m, err := bw.f.Write(chunk)
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[m:]
+	}
+	return written, nil
+}
+
+// flush syncs the file and releases all budget held for it
+func (bw *budgetWriter) flush() error {
+	if bw.held == 0 {
+		return nil
+	}
+	err := bw.f.Sync()
+	bw.budget.release(bw.held)
+	bw.held = 0
+	return err
+}
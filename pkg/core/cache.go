@@ -0,0 +1,223 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A compression cache (Options.CacheDir) holds the compressed data of each
+// file compressed with it, so compressing a mostly unchanged tree again copies
+// the data of unchanged files instead of compressing them, as UpdateArchive
+// does from the archive itself. Each file has one cache file, named after the
+// SHA-256 of its absolute path, which a changed file replaces:
+//
+//	magic(8) + source size(8) + source mtime(8) + codec(1) + level(8) +
+//	original size(8) + SHA-256(32) + CRC-32 of the data(4) + compressed data
+//
+// A file is unchanged when its size and modification time match the ones
+// recorded; the codec and level must match what it would be compressed with.
+// The cache is only ever an optimization: an unreadable or damaged cache file
+// is a miss, and a cache file that cannot be written is skipped.
+const cacheMagic = "AGCPCAC1"
+
+// cacheHeaderSize is the size of a cache file's header
+const cacheHeaderSize = 8 + 8 + 8 + 1 + 8 + 8 + sha256.Size + 4
+
+// cacheSlot is where an entry's compressed data is cached, and the state of
+// the file it was collected with
+type cacheSlot struct {
+	path  string // Cache file
+	size  int64  // Source size
+	mtime int64  // Source modification time
+	level int
+}
+
+// cacheHeader is the decoded header of a cache file
+type cacheHeader struct {
+	size, mtime  int64
+	codec        codec
+	level        int
+	originalSize uint64
+	hash         [sha256.Size]byte
+	crc          uint32
+}
+
+// encode returns the header as written at the start of a cache file
+func (h *cacheHeader) encode() []byte {
+	b := make([]byte, 0, cacheHeaderSize)
+	b = append(b, cacheMagic...)
+	b = binary.BigEndian.AppendUint64(b, uint64(h.size))
+	b = binary.BigEndian.AppendUint64(b, uint64(h.mtime))
+	b = append(b, byte(h.codec))
+	b = binary.BigEndian.AppendUint64(b, uint64(int64(h.level)))
+	b = binary.BigEndian.AppendUint64(b, h.originalSize)
+	b = append(b, h.hash[:]...)
+	return binary.BigEndian.AppendUint32(b, h.crc)
+}
+
+// decodeCacheHeader parses a cache file's header, reporting false if it is
+// not one
+func decodeCacheHeader(b []byte) (cacheHeader, bool) {
+	var h cacheHeader
+	if len(b) != cacheHeaderSize || string(b[:8]) != cacheMagic {
+		return h, false
+	}
+	b = b[8:]
+	h.size = int64(binary.BigEndian.Uint64(b[0:]))
+	h.mtime = int64(binary.BigEndian.Uint64(b[8:]))
+	h.codec = codec(b[16])
+	h.level = int(int64(binary.BigEndian.Uint64(b[17:])))
+	h.originalSize = binary.BigEndian.Uint64(b[25:])
+	copy(h.hash[:], b[33:])
+	h.crc = binary.BigEndian.Uint32(b[33+sha256.Size:])
+	return h, true
+}
+
+// openCache prepares opts.CacheDir for use, reporting false, with a warning,
+// if it cannot be
+func openCache(opts Options) bool {
+	if opts.CacheDir == "" {
+		return false
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0700); err != nil {
+		opts.warn(WarnCacheUnavailable, opts.CacheDir, fmt.Sprintf("compressing without the cache: %v", err))
+		return false
+	}
+	return true
+}
+
+// lookupCache makes entry copy its compressed data from the cache if the
+// cache holds it for the file's current size and modification time and the
+// entry's codec and level, and otherwise gives entry the slot to cache it in
+func lookupCache(entry *Entry, dir string) {
+	if entry.stream || entry.device || !entry.attrs.regular() || !entry.attrs.hasMtime {
+		return // Nothing says whether these changed
+	}
+	abs, err := filepath.Abs(entry.FilePath)
+	if err != nil {
+		return
+	}
+	key := sha256.Sum256([]byte(abs))
+	name := hex.EncodeToString(key[:])
+	slot := &cacheSlot{
+		path:  filepath.Join(dir, name[:2], name),
+		size:  entry.Size,
+		mtime: entry.attrs.mtime,
+		level: entry.level,
+	}
+	if h, size, ok := readCacheFile(slot.path); ok && h.size == slot.size && h.mtime == slot.mtime &&
+		h.codec == entry.attrs.codec && h.level == slot.level && h.originalSize == uint64(entry.Size) {
+		entry.attrs.hash = h.hash
+		entry.kept = &keptData{path: slot.path, offset: cacheHeaderSize, size: uint64(size), cached: true}
+		return
+	}
+	entry.cache = slot
+}
+
+// readCacheFile reads a cache file's header and checks its data, returning
+// the header and the size of the data
+func readCacheFile(path string) (cacheHeader, int64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cacheHeader{}, 0, false
+	}
+	defer f.Close()
+	b := make([]byte, cacheHeaderSize)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return cacheHeader{}, 0, false
+	}
+	h, ok := decodeCacheHeader(b)
+	if !ok {
+		return h, 0, false
+	}
+	crc := crc32.NewIEEE()
+	size, err := io.Copy(crc, f)
+	if err != nil || crc.Sum32() != h.crc {
+		return h, 0, false
+	}
+	return h, size, true
+}
+
+// cacheWriter copies an entry's compressed data into a new cache file as it
+// is written to the archive. Failing to write the cache never fails the
+// entry: the cache file is dropped instead.
+type cacheWriter struct {
+	slot    *cacheSlot
+	f       *os.File
+	crc     hash.Hash32
+	release func()
+	err     error
+}
+
+// newCacheWriter starts a cache file for slot, returning nil if it cannot
+func newCacheWriter(slot *cacheSlot) *cacheWriter {
+	if err := os.MkdirAll(filepath.Dir(slot.path), 0700); err != nil {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(slot.path), ".tmp-*")
+	if err != nil {
+		return nil
+	}
+	cw := &cacheWriter{slot: slot, f: f, crc: crc32.NewIEEE(), release: trackFile(f.Name(), cleanupTemp)}
+	if _, cw.err = f.Seek(cacheHeaderSize, io.SeekStart); cw.err != nil {
+		cw.discard()
+		return nil
+	}
+	return cw
+}
+
+// Write implements io.Writer, always reporting success
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.err == nil {
+		_, cw.err = cw.f.Write(p)
+		cw.crc.Write(p)
+	}
+	return len(p), nil
+}
+
+// commit completes the cache file for an entry compressed with codec id from
+// originalSize bytes with the given hash, replacing the file's previous cache
+// file. Data read from a file that changed since it was collected is not
+// cached.
+func (cw *cacheWriter) commit(id codec, originalSize uint64, sum [sha256.Size]byte) {
+	if cw.err != nil || originalSize != uint64(cw.slot.size) {
+		cw.discard()
+		return
+	}
+	h := cacheHeader{
+		size:         cw.slot.size,
+		mtime:        cw.slot.mtime,
+		codec:        id,
+		level:        cw.slot.level,
+		originalSize: originalSize,
+		hash:         sum,
+		crc:          cw.crc.Sum32(),
+	}
+	if _, err := cw.f.WriteAt(h.encode(), 0); err != nil {
+		cw.discard()
+		return
+	}
+	if err := cw.f.Close(); err != nil {
+		cw.discard()
+		return
+	}
+	if err := os.Rename(cw.f.Name(), cw.slot.path); err != nil {
+		cw.discard()
+		return
+	}
+	cw.release()
+}
+
+// discard removes the unfinished cache file
+func (cw *cacheWriter) discard() {
+	cw.f.Close()
+	os.Remove(cw.f.Name())
+	cw.release()
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Target is an operating system whose file name rules CheckPaths applies
+type Target int
+
+const (
+	TargetLinux Target = iota
+	TargetMacOS
+	TargetWindows
+)
+
+// ParseTarget parses a target name: "linux", "macos" (or "darwin") or "windows"
+func ParseTarget(name string) (Target, error) {
+	switch strings.ToLower(name) {
+	case "linux":
+		return TargetLinux, nil
+	case "macos", "darwin":
+		return TargetMacOS, nil
+	case "windows":
+		return TargetWindows, nil
+	}
+	return 0, fmt.Errorf("unknown target %q: want linux, macos or windows", name)
+}
+
+// String returns the target's name
+func (t Target) String() string {
+	switch t {
+	case TargetMacOS:
+		return "macos"
+	case TargetWindows:
+		return "windows"
+	}
+	return "linux"
+}
+
+// Path limits of each target: the longest path the usual APIs accept, and the
+// longest single name. Windows lengths count UTF-16 code units, the others bytes.
+const (
+	maxPathLinux   = 4096
+	maxPathMacOS   = 1024
+	maxPathWindows = 260
+	maxNameLength  = 255
+)
+
+// windowsReservedNames are device names Windows refuses as file names, with or
+// without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CheckReport summarizes the result of CheckPaths
+type CheckReport struct {
+	Entries int           // Entries checked
+	Issues  []*EntryError // Entries that cannot be restored as-is, in archive order
+}
+
+// CheckPaths checks that every entry of an archive can be restored on target
+// before an extraction fails midway: names with characters the target
+// reserves, names Windows refuses (device names, trailing dots and spaces),
+// names or paths longer than the target allows, and, on the case-insensitive
+// defaults of Windows and macOS, paths differing only in case. Paths are
+// checked as extracted under the archive's root directory.
+func CheckPaths(archivePath string, target Target) (*CheckReport, error) {
+	f, idx, err := openIndex(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	report := &CheckReport{Entries: len(idx.entries)}
+	seen := make(map[string]string, len(idx.entries))
+	for _, entry := range idx.entries {
+		name := idx.name(entry)
+		path := name
+		if idx.archiveType == ArchiveDir {
+			path = idx.rootName + "/" + entry.relPath
+		}
+		problem := checkTargetPath(path, target)
+		if problem == "" && target != TargetLinux {
+			folded := strings.ToLower(strings.ReplaceAll(path, "\\", "/"))
+			if other, ok := seen[folded]; ok {
+				problem = fmt.Sprintf("differs from %q only in case, so both restore to the same file on %s", other, target)
+			} else {
+				seen[folded] = name
+			}
+		}
+		if problem != "" {
+			report.Issues = append(report.Issues, &EntryError{Path: name, Op: "check", Err: fmt.Errorf("%s", problem)})
+		}
+	}
+	return report, nil
+}
+
+// checkTargetPath returns why path cannot be created on target, or ""
+func checkTargetPath(path string, target Target) string {
+	if strings.ContainsRune(path, 0) {
+		return "name contains a NUL byte"
+	}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if problem := checkTargetName(part, target); problem != "" {
+			return fmt.Sprintf("%q: %s", part, problem)
+		}
+	}
+	switch target {
+	case TargetWindows:
+		if n := len(utf16.Encode([]rune(path))); n >= maxPathWindows {
+			return fmt.Sprintf("path is %d characters; Windows limits paths to %d unless long paths are enabled", n, maxPathWindows-1)
+		}
+	case TargetMacOS:
+		if len(path) >= maxPathMacOS {
+			return fmt.Sprintf("path is %d bytes; macOS limits paths to %d", len(path), maxPathMacOS-1)
+		}
+	default:
+		if len(path) >= maxPathLinux {
+			return fmt.Sprintf("path is %d bytes; Linux limits paths to %d", len(path), maxPathLinux-1)
+		}
+	}
+	return ""
+}
+
+// checkTargetName returns why a single path component cannot be used as a
+// file name on target, or ""
+func checkTargetName(name string, target Target) string {
+	if target != TargetWindows {
+		if len(name) > maxNameLength {
+			return fmt.Sprintf("name is %d bytes; the limit is %d", len(name), maxNameLength)
+		}
+		if target == TargetMacOS && !utf8.ValidString(name) {
+			return "name is not valid UTF-8"
+		}
+		return ""
+	}
+
+	if !utf8.ValidString(name) {
+		return "name is not valid UTF-8, which Windows cannot store as-is"
+	}
+	if n := len(utf16.Encode([]rune(name))); n > maxNameLength {
+		return fmt.Sprintf("name is %d characters; the limit is %d", n, maxNameLength)
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+			return fmt.Sprintf("name contains %q, which Windows reserves", r)
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "name ends with a dot or space, which Windows strips"
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return "name is a device name Windows reserves"
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"os"
+	"sync"
+)
+
+// cleanupKind says how Cleanup treats a registered file
+type cleanupKind int
+
+const (
+	cleanupTemp    cleanupKind = iota // Scratch file, always removed
+	cleanupPartial                    // Partially written output, kept if asked to
+)
+
+// cleanupRegistry tracks the files that running operations have created and
+// not yet renamed into place or removed, so that a process interrupted by a
+// signal can remove them before it exits
+type cleanupRegistry struct {
+	mu    sync.Mutex
+	next  int
+	files map[int]trackedFile
+}
+
+// trackedFile is one registered file
+type trackedFile struct {
+	path   string
+	kind   cleanupKind
+	remove func() error // Removes it instead of os.Remove, if set
+}
+
+// cleanups is the registry of the running process
+var cleanups = &cleanupRegistry{files: make(map[int]trackedFile)}
+
+// trackFile registers a file for Cleanup and returns the function that
+// unregisters it, to be called once the file has been renamed into place or
+// removed by its operation
+func trackFile(path string, kind cleanupKind) (release func()) {
+	return track(trackedFile{path: path, kind: kind})
+}
+
+// trackRemoval registers something that is not a plain file, such as a
+// snapshot, for Cleanup to remove with remove
+func trackRemoval(path string, remove func() error) (release func()) {
+	return track(trackedFile{path: path, kind: cleanupTemp, remove: remove})
+}
+
+// track registers file and returns the function that unregisters it
+func track(file trackedFile) (release func()) {
+	cleanups.mu.Lock()
+	id := cleanups.next
+	cleanups.next++
+	cleanups.files[id] = file
+	cleanups.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cleanups.mu.Lock()
+			delete(cleanups.files, id)
+			cleanups.mu.Unlock()
+		})
+	}
+}
+
+// discardPartial removes a partial output left by a failed operation unless
+// keep is set, and unregisters it either way
+func discardPartial(path string, keep bool, release func()) {
+	if !keep {
+		os.Remove(path)
+	}
+	release()
+}
+
+// RegisterTemp registers a temporary file created outside this package, such as
+// a staging file, for removal by Cleanup. Call the returned function once the
+// file has been removed.
+func RegisterTemp(path string) (release func()) {
+	return trackFile(path, cleanupTemp)
+}
+
+// Cleanup removes the temporary files and partial outputs of every operation
+// still running, for a process about to exit on a signal. Partial outputs (see
+// PartialPath, and files being extracted) are kept if keepPartial is set, so an
+// interrupted compression can be resumed. It returns the paths removed.
+//
+// Operations remove their own files when they fail; Cleanup is only needed when
+// they are cut short.
+func Cleanup(keepPartial bool) []string {
+	cleanups.mu.Lock()
+	defer cleanups.mu.Unlock()
+	var removed []string
+	for id, file := range cleanups.files {
+		if file.kind == cleanupPartial && keepPartial {
+			continue
+		}
+		remove := file.remove
+		if remove == nil {
+			remove = func() error { return os.Remove(file.path) }
+		}
+		if err := remove(); err == nil {
+			removed = append(removed, file.path)
+		}
+		delete(cleanups.files, id)
+	}
+	return removed
+}
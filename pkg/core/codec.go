@@ -0,0 +1,147 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Codec selects how compressed entry data is encoded
+type Codec uint8
+
+const (
+	CodecDefault Codec = iota // LZ4, or gzip in builds without LZ4 (the nolz4 build tag)
+	CodecLZ4                  // LZ4 frames: fast, the format's native codec
+	CodecGzip                 // Gzip from the standard library: slower, but needs no third-party code
+	CodecZstd                 // Zstandard: much better ratios than LZ4 on text, at some cost in speed
+)
+
+// ParseCodec parses a codec name: "lz4", "gzip", "zstd" or the name of a
+// registered codec
+func ParseCodec(name string) (Codec, error) {
+	if c, err := parseBuiltinCodec(name); err == nil {
+		if err := c.available(); err != nil {
+			return 0, err
+		}
+		return c, nil
+	}
+	codecsMu.RLock()
+	custom := lookupCodecName(name)
+	codecsMu.RUnlock()
+	if custom != nil {
+		return Codec(custom.ID()), nil
+	}
+	want := strings.Join(append([]string{"lz4", "gzip", "zstd"}, registeredCodecNames()...), ", ")
+	return 0, fmt.Errorf("unknown codec %q (want one of %s)", name, want)
+}
+
+// Errors for built-in codecs left out of the build by a build tag
+var (
+	errNoLZ4  = errors.New("LZ4 codec not available: agcp was built with the nolz4 tag")
+	errNoZstd = errors.New("zstd codec not available: agcp was built with the nozstd tag")
+)
+
+// available returns an error if c is a built-in codec left out of this build
+func (c Codec) available() error {
+	switch {
+	case c == CodecLZ4 && !lz4Builtin:
+		return errNoLZ4
+	case c == CodecZstd && !zstdBuiltin:
+		return errNoZstd
+	}
+	return nil
+}
+
+// parseBuiltinCodec parses the name of a codec built into agcp
+func parseBuiltinCodec(name string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "default":
+		return CodecDefault, nil
+	case "lz4":
+		return CodecLZ4, nil
+	case "gzip":
+		return CodecGzip, nil
+	case "zstd":
+		return CodecZstd, nil
+	}
+	return 0, fmt.Errorf("unknown codec %q", name)
+}
+
+// String returns the codec name
+func (c Codec) String() string {
+	return c.resolve().String()
+}
+
+// resolve returns the on-disk codec written for c
+func (c Codec) resolve() codec {
+	switch c {
+	case CodecLZ4:
+		return codecLZ4
+	case CodecGzip:
+		return codecGzip
+	case CodecZstd:
+		return codecZstd
+	}
+	if c >= FirstCustomCodecID {
+		return codec(c) // Registered codecs are selected by their ID
+	}
+	return defaultCodec
+}
+
+// flushWriteCloser is a codec writer; Flush completes the data written so far
+// so the compressed size can be measured mid-stream
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// entryEncoder returns a writer encoding an entry's data according to its codec.
+// level is as for Options.Level: 0 is the fast default, 1 to 9 compress harder.
+func entryEncoder(w io.Writer, attrs entryAttrs, level int) (flushWriteCloser, error) {
+	switch attrs.codec {
+	case codecStore:
+		return nopFlusher{w}, nil
+	case codecGzip:
+		gzipLevel := gzip.BestSpeed
+		if level > 0 {
+			gzipLevel = min(level, gzip.BestCompression)
+		}
+		zw, err := gzip.NewWriterLevel(w, gzipLevel)
+		if err != nil {
+			return nil, fmt.Errorf("set compression level: %w", err)
+		}
+		return zw, nil
+	case codecLZ4:
+		return newLZ4Writer(w, level)
+	case codecZstd:
+		return newZstdWriter(w, level)
+	default:
+		return customEncoder(w, attrs.codec, level)
+	}
+}
+
+// entryDecoder returns a reader decoding an entry's data according to its codec
+func entryDecoder(r io.Reader, attrs entryAttrs) (io.Reader, error) {
+	switch attrs.codec {
+	case codecStore:
+		return r, nil
+	case codecGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("read gzip header: %w", err)
+		}
+		zr.Multistream(false)
+		return zr, nil
+	case codecInline:
+		return bytes.NewReader(attrs.inline), nil
+	case codecLZ4:
+		return newLZ4Reader(r)
+	case codecZstd:
+		return newZstdReader(r)
+	default:
+		return customDecoder(r, attrs.codec)
+	}
+}
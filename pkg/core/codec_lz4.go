@@ -0,0 +1,45 @@
+//go:build !nolz4
+
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// defaultCodec is the codec written when Options.Codec is CodecDefault
+const defaultCodec = codecLZ4
+
+// lz4Builtin reports whether LZ4 support is built in
+const lz4Builtin = true
+
+// newLZ4Writer returns an LZ4 frame writer at the given Options.Level. Blocks
+// carry their own checksums, so corruption is caught in the block it occurs in.
+func newLZ4Writer(w io.Writer, level int) (flushWriteCloser, error) {
+	lw := lz4.NewWriter(w)
+	if err := lw.Apply(lz4.CompressionLevelOption(lz4Level(level))); err != nil {
+		return nil, fmt.Errorf("set compression level: %w", err)
+	}
+	if err := lw.Apply(lz4.BlockSizeOption(lz4.Block4Mb), lz4.BlockChecksumOption(true)); err != nil {
+		return nil, fmt.Errorf("set block checksums: %w", err)
+	}
+	return lw, nil
+}
+
+// newLZ4Reader returns an LZ4 frame reader
+func newLZ4Reader(r io.Reader) (io.Reader, error) {
+	return lz4.NewReader(r), nil
+}
+
+// lz4Level maps Options.Level to an LZ4 compression level
+func lz4Level(level int) lz4.CompressionLevel {
+	if level <= 0 {
+		return lz4.Fast
+	}
+	if level > 9 {
+		level = 9
+	}
+	return lz4.CompressionLevel(1 << (8 + level)) // lz4.Level1 .. lz4.Level9
+}
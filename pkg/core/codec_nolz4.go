@@ -0,0 +1,21 @@
+//go:build nolz4
+
+package core
+
+import "io"
+
+// defaultCodec is the codec written when Options.Codec is CodecDefault
+const defaultCodec = codecGzip
+
+// lz4Builtin reports whether LZ4 support is built in
+const lz4Builtin = false
+
+// newLZ4Writer fails: LZ4 support is not built in
+func newLZ4Writer(w io.Writer, level int) (flushWriteCloser, error) {
+	return nil, errNoLZ4
+}
+
+// newLZ4Reader fails: LZ4 support is not built in
+func newLZ4Reader(r io.Reader) (io.Reader, error) {
+	return nil, errNoLZ4
+}
@@ -0,0 +1,18 @@
+//go:build nozstd
+
+package core
+
+import "io"
+
+// zstdBuiltin reports whether zstd support is built in
+const zstdBuiltin = false
+
+// newZstdWriter fails: zstd support is not built in
+func newZstdWriter(w io.Writer, level int) (flushWriteCloser, error) {
+	return nil, errNoZstd
+}
+
+// newZstdReader fails: zstd support is not built in
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	return nil, errNoZstd
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FirstCustomCodecID is the lowest ID a registered codec may use; lower IDs are
+// reserved for codecs built into agcp
+const FirstCustomCodecID = 128
+
+// ErrCodecUnavailable is returned when an entry uses a codec that is neither
+// built in nor registered; the error reads "codec N not available"
+var ErrCodecUnavailable = errors.New("not available")
+
+// CustomCodec is a codec supplied by the program embedding agcp and made
+// available with RegisterCodec. Archives record only its ID, so reading them
+// needs the same codec registered under the same ID.
+type CustomCodec interface {
+	// ID identifies the codec in archives: FirstCustomCodecID to 255, and
+	// fixed for good once archives using it exist
+	ID() uint8
+	// Name is the codec's name in ParseCodec, policies and listings
+	Name() string
+	// NewWriter returns a writer encoding to w at level, as for Options.Level.
+	// If the writer has a Flush method, Options.MinRatio can measure entries
+	// mid-stream; otherwise it measures them once they are complete.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader returns a reader decoding the data of one entry from r
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+// Registered codecs, by ID
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[codec]CustomCodec{}
+)
+
+// RegisterCodec makes c available for compression and extraction, returning
+// the Codec to select it with in Options.Codec. It fails if the ID is reserved
+// or the ID or name is already taken. Register codecs before starting any
+// operation, typically from an init function.
+func RegisterCodec(c CustomCodec) (Codec, error) {
+	id, name := codec(c.ID()), strings.ToLower(c.Name())
+	if id < FirstCustomCodecID {
+		return 0, fmt.Errorf("register codec %q: ID %d is reserved for built-in codecs", name, id)
+	}
+	if name == "" {
+		return 0, fmt.Errorf("register codec %d: empty name", id)
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if existing, ok := codecs[id]; ok {
+		return 0, fmt.Errorf("register codec %q: ID %d is already used by %q", name, id, existing.Name())
+	}
+	if _, err := parseBuiltinCodec(name); err == nil || lookupCodecName(name) != nil {
+		return 0, fmt.Errorf("register codec %q: name already in use", name)
+	}
+	codecs[id] = c
+	return Codec(id), nil
+}
+
+// lookupCodec returns the codec registered under id, or nil
+func lookupCodec(id codec) CustomCodec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[id]
+}
+
+// lookupCodecName returns the registered codec named name, or nil. The caller
+// holds codecsMu.
+func lookupCodecName(name string) CustomCodec {
+	for _, c := range codecs {
+		if strings.EqualFold(c.Name(), name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// registeredCodecNames returns the names of the registered codecs, sorted
+func registeredCodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// customEncoder returns a writer encoding with the registered codec id
+func customEncoder(w io.Writer, id codec, level int) (flushWriteCloser, error) {
+	c := lookupCodec(id)
+	if c == nil {
+		return nil, fmt.Errorf("%s %w", id, ErrCodecUnavailable)
+	}
+	cw, err := c.NewWriter(w, level)
+	if err != nil {
+		return nil, fmt.Errorf("%s writer: %w", c.Name(), err)
+	}
+	if fw, ok := cw.(flushWriteCloser); ok {
+		return fw, nil
+	}
+	return nopFlushCloser{cw}, nil
+}
+
+// customDecoder returns a reader decoding with the registered codec id
+func customDecoder(r io.Reader, id codec) (io.Reader, error) {
+	c := lookupCodec(id)
+	if c == nil {
+		return nil, fmt.Errorf("%s %w", id, ErrCodecUnavailable)
+	}
+	cr, err := c.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s reader: %w", c.Name(), err)
+	}
+	return cr, nil
+}
+
+// nopFlushCloser adapts a codec writer without a Flush method
+type nopFlushCloser struct{ io.WriteCloser }
+
+// Flush implements the codec writer interface; data buffered by the codec is
+// only counted once the writer is closed
+func (nopFlushCloser) Flush() error { return nil }
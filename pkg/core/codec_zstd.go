@@ -0,0 +1,47 @@
+//go:build !nozstd
+
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdBuiltin reports whether zstd support is built in
+const zstdBuiltin = true
+
+// newZstdWriter returns a zstd frame writer at the given Options.Level.
+// Entries are compressed in parallel already, so each encoder uses one
+// goroutine; frames carry a checksum of their content.
+func newZstdWriter(w io.Writer, level int) (flushWriteCloser, error) {
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("set compression level: %w", err)
+	}
+	return zw, nil
+}
+
+// newZstdReader returns a zstd frame reader. With a concurrency of one it
+// decodes on the calling goroutine, so it needs no closing.
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("read zstd frame: %w", err)
+	}
+	return zr, nil
+}
+
+// zstdLevel maps Options.Level (0 to 9) to the nearest zstd encoder preset.
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 0:
+		return zstd.SpeedFastest
+	case level <= 3:
+		return zstd.SpeedDefault
+	case level <= 6:
+		return zstd.SpeedBetterCompression
+	}
+	return zstd.SpeedBestCompression
+}
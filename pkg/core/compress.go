@@ -1,240 +1,747 @@
-package core
-
-import (
-	"encoding/binary"
-	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-
-	"agcp/pkg/progress"
-
-	"github.com/pierrec/lz4/v4"
-)
-
-// Compress handles the compression process for files or directories
-func Compress(input, output string) error {
-	info, err := os.Stat(input)
-	if err != nil {
-		return fmt.Errorf("stat input: %w", err)
-	}
-
-	var archiveType ArchiveType
-	var rootName string
-	var entries []Entry
-	if info.IsDir() {
-		archiveType = ArchiveDir
-		rootName = filepath.Base(input)
-		entries, err = collectDirEntries(input)
-		if err != nil {
-			return fmt.Errorf("collect entries: %w", err)
-		}
-	} else {
-		archiveType = ArchiveFile
-		rootName = filepath.Base(input)
-		entries = []Entry{{RelPath: "", FilePath: input}}
-	}
-
-	// Calculate total size for progress
-	totalSize := calculateTotalSize(entries)
-	progress.Init(totalSize)
-	defer progress.Stop()
-
-	return compressFiles(entries, output, archiveType, rootName)
-}
-
-// calculateTotalSize calculates the total size of all files to be compressed
-func calculateTotalSize(entries []Entry) uint64 {
-	var totalSize uint64
-	for _, entry := range entries {
-		info, err := os.Stat(entry.FilePath)
-		if err != nil {
-			continue
-		}
-		totalSize += uint64(info.Size())
-	}
-	if totalSize == 0 {
-		totalSize = 1 // Avoid division by zero
-	}
-	return totalSize
-}
-
-// collectDirEntries gathers all files in a directory with relative paths
-func collectDirEntries(root string) ([]Entry, error) {
-	var entries []Entry
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(root, path)
-			if err != nil {
-				return fmt.Errorf("relative path for %s: %w", path, err)
-			}
-			entries = append(entries, Entry{RelPath: relPath, FilePath: path})
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("walk directory %s: %w", root, err)
-	}
-	return entries, nil
-}
-
-// compressFiles compresses files using LZ4 streaming and writes to the archive
-func compressFiles(entries []Entry, output string, archiveType ArchiveType, rootName string) error {
-	// Clean up existing output file
-	if _, err := os.Stat(output); err == nil {
-		if err := os.Remove(output); err != nil {
-			return fmt.Errorf("remove existing output: %w", err)
-		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("check output existence: %w", err)
-	}
-
-	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
-		return fmt.Errorf("create output directory: %w", err)
-	}
-
-	f, err := os.Create(output)
-	if err != nil {
-		return fmt.Errorf("create output: %w", err)
-	}
-	defer f.Close()
-
-	// Write header
-	if err := writeArchiveHeader(f, archiveType, rootName, entries); err != nil {
-		return err
-	}
-
-	// Write metadata placeholders
-	entryOffsets := make([]int64, len(entries))
-	for i, entry := range entries {
-		entryOffsets[i], err = f.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("seek for entry %d: %w", i, err)
-		}
-		placeholderSize := 2 + len(entry.RelPath) + 8 + 8 // relPathLen + relPath + sizes
-		if _, err = f.Write(make([]byte, placeholderSize)); err != nil {
-			return fmt.Errorf("write placeholder %d: %w", i, err)
-		}
-	}
-
-	// Compress and update metadata
-	for i, entry := range entries {
-		startPos, err := f.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("seek start for %s: %w", entry.FilePath, err)
-		}
-		originalSize, err := compressFileStreaming(entry.FilePath, f)
-		if err != nil {
-			return fmt.Errorf("compress %s: %w", entry.FilePath, err)
-		}
-		endPos, err := f.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("seek end for %s: %w", entry.FilePath, err)
-		}
-		compressedSize := uint64(endPos - startPos)
-
-		// Update metadata
-		if err := updateEntryMetadata(f, entryOffsets[i], entry.RelPath, originalSize, compressedSize); err != nil {
-			return err
-		}
-
-		if _, err = f.Seek(endPos, io.SeekStart); err != nil {
-			return fmt.Errorf("seek back %d: %w", i, err)
-		}
-	}
-	return nil
-}
-
-// writeArchiveHeader writes the archive header to the output file
-func writeArchiveHeader(f *os.File, archiveType ArchiveType, rootName string, entries []Entry) error {
-	if _, err := f.Write([]byte(Magic)); err != nil {
-		return fmt.Errorf("write magic: %w", err)
-	}
-	if err := binary.Write(f, binary.BigEndian, uint8(Version)); err != nil {
-		return fmt.Errorf("write version: %w", err)
-	}
-	if err := binary.Write(f, binary.BigEndian, archiveType); err != nil {
-		return fmt.Errorf("write archive type: %w", err)
-	}
-
-	rootNameBytes := []byte(rootName)
-	if err := binary.Write(f, binary.BigEndian, uint16(len(rootNameBytes))); err != nil {
-		return fmt.Errorf("write root name length: %w", err)
-	}
-	if _, err := f.Write(rootNameBytes); err != nil {
-		return fmt.Errorf("write root name: %w", err)
-	}
-	if err := binary.Write(f, binary.BigEndian, uint32(len(entries))); err != nil {
-		return fmt.Errorf("write number of entries: %w", err)
-	}
-
-	return nil
-}
-
-// updateEntryMetadata updates the metadata for an entry in the archive
-func updateEntryMetadata(f *os.File, offset int64, relPath string, originalSize, compressedSize uint64) error {
-	if _, err := f.Seek(offset, io.SeekStart); err != nil {
-		return fmt.Errorf("seek metadata: %w", err)
-	}
-
-	relPathBytes := []byte(relPath)
-	if err := binary.Write(f, binary.BigEndian, uint16(len(relPathBytes))); err != nil {
-		return fmt.Errorf("write relPathLen: %w", err)
-	}
-	if _, err := f.Write(relPathBytes); err != nil {
-		return fmt.Errorf("write relPath: %w", err)
-	}
-	if err := binary.Write(f, binary.BigEndian, originalSize); err != nil {
-		return fmt.Errorf("write originalSize: %w", err)
-	}
-	if err := binary.Write(f, binary.BigEndian, compressedSize); err != nil {
-		return fmt.Errorf("write compressedSize: %w", err)
-	}
-
-	return nil
-}
-
-// compressFileStreaming compresses a file in chunks
-func compressFileStreaming(filePath string, w io.Writer) (uint64, error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return 0, fmt.Errorf("open %s: %w", filePath, err)
-	}
-	defer f.Close()
-
-	zw := lz4.NewWriter(w)
-	defer zw.Close()
-
-	info, err := f.Stat()
-	if err != nil {
-		return 0, fmt.Errorf("stat %s: %w", filePath, err)
-	}
-
-	if info.Size() == 0 {
-		return 0, nil // Empty file, no data written
-	}
-
-	buf := make([]byte, 32*1024)
-	var totalBytes uint64
-	for {
-		n, err := f.Read(buf)
-		if err != nil && err != io.EOF {
-			return 0, fmt.Errorf("read %s: %w", filePath, err)
-		}
-		if n == 0 {
-			break
-		}
-		if _, err = zw.Write(buf[:n]); err != nil {
-			return 0, fmt.Errorf("write compressed %s: %w", filePath, err)
-		}
-		totalBytes += uint64(n)
-		progress.AddBytes(uint64(n))
-	}
-	if err := zw.Close(); err != nil {
-		return 0, fmt.Errorf("close LZ4 writer %s: %w", filePath, err)
-	}
-	return totalBytes, nil
-}
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"agcp/pkg/progress"
+)
+
+// Compress compresses a file or directory into the archive output, configured
+// by opts, whose zero value gives the defaults. Once ctx is canceled, the
+// compression stops between chunks of the entries in progress, its workers
+// exit, and the partial archive is removed (kept with Options.KeepPartial, so
+// a run with PartialResume can continue it); the error wraps ctx.Err().
+func Compress(ctx context.Context, input, output string, opts Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts.ctx = ctx
+	return compress(input, output, opts)
+}
+
+// CompressWithOptions compresses a file or directory using the given options.
+//
+// Deprecated: Use Compress, which also takes a context.
+func CompressWithOptions(input, output string, opts Options) error {
+	return compress(input, output, opts)
+}
+
+// CompressContext compresses a file or directory, stopped by ctx.
+//
+// Deprecated: Use Compress, which takes the same arguments.
+func CompressContext(ctx context.Context, input, output string, opts Options) error {
+	return Compress(ctx, input, output, opts)
+}
+
+// compress is Compress, stopped by opts.ctx if set
+func compress(input, output string, opts Options) (err error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("stat input: %w", err)
+	}
+	if isStream(info) && opts.Snapshot != SnapshotNone {
+		return fmt.Errorf("cannot snapshot %s: it is a pipe", input)
+	}
+	snap, err := takeSnapshot(input, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := snap.release(); err == nil {
+			err = releaseErr
+		}
+	}()
+
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	archiveType, rootName, entries, err := collectInput(input, output, info, snap, opts)
+	if err != nil {
+		return err
+	}
+
+	// Calculate total size for progress
+	tracker.SetTotals(calculateTotalSize(entries), uint64(len(entries)))
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+
+	return compressFiles(entries, output, archiveType, rootName, opts, tracker)
+}
+
+// rootNameOf returns the root name recorded for input: its base name, or for
+// "." and ".." the name of the directory itself, which can be extracted under
+func rootNameOf(input string) string {
+	name := filepath.Base(input)
+	if name == "." || name == ".." {
+		if abs, err := filepath.Abs(input); err == nil {
+			name = filepath.Base(abs)
+		}
+	}
+	return name
+}
+
+// collectInput gathers the entries of the file or directory input, read from
+// snap if set, for an archive written to output. An empty output is a stream
+// rather than a file, which need not be kept out of the input.
+func collectInput(input, output string, info os.FileInfo, snap *snapshot, opts Options) (ArchiveType, string, []Entry, error) {
+	rootName := rootNameOf(input)
+	if info.IsDir() {
+		entries, err := collectDirEntries(snap.path(input), opts)
+		if err != nil {
+			return 0, "", nil, fmt.Errorf("collect entries: %w", err)
+		}
+		if output == "" {
+			return ArchiveDir, rootName, entries, nil
+		}
+		var excluded bool
+		entries, excluded = excludeOutput(entries, snap.path(output))
+		if excluded {
+			opts.warn(WarnOutputInInput, output, "output is inside the input directory; excluding it from the archive")
+		}
+		entries, _ = excludeOutput(entries, snap.path(PartialPath(output)))
+		entries, _ = excludeOutput(entries, snap.path(LockPath(output)))
+		return ArchiveDir, rootName, entries, nil
+	}
+
+	if output != "" && isSamePath(input, output) {
+		return 0, "", nil, fmt.Errorf("refusing to compress %s into itself", input)
+	}
+	switch {
+	case isStream(info):
+		// A pipe's name, such as 63 for /dev/fd/63, says nothing about its
+		// content; name the entry after the archive instead
+		rootName = "stdin"
+		if output != "" {
+			rootName = strings.TrimSuffix(filepath.Base(output), ".agcp")
+		}
+		return ArchiveFile, rootName, []Entry{newStreamEntry(input, info)}, nil
+	case isBlockDevice(info):
+		entry, err := newDeviceEntry(snap.path(input), info)
+		if err != nil {
+			return 0, "", nil, err
+		}
+		return ArchiveFile, rootName, []Entry{entry}, nil
+	}
+	if snap != nil {
+		var err error
+		if info, err = os.Stat(snap.path(input)); err != nil {
+			return 0, "", nil, fmt.Errorf("stat input in snapshot: %w", err)
+		}
+	}
+	return ArchiveFile, rootName, []Entry{newEntry("", snap.path(input), info)}, nil
+}
+
+// calculateTotalSize calculates the total size of all files to be compressed
+// from the sizes recorded when they were collected
+func calculateTotalSize(entries []Entry) uint64 {
+	var totalSize uint64
+	for _, entry := range entries {
+		totalSize += uint64(entry.Size)
+	}
+	if totalSize == 0 {
+		totalSize = 1 // Avoid division by zero
+	}
+	return totalSize
+}
+
+// collectDirEntries gathers all files in a directory with relative paths.
+// Devices, pipes and sockets are skipped with a warning: reading them would
+// block or never end.
+func collectDirEntries(root string, opts Options) ([]Entry, error) {
+	var hidden hiddenCount
+	entries, err := walkDirEntries(root, opts, &hidden)
+	if err != nil {
+		return nil, err
+	}
+	hidden.report(root, opts)
+	return entries, nil
+}
+
+// walkDirEntries is collectDirEntries, counting hidden files in hidden for the
+// caller to report
+func walkDirEntries(root string, opts Options, hidden *hiddenCount) ([]Entry, error) {
+	exclude, err := compileExclude(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	var rootDev uint64
+	hiddenDirs := make(map[string]bool)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := opts.canceled(); err != nil {
+			return err // Walking a large tree takes a while too
+		}
+		if path != root && exclude != nil {
+			if relPath, err := filepath.Rel(root, path); err == nil && exclude.match(relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if path != root && isHidden(info) {
+			switch {
+			case opts.SkipHidden && info.IsDir():
+				hidden.dirs++
+				return filepath.SkipDir
+			case opts.SkipHidden:
+				hidden.files++
+				return nil
+			case info.IsDir():
+				hiddenDirs[path] = true
+			}
+		}
+		if info.IsDir() && hiddenDirs[filepath.Dir(path)] {
+			hiddenDirs[path] = true
+		}
+		if opts.OneFileSystem && info.IsDir() {
+			if dev, ok := fileDevice(info); ok {
+				if path == root {
+					rootDev = dev
+				} else if dev != rootDev {
+					opts.warn(WarnSkippedMountPoint, path, "skipping mount point on another file system")
+					return filepath.SkipDir
+				}
+			}
+		}
+		if kind := specialFileKind(info.Mode()); kind != "" {
+			opts.warn(WarnSkippedSpecialFile, path, "skipping "+kind)
+			return nil
+		}
+		if !info.IsDir() {
+			relPath, err := filepath.Rel(root, path)
+			if err != nil {
+				return fmt.Errorf("relative path for %s: %w", path, err)
+			}
+			entries = append(entries, newEntry(relPath, path, info))
+			if path != root && (isHidden(info) || hiddenDirs[filepath.Dir(path)]) {
+				hidden.files++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk directory %s: %w", root, err)
+	}
+	return entries, nil
+}
+
+// specialFileKind names the kind of a device, pipe, socket or other file that
+// cannot be archived as regular data, or returns "" for anything else
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}
+
+// excludeOutput removes the archive being written from the entry list, reporting
+// whether it was found. Without this a previous run's archive inside the input tree
+// would be read while it is being rewritten.
+func excludeOutput(entries []Entry, output string) ([]Entry, bool) {
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return entries, false
+	}
+	outputInfo, statErr := os.Stat(output)
+	base := filepath.Base(absOutput)
+
+	kept := entries[:0]
+	excluded := false
+	for _, entry := range entries {
+		if filepath.Base(entry.FilePath) == base && matchesPath(entry.FilePath, absOutput, outputInfo, statErr) {
+			excluded = true
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, excluded
+}
+
+// isSamePath reports whether a and b name the same file
+func isSamePath(a, b string) bool {
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	bInfo, statErr := os.Stat(b)
+	return matchesPath(a, absB, bInfo, statErr)
+}
+
+// matchesPath compares path against a target given by absolute path and, when
+// it exists, its file info (catching symlinked or differently spelled paths)
+func matchesPath(path, absTarget string, targetInfo os.FileInfo, targetStatErr error) bool {
+	if absPath, err := filepath.Abs(path); err == nil && absPath == absTarget {
+		return true
+	}
+	if targetStatErr != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && os.SameFile(info, targetInfo)
+}
+
+// compressFiles compresses files with the selected codec and writes them to the archive
+func compressFiles(entries []Entry, output string, archiveType ArchiveType, rootName string, opts Options, tracker *progress.Tracker) (err error) {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	guard := newRatioGuard(opts)
+	if err := prepareEntries(entries, rootName, opts, guard); err != nil {
+		return err
+	}
+
+	// Hold the output's lock while writing it, so a concurrent run fails
+	// instead of writing the same partial file
+	unlock, err := lockOutput(output, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Write to the partial path, resuming an interrupted run if asked to
+	f, entryOffsets, headerLen, resumed, err := openOutput(output, archiveType, rootName, entries, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	release := trackFile(PartialPath(output), cleanupPartial)
+	defer func() {
+		if err != nil {
+			f.Close()
+			discardPartial(PartialPath(output), opts.KeepPartial || errors.Is(err, ErrDeadline), release)
+		}
+		release()
+	}()
+	if resumed > 0 {
+		opts.warn(WarnPartialResumed, PartialPath(output), fmt.Sprintf("resuming: %d of %d entries already complete", resumed, len(entries)))
+		creditResumed(entries[:resumed], rootName, tracker)
+	}
+
+	// Compress and update metadata. The ratio guard samples the stream in
+	// order, so it needs the sequential path.
+	if guard == nil && (compressWorkers(opts) > 1 || opts.Schedule != nil) {
+		err = compressParallel(entries, resumed, rootName, opts, tracker, func(i int, s *spill) error {
+			if err := appendSpill(f, entryOffsets[i], entries[i], s, uint32(opts.Align)); err != nil {
+				return &EntryError{Path: entries[i].name(rootName), Op: "compress", Err: err}
+			}
+			return syncEntry(f, opts.Fsync)
+		})
+	} else {
+		err = compressSequential(f, entries, resumed, entryOffsets, rootName, opts, tracker, guard)
+	}
+	if err != nil {
+		return err
+	}
+	if prov := provenance(opts); prov != nil {
+		if err := writeProvenance(f, prov); err != nil {
+			return err
+		}
+	}
+	if err := writeArchiveTrailer(f, headerLen); err != nil {
+		return err
+	}
+	if opts.Fsync != FsyncNone {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync output: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close output: %w", err)
+	}
+	if len(opts.Recipients) > 0 {
+		if err := encryptArchive(PartialPath(output), output, opts.Recipients); err != nil {
+			return err
+		}
+	} else if err := os.Rename(PartialPath(output), output); err != nil {
+		return fmt.Errorf("rename finished archive: %w", err)
+	}
+	if err := updateSidecar(output, opts); err != nil {
+		return err
+	}
+	if opts.Fsync == FsyncNone {
+		return nil
+	}
+	// Sync the archive's directory entry, and the archive itself in case
+	// encryption rewrote it
+	return syncFiles([]string{output}, FsyncPerArchive)
+}
+
+// prepareEntries chooses each entry's codec and level, applying the policy,
+// and settles the attributes recorded for it, so the entry table's layout is
+// known before any data is written. The codec is recorded unless it is the
+// LZ4 default, and the attribute reserved anyway when guard may switch to
+// storing.
+func prepareEntries(entries []Entry, rootName string, opts Options, guard *ratioGuard) error {
+	policy := opts.Policy.compile()
+	if opts.InlineMax < 0 || opts.InlineMax > MaxInline {
+		return fmt.Errorf("inline threshold %d is outside 0 to %d bytes", opts.InlineMax, MaxInline)
+	}
+	cache := openCache(opts)
+	for i := range entries {
+		if entries[i].kept == nil { // Kept entries keep the codec they were written with
+			entries[i].attrs.codec, entries[i].level = opts.Codec.resolve(), opts.Level
+			if rule := policy.match(entries[i].name(rootName)); rule != nil {
+				switch {
+				case rule.Store:
+					entries[i].attrs.codec = codecStore
+				case rule.Codec != CodecDefault:
+					entries[i].attrs.codec = rule.Codec.resolve()
+				}
+				if rule.Level != 0 {
+					entries[i].level = rule.Level
+				}
+			}
+			if err := inlineEntry(&entries[i], opts); err != nil {
+				return &EntryError{Path: entries[i].name(rootName), Op: "compress", Err: err}
+			}
+			entries[i].attrs.hasCodec = entries[i].attrs.codec != codecLZ4 || (guard != nil && opts.StoreIncompressible)
+			if cache && entries[i].attrs.codec != codecInline && entries[i].Size > 0 {
+				lookupCache(&entries[i], opts.CacheDir)
+			}
+		}
+		if opts.Reproducible {
+			entries[i].attrs.hasOwner = false
+			entries[i].attrs.uid, entries[i].attrs.gid = 0, 0
+			entries[i].attrs.hasMtime, entries[i].attrs.mtime = false, 0
+		}
+		entries[i].attrs.hasHash = true // Filled in once the entry is compressed
+		entries[i].attrs.rawName = !utf8.ValidString(entries[i].name(rootName))
+	}
+	applyTagRules(entries, rootName, opts.Tags)
+	if err := checkRecipients(opts.Recipients); err != nil {
+		return err
+	}
+	return checkFormatLimits(rootName, entries, opts.Align)
+}
+
+// encryptArchive encrypts the finished partial archive into output, removing
+// the unencrypted copy. Like an unencrypted archive, output appears only once
+// it is complete.
+func encryptArchive(partial, output string, recipients []Recipient) error {
+	encrypted := partial + ".enc"
+	defer trackFile(encrypted, cleanupTemp)()
+	if err := encryptFile(partial, encrypted, recipients); err != nil {
+		os.Remove(encrypted)
+		return err
+	}
+	if err := os.Remove(partial); err != nil {
+		return fmt.Errorf("remove unencrypted archive: %w", err)
+	}
+	if err := os.Rename(encrypted, output); err != nil {
+		return fmt.Errorf("rename finished archive: %w", err)
+	}
+	return nil
+}
+
+// compressSequential compresses entries[start:] one at a time, appending each
+// to f and filling in its table record. A tripped ratio guard with
+// StoreIncompressible stores the current entry and all later ones.
+func compressSequential(f *os.File, entries []Entry, start int, entryOffsets []int64, rootName string, opts Options, tracker *progress.Tracker, guard *ratioGuard) error {
+	store := false
+	for i := start; i < len(entries); i++ {
+		entry := entries[i]
+		if err := padToAlignment(f, uint32(opts.Align)); err != nil {
+			return err
+		}
+		startPos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("seek start for %s: %w", entry.FilePath, err)
+		}
+		tracker.StartEntry(entry.RelPath)
+		began := time.Now()
+		if store && entry.attrs.codec != codecInline && entry.kept == nil {
+			entry.attrs.codec = codecStore
+		}
+		originalSize, sum, err := compressFileStreaming(entry, f, opts, tracker, guard, 0)
+		if errors.Is(err, ErrIncompressible) && opts.StoreIncompressible && entry.stream {
+			err = fmt.Errorf("%w; the data read from a pipe cannot be read again to store it", err)
+		} else if errors.Is(err, ErrIncompressible) && opts.StoreIncompressible {
+			// Discard the partial entry and store it and everything after it
+			opts.warn(WarnStoredIncompressible, entry.name(rootName), fmt.Sprintf("%v; storing remaining entries uncompressed", err))
+			if _, err := f.Seek(startPos, io.SeekStart); err != nil {
+				return fmt.Errorf("seek back for %s: %w", entry.FilePath, err)
+			}
+			if err := f.Truncate(startPos); err != nil {
+				return fmt.Errorf("truncate partial %s: %w", entry.FilePath, err)
+			}
+			store = true
+			entry.attrs.codec = codecStore
+			originalSize, sum, err = compressFileStreaming(entry, f, opts, tracker, nil, originalSize)
+		}
+		if err != nil {
+			return &EntryError{Path: entry.name(rootName), Op: "compress", Err: err}
+		}
+		endPos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("seek end for %s: %w", entry.FilePath, err)
+		}
+		compressedSize := uint64(endPos - startPos)
+		if entry.attrs.codec != codecInline {
+			guard.add(originalSize, compressedSize)
+		}
+
+		// Update metadata
+		entry.attrs.hash = sum
+		if err := updateEntryMetadata(f, entryOffsets[i], entry, originalSize, compressedSize); err != nil {
+			return err
+		}
+		if err := syncEntry(f, opts.Fsync); err != nil {
+			return err
+		}
+		tracker.FinishEntry(entry.name(rootName))
+		opts.entryDone(entry.result(rootName, originalSize, compressedSize, time.Since(began)))
+
+		if _, err = f.Seek(endPos, io.SeekStart); err != nil {
+			return fmt.Errorf("seek back %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// writeArchiveHeader writes the archive header to the output file. created is
+// the creation time in nanoseconds since the Unix epoch, or 0 to leave it out;
+// prov, if set, is listed as a feature and written after the entry data.
+func writeArchiveHeader(f io.Writer, archiveType ArchiveType, rootName string, entries []Entry, align uint32, created int64, prov *Provenance) error {
+	if _, err := f.Write([]byte(Magic)); err != nil {
+		return fmt.Errorf("write magic: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, uint8(Version)); err != nil {
+		return fmt.Errorf("write version: %w", err)
+	}
+	if _, err := f.Write(encodeFeatures(archiveFeatures(entries, prov))); err != nil {
+		return fmt.Errorf("write features: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, archiveType); err != nil {
+		return fmt.Errorf("write archive type: %w", err)
+	}
+
+	rootNameBytes := []byte(rootName)
+	if _, err := f.Write(binary.AppendUvarint(nil, uint64(len(rootNameBytes)))); err != nil {
+		return fmt.Errorf("write root name length: %w", err)
+	}
+	if _, err := f.Write(rootNameBytes); err != nil {
+		return fmt.Errorf("write root name: %w", err)
+	}
+	if _, err := f.Write(binary.AppendUvarint(nil, uint64(len(entries)))); err != nil {
+		return fmt.Errorf("write number of entries: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, align); err != nil {
+		return fmt.Errorf("write alignment: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, created); err != nil {
+		return fmt.Errorf("write creation time: %w", err)
+	}
+
+	return nil
+}
+
+// creationTime returns the creation time to record in a new archive: now, in
+// nanoseconds since the Unix epoch, or 0 for reproducible archives
+func creationTime(opts Options) int64 {
+	if opts.Reproducible {
+		return 0
+	}
+	return time.Now().UnixNano()
+}
+
+// provenance returns the provenance to record in a new archive: that of the
+// options, or none for reproducible archives
+func provenance(opts Options) *Provenance {
+	if opts.Reproducible {
+		return nil
+	}
+	return opts.Provenance
+}
+
+// writeArchiveTrailer appends the end-of-archive trailer: the header length, a CRC-32
+// over the header and entry table, and the trailing magic
+func writeArchiveTrailer(f *os.File, headerLen int64) error {
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(f, 0, headerLen)); err != nil {
+		return fmt.Errorf("checksum header: %w", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek end for trailer: %w", err)
+	}
+	return writeTrailer(f, headerLen, crc.Sum32())
+}
+
+// writeTrailer writes the end-of-archive trailer for a header of headerLen
+// bytes with checksum crc
+func writeTrailer(w io.Writer, headerLen int64, crc uint32) error {
+	if err := binary.Write(w, binary.BigEndian, uint64(headerLen)); err != nil {
+		return fmt.Errorf("write header length: %w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, crc); err != nil {
+		return fmt.Errorf("write header checksum: %w", err)
+	}
+	if _, err := w.Write([]byte(TrailerMagic)); err != nil {
+		return fmt.Errorf("write trailer magic: %w", err)
+	}
+	return nil
+}
+
+// updateEntryMetadata updates the metadata for an entry in the archive
+func updateEntryMetadata(f *os.File, offset int64, entry Entry, originalSize, compressedSize uint64) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek metadata: %w", err)
+	}
+	return writeEntryRecord(f, entry, originalSize, compressedSize)
+}
+
+// writeEntryRecord writes the entry table record of an entry
+func writeEntryRecord(f io.Writer, entry Entry, originalSize, compressedSize uint64) error {
+	relPathBytes := []byte(entry.RelPath)
+	if _, err := f.Write(binary.AppendUvarint(nil, uint64(len(relPathBytes)))); err != nil {
+		return fmt.Errorf("write relPathLen: %w", err)
+	}
+	if _, err := f.Write(relPathBytes); err != nil {
+		return fmt.Errorf("write relPath: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, originalSize); err != nil {
+		return fmt.Errorf("write originalSize: %w", err)
+	}
+	if err := binary.Write(f, binary.BigEndian, compressedSize); err != nil {
+		return fmt.Errorf("write compressedSize: %w", err)
+	}
+
+	attrs := entry.attrs.encode()
+	if err := binary.Write(f, binary.BigEndian, uint16(len(attrs))); err != nil {
+		return fmt.Errorf("write attrsLen: %w", err)
+	}
+	if _, err := f.Write(attrs); err != nil {
+		return fmt.Errorf("write attrs: %w", err)
+	}
+
+	return nil
+}
+
+// compressFileStreaming compresses a file in chunks with the entry's codec,
+// returning its size and SHA-256. The ratio guard, if any, is checked once its
+// sample is complete; on ErrIncompressible the bytes consumed so far are
+// returned. credited is the number of bytes already credited to the tracker by
+// an abandoned attempt at this entry.
+func compressFileStreaming(entry Entry, w io.Writer, opts Options, tracker *progress.Tracker, guard *ratioGuard, credited uint64) (uint64, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	if entry.attrs.codec == codecInline {
+		// Read when the entry was planned; nothing goes in the data area
+		tracker.AddBytes(uint64(len(entry.attrs.inline)))
+		return uint64(len(entry.attrs.inline)), sha256.Sum256(entry.attrs.inline), nil
+	}
+	defer opts.acquireWorker()()
+	if entry.kept != nil {
+		return copyKept(entry, w, tracker)
+	}
+
+	filePath := entry.FilePath
+	var f *retryFile
+	src := entry.reader
+	if src == nil {
+		var err error
+		if f, err = openRetryFile(filePath, opts.Retry); err != nil {
+			return 0, sum, fmt.Errorf("open %s: %w", filePath, err)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	// Copy the compressed data into the cache as it is written, completing it
+	// once the encoder is closed for the last time
+	var cached bool
+	var cachedSize uint64
+	if entry.cache != nil {
+		if cache := newCacheWriter(entry.cache); cache != nil {
+			defer func() {
+				if cached {
+					cache.commit(entry.attrs.codec, cachedSize, sum)
+				} else {
+					cache.discard()
+				}
+			}()
+			w = io.MultiWriter(w, cache)
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	zw, err := entryEncoder(cw, entry.attrs, entry.level)
+	if err != nil {
+		return 0, sum, err
+	}
+	defer zw.Close()
+
+	if f != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, sum, fmt.Errorf("stat %s: %w", filePath, err)
+		}
+		if info.Size() == 0 && !entry.stream && !entry.device {
+			return 0, sha256.Sum256(nil), nil // Empty file, no data written
+		}
+	}
+
+	// Each chunk is hashed on another goroutine while it is compressed
+	h := newChunkHasher()
+	defer h.Sum()
+	var totalBytes uint64
+	for {
+		if err := opts.stopped(); err != nil {
+			return 0, sum, err
+		}
+		buf := h.buffer()
+		n, err := src.Read(buf)
+		if err != nil && err != io.EOF {
+			return 0, sum, fmt.Errorf("read %s: %w", filePath, err)
+		}
+		if n == 0 {
+			break
+		}
+		h.add(buf[:n])
+		if _, err = zw.Write(buf[:n]); err != nil {
+			return 0, sum, fmt.Errorf("write compressed %s: %w", filePath, err)
+		}
+		if totalBytes+uint64(n) > credited {
+			tracker.AddBytes(totalBytes + uint64(n) - max(totalBytes, credited))
+		}
+		totalBytes += uint64(n)
+
+		if guard.due(totalBytes) {
+			if err := zw.Flush(); err != nil {
+				return 0, sum, fmt.Errorf("flush compressed %s: %w", filePath, err)
+			}
+			if err := guard.check(totalBytes, cw.n); err != nil {
+				return totalBytes, sum, err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, sum, fmt.Errorf("close encoder %s: %w", filePath, err)
+	}
+	sum = h.Sum()
+	cached, cachedSize = true, totalBytes
+	return totalBytes, sum, nil
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrDeadline is returned when a compression or extraction stops because it
+// reached Options.Deadline. Compression keeps the partial archive, so a run
+// with PartialResume continues it; extraction keeps the entries it finished,
+// so a run with Update continues it.
+var ErrDeadline = errors.New("stopped at the deadline")
+
+// pastDeadline reports whether a deadline is set and has been reached
+func pastDeadline(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// stopped returns ErrDeadline once Options.Deadline is reached, or the
+// context's error once the operation's context is canceled (see
+// Compress), and nil while the operation may go on
+func (o Options) stopped() error {
+	if pastDeadline(o.Deadline) {
+		return ErrDeadline
+	}
+	return o.canceled()
+}
+
+// stoppable reports whether the operation can be stopped before it completes
+func (o Options) stoppable() bool {
+	return !o.Deadline.IsZero() || o.ctx != nil
+}
+
+// stopReader fails reads with the error of stopped once it returns one
+type stopReader struct {
+	r       io.Reader
+	stopped func() error
+}
+
+func (s *stopReader) Read(p []byte) (int, error) {
+	if err := s.stopped(); err != nil {
+		return 0, err
+	}
+	return s.r.Read(p)
+}
+
+// canceled returns the error of the operation's context once it is canceled
+func (o Options) canceled() error {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Err()
+}
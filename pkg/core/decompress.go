@@ -1,292 +1,567 @@
-package core
-
-import (
-	"bufio"
-	"encoding/binary"
-	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"runtime"
-	"sync"
-
-	"agcp/pkg/progress"
-
-	"github.com/pierrec/lz4/v4"
-)
-
-// Decompress handles the decompression process
-func Decompress(input, decompressedName string) error {
-	f, err := os.Open(input)
-	if err != nil {
-		return fmt.Errorf("open input: %w", err)
-	}
-	defer f.Close()
-
-	// Read and validate archive header
-	tasks, startOffset, outputDir, archiveType, err := readArchiveHeader(f, decompressedName)
-	if err != nil {
-		return err
-	}
-
-	// Calculate total size for progress tracking
-	var totalSize uint64
-	for _, task := range tasks {
-		totalSize += task.OriginalSize
-	}
-	if totalSize == 0 {
-		totalSize = 1
-	}
-	progress.Init(totalSize)
-	defer progress.Stop()
-
-	return decompressFiles(input, startOffset, tasks, archiveType, outputDir)
-}
-
-// readArchiveHeader reads and validates the archive header
-func readArchiveHeader(f *os.File, decompressedName string) ([]DecompressTask, int64, string, ArchiveType, error) {
-	br := bufio.NewReader(f)
-
-	// Read magic number
-	var magicBytes [4]byte
-	if _, err := io.ReadFull(br, magicBytes[:]); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read magic: %w", err)
-	}
-	if string(magicBytes[:]) != Magic {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("invalid magic number: %q", string(magicBytes[:]))
-	}
-
-	// Read version
-	var versionByte uint8
-	if err := binary.Read(br, binary.BigEndian, &versionByte); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read version: %w", err)
-	}
-	if versionByte != Version {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("unsupported version: %d", versionByte)
-	}
-
-	// Read archive type
-	var archiveType ArchiveType
-	if err := binary.Read(br, binary.BigEndian, &archiveType); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read archive type: %w", err)
-	}
-
-	// Read root name
-	var rootNameLen uint16
-	if err := binary.Read(br, binary.BigEndian, &rootNameLen); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read root name length: %w", err)
-	}
-	rootNameBytes := make([]byte, rootNameLen)
-	if _, err := io.ReadFull(br, rootNameBytes); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read root name: %w", err)
-	}
-	rootName := string(rootNameBytes)
-
-	// Decide the top-level output path.
-	// Directory archives: default to the original root folder name.
-	// Single-file archives: default to current directory; a provided name is treated as the full output file path.
-	var outputDir string
-	if decompressedName != "" {
-		outputDir = decompressedName
-	} else if archiveType == ArchiveDir {
-		outputDir = rootName
-	} else {
-		outputDir = "."
-	}
-
-	// Read number of entries
-	var numEntries uint32
-	if err := binary.Read(br, binary.BigEndian, &numEntries); err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("read num entries: %w", err)
-	}
-
-	// Read metadata for each entry
-	tasks := make([]DecompressTask, numEntries)
-	for i := 0; i < int(numEntries); i++ {
-		var relPathLen uint16
-		if err := binary.Read(br, binary.BigEndian, &relPathLen); err != nil {
-			return nil, 0, "", ArchiveDir, fmt.Errorf("read relPathLen %d: %w", i, err)
-		}
-		relPathBytes := make([]byte, relPathLen)
-		if _, err := io.ReadFull(br, relPathBytes); err != nil {
-			return nil, 0, "", ArchiveDir, fmt.Errorf("read relPath %d: %w", i, err)
-		}
-		relPath := string(relPathBytes)
-
-		var originalSize, compressedSize uint64
-		if err := binary.Read(br, binary.BigEndian, &originalSize); err != nil {
-			return nil, 0, "", ArchiveDir, fmt.Errorf("read originalSize %d: %w", i, err)
-		}
-		if err := binary.Read(br, binary.BigEndian, &compressedSize); err != nil {
-			return nil, 0, "", ArchiveDir, fmt.Errorf("read compressedSize %d: %w", i, err)
-		}
-
-		// Determine destination path
-		destPath := determineDestPath(archiveType, outputDir, relPath, rootName, f.Name(), decompressedName)
-
-		tasks[i] = DecompressTask{
-			RelPath:        relPath,
-			OriginalSize:   originalSize,
-			CompressedSize: compressedSize,
-			DestPath:       destPath,
-		}
-	}
-
-	// Calculate start offset for compressed data
-	offset, err := f.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, 0, "", ArchiveDir, fmt.Errorf("seek current: %w", err)
-	}
-	buffered := br.Buffered()
-	startOffset := offset - int64(buffered)
-
-	return tasks, startOffset, outputDir, archiveType, nil
-}
-
-// determineDestPath decides where an extracted entry should be written.
-//
-//	archiveType      – whether the archive represents a directory or a single file
-//	baseOutputDir    – resolved top-level output directory (root folder for directory archives or "." for file archives)
-//	relPath          – relative path stored in the archive entry metadata
-//	rootName         – name of the root directory or file recorded in the header
-//	inputPath        – path of the .agcp archive on disk (used only for fallback names)
-//	userOutputName   – raw value provided by the user on the CLI (may be empty)
-func determineDestPath(archiveType ArchiveType, baseOutputDir, relPath, rootName, inputPath, userOutputName string) string {
-	switch archiveType {
-	case ArchiveDir:
-		// Always preserve structure inside the chosen base directory.
-		return filepath.Join(baseOutputDir, relPath)
-
-	case ArchiveFile:
-		if userOutputName != "" {
-			// If destination exists and is a directory, place the file inside it.
-			if info, err := os.Stat(userOutputName); err == nil && info.IsDir() {
-				// Use provided directory but preserve original/root filename.
-				if relPath == "" {
-					return filepath.Join(userOutputName, rootName)
-				}
-				return filepath.Join(userOutputName, relPath)
-			}
-
-			// If relPath is non-empty treat userOutputName as base dir to preserve structure.
-			if relPath != "" {
-				return filepath.Join(userOutputName, relPath)
-			}
-
-			// Otherwise treat it as the exact file path the user wants.
-			return userOutputName
-		}
-
-		if relPath != "" {
-			return filepath.Join(baseOutputDir, relPath)
-		}
-
-		// Generate filename from header or archive filename.
-		fileName := rootName
-		if fileName == "" {
-			fileName = filepath.Base(inputPath)
-			if ext := filepath.Ext(fileName); ext == ".agcp" {
-				fileName = fileName[:len(fileName)-len(ext)]
-			}
-		}
-		return filepath.Join(baseOutputDir, fileName)
-	}
-	return ""
-}
-
-// decompressFiles decompresses files concurrently
-func decompressFiles(archivePath string, startOffset int64, tasks []DecompressTask, archiveType ArchiveType, baseOutput string) error {
-	// Calculate offsets for each compressed file in the archive
-	offsets := make([]int64, len(tasks))
-	currentOffset := startOffset
-	for i, task := range tasks {
-		offsets[i] = currentOffset
-		currentOffset += int64(task.CompressedSize)
-	}
-
-	// For directory archives ensure the top-level directory exists.
-	if archiveType == ArchiveDir {
-		if err := os.MkdirAll(baseOutput, 0755); err != nil {
-			return fmt.Errorf("create root dir %s: %w", baseOutput, err)
-		}
-	}
-
-	// Pre-create directories for all files
-	for _, task := range tasks {
-		if err := os.MkdirAll(filepath.Dir(task.DestPath), 0755); err != nil {
-			return fmt.Errorf("create dir for %s: %w", task.DestPath, err)
-		}
-	}
-
-	// Use a semaphore to limit concurrent goroutines
-	sem := make(chan struct{}, runtime.NumCPU())
-	var wg sync.WaitGroup
-	errCh := make(chan error, len(tasks))
-
-	// Decompress files concurrently
-	for i, task := range tasks {
-		wg.Add(1)
-		go func(task DecompressTask, offset int64) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			f, err := os.Open(archivePath)
-			if err != nil {
-				errCh <- fmt.Errorf("open archive for %s: %w", task.DestPath, err)
-				return
-			}
-			defer f.Close()
-
-			sr := io.NewSectionReader(f, offset, int64(task.CompressedSize))
-			if err := decompressFileStreaming(sr, task); err != nil {
-				errCh <- err
-				return
-			}
-		}(task, offsets[i])
-	}
-	wg.Wait()
-	close(errCh)
-
-	// Return first error if any
-	if len(errCh) > 0 {
-		return <-errCh
-	}
-	return nil
-}
-
-// decompressFileStreaming decompresses a file in chunks
-func decompressFileStreaming(r io.Reader, task DecompressTask) error {
-	// Ensure parent directory exists
-	if err := os.MkdirAll(filepath.Dir(task.DestPath), 0755); err != nil {
-		return fmt.Errorf("create parent dir for %s: %w", task.DestPath, err)
-	}
-
-	// Handle empty files
-	if task.OriginalSize == 0 {
-		f, err := os.Create(task.DestPath)
-		if err != nil {
-			return fmt.Errorf("create empty %s: %w", task.DestPath, err)
-		}
-		return f.Close()
-	}
-
-	// Create output file
-	f, err := os.Create(task.DestPath)
-	if err != nil {
-		return fmt.Errorf("create %s: %w", task.DestPath, err)
-	}
-	defer f.Close()
-
-	// Decompress
-	zr := lz4.NewReader(r)
-	pw := &progress.Writer{W: f}
-	n, err := io.CopyN(pw, zr, int64(task.OriginalSize))
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("copy %s: %w", task.DestPath, err)
-	}
-	if uint64(n) != task.OriginalSize {
-		return fmt.Errorf("copy %s: expected %d bytes, got %d", task.DestPath, task.OriginalSize, n)
-	}
-	return nil
-}
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// Decompress extracts the archive input to decompressedName, or to its root
+// name if that is empty, configured by opts, whose zero value gives the
+// defaults. Once ctx is canceled, the extraction stops between chunks, its
+// workers exit, and the files being written are removed (kept with
+// Options.KeepPartial); the error wraps ctx.Err(). Files already complete are
+// kept, so a run with Options.Update continues it.
+func Decompress(ctx context.Context, input, decompressedName string, opts Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts.ctx = ctx
+	return decompress(input, decompressedName, opts)
+}
+
+// DecompressWithOptions extracts an archive using the given options.
+//
+// Deprecated: Use Decompress, which also takes a context.
+func DecompressWithOptions(input, decompressedName string, opts Options) error {
+	return decompress(input, decompressedName, opts)
+}
+
+// DecompressContext extracts an archive, stopped by ctx.
+//
+// Deprecated: Use Decompress, which takes the same arguments.
+func DecompressContext(ctx context.Context, input, decompressedName string, opts Options) error {
+	return Decompress(ctx, input, decompressedName, opts)
+}
+
+// decompress is Decompress, stopped by opts.ctx if set
+func decompress(input, decompressedName string, opts Options) error {
+	files, err := decompressArchive(input, decompressedName, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Recursive > 0 {
+		unpackNested(files, opts.Recursive, opts)
+	}
+	return nil
+}
+
+// decompressArchive extracts an archive and returns the paths of the files written
+func decompressArchive(input, decompressedName string, opts Options) ([]string, error) {
+	if _, err := CheckSidecar(input); err != nil {
+		return nil, err
+	}
+	backend, err := encryptedBackend(input)
+	if err != nil {
+		return nil, err
+	}
+	if backend != "" {
+		decrypted, remove, err := decryptToTemp(backend, input, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer remove()
+		input = decrypted
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, fmt.Errorf("open input: %w", err)
+	}
+	defer f.Close()
+
+	// Read and validate archive header
+	tasks, outputDir, archiveType, err := readArchiveHeader(f, decompressedName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RefuseSystemPaths {
+		if err := checkSystemPaths(tasks); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ToDevice {
+		if err := checkDeviceTarget(archiveType, tasks, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hold the destination's lock while extracting, so a concurrent extraction
+	// into the same place fails instead of interleaving its writes
+	dest := outputDir
+	if archiveType == ArchiveFile && len(tasks) > 0 {
+		dest = tasks[0].DestPath
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("create output directory: %w", err)
+	}
+	unlock, err := lockOutput(dest, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Calculate total sizes for progress tracking: extraction may be bound by
+	// reading the compressed data as much as by writing the decompressed data
+	var totalSize, totalRead uint64
+	for _, task := range tasks {
+		totalSize += task.OriginalSize
+		totalRead += task.CompressedSize
+	}
+	if totalSize == 0 {
+		totalSize = 1
+	}
+	tracker := opts.newTracker(totalSize)
+	tracker.SetTotals(totalSize, uint64(len(tasks)))
+	tracker.SetReadTotal(totalRead)
+	tracker.SetPhase(progress.PhaseWriting)
+	tracker.Start()
+	defer tracker.Stop()
+
+	skipped, err := decompressFiles(input, tasks, archiveType, outputDir, opts, tracker)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(tasks))
+	for i, task := range tasks {
+		if !skipped[i] {
+			files = append(files, task.DestPath)
+		}
+	}
+	if err := syncFiles(files, opts.Fsync); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// readArchiveHeader reads and validates the archive header and resolves the
+// destination of every entry, under opts.Dir if set
+func readArchiveHeader(f *os.File, decompressedName string, opts Options) ([]DecompressTask, string, ArchiveType, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, "", ArchiveDir, fmt.Errorf("stat archive: %w", err)
+	}
+	idx, err := readIndex(f, info.Size())
+	if err != nil {
+		return nil, "", ArchiveDir, err
+	}
+	for _, feature := range unknownFeatures(idx.features) {
+		opts.warn(WarnIgnoredFeature, f.Name(), fmt.Sprintf("archive uses %v, which this agcp does not know; ignoring it", feature))
+	}
+	dir := opts.Dir
+	rootName, err := restoreName(idx.rootName, opts)
+	if err != nil {
+		return nil, "", ArchiveDir, err
+	}
+	if decompressedName != "" && !filepath.IsAbs(decompressedName) {
+		decompressedName = filepath.Join(dir, decompressedName)
+	}
+
+	// The root name names the output unless one is given (or, for a file, a
+	// directory to put it in); refuse names that would leave dir. An empty file
+	// name falls back to the archive's name.
+	usesRoot := decompressedName == ""
+	if info, err := os.Stat(decompressedName); err == nil && info.IsDir() && idx.archiveType == ArchiveFile {
+		usesRoot = true
+	}
+	if usesRoot && (rootName != "" || idx.archiveType == ArchiveDir) {
+		if err := checkRootName(rootName); err != nil {
+			return nil, "", ArchiveDir, fmt.Errorf("corrupt archive: %w; pass an output name to extract it", err)
+		}
+	}
+
+	// Decide the top-level output path, under dir if given.
+	// Directory archives: default to the original root folder name.
+	// Single-file archives: default to current directory; a provided name is treated as the full output file path.
+	var outputDir string
+	if decompressedName != "" {
+		outputDir = decompressedName
+	} else if idx.archiveType == ArchiveDir {
+		outputDir = filepath.Join(dir, rootName)
+	} else {
+		outputDir = filepath.Join(dir, ".")
+	}
+
+	tasks := make([]DecompressTask, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		if !entry.attrs.regular() {
+			opts.warn(WarnSkippedEntryType, idx.name(entry), fmt.Sprintf("skipping %v entry, which this agcp cannot restore", entry.attrs.kind))
+			continue
+		}
+
+		// Determine destination path, refusing paths that escape outputDir
+		if err := checkEntryPath(entry.relPath); err != nil {
+			return nil, "", ArchiveDir, &EntryError{Path: idx.name(entry), Op: "extract", Err: err}
+		}
+		relPath, err := restoreName(entry.relPath, opts)
+		if err != nil {
+			return nil, "", ArchiveDir, err
+		}
+		destPath := determineDestPath(idx.archiveType, outputDir, relPath, rootName, f.Name(), decompressedName)
+
+		tasks = append(tasks, DecompressTask{
+			RelPath:        entry.relPath,
+			OriginalSize:   entry.originalSize,
+			CompressedSize: entry.compressedSize,
+			DestPath:       destPath,
+			name:           idx.name(entry),
+			attrs:          entry.attrs,
+			offset:         entry.offset,
+		})
+	}
+
+	return tasks, outputDir, idx.archiveType, nil
+}
+
+// determineDestPath decides where an extracted entry should be written.
+//
+//	archiveType      – whether the archive represents a directory or a single file
+//	baseOutputDir    – resolved top-level output directory (root folder for directory archives or "." for file archives)
+//	relPath          – relative path stored in the archive entry metadata
+//	rootName         – name of the root directory or file recorded in the header
+//	inputPath        – path of the .agcp archive on disk (used only for fallback names)
+//	userOutputName   – raw value provided by the user on the CLI (may be empty)
+func determineDestPath(archiveType ArchiveType, baseOutputDir, relPath, rootName, inputPath, userOutputName string) string {
+	switch archiveType {
+	case ArchiveDir:
+		// Always preserve structure inside the chosen base directory.
+		return filepath.Join(baseOutputDir, relPath)
+
+	case ArchiveFile:
+		if userOutputName != "" {
+			// If destination exists and is a directory, place the file inside it.
+			if info, err := os.Stat(userOutputName); err == nil && info.IsDir() {
+				// Use provided directory but preserve original/root filename.
+				if relPath == "" {
+					return filepath.Join(userOutputName, rootName)
+				}
+				return filepath.Join(userOutputName, relPath)
+			}
+
+			// If relPath is non-empty treat userOutputName as base dir to preserve structure.
+			if relPath != "" {
+				return filepath.Join(userOutputName, relPath)
+			}
+
+			// Otherwise treat it as the exact file path the user wants.
+			return userOutputName
+		}
+
+		if relPath != "" {
+			return filepath.Join(baseOutputDir, relPath)
+		}
+
+		// Generate filename from header or archive filename.
+		fileName := rootName
+		if fileName == "" {
+			fileName = filepath.Base(inputPath)
+			if ext := filepath.Ext(fileName); ext == ".agcp" {
+				fileName = fileName[:len(fileName)-len(ext)]
+			}
+		}
+		return filepath.Join(baseOutputDir, fileName)
+	}
+	return ""
+}
+
+// decompressFiles decompresses files concurrently, reporting which entries it
+// skipped: rejected by the scan, or unchanged on disk under Options.Update
+func decompressFiles(archivePath string, tasks []DecompressTask, archiveType ArchiveType, baseOutput string, opts Options, tracker *progress.Tracker) ([]bool, error) {
+	// For directory archives ensure the top-level directory exists.
+	if archiveType == ArchiveDir {
+		if err := os.MkdirAll(baseOutput, 0755); err != nil {
+			return nil, fmt.Errorf("create root dir %s: %w", baseOutput, err)
+		}
+	}
+
+	// Pre-create directories for all files
+	for _, task := range tasks {
+		if err := os.MkdirAll(filepath.Dir(task.DestPath), 0755); err != nil {
+			return nil, &EntryError{Path: task.name, Op: "extract", Err: fmt.Errorf("create dir for %s: %w", task.DestPath, err)}
+		}
+	}
+
+	budget := newIOBudget(opts.IOBudget)
+	pacer := progress.NewPacer(opts.MaxWriteRate)
+	owners := newOwnerRestorer(opts)
+
+	// Decompress files concurrently, each worker reading a stretch of the
+	// archive front to back
+	offsets := make([]int64, len(tasks))
+	sizes := make([]uint64, len(tasks))
+	for i, task := range tasks {
+		offsets[i], sizes[i] = task.offset, task.CompressedSize
+	}
+	var total uint64
+	for _, size := range sizes {
+		total += size
+	}
+	skipped := make([]bool, len(tasks))
+	extract := func(i int) error {
+		task := tasks[i]
+		task.scan, task.transform = opts.Scan, opts.Transform
+		task.keepPartial, task.fsync = opts.KeepPartial, opts.Fsync
+		task.pacer, task.perms = pacer, !opts.NoPerms && !opts.ToDevice
+		task.toDevice, task.skipZeros = opts.ToDevice, opts.SkipZeros
+		if err := opts.stopped(); err != nil {
+			return &EntryError{Path: task.name, Op: "extract", Err: err}
+		}
+		if opts.Update {
+			unchanged, err := unchangedOnDisk(task)
+			if err != nil {
+				return &EntryError{Path: task.name, Op: "extract", Err: err}
+			}
+			if unchanged {
+				tracker.AddBytes(task.OriginalSize)
+				tracker.AddRead(task.CompressedSize)
+				tracker.FinishEntry(task.name)
+				skipped[i] = true
+				opts.entryDone(task.result(0, true))
+				return nil
+			}
+		}
+		began := time.Now()
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return &EntryError{Path: task.name, Op: "extract", Err: fmt.Errorf("open archive: %w", err)}
+		}
+		defer f.Close()
+
+		ra := NewRetryReaderAt(archivePath, f, opts.Retry)
+		defer ra.Close()
+		sr := io.NewSectionReader(ra, task.offset, int64(task.CompressedSize))
+		// An entry gets a share of the cores for its blocks in proportion to its
+		// share of the data, so a single large file still uses all of them
+		task.data = sr
+		task.blockWorkers = int(uint64(runtime.GOMAXPROCS(0)) * task.CompressedSize / max(total, 1))
+		if opts.Schedule != nil {
+			task.blockWorkers = 1 // The entry's worker decodes its blocks alone
+		}
+		var r io.Reader = &progress.Reader{R: sr, T: tracker}
+		if opts.stoppable() {
+			r = &stopReader{r: r, stopped: opts.stopped}
+			task.stopped = opts.stopped
+		}
+		tracker.StartEntry(task.RelPath)
+		if err := decompressFileStreaming(r, task, tracker, budget); err != nil {
+			var rejection *scanRejection
+			if !errors.As(err, &rejection) {
+				return &EntryError{Path: task.name, Op: "extract", Err: err}
+			}
+			opts.warn(WarnScanRejected, task.name, rejection.Error())
+			skipped[i] = true
+		}
+		// Reading stops once the content is complete, before the codec's
+		// end-of-stream trailer; count it as read so the totals match
+		if pos, err := sr.Seek(0, io.SeekCurrent); err == nil && pos < sr.Size() {
+			tracker.AddRead(uint64(sr.Size() - pos))
+		}
+		if !skipped[i] && !task.toDevice {
+			owners.restore(task.DestPath, task.attrs)
+			if opts.Update {
+				if err := setUpdateTime(task); err != nil {
+					return &EntryError{Path: task.name, Op: "extract", Err: err}
+				}
+			}
+		}
+		tracker.FinishEntry(task.name)
+		opts.entryDone(task.result(time.Since(began), skipped[i]))
+		return nil
+	}
+	var err error
+	if sched := newScheduler(opts, "extract", compressWorkers(opts)); sched != nil {
+		defer sched.stop()
+		names := make([]string, len(tasks))
+		for i, task := range tasks {
+			names[i] = task.name
+		}
+		err = sched.forEachByOffset(offsets, names, extract)
+	} else {
+		err = forEachByOffset(offsets, sizes, extract)
+	}
+	owners.report(opts)
+	return skipped, err
+}
+
+// decompressFileStreaming decompresses a file in chunks
+func decompressFileStreaming(r io.Reader, task DecompressTask, tracker *progress.Tracker, budget *ioBudget) (err error) {
+	if task.toDevice {
+		return writeDevice(r, task, tracker, budget)
+	}
+
+	// Ensure parent directory exists
+	if err := os.MkdirAll(filepath.Dir(task.DestPath), 0755); err != nil {
+		return fmt.Errorf("create parent dir for %s: %w", task.DestPath, err)
+	}
+
+	// Handle empty files; a transform may still write content for them
+	if task.OriginalSize == 0 && task.transform == nil {
+		if task.scan != nil {
+			if err := scanEmpty(task.scan, task.name); err != nil {
+				return err
+			}
+		}
+		f, err := createOver(task.DestPath)
+		if err != nil {
+			return fmt.Errorf("create empty %s: %w", task.DestPath, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return restoreMode(task.DestPath, task)
+	}
+
+	// Create output file; a scanned entry goes to a temporary file until the
+	// scan accepts it
+	create := createOver
+	if task.scan != nil {
+		create = scanTempFile
+	}
+	f, err := create(task.DestPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", task.DestPath, err)
+	}
+	defer f.Close()
+
+	// Remove the half-written file if extraction fails. A scan's temporary file
+	// is never kept.
+	kind := cleanupPartial
+	if task.scan != nil {
+		kind = cleanupTemp
+	}
+	release := trackFile(f.Name(), kind)
+	defer func() {
+		if err != nil {
+			f.Close()
+			discardPartial(f.Name(), task.keepPartial && kind == cleanupPartial, release)
+		}
+		release()
+	}()
+
+	// Reserve the space up front; a transform may change the size
+	if task.transform == nil {
+		if err := preallocate(f, int64(task.OriginalSize)); err != nil {
+			return err
+		}
+	}
+
+	// A large LZ4 entry is decoded a block per core, unless its writes must go
+	// through a scan, transform or I/O budget in order
+	if task.blockWorkers > 1 && task.scan == nil && task.transform == nil && budget == nil && task.attrs.codec == codecLZ4 {
+		if split, err := extractBlocks(f, task, tracker); split || err != nil {
+			if err != nil {
+				return err
+			}
+			if task.skipZeros {
+				if err := endSkippedZeros(f, int64(task.OriginalSize)); err != nil {
+					return err
+				}
+			}
+			if err := restoreMode(f.Name(), task); err != nil {
+				return err
+			}
+			return syncEntry(f, task.fsync)
+		}
+	}
+
+	// Bound in-flight data across workers when an I/O budget is set
+	var w io.Writer = f
+	var bw *budgetWriter
+	if budget != nil {
+		bw = &budgetWriter{f: f, budget: budget}
+		defer bw.flush() // Release held budget on error paths
+		w = bw
+	}
+	if task.skipZeros {
+		w = &zeroSkipWriter{w: w, f: f}
+	}
+
+	// Decompress
+	var zr io.Reader = bytes.NewReader(nil) // An empty entry may have no data to decode
+	if task.OriginalSize > 0 {
+		if zr, err = entryDecoder(r, task.attrs); err != nil {
+			return fmt.Errorf("decode %s: %w", task.DestPath, err)
+		}
+	}
+	if task.scan == nil {
+		if err := copyEntry(w, zr, task, tracker, bw); err != nil {
+			return err
+		}
+		if err := endZeros(f, task); err != nil {
+			return err
+		}
+		if err := restoreMode(f.Name(), task); err != nil {
+			return err
+		}
+		return syncEntry(f, task.fsync)
+	}
+
+	tee := newScanTee(task.scan, task.name)
+	err = copyEntry(io.MultiWriter(w, tee), zr, task, tracker, bw)
+	if err := tee.wait(err); err != nil {
+		return err
+	}
+	if err := endZeros(f, task); err != nil {
+		return err
+	}
+	if err := restoreMode(f.Name(), task); err != nil {
+		return err
+	}
+	if err := syncEntry(f, task.fsync); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", task.DestPath, err)
+	}
+	if err := os.Rename(f.Name(), task.DestPath); err != nil {
+		return fmt.Errorf("move scanned %s into place: %w", task.DestPath, err)
+	}
+	return nil
+}
+
+// endZeros ends a file extracted with task.skipZeros at the current offset,
+// the end of the content copied to it
+func endZeros(f *os.File, task DecompressTask) error {
+	if !task.skipZeros {
+		return nil
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return endSkippedZeros(f, end)
+}
+
+// copyEntry decodes an entry's content from zr to w, through the entry's
+// transform if any, flushing bw if set
+func copyEntry(w io.Writer, zr io.Reader, task DecompressTask, tracker *progress.Tracker, bw *budgetWriter) error {
+	var n int64
+	var err error
+	if task.transform != nil {
+		if n, err = transformEntry(w, zr, task, tracker); err != nil {
+			return err
+		}
+	} else {
+		n, err = io.CopyN(&progress.Writer{W: w, T: tracker, P: task.pacer}, zr, int64(task.OriginalSize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("copy %s: %w", task.DestPath, err)
+		}
+	}
+	if uint64(n) != task.OriginalSize {
+		return fmt.Errorf("copy %s: expected %d bytes, got %d", task.DestPath, task.OriginalSize, n)
+	}
+	if bw != nil {
+		if err := bw.flush(); err != nil {
+			return fmt.Errorf("flush %s: %w", task.DestPath, err)
+		}
+	}
+	return nil
+}
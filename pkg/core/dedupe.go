@@ -0,0 +1,147 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// DedupeReport summarizes how much content two archives have in common.
+// Entries are compared by the SHA-256 of their decompressed content; empty
+// entries are ignored.
+type DedupeReport struct {
+	EntriesA, EntriesB             int    // Non-empty entries in each archive
+	BytesA, BytesB                 uint64 // Original bytes of those entries
+	SharedEntriesA, SharedEntriesB int    // Entries whose content also occurs in the other archive
+	SharedBytesA, SharedBytesB     uint64 // Original bytes of the shared entries
+	SharedContents                 int    // Distinct contents present in both archives
+	HashedEntries                  int    // Entries that had to be decompressed and hashed
+}
+
+// CompareContent reports the content shared between archives a and b. The entry
+// tables are used to rule out entries whose size has no counterpart in the other
+// archive, so only candidate duplicates are decompressed and hashed.
+func CompareContent(a, b string) (*DedupeReport, error) {
+	fa, idxA, err := openIndex(a)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a, err)
+	}
+	defer fa.Close()
+	fb, idxB, err := openIndex(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b, err)
+	}
+	defer fb.Close()
+
+	report := &DedupeReport{}
+	sizesA := make(map[uint64]bool)
+	sizesB := make(map[uint64]bool)
+	for _, e := range idxA.entries {
+		if e.originalSize > 0 && e.attrs.regular() {
+			report.EntriesA++
+			report.BytesA += e.originalSize
+			sizesA[e.originalSize] = true
+		}
+	}
+	for _, e := range idxB.entries {
+		if e.originalSize > 0 && e.attrs.regular() {
+			report.EntriesB++
+			report.BytesB += e.originalSize
+			sizesB[e.originalSize] = true
+		}
+	}
+
+	candidatesA := filterBySize(idxA.entries, sizesB)
+	candidatesB := filterBySize(idxB.entries, sizesA)
+	report.HashedEntries = len(candidatesA) + len(candidatesB)
+
+	hashesA, err := hashEntries(fa, candidatesA)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a, err)
+	}
+	hashesB, err := hashEntries(fb, candidatesB)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b, err)
+	}
+
+	inA := make(map[[sha256.Size]byte]bool, len(hashesA))
+	for _, h := range hashesA {
+		inA[h] = true
+	}
+	inB := make(map[[sha256.Size]byte]bool, len(hashesB))
+	for _, h := range hashesB {
+		inB[h] = true
+	}
+
+	for i, h := range hashesA {
+		if inB[h] {
+			report.SharedEntriesA++
+			report.SharedBytesA += candidatesA[i].originalSize
+		}
+	}
+	shared := make(map[[sha256.Size]byte]bool)
+	for i, h := range hashesB {
+		if inA[h] {
+			report.SharedEntriesB++
+			report.SharedBytesB += candidatesB[i].originalSize
+			shared[h] = true
+		}
+	}
+	report.SharedContents = len(shared)
+	return report, nil
+}
+
+// filterBySize returns the non-empty entries whose size is in sizes
+func filterBySize(entries []indexEntry, sizes map[uint64]bool) []indexEntry {
+	var out []indexEntry
+	for _, e := range entries {
+		if e.originalSize > 0 && e.attrs.regular() && sizes[e.originalSize] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// hashEntries computes the SHA-256 of each entry's decompressed content in parallel
+func hashEntries(r io.ReaderAt, entries []indexEntry) ([][sha256.Size]byte, error) {
+	hashes := make([][sha256.Size]byte, len(entries))
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(entries))
+
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry indexEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			zr, err := entryReader(r, entry)
+			if err != nil {
+				errCh <- &EntryError{Path: entry.relPath, Op: "hash", Err: err}
+				return
+			}
+			h := sha256.New()
+			n, err := io.Copy(h, zr)
+			if err != nil {
+				errCh <- &EntryError{Path: entry.relPath, Op: "hash", Err: err}
+				return
+			}
+			if uint64(n) != entry.originalSize {
+				errCh <- &EntryError{Path: entry.relPath, Op: "hash", Err: fmt.Errorf("expected %d bytes, got %d", entry.originalSize, n)}
+				return
+			}
+			copy(hashes[i][:], h.Sum(nil))
+		}(i, entry)
+	}
+	wg.Wait()
+	close(errCh)
+
+	// Return first error if any
+	if len(errCh) > 0 {
+		return nil, <-errCh
+	}
+	return hashes, nil
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"agcp/pkg/progress"
+)
+
+// isBlockDevice reports whether info describes a block device, such as a
+// disk partition, whose content is read and written like a file of fixed size
+func isBlockDevice(info os.FileInfo) bool {
+	return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+}
+
+// newDeviceEntry creates the entry for a block device given as input. Its
+// size is the device's rather than the 0 that stat reports, and it is
+// recorded as a regular file, so the image extracts to a file by default.
+func newDeviceEntry(filePath string, info os.FileInfo) (Entry, error) {
+	size, err := deviceSize(filePath)
+	if err != nil {
+		return Entry{}, fmt.Errorf("size of device %s: %w", filePath, err)
+	}
+	entry := newEntry("", filePath, info)
+	entry.Size, entry.device = size, true
+	entry.attrs.mode = info.Mode().Perm()
+	return entry, nil
+}
+
+// targetSize returns the size of an existing extraction target under
+// Options.ToDevice: the device's size, or a regular file's length
+func targetSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case isBlockDevice(info):
+		return deviceSize(path)
+	case info.Mode().IsRegular():
+		return info.Size(), nil
+	}
+	return 0, fmt.Errorf("%s is neither a block device nor a regular file", path)
+}
+
+// checkDeviceTarget checks that an archive can be written over the device at
+// its destination under Options.ToDevice: a file archive, whose content fits
+func checkDeviceTarget(archiveType ArchiveType, tasks []DecompressTask, opts Options) error {
+	if archiveType != ArchiveFile || len(tasks) != 1 {
+		return fmt.Errorf("writing to a device needs a single-file archive, such as a disk image")
+	}
+	if opts.Scan != nil || opts.Transform != nil || opts.Update {
+		return fmt.Errorf("writing to a device cannot be combined with scan, transform or update")
+	}
+	task := tasks[0]
+	size, err := targetSize(task.DestPath)
+	if err != nil {
+		return fmt.Errorf("target device: %w", err)
+	}
+	if uint64(size) < task.OriginalSize {
+		return fmt.Errorf("target device %s is %d bytes, the image needs %d", task.DestPath, size, task.OriginalSize)
+	}
+	return nil
+}
+
+// zeroSkipWriter writes to a file through w, seeking past writes that are
+// all zeros instead, for Options.SkipZeros
+type zeroSkipWriter struct {
+	w io.Writer
+	f *os.File
+}
+
+// Write implements io.Writer
+func (zw *zeroSkipWriter) Write(p []byte) (int, error) {
+	if !allZero(p) {
+		return zw.w.Write(p)
+	}
+	if _, err := zw.f.Seek(int64(len(p)), io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// endSkippedZeros sets the length of a file extracted with Options.SkipZeros
+// to size. Zeros skipped at its end were only seeked past, and a file ends at
+// its last write; the missing end reads as zeros, without being written.
+func endSkippedZeros(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("extend %s past skipped zeros: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// allZero reports whether b holds only zero bytes
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeDevice extracts the image in task over the existing device or file at
+// its destination, which checkDeviceTarget has found large enough. The target
+// is written in place: not created, truncated, preallocated or removed on
+// failure, and bytes past the image are left as they were.
+func writeDevice(r io.Reader, task DecompressTask, tracker *progress.Tracker, budget *ioBudget) error {
+	if task.OriginalSize == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(task.DestPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open device %s: %w", task.DestPath, err)
+	}
+	defer f.Close()
+
+	if task.blockWorkers > 1 && budget == nil && task.attrs.codec == codecLZ4 {
+		if split, err := extractBlocks(f, task, tracker); split || err != nil {
+			if err != nil {
+				return err
+			}
+			return syncEntry(f, task.fsync)
+		}
+	}
+
+	var w io.Writer = f
+	var bw *budgetWriter
+	if budget != nil {
+		bw = &budgetWriter{f: f, budget: budget}
+		defer bw.flush() // Release held budget on error paths
+		w = bw
+	}
+	if task.skipZeros {
+		w = &zeroSkipWriter{w: w, f: f}
+	}
+	zr, err := entryDecoder(r, task.attrs)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", task.DestPath, err)
+	}
+	if err := copyEntry(w, zr, task, tracker, bw); err != nil {
+		return err
+	}
+	if err := syncEntry(f, task.fsync); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,34 @@
+//go:build darwin
+
+package core
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Disk ioctls from <sys/disk.h>
+const (
+	dkiocGetBlockSize  = 0x40046418 // DKIOCGETBLOCKSIZE
+	dkiocGetBlockCount = 0x40086419 // DKIOCGETBLOCKCOUNT
+)
+
+// deviceSize returns the size of the block device at path from its block
+// size and count, since seeking to the end of a macOS disk device finds 0
+func deviceSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var blockSize uint32
+	var blockCount uint64
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), dkiocGetBlockSize, uintptr(unsafe.Pointer(&blockSize))); errno != 0 {
+		return 0, errno
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), dkiocGetBlockCount, uintptr(unsafe.Pointer(&blockCount))); errno != 0 {
+		return 0, errno
+	}
+	return int64(blockCount) * int64(blockSize), nil
+}
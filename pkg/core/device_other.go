@@ -0,0 +1,19 @@
+//go:build !darwin
+
+package core
+
+import (
+	"io"
+	"os"
+)
+
+// deviceSize returns the size of the block device at path by seeking to its
+// end, which Linux and the BSDs report for disk devices
+func deviceSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Seek(0, io.SeekEnd)
+}
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// FindingStatus is how a Doctor check came out
+type FindingStatus string
+
+const (
+	FindingOK   FindingStatus = "ok"
+	FindingWarn FindingStatus = "warn" // agcp works, but not as well as it could
+	FindingFail FindingStatus = "fail" // agcp will not work properly
+)
+
+// Finding is the outcome of one Doctor check
+type Finding struct {
+	Check  string        `json:"check"`
+	Status FindingStatus `json:"status"`
+	Detail string        `json:"detail"`
+	Advice string        `json:"advice,omitempty"` // What to do about a warning or failure
+}
+
+// DoctorReport is the result of Doctor
+type DoctorReport struct {
+	Findings []Finding
+}
+
+// Worst returns the most severe status among the findings
+func (r *DoctorReport) Worst() FindingStatus {
+	worst := FindingOK
+	for _, f := range r.Findings {
+		switch {
+		case f.Status == FindingFail:
+			return FindingFail
+		case f.Status == FindingWarn:
+			worst = FindingWarn
+		}
+	}
+	return worst
+}
+
+// Doctor thresholds
+const (
+	doctorMinTempSpace  = 1 << 30  // Free space in the temporary directory below which to warn
+	doctorMinOpenFiles  = 1024     // Open file limit below which to warn
+	doctorMinRate       = 20 << 20 // Compression rate, in bytes per second, below which to warn
+	doctorThroughputLen = 32 << 20 // Sample data compressed to measure throughput
+)
+
+// Doctor runs agcp's self-check: it describes the build, checks the
+// temporary directory (opts.TempDir, or the OS default) and its free space,
+// the open file limit and every codec, round-trips a small tree through an
+// archive and measures compression and extraction throughput on sample data,
+// reporting a Finding for each. Scratch files go in the temporary directory
+// and are removed before it returns.
+func Doctor(opts Options) *DoctorReport {
+	r := &DoctorReport{}
+	add := func(f Finding) { r.Findings = append(r.Findings, f) }
+
+	add(Finding{Check: "build", Status: FindingOK,
+		Detail: fmt.Sprintf("agcp %s, format v%d, %s/%s, %s", buildVersion(), Version, runtime.GOOS, runtime.GOARCH, runtime.Version())})
+	add(checkCPUs())
+
+	scratch, finding := checkTempDir(opts.TempDir)
+	add(finding)
+	if scratch != "" {
+		defer os.RemoveAll(scratch)
+		add(checkTempSpace(scratch))
+	}
+	add(checkOpenFiles())
+	add(checkCodecs())
+
+	if scratch == "" {
+		for _, check := range []string{"round-trip", "throughput"} {
+			add(Finding{Check: check, Status: FindingFail, Detail: "skipped: no scratch directory", Advice: "fix the temporary directory first"})
+		}
+		return r
+	}
+	scratchOpts := Options{TempDir: scratch}
+	add(checkRoundTrip(filepath.Join(scratch, "round-trip"), scratchOpts))
+	add(checkThroughput(filepath.Join(scratch, "throughput"), scratchOpts))
+	return r
+}
+
+// checkCPUs reports the cores agcp runs on
+func checkCPUs() Finding {
+	cpus, procs := runtime.NumCPU(), runtime.GOMAXPROCS(0)
+	f := Finding{Check: "cpu", Status: FindingOK, Detail: fmt.Sprintf("%d CPUs, using %d", cpus, procs)}
+	if procs < cpus {
+		f.Status = FindingWarn
+		f.Advice = fmt.Sprintf("GOMAXPROCS limits agcp to %d of %d CPUs; unset it to compress on all of them", procs, cpus)
+	}
+	return f
+}
+
+// checkTempDir checks that a scratch directory can be made in dir, returning
+// its path, or "" if it cannot
+func checkTempDir(dir string) (string, Finding) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f := Finding{Check: "temp-dir"}
+	scratch, err := os.MkdirTemp(dir, "agcp-doctor-*")
+	if err != nil {
+		f.Status, f.Detail = FindingFail, fmt.Sprintf("cannot create files in %s: %v", dir, err)
+		f.Advice = "point --tmpdir (or TMPDIR) at a writable directory"
+		return "", f
+	}
+	f.Status, f.Detail = FindingOK, fmt.Sprintf("%s is writable", dir)
+	return scratch, f
+}
+
+// checkTempSpace reports the free space in the temporary directory, which
+// holds staged, decrypted and spilled data
+func checkTempSpace(scratch string) Finding {
+	f := Finding{Check: "temp-space", Status: FindingOK}
+	free, err := freeSpace(scratch)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		f.Detail = "free space is not known on this platform"
+	case err != nil:
+		f.Status, f.Detail = FindingWarn, fmt.Sprintf("cannot read free space: %v", err)
+	default:
+		f.Detail = fmt.Sprintf("%s free in %s", gibibytes(free), filepath.Dir(scratch))
+		if free < doctorMinTempSpace {
+			f.Status = FindingWarn
+			f.Advice = "encrypted archives, tape volumes and parallel compression stage data here; free some space or use --tmpdir on a larger volume"
+		}
+	}
+	return f
+}
+
+// checkOpenFiles reports the open file limit, which bounds how many files
+// workers can have open at once
+func checkOpenFiles() Finding {
+	f := Finding{Check: "open-files", Status: FindingOK}
+	soft, hard, err := openFileLimit()
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		f.Detail = "no per-process limit on this platform"
+	case err != nil:
+		f.Status, f.Detail = FindingWarn, fmt.Sprintf("cannot read the open file limit: %v", err)
+	default:
+		f.Detail = fmt.Sprintf("limit %d (hard limit %d)", soft, hard)
+		if soft < doctorMinOpenFiles {
+			f.Status = FindingWarn
+			if hard > soft {
+				f.Advice = fmt.Sprintf("large trees and many workers can run out of file descriptors; raise the limit with ulimit -n %d", min(hard, 4096))
+			} else {
+				f.Advice = "large trees and many workers can run out of file descriptors; raise the hard limit in limits.conf, or LimitNOFILE for a service"
+			}
+		}
+	}
+	return f
+}
+
+// checkCodecs round-trips sample data through every built-in and registered
+// codec
+func checkCodecs() Finding {
+	sample := doctorSample(rand.New(rand.NewSource(1)), 256<<10)
+	var ok, missing []string
+	var advice []string
+	ids := []codec{codecLZ4, codecGzip, codecZstd}
+	for _, name := range registeredCodecNames() {
+		if c, err := ParseCodec(name); err == nil {
+			ids = append(ids, c.resolve())
+		}
+	}
+	for _, id := range ids {
+		if err := codecRoundTrip(id, sample); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (%v)", id, err))
+			switch id {
+			case codecLZ4:
+				advice = append(advice, "LZ4 is the default codec: use a build without the nolz4 tag to read most archives")
+			case codecZstd:
+				advice = append(advice, "use a build without the nozstd tag to read zstd archives")
+			}
+			continue
+		}
+		ok = append(ok, id.String())
+	}
+	f := Finding{Check: "codecs", Status: FindingOK, Detail: strings.Join(ok, ", ") + " working"}
+	if len(missing) > 0 {
+		f.Status = FindingWarn
+		f.Detail += "; not working: " + strings.Join(missing, ", ")
+		f.Advice = strings.Join(advice, "; ")
+	}
+	return f
+}
+
+// codecRoundTrip encodes and decodes data with codec id
+func codecRoundTrip(id codec, data []byte) error {
+	var buf bytes.Buffer
+	zw, err := entryEncoder(&buf, entryAttrs{codec: id}, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	zr, err := entryDecoder(&buf, entryAttrs{codec: id})
+	if err != nil {
+		return err
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("decoded data differs")
+	}
+	return nil
+}
+
+// doctorFiles is the tree checkRoundTrip archives: text, incompressible data,
+// an empty file, a nested directory and a name outside ASCII
+var doctorFiles = map[string]int{
+	"text.txt":             200 << 10,
+	"random.bin":           1 << 20,
+	"empty":                0,
+	"nested/deeper/a.log":  64 << 10,
+	"nested/ünïcödé.txt":   1 << 10,
+	"nested/deeper/b.data": 5 << 20,
+}
+
+// checkRoundTrip compresses a small tree, verifies the archive and extracts
+// it, comparing every file with the original
+func checkRoundTrip(dir string, opts Options) Finding {
+	f := Finding{Check: "round-trip", Status: FindingFail, Advice: "agcp cannot archive reliably here; report this output"}
+	src := filepath.Join(dir, "src")
+	rng := rand.New(rand.NewSource(2))
+	for name, size := range doctorFiles {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			f.Detail = fmt.Sprintf("create sample tree: %v", err)
+			return f
+		}
+		data := doctorSample(rng, size)
+		if name == "random.bin" {
+			rng.Read(data)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			f.Detail = fmt.Sprintf("create sample tree: %v", err)
+			return f
+		}
+	}
+	archive := filepath.Join(dir, "src.agcp")
+	if err := Compress(context.Background(), src, archive, opts); err != nil {
+		f.Detail = fmt.Sprintf("compress: %v", err)
+		return f
+	}
+	if _, err := Verify(archive, false, opts); err != nil {
+		f.Detail = fmt.Sprintf("verify: %v", err)
+		return f
+	}
+	out := filepath.Join(dir, "out")
+	if err := Decompress(context.Background(), archive, out, opts); err != nil {
+		f.Detail = fmt.Sprintf("extract: %v", err)
+		return f
+	}
+	for name := range doctorFiles {
+		want, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(name)))
+		if err != nil {
+			f.Detail = fmt.Sprintf("read %s: %v", name, err)
+			return f
+		}
+		got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			f.Detail = fmt.Sprintf("read extracted %s: %v", name, err)
+			return f
+		}
+		if !bytes.Equal(got, want) {
+			f.Detail = fmt.Sprintf("extracted %s differs from the original", name)
+			return f
+		}
+	}
+	f.Status, f.Advice = FindingOK, ""
+	f.Detail = fmt.Sprintf("%d files compressed, verified and extracted intact", len(doctorFiles))
+	return f
+}
+
+// checkThroughput times compressing and extracting sample data that is
+// three quarters text and one quarter incompressible
+func checkThroughput(dir string, opts Options) Finding {
+	f := Finding{Check: "throughput", Status: FindingFail}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		f.Detail = fmt.Sprintf("create sample data: %v", err)
+		return f
+	}
+	rng := rand.New(rand.NewSource(3))
+	data := doctorSample(rng, doctorThroughputLen)
+	rng.Read(data[len(data)*3/4:])
+	src := filepath.Join(dir, "sample")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		f.Detail = fmt.Sprintf("create sample data: %v", err)
+		return f
+	}
+	archive := filepath.Join(dir, "sample.agcp")
+	began := time.Now()
+	if err := Compress(context.Background(), src, archive, opts); err != nil {
+		f.Detail = fmt.Sprintf("compress: %v", err)
+		return f
+	}
+	compressRate := float64(len(data)) / time.Since(began).Seconds()
+	began = time.Now()
+	if err := Decompress(context.Background(), archive, filepath.Join(dir, "out"), opts); err != nil {
+		f.Detail = fmt.Sprintf("extract: %v", err)
+		return f
+	}
+	extractRate := float64(len(data)) / time.Since(began).Seconds()
+
+	f.Status = FindingOK
+	f.Detail = fmt.Sprintf("compress %.0f MiB/s, extract %.0f MiB/s on %d MiB of sample data", compressRate/(1<<20), extractRate/(1<<20), len(data)>>20)
+	if compressRate < doctorMinRate {
+		f.Status = FindingWarn
+		f.Advice = "compression is unusually slow; check for CPU throttling, other busy processes or slow storage under the temporary directory"
+	}
+	return f
+}
+
+// doctorSample returns size bytes of text-like sample data
+func doctorSample(rng *rand.Rand, size int) []byte {
+	words := []string{"archive", "block", "codec", "data", "entry", "frame", "header", "index", "stream", "volume"}
+	var b bytes.Buffer
+	for b.Len() < size {
+		b.WriteString(words[rng.Intn(len(words))])
+		if rng.Intn(12) == 0 {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.Bytes()[:size]
+}
+
+// gibibytes renders a byte count in GiB
+func gibibytes(n uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !freebsd && !linux && !windows
+
+package core
+
+import "errors"
+
+// freeSpace is not supported on this platform
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// openFileLimit is not supported on this platform
+func openFileLimit() (soft, hard uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,24 @@
+//go:build darwin || freebsd || linux
+
+package core
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding path
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// openFileLimit returns the soft and hard limits on open files
+func openFileLimit() (soft, hard uint64, err error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	return uint64(rl.Cur), uint64(rl.Max), nil
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrEncrypted is returned when an encrypted archive is read without being
+// decrypted first
+var ErrEncrypted = errors.New("archive is encrypted")
+
+// EncryptBackend is a public-key encryption tool archives can be encrypted
+// with. Backends run the tool's command, which must be on the PATH.
+type EncryptBackend string
+
+const (
+	BackendAge EncryptBackend = "age" // age (https://age-encryption.org), recipients are age or SSH public keys
+	BackendGPG EncryptBackend = "gpg" // GnuPG, recipients are key IDs, fingerprints or email addresses
+)
+
+// Recipient is a public key an archive is encrypted to. Only the holder of the
+// matching secret key can decrypt it, so archives can be created on machines
+// that never hold a decryption secret.
+type Recipient struct {
+	Backend EncryptBackend
+	Key     string
+}
+
+// ParseRecipient parses a recipient: an age public key ("age1..."), an SSH
+// public key, or a GPG key ID, fingerprint or email address. An "age:" or
+// "gpg:" prefix selects the backend explicitly.
+func ParseRecipient(s string) (Recipient, error) {
+	s = strings.TrimSpace(s)
+	if backend, key, ok := strings.Cut(s, ":"); ok && (backend == "age" || backend == "gpg") {
+		if key == "" {
+			return Recipient{}, fmt.Errorf("empty %s recipient", backend)
+		}
+		return Recipient{Backend: EncryptBackend(backend), Key: key}, nil
+	}
+	switch {
+	case s == "":
+		return Recipient{}, fmt.Errorf("empty recipient")
+	case strings.HasPrefix(s, "age1"), strings.HasPrefix(s, "ssh-"):
+		return Recipient{Backend: BackendAge, Key: s}, nil
+	}
+	return Recipient{Backend: BackendGPG, Key: s}, nil
+}
+
+// String formats the recipient as "backend:key"
+func (r Recipient) String() string {
+	return string(r.Backend) + ":" + r.Key
+}
+
+// checkRecipients checks that the recipients share one backend whose command
+// is installed, before any time is spent compressing
+func checkRecipients(recipients []Recipient) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	backend := recipients[0].Backend
+	for _, r := range recipients[1:] {
+		if r.Backend != backend {
+			return fmt.Errorf("recipients %s and %s use different encryption backends", recipients[0], r)
+		}
+	}
+	if backend != BackendAge && backend != BackendGPG {
+		return fmt.Errorf("unknown encryption backend %q", backend)
+	}
+	if _, err := exec.LookPath(string(backend)); err != nil {
+		return fmt.Errorf("encrypt to %s: %w", recipients[0], err)
+	}
+	return nil
+}
+
+// encryptFile encrypts input to output for every recipient. The recipients
+// must have passed checkRecipients.
+func encryptFile(input, output string, recipients []Recipient) error {
+	backend := recipients[0].Backend
+	var args []string
+	switch backend {
+	case BackendAge:
+		args = []string{"--encrypt"}
+		for _, r := range recipients {
+			args = append(args, "--recipient", r.Key)
+		}
+	case BackendGPG:
+		// The recipients were named explicitly, so don't require them to be
+		// certified in the keyring's web of trust
+		args = []string{"--batch", "--yes", "--trust-model", "always", "--encrypt"}
+		for _, r := range recipients {
+			args = append(args, "--recipient", r.Key)
+		}
+	}
+	return runBackend(backend, "encrypt", append(args, "--output", output, input)...)
+}
+
+// decryptFile decrypts input to output with the backend it was encrypted
+// with. age needs identity files; GPG finds its secret keys in the keyring.
+func decryptFile(backend EncryptBackend, input, output string, identities []string) error {
+	var args []string
+	switch backend {
+	case BackendAge:
+		if len(identities) == 0 {
+			return fmt.Errorf("%w with age; give an identity file to decrypt it", ErrEncrypted)
+		}
+		args = []string{"--decrypt"}
+		for _, id := range identities {
+			args = append(args, "--identity", id)
+		}
+	case BackendGPG:
+		args = []string{"--batch", "--yes", "--decrypt"}
+	}
+	return runBackend(backend, "decrypt", append(args, "--output", output, input)...)
+}
+
+// runBackend runs a backend's command, including its error output in any error
+func runBackend(backend EncryptBackend, op string, args ...string) error {
+	cmd := exec.Command(string(backend), args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s %s: %w: %s", backend, op, err, msg)
+		}
+		return fmt.Errorf("%s %s: %w", backend, op, err)
+	}
+	return nil
+}
+
+// sniffEncryption returns the backend that produced data starting with head,
+// or "" when it does not look encrypted
+func sniffEncryption(head []byte) EncryptBackend {
+	switch {
+	case bytes.HasPrefix(head, []byte("age-encryption.org/")),
+		bytes.HasPrefix(head, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return BackendAge
+	case bytes.HasPrefix(head, []byte("-----BEGIN PGP MESSAGE-----")):
+		return BackendGPG
+	case len(head) > 0 && (head[0] == 0x84 || head[0] == 0x85 || head[0] == 0xc1):
+		return BackendGPG // OpenPGP public-key encrypted session key packet
+	}
+	return ""
+}
+
+// encryptedBackend reports the backend a file was encrypted with, or ""
+func encryptedBackend(path string) (EncryptBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open input: %w", err)
+	}
+	defer f.Close()
+	head := make([]byte, 64)
+	n, _ := f.Read(head)
+	return sniffEncryption(head[:n]), nil
+}
+
+// decryptToTemp decrypts an encrypted archive to a temporary file in
+// opts.TempDir, returning its path and the function that removes it
+func decryptToTemp(backend EncryptBackend, input string, opts Options) (string, func(), error) {
+	f, err := os.CreateTemp(opts.TempDir, "agcp-decrypted-*.agcp")
+	if err != nil {
+		return "", nil, fmt.Errorf("create decryption file: %w", err)
+	}
+	f.Close()
+	release := trackFile(f.Name(), cleanupTemp)
+	remove := func() {
+		os.Remove(f.Name())
+		release()
+	}
+	if err := decryptFile(backend, input, f.Name(), opts.Identities); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
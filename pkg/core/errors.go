@@ -0,0 +1,19 @@
+package core
+
+// EntryError records a failure affecting a single archive entry, so callers can
+// tell which entry failed with errors.As instead of parsing the message
+type EntryError struct {
+	Path string // Entry path within the archive (the root name for file archives)
+	Op   string // Operation that failed: "compress", "extract", "search", "hash", "verify", "check", "stat", "read" or "copy"
+	Err  error  // Underlying error
+}
+
+// Error implements error
+func (e *EntryError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
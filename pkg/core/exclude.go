@@ -0,0 +1,47 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// excludeMatcher is Options.Exclude compiled for matching the paths of a
+// directory walk
+type excludeMatcher struct {
+	set     *globSet
+	matches []int // Scratch for the set's matches
+}
+
+// compileExclude prepares patterns for matching, returning a nil matcher,
+// which matches nothing, if there are none
+func compileExclude(patterns []string) (*excludeMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	m := &excludeMatcher{set: newGlobSet()}
+	for i, pattern := range patterns {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+			}
+		}
+		if strings.Contains(pattern, "/") {
+			m.set.addPath(i, pattern)
+		} else {
+			m.set.addBase(i, pattern)
+		}
+	}
+	return m, nil
+}
+
+// match reports whether the file or directory with the given path in the
+// archive is excluded
+func (m *excludeMatcher) match(name string) bool {
+	if m == nil {
+		return false
+	}
+	m.matches = m.set.appendMatches(m.matches[:0], filepath.ToSlash(name))
+	return len(m.matches) > 0
+}
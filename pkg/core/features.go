@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MinReaderVersion is the oldest format version whose readers can read the
+// archives this agcp writes, unless an archive uses a required feature
+// introduced later, which raises its minimum to that feature's version. Since v7 the header records the minimum reader
+// version of each archive right after the format version, so a reader can
+// tell an archive it cannot read from one that only adds optional features:
+// a writer may record a minimum below its own version only if the archive
+// keeps the layout of that version and adds nothing but optional features.
+const MinReaderVersion = 7
+
+// ErrUnsupportedVersion is wrapped by the *VersionError returned for archives
+// this agcp cannot read
+var ErrUnsupportedVersion = errors.New("unsupported archive version")
+
+// Feature is a part of the format an archive uses. Since v7 the header lists
+// the features of each archive by name, so a reader that doesn't know one can
+// say what it is missing.
+type Feature struct {
+	Name     string // e.g. "gzip"
+	Since    int    // Format version whose readers understand it
+	Required bool   // Readers without it cannot extract the archive; optional features are ignored
+}
+
+// String describes the feature for error messages, e.g. "gzip (required, format v3)"
+func (f Feature) String() string {
+	kind := "optional"
+	if f.Required {
+		kind = "required"
+	}
+	return fmt.Sprintf("%s (%s, format v%d)", f.Name, kind, f.Since)
+}
+
+// Features recorded in the header. Owners, modes, times and hashes are
+// recorded for almost every entry and are not listed.
+var (
+	featureGzip       = Feature{Name: "gzip", Since: 3, Required: true}
+	featureTags       = Feature{Name: "tags", Since: 3}
+	featureInline     = Feature{Name: "inline", Since: 7, Required: true}
+	featureRawNames   = Feature{Name: "raw-names", Since: 7}
+	featureZstd       = Feature{Name: "zstd", Since: 8, Required: true}
+	featureProvenance = Feature{Name: "provenance", Since: 9, Required: true}
+)
+
+// VersionError is returned for an archive whose format version is newer than
+// this agcp reads and whose minimum reader version is newer too
+type VersionError struct {
+	Version   int       // Format version of the archive
+	MinReader int       // Oldest format version whose readers can read it
+	Missing   []Feature // Features of the archive this agcp doesn't know
+}
+
+// Error implements error
+func (e *VersionError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v: the archive is format v%d and needs an agcp that reads format v%d or later, but this one reads v1 to v%d; upgrade agcp to extract it",
+		ErrUnsupportedVersion, e.Version, e.MinReader, Version)
+	if len(e.Missing) > 0 {
+		names := make([]string, len(e.Missing))
+		for i, f := range e.Missing {
+			names[i] = f.String()
+		}
+		fmt.Fprintf(&sb, ". It uses %s", strings.Join(names, ", "))
+	}
+	return sb.String()
+}
+
+// Unwrap returns ErrUnsupportedVersion
+func (e *VersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
+// archiveFeatures returns the features used by entries and by the provenance
+// block if prov is set, in a fixed order
+func archiveFeatures(entries []Entry, prov *Provenance) []Feature {
+	var gzip, tags, inline, raw, zstd bool
+	for _, entry := range entries {
+		gzip = gzip || entry.attrs.codec == codecGzip
+		zstd = zstd || entry.attrs.codec == codecZstd
+		tags = tags || len(entry.attrs.tags) > 0
+		inline = inline || entry.attrs.codec == codecInline
+		raw = raw || entry.attrs.rawName
+	}
+	var features []Feature
+	for _, f := range []struct {
+		used    bool
+		feature Feature
+	}{{gzip, featureGzip}, {tags, featureTags}, {inline, featureInline}, {raw, featureRawNames}, {zstd, featureZstd}, {prov != nil, featureProvenance}} {
+		if f.used {
+			features = append(features, f.feature)
+		}
+	}
+	return features
+}
+
+// encodeFeatures encodes the minimum reader version and feature list of a v7+
+// header: minReader(1) + count(uvarint), then for each feature since(1) +
+// required(1) + nameLen(uvarint) + name
+func encodeFeatures(features []Feature) []byte {
+	minReader := MinReaderVersion
+	for _, f := range features {
+		if f.Required {
+			minReader = max(minReader, f.Since)
+		}
+	}
+	b := []byte{byte(minReader)}
+	b = binary.AppendUvarint(b, uint64(len(features)))
+	for _, f := range features {
+		required := byte(0)
+		if f.Required {
+			required = 1
+		}
+		b = append(b, byte(f.Since), required)
+		b = binary.AppendUvarint(b, uint64(len(f.Name)))
+		b = append(b, f.Name...)
+	}
+	return b
+}
+
+// Bounds on the feature list read from a header
+const (
+	maxFeatures    = 64
+	maxFeatureName = 255
+)
+
+// readFeatures reads the minimum reader version and feature list of a v7+
+// header
+func readFeatures(r *bufio.Reader) (uint8, []Feature, error) {
+	minReader, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("read minimum reader version: %w", err)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read feature count: %w", err)
+	}
+	if count > maxFeatures {
+		return 0, nil, fmt.Errorf("corrupt archive: %d features", count)
+	}
+	features := make([]Feature, count)
+	for i := range features {
+		since, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("read feature %d: %w", i, err)
+		}
+		required, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("read feature %d: %w", i, err)
+		}
+		nameLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, nil, fmt.Errorf("read feature %d: %w", i, err)
+		}
+		if nameLen > maxFeatureName {
+			return 0, nil, fmt.Errorf("corrupt archive: feature name length %d", nameLen)
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return 0, nil, fmt.Errorf("read feature %d: %w", i, err)
+		}
+		features[i] = Feature{Name: string(name), Since: int(since), Required: required&1 != 0}
+	}
+	return minReader, features, nil
+}
+
+// hasFeature reports whether features includes the feature named like f
+func hasFeature(features []Feature, f Feature) bool {
+	for _, feature := range features {
+		if feature.Name == f.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFeatures returns the features newer than this agcp reads
+func unknownFeatures(features []Feature) []Feature {
+	var unknown []Feature
+	for _, f := range features {
+		if f.Since > Version {
+			unknown = append(unknown, f)
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FsyncPolicy says when written data is synced to stable storage
+type FsyncPolicy uint8
+
+const (
+	FsyncNone       FsyncPolicy = iota // Leave flushing to the operating system: fastest, for CI and scratch data
+	FsyncPerFile                       // Sync each entry as it is completed, and everything at the end
+	FsyncPerArchive                    // Sync once, when the archive or extracted tree is complete
+)
+
+// ParseFsyncPolicy parses a policy name: "none", "per-file" or "per-archive"
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return FsyncNone, nil
+	case "per-file":
+		return FsyncPerFile, nil
+	case "per-archive":
+		return FsyncPerArchive, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q (want none, per-file or per-archive)", name)
+}
+
+// String returns the policy name
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncPerFile:
+		return "per-file"
+	case FsyncPerArchive:
+		return "per-archive"
+	}
+	return "none"
+}
+
+// syncEntry syncs f after an entry was written to it, under FsyncPerFile
+func syncEntry(f *os.File, policy FsyncPolicy) error {
+	if policy != FsyncPerFile {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// syncFiles syncs the data of the files at paths under FsyncPerArchive, and
+// under any policy but FsyncNone the directories holding them, so the files
+// themselves survive a crash
+func syncFiles(paths []string, policy FsyncPolicy) error {
+	if policy == FsyncNone {
+		return nil
+	}
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = true
+		if policy != FsyncPerArchive {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("open %s to sync: %w", path, err)
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("sync %s: %w", path, err)
+		}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("sync directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package core
+
+import "os"
+
+// syncDir syncs a directory, making the creation and renaming of the files in
+// it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package core
+
+// syncDir is a no-op on Windows, where directories cannot be synced and
+// NTFS journals their entries itself
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// globSet matches archive paths against many patterns at once, for policies
+// and tag rules with hundreds of patterns. Patterns are compiled once: the
+// common shapes (literal paths and base names, "*.ext" base names, "dir/**")
+// are looked up in maps, so a path costs a few lookups however many of them
+// there are, and only the remaining patterns are tried one by one.
+type globSet struct {
+	exact  map[string][]int // Whole paths without wildcards
+	base   map[string][]int // Base names without wildcards
+	suffix map[string][]int // "*.ext" base names, by suffix from its dot
+	dirs   map[string][]int // "dir/**" paths, by directory without wildcards
+	other  []globPattern    // Patterns matched one by one
+}
+
+// globPattern is a compiled pattern that has to be matched on its own
+type globPattern struct {
+	index int
+	base  bool     // Match the base name rather than the whole path
+	segs  []string // Pattern segments, or the single base name pattern
+}
+
+// newGlobSet returns an empty set
+func newGlobSet() *globSet {
+	return &globSet{
+		exact:  make(map[string][]int),
+		base:   make(map[string][]int),
+		suffix: make(map[string][]int),
+		dirs:   make(map[string][]int),
+	}
+}
+
+// hasMeta reports whether a pattern segment has wildcards
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// addPath adds pattern number index, matched against the whole path segment
+// by segment, where a "**" segment matches zero or more whole segments
+func (s *globSet) addPath(index int, pattern string) {
+	segs := strings.Split(pattern, "/")
+	last := len(segs) - 1
+	literal := func(segs []string) bool {
+		for _, seg := range segs {
+			if seg == "**" || hasMeta(seg) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case literal(segs):
+		s.exact[pattern] = append(s.exact[pattern], index)
+	case last > 0 && segs[last] == "**" && literal(segs[:last]):
+		dir := strings.Join(segs[:last], "/")
+		s.dirs[dir] = append(s.dirs[dir], index)
+	case len(segs) == 2 && segs[0] == "**" && segs[1] != "**":
+		s.addBase(index, segs[1]) // Any depth, so only the base name counts
+	default:
+		s.other = append(s.other, globPattern{index: index, segs: segs})
+	}
+}
+
+// addBase adds pattern number index, matched against the base name as by
+// path.Match
+func (s *globSet) addBase(index int, pattern string) {
+	switch {
+	case !hasMeta(pattern):
+		s.base[pattern] = append(s.base[pattern], index)
+	case strings.HasPrefix(pattern, "*.") && !hasMeta(pattern[1:]):
+		s.suffix[pattern[1:]] = append(s.suffix[pattern[1:]], index)
+	default:
+		s.other = append(s.other, globPattern{index: index, base: true, segs: []string{pattern}})
+	}
+}
+
+// appendMatches appends the numbers of the patterns matching the
+// slash-separated name to dst, in increasing order
+func (s *globSet) appendMatches(dst []int, name string) []int {
+	start := len(dst)
+	dst = append(dst, s.exact[name]...)
+	if len(s.dirs) > 0 {
+		dst = append(dst, s.dirs[name]...)
+		for i := 0; i < len(name); i++ {
+			if name[i] == '/' {
+				dst = append(dst, s.dirs[name[:i]]...)
+			}
+		}
+	}
+	base := path.Base(name)
+	dst = append(dst, s.base[base]...)
+	if len(s.suffix) > 0 {
+		for i := 0; i < len(base); i++ {
+			if base[i] == '.' {
+				dst = append(dst, s.suffix[base[i:]]...)
+			}
+		}
+	}
+	var segs []string
+	for _, p := range s.other {
+		if p.base {
+			if ok, _ := path.Match(p.segs[0], base); ok {
+				dst = append(dst, p.index)
+			}
+			continue
+		}
+		if segs == nil {
+			segs = strings.Split(name, "/")
+		}
+		if matchSegments(p.segs, segs) {
+			dst = append(dst, p.index)
+		}
+	}
+	sort.Ints(dst[start:])
+	return dst
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+// GrepMatch is one matching line found by Grep
+type GrepMatch struct {
+	Path   string // Entry path within the archive
+	Line   int    // 1-based line number
+	Text   string // Matching line without its line terminator
+	Binary bool   // Entry looks binary; only reports that it matches, Line and Text are unset
+}
+
+// maxGrepLine is the longest line Grep searches and reports; the rest of a
+// longer line is skipped, so a huge entry without newlines is never read into
+// memory at once
+const maxGrepLine = 1 << 20
+
+// grepResult is what searching one entry found
+type grepResult struct {
+	matches []GrepMatch
+	err     error
+}
+
+// Grep searches the decompressed content of archive entries for lines matching re
+// without extracting them. Only entries whose path or base name matches one of the
+// include glob patterns are searched; an empty include list searches all entries.
+// Entries are searched concurrently, but fn is called once per entry with at
+// least one match in entry table order, never concurrently. Lines longer than
+// 1 MiB are searched and reported only up to that length.
+func Grep(archivePath string, re *regexp.Regexp, include []string, fn func(matches []GrepMatch)) error {
+	f, idx, err := openIndex(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Validate patterns up front so a typo doesn't silently match nothing
+	for _, pattern := range include {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+
+	var selected []indexEntry
+	for _, entry := range idx.entries {
+		if entry.attrs.regular() && matchesAny(include, idx.name(entry)) {
+			selected = append(selected, entry)
+		}
+	}
+
+	// A fixed pool of workers takes the entries in order; each result goes to
+	// the entry's own channel so they are emitted in order as they come in
+	results := make([]chan grepResult, len(selected))
+	for i := range results {
+		results[i] = make(chan grepResult, 1)
+	}
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(jobs)
+		for i := range selected {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	workers := min(runtime.NumCPU(), len(selected))
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				name := idx.name(selected[i])
+				var r grepResult
+				zr, err := entryReader(f, selected[i])
+				if err == nil {
+					r.matches, err = grepReader(zr, re, name)
+				}
+				if err != nil {
+					r.err = &EntryError{Path: name, Op: "search", Err: err}
+				}
+				results[i] <- r
+			}
+		}()
+	}
+
+	// Search every entry and return the first error, if any
+	var firstErr error
+	for i := range selected {
+		r := <-results[i]
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		if len(r.matches) > 0 {
+			fn(r.matches)
+		}
+	}
+	return firstErr
+}
+
+// grepReader returns the lines of r matching re. A binary entry yields a
+// single match and is read only up to its first matching line.
+func grepReader(r io.Reader, re *regexp.Regexp, name string) ([]GrepMatch, error) {
+	var matches []GrepMatch
+	br := bufio.NewReaderSize(r, maxGrepLine)
+
+	// Like grep, treat content with NUL bytes near the start as binary
+	sample, _ := br.Peek(8000)
+	binary := bytes.IndexByte(sample, 0) >= 0
+
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Search the first maxGrepLine bytes and skip the rest of the line
+			line = bytes.Clone(line)
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+		}
+		if len(line) > 0 {
+			line = bytes.TrimRight(line, "\r\n")
+			if re.Match(line) {
+				if binary {
+					return []GrepMatch{{Path: name, Binary: true}}, nil
+				}
+				matches = append(matches, GrepMatch{Path: name, Line: lineNum, Text: string(line)})
+			}
+		}
+		if err == io.EOF {
+			return matches, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// matchesAny reports whether path or its base name matches one of the glob patterns.
+// An empty pattern list matches everything.
+func matchesAny(patterns []string, path string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	base := filepath.Base(path)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Archive is an open archive whose header and entry table are parsed once, for
+// programs that look up or extract many entries of the same archive. With
+// Options.CacheSize set, the decompressed content of recently read entries is
+// kept in an LRU cache. An Archive is safe for concurrent use.
+type Archive struct {
+	f      *os.File
+	idx    *archiveIndex
+	byName map[string]int // Slash-separated entry path to index in idx.entries
+	retry  RetryPolicy
+	cache  *contentCache
+}
+
+// OpenArchive opens an archive and parses its index. The caller must Close it.
+func OpenArchive(path string, opts Options) (*Archive, error) {
+	f, idx, err := openIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		f:      f,
+		idx:    idx,
+		byName: make(map[string]int, len(idx.entries)),
+		retry:  opts.Retry,
+		cache:  newContentCache(opts.CacheSize),
+	}
+	for i, entry := range idx.entries {
+		a.byName[filepath.ToSlash(idx.name(entry))] = i
+	}
+	return a, nil
+}
+
+// Close closes the archive file
+func (a *Archive) Close() error {
+	return a.f.Close()
+}
+
+// Created returns when the archive was written, in UTC. It is zero for
+// reproducible archives and archives written before format v6.
+func (a *Archive) Created() time.Time {
+	if a.idx.created == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, a.idx.created).UTC()
+}
+
+// Version returns the archive's format version
+func (a *Archive) Version() int {
+	return int(a.idx.version)
+}
+
+// Features returns the features the archive lists, for format v7 and later
+func (a *Archive) Features() []Feature {
+	return append([]Feature(nil), a.idx.features...)
+}
+
+// Provenance returns how the archive was produced, or nil if it was not
+// recorded
+func (a *Archive) Provenance() *Provenance {
+	return a.idx.provenance
+}
+
+// Entries returns the archive's entries in archive order
+func (a *Archive) Entries() []EntryInfo {
+	infos := make([]EntryInfo, len(a.idx.entries))
+	for i := range a.idx.entries {
+		infos[i] = a.info(i)
+	}
+	return infos
+}
+
+// Stat returns the entry with the given path. Paths use forward slashes; a
+// missing entry gives an error wrapping fs.ErrNotExist.
+func (a *Archive) Stat(name string) (EntryInfo, error) {
+	i, err := a.lookup(name, "stat")
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	return a.info(i), nil
+}
+
+// ReadEntry returns the decompressed content of an entry, from the cache if it
+// holds the entry. The returned slice may be shared with the cache and must not
+// be modified.
+func (a *Archive) ReadEntry(name string) ([]byte, error) {
+	i, err := a.lookupContent(name, "read")
+	if err != nil {
+		return nil, err
+	}
+	data, err := a.content(i)
+	if err != nil {
+		return nil, &EntryError{Path: name, Op: "read", Err: err}
+	}
+	return data, nil
+}
+
+// HeadEntry returns up to the first n bytes of an entry's content, decoding
+// only as much of the entry as that takes, so the start of a large file can be
+// inspected without decompressing all of it
+func (a *Archive) HeadEntry(name string, n int64) ([]byte, error) {
+	i, err := a.lookupContent(name, "head")
+	if err != nil {
+		return nil, err
+	}
+	entry := a.idx.entries[i]
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	if uint64(n) > entry.originalSize {
+		n = int64(entry.originalSize)
+	}
+	if data, ok := a.cache.get(i); ok {
+		return data[:n], nil
+	}
+	sr, done := a.section(entry)
+	defer done()
+	zr, err := entryDecoder(sr, entry.attrs)
+	if err != nil {
+		return nil, &EntryError{Path: name, Op: "head", Err: err}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, &EntryError{Path: name, Op: "head", Err: fmt.Errorf("decode: %w", err)}
+	}
+	return data, nil
+}
+
+// ExtractEntry writes the decompressed content of an entry to destPath,
+// creating its parent directories
+func (a *Archive) ExtractEntry(name, destPath string) error {
+	i, err := a.lookupContent(name, "extract")
+	if err != nil {
+		return err
+	}
+	entry := a.idx.entries[i]
+
+	if data, ok := a.cache.get(i); ok {
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return &EntryError{Path: name, Op: "extract", Err: fmt.Errorf("create parent dir for %s: %w", destPath, err)}
+		}
+		if err := os.WriteFile(destPath, data, 0644); err != nil {
+			return &EntryError{Path: name, Op: "extract", Err: err}
+		}
+		return nil
+	}
+
+	task := DecompressTask{
+		RelPath:        entry.relPath,
+		OriginalSize:   entry.originalSize,
+		CompressedSize: entry.compressedSize,
+		DestPath:       destPath,
+		name:           name,
+		attrs:          entry.attrs,
+	}
+	sr, done := a.section(entry)
+	defer done()
+	if err := decompressFileStreaming(sr, task, nil, nil); err != nil {
+		return &EntryError{Path: name, Op: "extract", Err: err}
+	}
+	return nil
+}
+
+// lookup returns the index of the named entry
+func (a *Archive) lookup(name, op string) (int, error) {
+	i, ok := a.byName[filepath.ToSlash(name)]
+	if !ok {
+		return 0, &EntryError{Path: name, Op: op, Err: fs.ErrNotExist}
+	}
+	return i, nil
+}
+
+// lookupContent returns the index of the named entry, which must be one whose
+// content can be read
+func (a *Archive) lookupContent(name, op string) (int, error) {
+	i, err := a.lookup(name, op)
+	if err != nil {
+		return 0, err
+	}
+	if kind := a.idx.entries[i].attrs.kind; kind != typeRegular {
+		return 0, &EntryError{Path: name, Op: op, Err: fmt.Errorf("%w: %v", ErrEntryType, kind)}
+	}
+	return i, nil
+}
+
+// info describes entry i
+func (a *Archive) info(i int) EntryInfo {
+	entry := a.idx.entries[i]
+	info := EntryInfo{
+		Path:           a.idx.name(entry),
+		OriginalSize:   entry.originalSize,
+		CompressedSize: entry.compressedSize,
+		Offset:         entry.offset,
+		Type:           entry.attrs.kind.String(),
+		Codec:          entry.attrs.codec.String(),
+		Tags:           entry.attrs.tags,
+		Mode:           entry.attrs.mode,
+		RawName:        entry.attrs.rawName,
+	}
+	if entry.attrs.hasMtime {
+		info.ModTime = time.Unix(0, entry.attrs.mtime).UTC()
+	}
+	if entry.attrs.hasHash {
+		info.SHA256 = append([]byte(nil), entry.attrs.hash[:]...)
+	}
+	return info
+}
+
+// section returns a reader over the compressed data of an entry, and a
+// function to call once done with it
+func (a *Archive) section(entry indexEntry) (*io.SectionReader, func() error) {
+	ra := NewRetryReaderAt(a.f.Name(), a.f, a.retry)
+	return io.NewSectionReader(ra, entry.offset, int64(entry.compressedSize)), ra.Close
+}
+
+// content returns the decompressed content of entry i, caching it if it fits
+func (a *Archive) content(i int) ([]byte, error) {
+	if data, ok := a.cache.get(i); ok {
+		return data, nil
+	}
+	entry := a.idx.entries[i]
+	if entry.originalSize == 0 {
+		return []byte{}, nil
+	}
+	sr, done := a.section(entry)
+	defer done()
+	zr, err := entryDecoder(sr, entry.attrs)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(entry.originalSize))
+	n, err := io.CopyN(&buf, zr, int64(entry.originalSize))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if uint64(n) != entry.originalSize {
+		return nil, fmt.Errorf("decoded %d bytes, expected %d", n, entry.originalSize)
+	}
+	a.cache.put(i, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// contentCache is an LRU cache of decompressed entry content bounded by total
+// size. A nil cache holds nothing.
+type contentCache struct {
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	order *list.List            // Most recently used at the front
+	items map[int]*list.Element // Entry index to element holding a *cacheItem
+}
+
+// cacheItem is one cached entry
+type cacheItem struct {
+	index int
+	data  []byte
+}
+
+// newContentCache returns a cache holding up to max bytes, or nil if max is not positive
+func newContentCache(max int64) *contentCache {
+	if max <= 0 {
+		return nil
+	}
+	return &contentCache{max: max, order: list.New(), items: make(map[int]*list.Element)}
+}
+
+// get returns the cached content of entry i
+func (c *contentCache) get(i int) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[i]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheItem).data, true
+}
+
+// put caches the content of entry i, evicting the least recently used entries
+// to make room. Content larger than the whole cache is not cached.
+func (c *contentCache) put(i int, data []byte) {
+	if c == nil || int64(len(data)) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[i]; ok {
+		return
+	}
+	for c.size+int64(len(data)) > c.max {
+		oldest := c.order.Back()
+		item := oldest.Value.(*cacheItem)
+		c.order.Remove(oldest)
+		delete(c.items, item.index)
+		c.size -= int64(len(item.data))
+	}
+	c.items[i] = c.order.PushFront(&cacheItem{index: i, data: data})
+	c.size += int64(len(data))
+}
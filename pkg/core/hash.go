@@ -0,0 +1,63 @@
+package core
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// hashChunkSize is the size of the chunks a file is read in, each fed to both
+// the entry's encoder and its hasher
+const hashChunkSize = 32 * 1024
+
+// chunkHasher computes the SHA-256 of a stream of chunks on its own goroutine,
+// so hashing a chunk overlaps with compressing it and a compressing worker runs
+// at close to the codec's own speed. It owns two chunk buffers: one can be
+// read into and compressed while the hasher works through the other.
+type chunkHasher struct {
+	free   chan []byte // Buffers the hasher is done with
+	chunks chan []byte // Chunks waiting to be hashed
+	done   chan struct{}
+	once   sync.Once
+	sum    [sha256.Size]byte
+}
+
+// newChunkHasher starts a hasher. Its Sum must be called, even on error
+// paths, to stop it.
+func newChunkHasher() *chunkHasher {
+	h := &chunkHasher{
+		free:   make(chan []byte, 2),
+		chunks: make(chan []byte, 2),
+		done:   make(chan struct{}),
+	}
+	h.free <- make([]byte, hashChunkSize)
+	h.free <- make([]byte, hashChunkSize)
+	go func() {
+		defer close(h.done)
+		sha := sha256.New()
+		for chunk := range h.chunks {
+			sha.Write(chunk)
+			h.free <- chunk[:cap(chunk)]
+		}
+		sha.Sum(h.sum[:0])
+	}()
+	return h
+}
+
+// buffer returns a buffer to read the next chunk into, waiting until the
+// hasher is done with it. The caller must be done with the previous chunk.
+func (h *chunkHasher) buffer() []byte {
+	return <-h.free
+}
+
+// add queues a chunk read into a buffer from buffer for hashing. The caller
+// may go on reading the chunk, but not write to it.
+func (h *chunkHasher) add(chunk []byte) {
+	h.chunks <- chunk
+}
+
+// Sum waits for the queued chunks to be hashed and returns their SHA-256
+func (h *chunkHasher) Sum() [sha256.Size]byte {
+	h.once.Do(func() { close(h.chunks) })
+	<-h.done
+	return h.sum
+}
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HealthCatalogName is the catalog CheckHealth keeps in the directory it checks,
+// recording when each archive was last fully verified
+const HealthCatalogName = ".agcp-catalog.json"
+
+// Health scoring: an archive starts at 100 and loses points for each problem
+const (
+	scoreUnreadable     = 0  // Score of an archive that cannot be read at all
+	scoreFailed         = 25 // Highest score of an archive with failing entries
+	penaltyNeverChecked = 20 // Never fully verified
+	penaltyStalePeriod  = 10 // Per maxAge since the last full verification...
+	penaltyStaleMax     = 40 // ...up to this
+	penaltyNoHashes     = 10 // Format too old to record content hashes
+)
+
+// Recommended actions for an archive
+const (
+	ActionReverify = "re-verify" // Verify every entry again
+	ActionRecreate = "re-create" // Compress the data into a new archive
+)
+
+// Recommendation is an action CheckHealth suggests for an archive, and why
+type Recommendation struct {
+	Action string // ActionReverify or ActionRecreate
+	Reason string
+}
+
+// ArchiveHealth is the health of one archive
+type ArchiveHealth struct {
+	Path            string // Path of the archive, under the checked directory
+	Score           int    // From 0 (unusable) to 100 (healthy)
+	Size            int64
+	Version         int           // Format version
+	Created         time.Time     // Zero if the archive does not record it
+	Entries         int           // Entries in the archive
+	Decoded         int           // Entries decoded by this check
+	Failures        []*EntryError // Entries that failed a check
+	Err             error         // Why the archive could not be read at all
+	LastVerified    time.Time     // Last full verification that passed, zero if never
+	Recommendations []Recommendation
+}
+
+// Healthy reports whether the archive could be read and no entry failed
+func (h *ArchiveHealth) Healthy() bool {
+	return h.Err == nil && len(h.Failures) == 0
+}
+
+// HealthReport is the result of CheckHealth
+type HealthReport struct {
+	Archives []*ArchiveHealth // In path order
+}
+
+// healthCatalog is the content of HealthCatalogName
+type healthCatalog struct {
+	Archives map[string]catalogRecord `json:"archives"` // By slash-separated path relative to the directory
+}
+
+// catalogRecord is what the catalog knows about one archive. A record whose
+// size or modification time no longer match the archive is stale: the archive
+// was rewritten since.
+type catalogRecord struct {
+	Size         int64      `json:"size"`
+	ModTime      time.Time  `json:"mod_time"`
+	LastChecked  time.Time  `json:"last_checked"`
+	LastVerified *time.Time `json:"last_verified,omitempty"` // Last full verification that passed
+}
+
+// CheckHealth checks every archive under dir and scores its health. Each
+// archive's structure is verified and a random sample of its entries decoded:
+// sample is the share of entries to decode, from 0 to 1, where 1 is a full
+// verification. The catalog in dir (see HealthCatalogName) records when each
+// archive last passed a full verification; archives not fully verified within
+// maxAge, or ever, are recommended for re-verification, and archives that are
+// unreadable, have failing entries or predate content hashes for re-creation.
+// The catalog is updated with the results.
+func CheckHealth(dir string, sample float64, maxAge time.Duration, opts Options) (*HealthReport, error) {
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("sample fraction %g is outside 0 to 1", sample)
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("maximum verification age must be positive")
+	}
+	archives, err := findArchives(dir)
+	if err != nil {
+		return nil, err
+	}
+	catalogPath := filepath.Join(dir, HealthCatalogName)
+	catalog, err := readHealthCatalog(catalogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HealthReport{}
+	records := make(map[string]catalogRecord, len(archives))
+	for _, rel := range archives {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		now := time.Now()
+		h, record := checkArchiveHealth(path, catalog.Archives[rel], sample, opts, now)
+		scoreHealth(h, maxAge, now)
+		report.Archives = append(report.Archives, h)
+		if !record.LastChecked.IsZero() {
+			records[rel] = record
+		}
+	}
+
+	// Records of archives that are gone are dropped
+	catalog.Archives = records
+	if err := writeHealthCatalog(catalogPath, catalog); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// checkArchiveHealth verifies the archive at path, returning its health, yet to
+// be scored, and its updated catalog record
+func checkArchiveHealth(path string, record catalogRecord, sample float64, opts Options, now time.Time) (*ArchiveHealth, catalogRecord) {
+	h := &ArchiveHealth{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		h.Err = err
+		return h, catalogRecord{}
+	}
+	h.Size = info.Size()
+	if record.Size != info.Size() || !record.ModTime.Equal(info.ModTime()) {
+		record = catalogRecord{Size: info.Size(), ModTime: info.ModTime()}
+	}
+	record.LastChecked = now
+
+	f, idx, err := openIndex(path)
+	if err != nil {
+		h.Err = err
+		return h, record
+	}
+	f.Close()
+	h.Version = int(idx.version)
+	if idx.created != 0 {
+		h.Created = time.Unix(0, idx.created)
+	}
+
+	verified, err := VerifySample(path, sample, opts)
+	if err != nil {
+		h.Err = err
+		return h, record
+	}
+	h.Entries, h.Decoded, h.Failures = verified.Entries, verified.Decoded, verified.Failures
+	if len(h.Failures) == 0 && h.Decoded == h.Entries {
+		record.LastVerified = &now
+	}
+	if record.LastVerified != nil {
+		h.LastVerified = *record.LastVerified
+	}
+	return h, record
+}
+
+// scoreHealth scores a checked archive and recommends what to do about it
+func scoreHealth(h *ArchiveHealth, maxAge time.Duration, now time.Time) {
+	recommend := func(action, reason string) {
+		h.Recommendations = append(h.Recommendations, Recommendation{Action: action, Reason: reason})
+	}
+	if h.Err != nil {
+		h.Score = scoreUnreadable
+		recommend(ActionRecreate, fmt.Sprintf("the archive cannot be read: %v", h.Err))
+		return
+	}
+
+	h.Score = 100
+	if len(h.Failures) > 0 {
+		h.Score = scoreFailed
+		recommend(ActionRecreate, fmt.Sprintf("%d of %d checked entries failed verification", len(h.Failures), h.Entries))
+	}
+	if h.LastVerified.IsZero() {
+		h.Score -= penaltyNeverChecked
+		recommend(ActionReverify, "never fully verified")
+	} else if age := now.Sub(h.LastVerified); age > maxAge {
+		penalty := penaltyStalePeriod * int(age/maxAge)
+		if penalty > penaltyStaleMax {
+			penalty = penaltyStaleMax
+		}
+		h.Score -= penalty
+		recommend(ActionReverify, fmt.Sprintf("last fully verified %d days ago", int(age.Hours()/24)))
+	}
+	if h.Version < 4 {
+		h.Score -= penaltyNoHashes
+		recommend(ActionRecreate, fmt.Sprintf("format version %d records no content hashes", h.Version))
+	}
+	if h.Score < 0 {
+		h.Score = 0
+	}
+}
+
+// findArchives returns the slash-separated paths, relative to dir, of the
+// archives under it, in order. Files are recognized by their magic number;
+// partial archives of interrupted runs are skipped.
+func findArchives(dir string) ([]string, error) {
+	var archives []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, PartialPath("")) || !hasArchiveMagic(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find archives: %w", err)
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// hasArchiveMagic reports whether the file at path starts with the archive magic
+func hasArchiveMagic(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(Magic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == Magic
+}
+
+// readHealthCatalog reads the catalog at path; a missing catalog is empty
+func readHealthCatalog(path string) (*healthCatalog, error) {
+	catalog := &healthCatalog{Archives: make(map[string]catalogRecord)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read catalog: %w", err)
+	}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("parse catalog %s: %w", path, err)
+	}
+	if catalog.Archives == nil {
+		catalog.Archives = make(map[string]catalogRecord)
+	}
+	return catalog, nil
+}
+
+// writeHealthCatalog replaces the catalog at path
+func writeHealthCatalog(path string, catalog *healthCatalog) error {
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode catalog: %w", err)
+	}
+	tmp := PartialPath(path)
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write catalog: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write catalog: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isHidden reports whether a file or directory is hidden: its name starts with
+// a dot on every platform, and it also carries the hidden flag on macOS or the
+// hidden or system attribute on Windows
+func isHidden(info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), ".") || hiddenAttr(info)
+}
+
+// hiddenCount counts the hidden files a walk came across, to report them in
+// one warning instead of one per file
+type hiddenCount struct {
+	files int // Hidden files skipped or, when not skipping, files included that are hidden or in a hidden directory
+	dirs  int // Hidden directories skipped
+}
+
+// report warns about the hidden files the walk of root included or skipped
+func (c *hiddenCount) report(root string, opts Options) {
+	switch {
+	case opts.SkipHidden && c.files+c.dirs > 0:
+		opts.warn(WarnSkippedHidden, root, fmt.Sprintf("skipped %d hidden %s and %d hidden %s",
+			c.files, plural(c.files, "file", "files"), c.dirs, plural(c.dirs, "directory", "directories")))
+	case !opts.SkipHidden && c.files > 0:
+		opts.warn(WarnIncludedHidden, root, fmt.Sprintf("included %d hidden %s", c.files, plural(c.files, "file", "files")))
+	}
+}
@@ -0,0 +1,17 @@
+//go:build darwin
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// ufHidden is the UF_HIDDEN file flag, set by chflags hidden and the Finder
+const ufHidden = 0x8000
+
+// hiddenAttr reports whether a file carries the hidden flag
+func hiddenAttr(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Flags&ufHidden != 0
+}
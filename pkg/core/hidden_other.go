@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package core
+
+import "os"
+
+// hiddenAttr reports no hidden attribute; only dot names are hidden here
+func hiddenAttr(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// hiddenAttr reports whether a file carries the hidden or system attribute
+func hiddenAttr(info os.FileInfo) bool {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && attrs.FileAttributes&(syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_SYSTEM) != 0
+}
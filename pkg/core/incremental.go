@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"agcp/pkg/progress"
+)
+
+// UpdateReport counts what UpdateArchive did with the input's files
+type UpdateReport struct {
+	Added     int  // Files not in the archive, compressed
+	Changed   int  // Files whose content changed, compressed again
+	Unchanged int  // Files whose archived data was kept
+	Removed   int  // Archived entries whose file no longer exists, dropped
+	Rewritten bool // Whether the archive was rewritten; false if it was already up to date
+}
+
+// keptData locates an entry's compressed data in the archive being updated,
+// or in a cache file
+type keptData struct {
+	r      io.ReaderAt
+	path   string // File to read the data from, if r is nil
+	offset int64
+	size   uint64
+	cached bool // The data comes from Options.CacheDir
+}
+
+// UpdateArchive brings the archive at archivePath up to date with input, the
+// file or directory it was made from. Files whose size and modification time,
+// or failing that SHA-256 hash, match their entry keep their compressed data,
+// which is copied as is; new and changed files are compressed with opts, and
+// entries whose file is gone are dropped. Kept entries take the file's current
+// owner, mode and modification time where the archive records them.
+//
+// The format has no room to append to a finished archive, so the archive is
+// rewritten next to the original and renamed over it once complete, the same
+// way a new archive is written; an archive that is already up to date is left
+// untouched. The root name recorded in the archive is kept.
+func UpdateArchive(archivePath, input string, opts Options) (*UpdateReport, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("stat input: %w", err)
+	}
+	unlock, err := lockOutput(archivePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	old, idx, err := openIndex(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+	if info.IsDir() != (idx.archiveType == ArchiveDir) {
+		if info.IsDir() {
+			return nil, fmt.Errorf("%s holds a single file, but %s is a directory", archivePath, input)
+		}
+		return nil, fmt.Errorf("%s holds a directory, but %s is a file", archivePath, input)
+	}
+
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	var entries []Entry
+	if info.IsDir() {
+		if entries, err = collectDirEntries(input, opts); err != nil {
+			return nil, fmt.Errorf("collect entries: %w", err)
+		}
+		entries, _ = excludeOutput(entries, archivePath)
+		entries, _ = excludeOutput(entries, stagedPath(archivePath))
+		entries, _ = excludeOutput(entries, PartialPath(stagedPath(archivePath)))
+		entries, _ = excludeOutput(entries, LockPath(archivePath))
+		entries, _ = excludeOutput(entries, LockPath(stagedPath(archivePath)))
+	} else {
+		entries = []Entry{newEntry("", input, info)}
+	}
+
+	// Match each file with its entry, hashing only files whose size matches but
+	// whose modification time does not
+	archived := make(map[string]indexEntry, len(idx.entries))
+	for _, e := range idx.entries {
+		archived[e.relPath] = e
+	}
+	report := &UpdateReport{}
+	refreshed := false
+	for i := range entries {
+		e, ok := archived[entries[i].RelPath]
+		if !ok {
+			report.Added++
+			continue
+		}
+		delete(archived, entries[i].RelPath)
+		same, err := keepEntry(&entries[i], e, old, opts)
+		if err != nil {
+			return nil, &EntryError{Path: entries[i].name(idx.rootName), Op: "hash", Err: err}
+		}
+		switch {
+		case !same:
+			report.Changed++
+		case bytes.Equal(entries[i].attrs.encode(), e.attrs.encode()):
+			report.Unchanged++
+		default:
+			report.Unchanged++
+			refreshed = true
+		}
+	}
+	report.Removed = len(archived)
+	if report.Added+report.Changed+report.Removed == 0 && !refreshed {
+		if opts.Sidecar {
+			return report, writeSidecar(archivePath, opts.Fsync)
+		}
+		return report, nil
+	}
+
+	tracker.SetTotals(calculateTotalSize(entries), uint64(len(entries)))
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+
+	// Write the updated archive under another name while the original is
+	// still being read, then replace the original with it
+	staged := stagedPath(archivePath)
+	release := trackFile(staged, cleanupTemp)
+	defer release()
+	stagedOpts := opts
+	stagedOpts.Sidecar = false // The sidecar goes next to the archive once it is replaced
+	if err := compressFiles(entries, staged, idx.archiveType, idx.rootName, stagedOpts, tracker); err != nil {
+		return nil, err
+	}
+	old.Close()
+	if err := os.Rename(staged, archivePath); err != nil {
+		os.Remove(staged)
+		return nil, fmt.Errorf("replace archive: %w", err)
+	}
+	if err := updateSidecar(archivePath, opts); err != nil {
+		return nil, err
+	}
+	report.Rewritten = true
+	if opts.Fsync == FsyncNone {
+		return report, nil
+	}
+	return report, syncFiles([]string{archivePath}, FsyncPerArchive)
+}
+
+// stagedPath returns the path an updated archive is written to before it
+// replaces the original
+func stagedPath(archivePath string) string {
+	return archivePath + ".update"
+}
+
+// keepEntry reports whether entry's file still holds the content of archived
+// entry e and, if so, makes entry copy e's data from r: it takes e's
+// attributes, with the owner, mode and modification time refreshed from the
+// file where e records them
+func keepEntry(entry *Entry, e indexEntry, r io.ReaderAt, opts Options) (bool, error) {
+	if !e.attrs.hasHash || !e.attrs.regular() || uint64(entry.Size) != e.originalSize {
+		return false, nil // Entries before v4 record no hash to carry over
+	}
+	if !(e.attrs.hasMtime && entry.attrs.hasMtime && e.attrs.mtime == entry.attrs.mtime) {
+		same, err := hashMatches(entry.FilePath, e.attrs.hash, opts)
+		if err != nil || !same {
+			return false, err
+		}
+	}
+
+	current := entry.attrs
+	entry.attrs = e.attrs
+	if e.attrs.hasOwner && current.hasOwner {
+		entry.attrs.uid, entry.attrs.gid = current.uid, current.gid
+	}
+	if e.attrs.hasMode && current.hasMode {
+		entry.attrs.mode = current.mode
+	}
+	if e.attrs.hasMtime && current.hasMtime {
+		entry.attrs.mtime = current.mtime
+	}
+	entry.kept = &keptData{r: r, offset: e.offset, size: e.compressedSize}
+	return true, nil
+}
+
+// copyKept copies a kept entry's compressed data from the archive being
+// updated or the cache, returning its original size and recorded hash
+func copyKept(entry Entry, w io.Writer, tracker *progress.Tracker) (uint64, [sha256.Size]byte, error) {
+	r := entry.kept.r
+	if r == nil {
+		f, err := os.Open(entry.kept.path)
+		if err != nil {
+			return 0, entry.attrs.hash, fmt.Errorf("open kept data: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, entry.kept.offset, int64(entry.kept.size))); err != nil {
+		return 0, entry.attrs.hash, fmt.Errorf("copy kept data: %w", err)
+	}
+	tracker.AddBytes(uint64(entry.Size))
+	return uint64(entry.Size), entry.attrs.hash, nil
+}
@@ -0,0 +1,338 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// archiveIndex is the parsed header and entry table of an archive
+type archiveIndex struct {
+	version     uint8
+	archiveType ArchiveType
+	rootName    string
+	entries     []indexEntry
+	align       uint32      // Alignment of each entry's data (v4+); 0 means none
+	created     int64       // Creation time in nanoseconds since the Unix epoch (v6+); 0 if not recorded
+	features    []Feature   // Features the archive uses (v7+)
+	provenance  *Provenance // How the archive was produced (v9+); nil if not recorded
+	dataOffset  int64       // Offset of the first entry's compressed data
+}
+
+// indexEntry is one record of the entry table with its data location resolved
+type indexEntry struct {
+	relPath        string
+	originalSize   uint64
+	compressedSize uint64
+	offset         int64 // Absolute offset of the compressed data in the archive
+	attrs          entryAttrs
+}
+
+// name returns the path shown to users: the relative path, or the root name
+// for the single entry of a file archive
+func (idx *archiveIndex) name(e indexEntry) string {
+	if e.relPath == "" {
+		return idx.rootName
+	}
+	return e.relPath
+}
+
+// openIndex opens an archive and parses its index. The caller closes the file.
+func openIndex(path string) (*os.File, *archiveIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open input: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("stat archive: %w", err)
+	}
+	idx, err := readIndex(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, idx, nil
+}
+
+// indexSource is what an index is read from: an archive file, or an archive
+// held in memory
+type indexSource interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// readIndex reads and validates the archive header and entry table from the
+// start of an archive of size bytes
+func readIndex(f indexSource, size int64) (*archiveIndex, error) {
+	br := bufio.NewReader(f)
+
+	// Encrypted archives must be decrypted before their index can be read
+	if head, _ := br.Peek(64); sniffEncryption(head) != "" {
+		return nil, fmt.Errorf("%w with %s; extract it with decompress, or decrypt it first", ErrEncrypted, sniffEncryption(head))
+	}
+
+	// Read magic number
+	var magicBytes [4]byte
+	if _, err := io.ReadFull(br, magicBytes[:]); err != nil {
+		return nil, fmt.Errorf("read magic: %w", err)
+	}
+	if string(magicBytes[:]) != Magic {
+		return nil, fmt.Errorf("invalid magic number: %q", string(magicBytes[:]))
+	}
+
+	// Read version
+	var versionByte uint8
+	if err := binary.Read(br, binary.BigEndian, &versionByte); err != nil {
+		return nil, fmt.Errorf("read version: %w", err)
+	}
+	if versionByte < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, versionByte)
+	}
+
+	// v7+ headers record the oldest format version that can read the archive
+	// and the features it uses, so newer archives that only add optional
+	// features can still be read
+	minReader := versionByte
+	var features []Feature
+	if versionByte >= 7 {
+		var err error
+		if minReader, features, err = readFeatures(br); err != nil {
+			return nil, err
+		}
+	}
+	if minReader > Version {
+		return nil, &VersionError{Version: int(versionByte), MinReader: int(minReader), Missing: unknownFeatures(features)}
+	}
+
+	// v2+ archives carry a trailer protecting the header; check it before trusting any counts
+	var headerLen int64
+	if versionByte >= 2 {
+		var err error
+		headerLen, err = verifyArchiveTrailer(f, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Read archive type
+	var archiveType ArchiveType
+	if err := binary.Read(br, binary.BigEndian, &archiveType); err != nil {
+		return nil, fmt.Errorf("read archive type: %w", err)
+	}
+
+	// Read root name
+	rootNameLen, err := readLength(br, versionByte, 2)
+	if err != nil {
+		return nil, fmt.Errorf("read root name length: %w", err)
+	}
+	if rootNameLen > MaxPathLen {
+		return nil, fmt.Errorf("corrupt archive: root name length %d exceeds the %d-byte limit", rootNameLen, MaxPathLen)
+	}
+	rootNameBytes := make([]byte, rootNameLen)
+	if _, err := io.ReadFull(br, rootNameBytes); err != nil {
+		return nil, fmt.Errorf("read root name: %w", err)
+	}
+
+	// Read number of entries, which cannot exceed what the file could hold
+	numEntries, err := readLength(br, versionByte, 4)
+	if err != nil {
+		return nil, fmt.Errorf("read num entries: %w", err)
+	}
+	if numEntries > uint64(size)/minEntryRecord {
+		return nil, fmt.Errorf("corrupt archive: %d entries cannot fit in %d bytes", numEntries, size)
+	}
+
+	// v4+ headers record the alignment of entry data
+	var align uint32
+	if versionByte >= 4 {
+		if err := binary.Read(br, binary.BigEndian, &align); err != nil {
+			return nil, fmt.Errorf("read alignment: %w", err)
+		}
+	}
+
+	// v6+ headers record when the archive was created
+	var created int64
+	if versionByte >= 6 {
+		if err := binary.Read(br, binary.BigEndian, &created); err != nil {
+			return nil, fmt.Errorf("read creation time: %w", err)
+		}
+	}
+
+	// Read metadata for each entry
+	entries := make([]indexEntry, numEntries)
+	for i := range entries {
+		relPathLen, err := readLength(br, versionByte, 2)
+		if err != nil {
+			return nil, fmt.Errorf("read relPathLen %d: %w", i, err)
+		}
+		if relPathLen > MaxPathLen {
+			return nil, fmt.Errorf("corrupt archive: path length %d of entry %d exceeds the %d-byte limit", relPathLen, i, MaxPathLen)
+		}
+		relPathBytes := make([]byte, relPathLen)
+		if _, err := io.ReadFull(br, relPathBytes); err != nil {
+			return nil, fmt.Errorf("read relPath %d: %w", i, err)
+		}
+
+		var originalSize, compressedSize uint64
+		if err := binary.Read(br, binary.BigEndian, &originalSize); err != nil {
+			return nil, fmt.Errorf("read originalSize %d: %w", i, err)
+		}
+		if err := binary.Read(br, binary.BigEndian, &compressedSize); err != nil {
+			return nil, fmt.Errorf("read compressedSize %d: %w", i, err)
+		}
+
+		// v3+ entries carry an attribute block
+		var attrs entryAttrs
+		if versionByte >= 3 {
+			var attrsLen uint16
+			if err := binary.Read(br, binary.BigEndian, &attrsLen); err != nil {
+				return nil, fmt.Errorf("read attrsLen %d: %w", i, err)
+			}
+			attrBytes := make([]byte, attrsLen)
+			if _, err := io.ReadFull(br, attrBytes); err != nil {
+				return nil, fmt.Errorf("read attrs %d: %w", i, err)
+			}
+			var err error
+			if attrs, err = decodeAttrs(attrBytes); err != nil {
+				return nil, fmt.Errorf("decode attrs %d: %w", i, err)
+			}
+		}
+
+		entries[i] = indexEntry{
+			relPath:        string(relPathBytes),
+			originalSize:   originalSize,
+			compressedSize: compressedSize,
+			attrs:          attrs,
+		}
+	}
+
+	// Calculate start offset for compressed data
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("seek current: %w", err)
+	}
+	buffered := br.Buffered()
+	startOffset := offset - int64(buffered)
+	if versionByte >= 2 && startOffset != headerLen {
+		return nil, fmt.Errorf("corrupt archive: header is %d bytes but trailer records %d", startOffset, headerLen)
+	}
+
+	idx := &archiveIndex{
+		version:     versionByte,
+		archiveType: archiveType,
+		rootName:    string(rootNameBytes),
+		entries:     entries,
+		align:       align,
+		created:     created,
+		features:    features,
+		dataOffset:  startOffset,
+	}
+	dataEnd := size
+	if versionByte >= 2 {
+		dataEnd -= trailerSize
+	}
+	// v9+ archives listing provenance carry it between the data and the trailer
+	if hasFeature(features, featureProvenance) {
+		prov, n, err := readProvenance(f, dataEnd)
+		if err != nil {
+			return nil, err
+		}
+		idx.provenance, dataEnd = prov, dataEnd-n
+	}
+	if err := idx.resolveOffsets(dataEnd); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// resolveOffsets sets the absolute offset of each entry's compressed data,
+// skipping the padding before aligned entries, and checks that the entries'
+// data exactly fills the space from the end of the entry table to dataEnd. A
+// corrupt size that would run an entry past dataEnd, or leave bytes over, is
+// reported before any entry is read.
+func (idx *archiveIndex) resolveOffsets(dataEnd int64) error {
+	offset := idx.dataOffset
+	for i := range idx.entries {
+		entry := &idx.entries[i]
+		entry.offset = alignOffset(offset, idx.align)
+		if entry.offset > dataEnd || entry.compressedSize > uint64(dataEnd-entry.offset) {
+			return fmt.Errorf("corrupt archive: entry %d (%s) records %d bytes of data at offset %d, but the entry data ends at offset %d",
+				i, idx.name(*entry), entry.compressedSize, entry.offset, dataEnd)
+		}
+		offset = entry.offset + int64(entry.compressedSize)
+	}
+	if offset != dataEnd {
+		return fmt.Errorf("corrupt archive: entry data ends at offset %d, leaving %d unaccounted bytes before offset %d",
+			offset, dataEnd-offset, dataEnd)
+	}
+	return nil
+}
+
+// minEntryRecord is the smallest entry record in any format version: a path
+// length and the two sizes
+const minEntryRecord = 1 + 8 + 8
+
+// readLength reads a count or length field: a varint since v5, and a big-endian
+// integer of width bytes before that
+func readLength(br *bufio.Reader, version uint8, width int) (uint64, error) {
+	if version >= 5 {
+		return binary.ReadUvarint(br)
+	}
+	var b [4]byte
+	if _, err := io.ReadFull(br, b[:width]); err != nil {
+		return 0, err
+	}
+	if width == 2 {
+		return uint64(binary.BigEndian.Uint16(b[:2])), nil
+	}
+	return uint64(binary.BigEndian.Uint32(b[:4])), nil
+}
+
+// verifyArchiveTrailer checks the end-of-archive marker and the header checksum,
+// returning the recorded header length. Truncated archives and header bit-flips
+// are reported here, before any entry metadata is parsed.
+func verifyArchiveTrailer(f io.ReaderAt, size int64) (int64, error) {
+	if size < trailerSize {
+		return 0, fmt.Errorf("archive truncated: %d bytes is too small to hold the end-of-archive trailer", size)
+	}
+
+	var trailer [trailerSize]byte
+	if _, err := f.ReadAt(trailer[:], size-trailerSize); err != nil {
+		return 0, fmt.Errorf("read trailer: %w", err)
+	}
+	if string(trailer[12:]) != TrailerMagic {
+		return 0, fmt.Errorf("archive truncated or corrupt: missing end-of-archive marker")
+	}
+
+	headerLen := int64(binary.BigEndian.Uint64(trailer[0:8]))
+	storedCRC := binary.BigEndian.Uint32(trailer[8:12])
+	if headerLen <= int64(len(Magic)) || headerLen > size-trailerSize {
+		return 0, fmt.Errorf("corrupt trailer: header length %d out of range for %d-byte archive", headerLen, size)
+	}
+
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(f, 0, headerLen)); err != nil {
+		return 0, fmt.Errorf("checksum header: %w", err)
+	}
+	if computed := crc.Sum32(); computed != storedCRC {
+		return 0, fmt.Errorf("header checksum mismatch: stored %08x, computed %08x", storedCRC, computed)
+	}
+	return headerLen, nil
+}
+
+// entryReader returns a reader over the decompressed content of an entry
+func entryReader(r io.ReaderAt, e indexEntry) (io.Reader, error) {
+	if e.originalSize == 0 {
+		return io.LimitReader(nil, 0), nil
+	}
+	zr, err := entryDecoder(io.NewSectionReader(r, e.offset, int64(e.compressedSize)), e.attrs)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(zr, int64(e.originalSize)), nil
+}
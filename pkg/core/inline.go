@@ -0,0 +1,30 @@
+package core
+
+import (
+	"fmt"
+	"io"
+)
+
+// inlineEntry reads the content of a file of up to opts.InlineMax bytes into
+// its entry's attributes, so it is stored in the entry table record instead of
+// as a codec frame of its own. Empty files, which have no data anyway, and
+// files that grew past the threshold since they were scanned keep their codec.
+func inlineEntry(entry *Entry, opts Options) error {
+	if opts.InlineMax <= 0 || entry.Size <= 0 || entry.Size > opts.InlineMax {
+		return nil
+	}
+	f, err := openRetryFile(entry.FilePath, opts.Retry)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", entry.FilePath, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, opts.InlineMax+1))
+	if err != nil {
+		return fmt.Errorf("read %s: %w", entry.FilePath, err)
+	}
+	if len(data) == 0 || int64(len(data)) > opts.InlineMax {
+		return nil
+	}
+	entry.attrs.codec, entry.attrs.inline = codecInline, data
+	return nil
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"os"
+	"time"
+)
+
+// EntryInfo describes an archive entry as recorded in its entry table. Mode,
+// ModTime and SHA256 are zero for archives written before they were recorded;
+// ModTime is also zero in reproducible archives.
+//
+// An entry's data is the CompressedSize bytes of the archive file starting at
+// Offset, encoded as a single stream of its Codec (an LZ4 frame, a gzip member,
+// or the content itself when stored), so it can be fetched from a remotely
+// stored archive with an HTTP range request and decoded on its own. Inline
+// entries have no data there: their content is in the entry table.
+type EntryInfo struct {
+	Path           string            // Entry path within the archive
+	OriginalSize   uint64            // Uncompressed size
+	CompressedSize uint64            // Size of the entry's data in the archive
+	Offset         int64             // Absolute offset of the entry's data in the archive file
+	Type           string            // Kind of file: "regular", or a type this agcp cannot restore ("symlink", "type 9")
+	Codec          string            // Codec the data is encoded with: "lz4", "gzip", "zstd" or "store"
+	Tags           map[string]string // Tags attached at compress time (see TagRule); nil if none
+	Mode           os.FileMode       // File mode when archived
+	ModTime        time.Time         // Modification time when archived, in UTC
+	SHA256         []byte            // SHA-256 of the uncompressed content
+	RawName        bool              // Path is not valid UTF-8 but the raw bytes of the original file name
+}
+
+// Ratio returns the entry's compressed size as a fraction of its original size:
+// 0.25 for data compressed to a quarter. It is 0 for an empty entry.
+func (e EntryInfo) Ratio() float64 {
+	if e.OriginalSize == 0 {
+		return 0
+	}
+	return float64(e.CompressedSize) / float64(e.OriginalSize)
+}
+
+// ListEntries returns the entries of an archive in archive order, reading only
+// its header and entry table
+func ListEntries(archivePath string) ([]EntryInfo, error) {
+	a, err := OpenArchive(archivePath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	return a.Entries(), nil
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errLockHeld is returned by lockFile when another process holds the lock
+var errLockHeld = errors.New("lock is held")
+
+// LockedError is returned when another process is writing the same archive
+// or extracting into the same destination
+type LockedError struct {
+	Path string // Archive or destination that is locked
+	PID  int    // Process holding the lock, 0 if unknown
+}
+
+// Error implements error
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by PID %d", e.Path, e.PID)
+}
+
+// LockPath returns the path of the lock file guarding an archive or extraction
+// destination while an operation writes it. The file holds the PID of the
+// process holding the lock and is removed when the lock is released.
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// lockOutput takes the advisory lock (flock, or LockFileEx on Windows) guarding
+// path, failing fast with a *LockedError if another process holds it, so two
+// runs never interleave their writes. Where the file system does not support
+// locking, it warns and carries on unlocked. Call unlock once path is written.
+func lockOutput(path string, opts Options) (unlock func(), err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", path, err)
+	}
+	lockPath := LockPath(abs)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			opts.warn(WarnNotLocked, path, fmt.Sprintf("cannot create lock file: %v", err))
+			return func() {}, nil
+		}
+		if err := lockFile(f); err != nil {
+			pid := lockHolder(f)
+			f.Close()
+			if errors.Is(err, errLockHeld) {
+				return nil, &LockedError{Path: path, PID: pid}
+			}
+			opts.warn(WarnNotLocked, path, fmt.Sprintf("cannot lock: %v", err))
+			return func() {}, nil
+		}
+
+		// The previous holder removes the lock file as it releases it; if it
+		// did so after we opened it, we locked a file nobody else will see
+		locked, statErr := f.Stat()
+		current, err := os.Stat(lockPath)
+		if statErr != nil || err != nil || !os.SameFile(locked, current) {
+			f.Close()
+			continue
+		}
+
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		}
+		release := trackFile(lockPath, cleanupTemp)
+		return func() {
+			unlockFile(f, lockPath)
+			release()
+		}, nil
+	}
+}
+
+// CheckLock returns a *LockedError if another process holds the lock guarding
+// path, without taking it
+func CheckLock(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", path, err)
+	}
+	f, err := os.OpenFile(LockPath(abs), os.O_RDWR, 0)
+	if err != nil {
+		return nil // No lock file, so no lock
+	}
+	defer f.Close()
+	if err := lockFile(f); errors.Is(err, errLockHeld) {
+		return &LockedError{Path: path, PID: lockHolder(f)}
+	}
+	return nil
+}
+
+// lockHolder returns the PID recorded in a lock file, or 0 if there is none
+func lockHolder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
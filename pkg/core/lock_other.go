@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile reports that advisory locks are not supported on this platform
+func lockFile(f *os.File) error {
+	return errors.New("file locking is not supported on this platform")
+}
+
+// unlockFile is never called on this platform, where no lock is taken
+func unlockFile(f *os.File, path string) {
+	f.Close()
+	os.Remove(path)
+}
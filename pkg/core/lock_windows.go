@@ -0,0 +1,41 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33 // ERROR_LOCK_VIOLATION
+)
+
+// lockFile takes an exclusive LockFileEx lock on f without waiting. Windows
+// locks are mandatory, so the locked byte lies far past the PID the file holds,
+// leaving it readable to other processes.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: 0x7fffffff}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases the lock and then removes the lock file. An open file
+// cannot be removed on Windows, so the removal fails, leaving the file in place,
+// if another process opened it in the meantime.
+func unlockFile(f *os.File, path string) {
+	f.Close()
+	os.Remove(path)
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ManifestFile is one file of a manifest. Fields the archive did not record
+// are empty.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   uint64 `json:"size"`
+	SHA256 string `json:"sha256,omitempty"` // Hex SHA-256 of the content
+	Mode   string `json:"mode,omitempty"`   // Octal permission bits, such as "0644"
+	MTime  string `json:"mtime,omitempty"`  // Modification time, RFC 3339 in UTC
+}
+
+// Manifest is a bill of materials listing every file of an archive, built
+// from its entry table alone without decompressing anything
+type Manifest struct {
+	Archive string         `json:"archive"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// BuildManifest builds the manifest of an archive
+func BuildManifest(archivePath string) (*Manifest, error) {
+	entries, err := ListEntries(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Archive: filepath.Base(archivePath), Files: make([]ManifestFile, len(entries))}
+	for i, entry := range entries {
+		file := ManifestFile{
+			Path:   filepath.ToSlash(entry.Path),
+			Size:   entry.OriginalSize,
+			SHA256: hex.EncodeToString(entry.SHA256),
+		}
+		if entry.Mode != 0 {
+			file.Mode = fmt.Sprintf("%04o", entry.Mode.Perm())
+		}
+		if !entry.ModTime.IsZero() {
+			file.MTime = entry.ModTime.UTC().Format(time.RFC3339Nano)
+		}
+		m.Files[i] = file
+	}
+	return m, nil
+}
+
+// WriteJSON writes the manifest as indented JSON
+func (m *Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// WriteCSV writes the manifest as CSV with a header row
+func (m *Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "size", "sha256", "mode", "mtime"}); err != nil {
+		return err
+	}
+	for _, f := range m.Files {
+		if err := cw.Write([]string{f.Path, strconv.FormatUint(f.Size, 10), f.SHA256, f.Mode, f.MTime}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// MemoryRootName is the root directory name of archives built by BuildArchive,
+// where Decompress extracts them by default
+const MemoryRootName = "archive"
+
+// BuildArchive returns a directory archive holding files, keyed by
+// slash-separated path relative to the archive root, without touching the file
+// system. Entries are written in path order with the default codec, a SHA-256
+// hash and no owner, mode or times, so the same files always give the same
+// bytes. A path that is also the parent directory of another is rejected.
+func BuildArchive(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("build archive: invalid path %q", name)
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := files[dir]; ok {
+				return nil, fmt.Errorf("build archive: %q is both a file and the parent of %q", dir, name)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]Entry, len(names))
+	for i, name := range names {
+		entries[i] = Entry{RelPath: filepath.FromSlash(name), Size: int64(len(files[name]))}
+		entries[i].attrs.codec = CodecDefault.resolve()
+		entries[i].attrs.hasCodec = entries[i].attrs.codec != codecLZ4
+		entries[i].attrs.hasHash, entries[i].attrs.hash = true, sha256.Sum256(files[name])
+	}
+	if err := checkFormatLimits(MemoryRootName, entries, 0); err != nil {
+		return nil, err
+	}
+
+	// Compress every entry first, so the table can be written with its sizes
+	data := make([][]byte, len(entries))
+	for i, entry := range entries {
+		content := files[names[i]]
+		if len(content) == 0 {
+			continue // Empty file, no data written
+		}
+		var buf bytes.Buffer
+		zw, err := entryEncoder(&buf, entry.attrs, 0)
+		if err != nil {
+			return nil, &EntryError{Path: names[i], Op: "compress", Err: err}
+		}
+		if _, err := zw.Write(content); err != nil {
+			return nil, &EntryError{Path: names[i], Op: "compress", Err: err}
+		}
+		if err := zw.Close(); err != nil {
+			return nil, &EntryError{Path: names[i], Op: "compress", Err: err}
+		}
+		data[i] = buf.Bytes()
+	}
+
+	var archive bytes.Buffer
+	if err := writeArchiveHeader(&archive, ArchiveDir, MemoryRootName, entries, 0, 0, nil); err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if err := writeEntryRecord(&archive, entry, uint64(len(files[names[i]])), uint64(len(data[i]))); err != nil {
+			return nil, err
+		}
+	}
+	headerLen := int64(archive.Len())
+	crc := crc32.ChecksumIEEE(archive.Bytes())
+	for _, d := range data {
+		archive.Write(d)
+	}
+	if err := writeTrailer(&archive, headerLen, crc); err != nil {
+		return nil, err
+	}
+	return archive.Bytes(), nil
+}
+
+// ReadAll decodes every entry of an archive held in memory, returning their
+// content keyed by slash-separated path: the relative path within a directory
+// archive, or the root name for a file archive. Entries with a recorded
+// SHA-256 hash are checked against it. Entries of types this agcp cannot
+// restore are left out. Encrypted archives are not supported.
+func ReadAll(archive []byte) (map[string][]byte, error) {
+	r := bytes.NewReader(archive)
+	idx, err := readIndex(r, int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(idx.entries))
+	for _, entry := range idx.entries {
+		if !entry.attrs.regular() {
+			continue
+		}
+		name := filepath.ToSlash(idx.name(entry))
+		zr, err := entryReader(r, entry)
+		if err != nil {
+			return nil, &EntryError{Path: name, Op: "read", Err: err}
+		}
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, &EntryError{Path: name, Op: "read", Err: fmt.Errorf("decode: %w", err)}
+		}
+		if uint64(len(content)) != entry.originalSize {
+			return nil, &EntryError{Path: name, Op: "read", Err: fmt.Errorf("decoded %d bytes, expected %d", len(content), entry.originalSize)}
+		}
+		if entry.attrs.hasHash && sha256.Sum256(content) != entry.attrs.hash {
+			return nil, &EntryError{Path: name, Op: "read", Err: fmt.Errorf("SHA-256 mismatch")}
+		}
+		files[name] = content
+	}
+	return files, nil
+}
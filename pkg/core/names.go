@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameEscape says how extraction on Windows restores entry names that are not
+// valid UTF-8. Linux and most Unix file systems allow any bytes in a name, and
+// agcp archives store them verbatim, but Windows names are UTF-16 and cannot
+// hold the invalid bytes. Other platforms restore such names unchanged.
+type NameEscape int
+
+const (
+	// NameEscapeHex writes each invalid byte as %XX, and a literal % in the
+	// same name as %25, so the original bytes can be recovered from the name
+	NameEscapeHex NameEscape = iota
+	// NameEscapeReplace writes each run of invalid bytes as U+FFFD, the
+	// Unicode replacement character. Names differing only in invalid bytes
+	// then restore to the same file.
+	NameEscapeReplace
+	// NameEscapeFail fails the extraction before writing anything
+	NameEscapeFail
+)
+
+// ParseNameEscape parses a name escape mode: "hex", "replace" or "fail"
+func ParseNameEscape(name string) (NameEscape, error) {
+	switch strings.ToLower(name) {
+	case "", "hex":
+		return NameEscapeHex, nil
+	case "replace":
+		return NameEscapeReplace, nil
+	case "fail":
+		return NameEscapeFail, nil
+	}
+	return 0, fmt.Errorf("unknown name escape %q: want hex, replace or fail", name)
+}
+
+// EscapeName returns name in a form Windows can store: unchanged if it is
+// valid UTF-8, otherwise with its invalid bytes escaped as mode says. It fails
+// only with NameEscapeFail.
+func EscapeName(name string, mode NameEscape) (string, error) {
+	if utf8.ValidString(name) {
+		return name, nil
+	}
+	switch mode {
+	case NameEscapeReplace:
+		return strings.ToValidUTF8(name, "\uFFFD"), nil
+	case NameEscapeFail:
+		return "", fmt.Errorf("name is not valid UTF-8, which Windows cannot store")
+	}
+	var sb strings.Builder
+	for len(name) > 0 {
+		r, size := utf8.DecodeRuneInString(name)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&sb, "%%%02X", name[0])
+		case r == '%':
+			sb.WriteString("%25")
+		default:
+			sb.WriteString(name[:size])
+		}
+		name = name[size:]
+	}
+	return sb.String(), nil
+}
+
+// restoreName returns the name an entry path is extracted under on this
+// platform: verbatim, except on Windows, where names that are not valid UTF-8
+// are escaped as opts.NameEscape says, with a warning
+func restoreName(name string, opts Options) (string, error) {
+	if runtime.GOOS != "windows" || utf8.ValidString(name) {
+		return name, nil
+	}
+	escaped, err := EscapeName(name, opts.NameEscape)
+	if err != nil {
+		return "", &EntryError{Path: name, Op: "extract", Err: err}
+	}
+	opts.warn(WarnEscapedName, name, "name is not valid UTF-8; restored as "+escaped)
+	return escaped, nil
+}
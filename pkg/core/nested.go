@@ -0,0 +1,204 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nestedKind is a kind of archive unpacked by recursive extraction
+type nestedKind int
+
+const (
+	nestedNone nestedKind = iota
+	nestedAGCP
+	nestedZip
+	nestedTar
+	nestedTarGz
+)
+
+// nestedArchive returns the kind of archive path is, judged by its extension,
+// and the path with the extension removed
+func nestedArchive(path string) (nestedKind, string) {
+	lower := strings.ToLower(path)
+	for _, s := range []struct {
+		ext  string
+		kind nestedKind
+	}{
+		{".agcp", nestedAGCP},
+		{".zip", nestedZip},
+		{".tar.gz", nestedTarGz},
+		{".tgz", nestedTarGz},
+		{".tar", nestedTar},
+	} {
+		if strings.HasSuffix(lower, s.ext) && len(path) > len(s.ext) {
+			return s.kind, path[:len(path)-len(s.ext)]
+		}
+	}
+	return nestedNone, ""
+}
+
+// unpackNested unpacks the archives among the extracted files in place, up to
+// depth levels deep: each archive is extracted next to itself, into a path named
+// after it without the extension, and then removed. Archives that fail to
+// unpack, or whose target already exists, are left as they are with a warning.
+func unpackNested(files []string, depth int, opts Options) {
+	for level := 0; level < depth && len(files) > 0; level++ {
+		var unpacked []string
+		for _, path := range files {
+			kind, target := nestedArchive(path)
+			if kind == nestedNone {
+				continue
+			}
+			if _, err := os.Lstat(target); err == nil {
+				opts.warn(WarnNestedNotUnpacked, path, fmt.Sprintf("not unpacking nested archive: %s already exists", target))
+				continue
+			}
+			extracted, err := unpackArchive(kind, path, target, opts)
+			if err != nil {
+				opts.warn(WarnNestedNotUnpacked, path, fmt.Sprintf("not unpacking nested archive: %v", err))
+				os.RemoveAll(target)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				opts.warn(WarnNestedNotRemoved, path, fmt.Sprintf("remove unpacked archive: %v", err))
+			}
+			unpacked = append(unpacked, extracted...)
+		}
+		files = unpacked
+	}
+}
+
+// unpackArchive extracts one nested archive to target, returning the files written
+func unpackArchive(kind nestedKind, path, target string, opts Options) ([]string, error) {
+	switch kind {
+	case nestedAGCP:
+		nestedOpts := opts
+		nestedOpts.Recursive = 0 // The caller handles the next level
+		return decompressArchive(path, target, nestedOpts)
+	case nestedZip:
+		return unpackZip(path, target)
+	default:
+		return unpackTar(path, target, kind == nestedTarGz)
+	}
+}
+
+// nestedDest returns where an entry named name inside a nested archive is
+// written under target, refusing names that escape it
+func nestedDest(target, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q escapes the extraction directory", name)
+	}
+	return filepath.Join(target, clean), nil
+}
+
+// writeNestedFile writes r to dest, creating its parent directories
+func writeNestedFile(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("create parent dir for %s: %w", dest, err)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dest, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", dest, err)
+	}
+	return f.Close()
+}
+
+// unpackZip extracts the regular files and directories of a zip archive
+func unpackZip(path, target string) ([]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", target, err)
+	}
+	var files []string
+	for _, zf := range zr.File {
+		dest, err := nestedDest(target, zf.Name)
+		if err != nil {
+			return nil, err
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return nil, fmt.Errorf("create %s: %w", dest, err)
+			}
+		case mode.IsRegular():
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, fmt.Errorf("open %s: %w", zf.Name, err)
+			}
+			err = writeNestedFile(dest, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, dest)
+		}
+	}
+	return files, nil
+}
+
+// unpackTar extracts the regular files and directories of a tar archive,
+// optionally gzip-compressed
+func unpackTar(path, target string, gzipped bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", target, err)
+	}
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		dest, err := nestedDest(target, hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return nil, fmt.Errorf("create %s: %w", dest, err)
+			}
+		case tar.TypeReg:
+			if err := writeNestedFile(dest, tr); err != nil {
+				return nil, err
+			}
+			files = append(files, dest)
+		}
+	}
+}
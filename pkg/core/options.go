@@ -0,0 +1,272 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// Options configures a compression or decompression operation.
+// The zero value gives the default behavior.
+type Options struct {
+	Retry RetryPolicy // Retry policy for transient read errors
+
+	// Level sets the compression effort: 0 is the fast default, 1 to 9 trade
+	// speed for smaller output (9 is smallest)
+	Level int
+
+	// Codec selects the compression codec. The default is LZ4; CodecGzip uses
+	// only the standard library, for builds that can't use LZ4. Builds with the
+	// nolz4 tag write gzip by default and only read archives without LZ4
+	// entries, which rules out v1 and v2 archives and anything written with
+	// the default codec by a regular build.
+	Codec Codec
+
+	// Policy, if set, overrides Codec and Level, or stores entries uncompressed,
+	// by entry path (see LoadPolicy)
+	Policy *Policy
+
+	// InlineMax, if set, stores files of up to this many bytes (at most
+	// MaxInline) in their entry table record rather than as compressed data,
+	// saving the per-entry codec framing that makes archives of many tiny
+	// files larger than their input. Inlined content is stored uncompressed.
+	InlineMax int64
+
+	// Tags attaches key/value tags to the entries matching each rule, stored in
+	// the entry table and returned by ListEntries
+	Tags []TagRule
+
+	// Workers sets how many entries are compressed concurrently; zero means one
+	// per CPU. Entries are always written in entry table order, so the archive
+	// does not depend on the number of workers.
+	Workers int
+
+	// Schedule, if set, runs Compress or Decompress on a fixed, seeded schedule
+	// of Workers workers, for debugging (see Schedule). Compressing with
+	// MinRatio is sequential anyway and is not scheduled.
+	Schedule *Schedule
+
+	// Reproducible leaves out metadata that depends on the machine, user or
+	// checkout rather than the input tree's content (file ownership,
+	// modification times and the archive's creation time), so the same tree
+	// always produces a byte-identical archive
+	Reproducible bool
+
+	// Provenance, if set, is recorded in the archive so that readers can see
+	// how it was produced, typically CurrentProvenance(). Archives recording it
+	// need a reader of format v9. Reproducible archives leave it out.
+	Provenance *Provenance
+
+	// CacheDir, if set, keeps each compressed file's data in this directory, so
+	// compressing it again while its size and modification time are unchanged
+	// copies the data from there instead of compressing it. Only files
+	// compressed with the same codec and level are reused. The directory can
+	// be deleted at any time; it is refilled as files are compressed.
+	CacheDir string
+
+	// OneFileSystem keeps directory walks on the file system of the input: a
+	// subdirectory on another device (a mount point such as /proc, a network
+	// share or a bind mount) is skipped with a warning. It has no effect on
+	// Windows.
+	OneFileSystem bool
+
+	// SkipHidden leaves hidden files and directories out of directory walks:
+	// names starting with a dot, plus files with the hidden flag on macOS or the
+	// hidden or system attribute on Windows. The input itself is always walked.
+	// Either way, a summary warning counts the hidden files skipped or included.
+	SkipHidden bool
+
+	// Exclude leaves the files and directories matching any of these glob
+	// patterns out of directory walks. A pattern with a slash is matched against
+	// the whole path in the archive, where "**" matches any number of
+	// directories; one without is matched against the base name. An excluded
+	// directory is skipped with everything in it.
+	Exclude []string
+
+	// Align, if set, starts each entry's compressed data at a multiple of this
+	// many bytes, padding with zeros, so entries can be read with direct IO. It
+	// must be a power of two and is recorded in the header.
+	Align int64
+
+	// Sidecar writes a checksum of the finished archive next to it, in
+	// archive.agcp.sha256 (see SidecarPath). An archive that already has a
+	// sidecar gets it rewritten whether or not this is set. Extraction and
+	// verification check the sidecar whenever one is present.
+	Sidecar bool
+
+	// Recipients, if set, encrypts the finished archive to these public keys
+	// with their backend's command (age or gpg), so the machine creating it never
+	// needs the decryption secret. All recipients must use the same backend.
+	Recipients []Recipient
+
+	// Identities are the age identity files tried when extracting an
+	// age-encrypted archive. GPG-encrypted archives use the GPG keyring.
+	Identities []string
+
+	// Dir, if set, is the directory extraction writes relative to, like tar -C:
+	// the default output (the archive's root name) and a relative output name
+	// are both placed under it
+	Dir string
+
+	// Update makes extraction into an existing tree incremental: entries whose
+	// file on disk has the same size and modification time, or the same size
+	// and content hash, are left alone. Files it writes or finds unchanged by
+	// hash get the archived modification time, so the next update is faster.
+	Update bool
+
+	// NameEscape says how extraction on Windows restores entry names that are
+	// not valid UTF-8, which Windows cannot store. Elsewhere they are restored
+	// byte for byte.
+	NameEscape NameEscape
+
+	// RefuseSystemPaths makes extraction fail with ErrSystemPath, before
+	// writing anything, if an entry would be written into a file system root
+	// (/, C:\) or into a system directory such as /etc, /usr or C:\Windows
+	RefuseSystemPaths bool
+
+	// Snapshot, if set, compresses from a snapshot of the input's file system
+	// taken when compression starts and removed when it ends, so files written
+	// to meanwhile are captured consistently. Taking snapshots usually needs
+	// root (or Administrator) rights and the file system's tools on the PATH.
+	Snapshot SnapshotMode
+
+	// Fsync says when written data is synced to stable storage: the archive
+	// being created, or the files being extracted. The default, FsyncNone,
+	// leaves it to the operating system; backups want FsyncPerArchive.
+	Fsync FsyncPolicy
+
+	// TempDir is the directory for scratch files: compressed entries waiting
+	// their turn to be written to the archive, and decrypted copies of
+	// encrypted archives being extracted. Empty means the OS default
+	// (os.TempDir). Partial outputs are written next to the output instead, so
+	// they can be renamed into place.
+	TempDir string
+
+	// IOBudget bounds the bytes extraction workers may have written but not yet
+	// flushed to disk, across all workers. Workers sync their files to stay within
+	// it. Zero means unbounded.
+	IOBudget int64
+
+	// NoPerms extracts files with default permissions (0644 less the umask)
+	// instead of the permission bits recorded in the archive.
+	NoPerms bool
+
+	// MaxWriteRate limits extraction to writing this many bytes per second,
+	// across all workers, so a restore onto shared storage leaves bandwidth for
+	// its other users. Reading the archive is not limited beyond what the
+	// writes hold back. Zero means unlimited.
+	MaxWriteRate int64
+
+	// ToDevice writes a single-file archive, such as a disk image taken from a
+	// block device, over the existing device or file named as the output: in
+	// place, without creating, truncating or removing it. The target must be at
+	// least as large as the image; bytes past it are left as they were.
+	// Permissions and ownership are not applied to the target.
+	ToDevice bool
+
+	// SkipZeros seeks past runs of zeros in the extracted content instead of
+	// writing them, which saves writing the unused space of a disk image.
+	// Extracted files are new, so they read as zeros there and come out sparse
+	// where the file system supports it. With ToDevice it is only safe when
+	// the target already reads as zeros, such as a discarded or
+	// thin-provisioned volume.
+	SkipZeros bool
+
+	// Scan, if set, is run on the content of every entry as it is extracted.
+	// Entries it rejects are skipped with a warning. Content reaches its final
+	// path only after the scan accepted it.
+	Scan ScanFunc
+
+	// Transform, if set, rewrites the content of every entry as it is extracted,
+	// in the same pass. The scan, if any, sees the transformed content.
+	Transform TransformFunc
+
+	// Events, if set, receives typed progress events (phase, current entry,
+	// byte and file counts, rate, ETA). Sends never block; use a buffered channel.
+	Events chan<- progress.Event
+
+	// Progress, if set, receives the operation's progress instead of the
+	// progress lines printed to standard output: its start, the bytes done on
+	// every tick, each entry finished and its end
+	Progress progress.Reporter
+
+	// Recursive, if positive, makes extraction unpack archives found among the
+	// extracted files (.agcp, .zip, .tar, .tar.gz and .tgz) in place, up to this
+	// many levels of nesting. Each is extracted next to itself, into a path named
+	// after it without the extension, and then removed.
+	Recursive int
+
+	// CacheSize bounds the decompressed entry content an Archive handle keeps in
+	// memory for repeated reads. Zero disables the cache.
+	CacheSize int64
+
+	// OwnerMap, if set, translates archived user and group IDs when restoring
+	// ownership. Ownership is restored when running as root or when OwnerMap is set.
+	OwnerMap *OwnerMap
+
+	// MinRatio, if set, stops a compression whose data turns out to be essentially
+	// incompressible: once RatioSample input bytes have been compressed, the job
+	// fails with ErrIncompressible if compressed/original exceeds MinRatio.
+	MinRatio    float64
+	RatioSample int64 // Input bytes to sample before checking MinRatio; 64 MiB when zero
+
+	// StoreIncompressible makes a failed MinRatio check store the remaining
+	// entries uncompressed instead of failing
+	StoreIncompressible bool
+
+	// Partial says what to do when an interrupted run left a partial archive
+	// (see PartialPath) for the output. The default fails with ErrPartialOutput.
+	Partial PartialAction
+
+	// KeepPartial keeps the partial output of a failed or interrupted operation
+	// instead of removing it: the archive's partial file (see PartialPath), so a
+	// later run can resume it, or the file an extraction was writing
+	KeepPartial bool
+
+	// Deadline, if set, stops a compression or extraction with ErrDeadline
+	// once it is reached, between chunks of the entry in progress. A stopped
+	// compression keeps its partial archive to resume.
+	Deadline time.Time
+
+	// Warn, if set, receives non-fatal warnings such as inputs that were
+	// skipped. Pass a WarningLog's Add method to collect them.
+	Warn func(w Warning)
+
+	// OnEntry, if set, is called as each entry is compressed, extracted or
+	// verified. It may be called from several goroutines at once.
+	OnEntry func(r EntryResult)
+
+	// ctx, if set, cancels the operation (see Compress)
+	ctx context.Context
+
+	// workerSlots, if set, is a worker budget shared by several archives written
+	// at once: each entry holds a slot while it is compressed
+	workerSlots chan struct{}
+}
+
+// acquireWorker waits for a slot in the shared worker budget, if any, and
+// returns the function that releases it
+func (o Options) acquireWorker() func() {
+	if o.workerSlots == nil {
+		return func() {}
+	}
+	o.workerSlots <- struct{}{}
+	return func() { <-o.workerSlots }
+}
+
+// newTracker creates the progress tracker of an operation of the given size,
+// reporting to Events and Progress
+func (o Options) newTracker(size uint64) *progress.Tracker {
+	tracker := progress.NewTracker(size)
+	tracker.SetEvents(o.Events)
+	tracker.SetReporter(o.Progress)
+	return tracker
+}
+
+// warn reports a non-fatal warning through the Warn callback, if any
+func (o Options) warn(code WarningCode, path, msg string) {
+	if o.Warn != nil {
+		o.Warn(Warning{Code: code, Path: path, Message: msg})
+	}
+}
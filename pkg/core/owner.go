@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OwnerMap translates archived user and group IDs when restoring ownership, so
+// archives created as root can be restored into rootless containers or home
+// directories. IDs without a mapping are restored unchanged.
+type OwnerMap struct {
+	UIDs map[uint32]uint32
+	GIDs map[uint32]uint32
+}
+
+// ParseOwnerMap parses a mapping such as "uid:0=1000,gid:0=1000"
+func ParseOwnerMap(s string) (*OwnerMap, error) {
+	m := &OwnerMap{UIDs: make(map[uint32]uint32), GIDs: make(map[uint32]uint32)}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, pair, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid owner mapping %q: want uid:FROM=TO or gid:FROM=TO", item)
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid owner mapping %q: want uid:FROM=TO or gid:FROM=TO", item)
+		}
+		fromID, err := strconv.ParseUint(from, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid owner mapping %q: %w", item, err)
+		}
+		toID, err := strconv.ParseUint(to, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid owner mapping %q: %w", item, err)
+		}
+		switch kind {
+		case "uid":
+			m.UIDs[uint32(fromID)] = uint32(toID)
+		case "gid":
+			m.GIDs[uint32(fromID)] = uint32(toID)
+		default:
+			return nil, fmt.Errorf("invalid owner mapping %q: kind must be uid or gid", item)
+		}
+	}
+	return m, nil
+}
+
+// Map returns the IDs an archived owner is restored as
+func (m *OwnerMap) Map(uid, gid uint32) (uint32, uint32) {
+	if m == nil {
+		return uid, gid
+	}
+	if mapped, ok := m.UIDs[uid]; ok {
+		uid = mapped
+	}
+	if mapped, ok := m.GIDs[gid]; ok {
+		gid = mapped
+	}
+	return uid, gid
+}
+
+// ownerRestorer applies archived ownership to extracted files. Failures are
+// counted rather than aborting extraction, and reported once at the end.
+type ownerRestorer struct {
+	mapping  *OwnerMap
+	perms    bool // Restore the setuid, setgid and sticky bits after the owner
+	mu       sync.Mutex
+	failed   int
+	firstErr error
+}
+
+// newOwnerRestorer returns a restorer, or nil when ownership should be left
+// alone: like tar, ownership is restored only when running as root or when the
+// caller asked for it by giving a mapping.
+func newOwnerRestorer(opts Options) *ownerRestorer {
+	if opts.OwnerMap == nil && os.Geteuid() != 0 {
+		return nil
+	}
+	return &ownerRestorer{mapping: opts.OwnerMap, perms: !opts.NoPerms}
+}
+
+// restore sets the owner of path from the archived attributes, then the
+// setuid, setgid and sticky bits that go with it
+func (r *ownerRestorer) restore(path string, attrs entryAttrs) {
+	if r == nil || !attrs.hasOwner {
+		return
+	}
+	uid, gid := r.mapping.Map(attrs.uid, attrs.gid)
+	err := chownFile(path, uid, gid)
+	if err == nil && r.perms && attrs.hasMode && attrs.mode&specialBits != 0 {
+		err = os.Chmod(path, attrs.mode&(os.ModePerm|specialBits))
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.failed++
+		if r.firstErr == nil {
+			r.firstErr = err
+		}
+		r.mu.Unlock()
+	}
+}
+
+// report warns about files whose ownership could not be restored
+func (r *ownerRestorer) report(opts Options) {
+	if r == nil || r.failed == 0 {
+		return
+	}
+	opts.warn(WarnOwnerNotRestored, "", fmt.Sprintf("could not restore ownership of %d %s: %v", r.failed, plural(r.failed, "file", "files"), r.firstErr))
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner recorded for a file, if the platform has one
+func fileOwner(info os.FileInfo) entryAttrs {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return entryAttrs{}
+	}
+	return entryAttrs{hasOwner: true, uid: st.Uid, gid: st.Gid}
+}
+
+// fileDevice returns the ID of the device a file is on
+func fileDevice(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// chownFile sets the owner of an extracted file
+func chownFile(path string, uid, gid uint32) error {
+	return os.Lchown(path, int(uid), int(gid))
+}
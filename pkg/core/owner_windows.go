@@ -0,0 +1,20 @@
+//go:build windows
+
+package core
+
+import "os"
+
+// fileOwner returns the owner recorded for a file; Windows has no numeric owner
+func fileOwner(info os.FileInfo) entryAttrs {
+	return entryAttrs{}
+}
+
+// fileDevice reports no device; Windows walks are not split by file system
+func fileDevice(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// chownFile is a no-op on Windows, which has no numeric ownership to restore
+func chownFile(path string, uid, gid uint32) error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"agcp/pkg/progress"
+)
+
+// ErrPartialOutput is returned when an interrupted run left a partial archive
+// and Options.Partial does not say what to do with it
+var ErrPartialOutput = errors.New("partial output from an interrupted run")
+
+// PartialAction says what to do with a partial archive left by an interrupted run
+type PartialAction int
+
+const (
+	PartialAbort     PartialAction = iota // Fail with ErrPartialOutput (the default)
+	PartialResume                         // Keep the finished entries and compress the rest
+	PartialOverwrite                      // Discard the partial archive and start over
+)
+
+// PartialPath returns the path an archive is written to until it is complete.
+// The finished archive is renamed to output, so output is never half-written.
+func PartialPath(output string) string {
+	return output + ".tmp"
+}
+
+// openOutput creates the partial file for output, or reopens a partial file
+// left by an interrupted run according to opts.Partial. It returns the file, the
+// offsets of the entry table records and the header length, and the number of
+// entries already complete.
+func openOutput(output string, archiveType ArchiveType, rootName string, entries []Entry, opts Options) (*os.File, []int64, int64, int, error) {
+	tmp := PartialPath(output)
+	if _, err := os.Stat(tmp); err == nil {
+		switch opts.Partial {
+		case PartialResume:
+			f, err := os.OpenFile(tmp, os.O_RDWR, 0)
+			if err != nil {
+				return nil, nil, 0, 0, fmt.Errorf("open partial output: %w", err)
+			}
+			offsets, headerLen, done, err := resumePartial(f, archiveType, rootName, entries, uint32(opts.Align), provenance(opts))
+			if err != nil {
+				f.Close()
+				return nil, nil, 0, 0, fmt.Errorf("resume %s: %w", tmp, err)
+			}
+			return f, offsets, headerLen, done, nil
+		case PartialOverwrite:
+			if err := os.Remove(tmp); err != nil {
+				return nil, nil, 0, 0, fmt.Errorf("remove partial output: %w", err)
+			}
+		default:
+			return nil, nil, 0, 0, fmt.Errorf("%w: %s; resume or overwrite it", ErrPartialOutput, tmp)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, 0, 0, fmt.Errorf("check partial output: %w", err)
+	}
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, 0, 0, fmt.Errorf("create output: %w", err)
+	}
+
+	// Write header
+	prov := provenance(opts)
+	if err := writeArchiveHeader(f, archiveType, rootName, entries, uint32(opts.Align), creationTime(opts), prov); err != nil {
+		f.Close()
+		return nil, nil, 0, 0, err
+	}
+
+	// Write metadata placeholders
+	offsets, headerLen := entryTableLayout(rootName, entries, prov)
+	if _, err := f.Write(make([]byte, headerLen-headerSize(rootName, entries, prov))); err != nil {
+		f.Close()
+		return nil, nil, 0, 0, fmt.Errorf("write placeholders: %w", err)
+	}
+	return f, offsets, headerLen, 0, nil
+}
+
+// headerSize returns the size of the archive header preceding the entry table
+func headerSize(rootName string, entries []Entry, prov *Provenance) int64 {
+	return int64(len(Magic) + 1 + len(encodeFeatures(archiveFeatures(entries, prov))) + 1 + uvarintLen(uint64(len(rootName))) + len(rootName) + uvarintLen(uint64(len(entries))) + 4 + 8) // magic + version + features + type + rootNameLen + rootName + count + alignment + creation time
+}
+
+// entryTableLayout returns the offset of each entry table record and the total
+// header length including the entry table
+func entryTableLayout(rootName string, entries []Entry, prov *Provenance) ([]int64, int64) {
+	offset := headerSize(rootName, entries, prov)
+	offsets := make([]int64, len(entries))
+	for i, entry := range entries {
+		offsets[i] = offset
+		offset += int64(uvarintLen(uint64(len(entry.RelPath))) + len(entry.RelPath) + 8 + 8 + 2 + len(entry.attrs.encode())) // relPathLen + relPath + sizes + attrsLen + attrs
+	}
+	return offsets, offset
+}
+
+// resumePartial validates a partial archive against the entries being
+// compressed and positions f after the last complete entry. Entries are
+// complete when their table record has been filled in and the source file
+// still has the recorded size.
+func resumePartial(f *os.File, archiveType ArchiveType, rootName string, entries []Entry, align uint32, prov *Provenance) ([]int64, int64, int, error) {
+	offsets, headerLen := entryTableLayout(rootName, entries, prov)
+
+	// The header must match apart from the creation time, which stays that of
+	// the run that created the partial archive
+	var want bytes.Buffer
+	if err := writeArchiveHeader(&want, archiveType, rootName, entries, align, 0, prov); err != nil {
+		return nil, 0, 0, err
+	}
+	table := make([]byte, headerLen)
+	if _, err := f.ReadAt(table, 0); err != nil {
+		return nil, 0, 0, fmt.Errorf("read header: %w", err)
+	}
+	if n := want.Len() - 8; !bytes.Equal(table[:n], want.Bytes()[:n]) {
+		return nil, 0, 0, fmt.Errorf("partial archive was written for a different input")
+	}
+
+	dataEnd := headerLen
+	done := 0
+	for i, entry := range entries {
+		record := table[offsets[i]:]
+		if i+1 < len(entries) {
+			record = table[offsets[i]:offsets[i+1]]
+		}
+		if bytes.Count(record, []byte{0}) == len(record) {
+			break // Not written yet
+		}
+		if entry.stream {
+			break // A pipe cannot be read again to check it, so compress it anew
+		}
+
+		// The record's length was laid out for this entry, so once its path
+		// matches, the fixed-size fields that follow are in bounds
+		pathLen, n := binary.Uvarint(record)
+		if n <= 0 || pathLen != uint64(len(entry.RelPath)) || string(record[n:n+len(entry.RelPath)]) != entry.RelPath {
+			return nil, 0, 0, fmt.Errorf("entry %d in the partial archive is not %q", i, entry.RelPath)
+		}
+		rest := record[n+len(entry.RelPath):]
+		originalSize := binary.BigEndian.Uint64(rest)
+		compressedSize := binary.BigEndian.Uint64(rest[8:])
+		if attrsLen := int(binary.BigEndian.Uint16(rest[16:])); attrsLen != len(entry.attrs.encode()) {
+			return nil, 0, 0, fmt.Errorf("entry %q was written with different options", entry.RelPath)
+		}
+		if uint64(entry.Size) != originalSize {
+			return nil, 0, 0, fmt.Errorf("%s changed size since it was compressed", entry.FilePath)
+		}
+		dataEnd = alignOffset(dataEnd, align) + int64(compressedSize)
+		done++
+	}
+
+	// Drop any half-written entry data (and a trailer, if the run got that far)
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("stat partial archive: %w", err)
+	}
+	if dataEnd > info.Size() {
+		return nil, 0, 0, fmt.Errorf("partial archive is truncated: entries end at %d but file is %d bytes", dataEnd, info.Size())
+	}
+	if err := f.Truncate(dataEnd); err != nil {
+		return nil, 0, 0, fmt.Errorf("truncate partial archive: %w", err)
+	}
+	if _, err := f.Seek(dataEnd, io.SeekStart); err != nil {
+		return nil, 0, 0, fmt.Errorf("seek partial archive: %w", err)
+	}
+	return offsets, headerLen, done, nil
+}
+
+// creditResumed reports the entries kept from a partial archive as done
+func creditResumed(entries []Entry, rootName string, tracker *progress.Tracker) {
+	for _, entry := range entries {
+		tracker.AddBytes(uint64(entry.Size))
+		tracker.FinishEntry(entry.name(rootName))
+	}
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// specialBits are the mode bits restored only along with a file's owner, as
+// tar does: setuid and setgid belong to the owner, and changing the owner
+// clears them
+const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// restoreMode gives an extracted file its archived permission bits, unless
+// Options.NoPerms is set. Files of archives that record no mode keep the
+// default of 0644 less the umask.
+func restoreMode(path string, task DecompressTask) error {
+	if !task.perms || !task.attrs.hasMode {
+		return nil
+	}
+	if err := os.Chmod(path, task.attrs.mode.Perm()); err != nil {
+		return fmt.Errorf("set permissions of %s: %w", task.DestPath, err)
+	}
+	return nil
+}
+
+// createOver creates or truncates path like os.Create, replacing a read-only
+// file there, such as one an earlier extraction restored as read-only
+func createOver(path string) (*os.File, error) {
+	f, err := os.Create(path)
+	if !errors.Is(err, fs.ErrPermission) {
+		return f, err
+	}
+	if info, lerr := os.Lstat(path); lerr == nil && info.Mode().IsRegular() && info.Mode().Perm()&0200 == 0 {
+		if os.Remove(path) == nil {
+			return os.Create(path)
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// spillMemory is how much of an entry's compressed data is buffered in memory
+// before it spills to a temporary file
+const spillMemory = 4 << 20
+
+// compressWorkers returns the number of entries compressed concurrently
+func compressWorkers(opts Options) int {
+	if opts.Workers > 0 {
+		return opts.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// spill holds one entry's compressed data until the writer appends it to the archive
+type spill struct {
+	mem  bytes.Buffer
+	dir  string            // Directory for the temporary file; the OS default if empty
+	file *os.File          // Temporary file holding the data once it outgrows spillMemory
+	done func()            // Unregisters file from the cleanup registry
+	orig uint64            // Original size of the entry
+	comp uint64            // Compressed size
+	sum  [sha256.Size]byte // SHA-256 of the original content
+	took time.Duration     // Time spent compressing the entry
+	err  error
+}
+
+// Write implements io.Writer, moving the data to a temporary file once it
+// outgrows spillMemory
+func (s *spill) Write(p []byte) (int, error) {
+	if s.file == nil && s.mem.Len()+len(p) > spillMemory {
+		f, err := os.CreateTemp(s.dir, "agcp-spill-*")
+		if err != nil {
+			return 0, fmt.Errorf("create spill file: %w", err)
+		}
+		s.file, s.done = f, trackFile(f.Name(), cleanupTemp)
+		if _, err := s.mem.WriteTo(f); err != nil {
+			return 0, fmt.Errorf("write spill file: %w", err)
+		}
+	}
+	s.comp += uint64(len(p))
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+	return s.mem.Write(p)
+}
+
+// writeTo copies the spilled data to w
+func (s *spill) writeTo(w io.Writer) error {
+	if s.file == nil {
+		_, err := s.mem.WriteTo(w)
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, s.file)
+	return err
+}
+
+// release removes the spill's temporary file, if any
+func (s *spill) release() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.done()
+		s.file = nil
+	}
+}
+
+// compressParallel compresses entries[start:] on several workers and passes
+// them to write strictly in entry table order. Workers compress into spills; an
+// ordered writer stage writes each entry's data only after all earlier entries,
+// so the archive is byte-identical whatever the number of workers or the order
+// in which they finish. Large and small entries are scheduled in separate lanes
+// as in forEachByOffset, so a few giant entries cannot occupy every worker while
+// the small ones wait. Workers run large entries at most two per worker ahead of
+// the writer and small ones, which each hold little, at most smallAhead per
+// worker, which bounds the compressed data held in memory or spill files.
+func compressParallel(entries []Entry, start int, rootName string, opts Options, tracker *progress.Tracker, write func(i int, s *spill) error) error {
+	workers := compressWorkers(opts)
+	if sched := newScheduler(opts, "compress", workers); sched != nil {
+		defer sched.stop()
+		return compressScheduled(sched, entries, start, rootName, opts, tracker, write)
+	}
+	results := make([]chan *spill, len(entries))
+	q := newFairQueue()
+	q.large.ahead = 2 * workers
+	q.small.ahead = smallAhead * workers
+	q.written = start
+	for i := start; i < len(entries); i++ {
+		results[i] = make(chan *spill, 1)
+		if entries[i].Size >= largeEntrySize {
+			q.large.runs = append(q.large.runs, []int{i})
+		} else {
+			q.small.runs = append(q.small.runs, []int{i})
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		q.run(workers, func(run []int) {
+			i := run[0]
+			tracker.StartEntry(entries[i].RelPath)
+			s := &spill{dir: opts.TempDir}
+			began := time.Now()
+			s.orig, s.sum, s.err = compressFileStreaming(entries[i], s, opts, tracker, nil, 0)
+			s.took = time.Since(began)
+			results[i] <- s
+		})
+	}()
+
+	err := writeInOrder(entries, start, rootName, opts, results, q, tracker, write)
+	q.close()
+	<-finished
+
+	// Release spills the writer never reached after an error
+	for i := start; i < len(entries); i++ {
+		select {
+		case s := <-results[i]:
+			s.release()
+		default:
+		}
+	}
+	return err
+}
+
+// smallAhead is how many small entries per worker compressParallel may run
+// ahead of the writer
+const smallAhead = 8
+
+// writeInOrder is the writer stage of compressParallel: it passes each
+// entry's spill to write in entry table order
+func writeInOrder(entries []Entry, start int, rootName string, opts Options, results []chan *spill, q *fairQueue, tracker *progress.Tracker, write func(i int, s *spill) error) error {
+	for i := start; i < len(entries); i++ {
+		entry := entries[i]
+		s := <-results[i]
+		q.advance(i + 1)
+		if err := opts.stopped(); err != nil {
+			s.release() // Workers may be done with every entry by the time ctx is canceled
+			return err
+		}
+		err := write(i, s)
+		s.release()
+		if err != nil {
+			return err
+		}
+		tracker.FinishEntry(entry.name(rootName))
+		opts.entryDone(entry.result(rootName, s.orig, s.comp, s.took))
+	}
+	return nil
+}
+
+// compressScheduled is compressParallel on a schedule: each entry is
+// compressed on the worker the schedule picks and written before the next one
+// starts
+func compressScheduled(sched *scheduler, entries []Entry, start int, rootName string, opts Options, tracker *progress.Tracker, write func(i int, s *spill) error) error {
+	for i := start; i < len(entries); i++ {
+		entry := entries[i]
+		s := &spill{dir: opts.TempDir}
+		err := sched.run(i, entry.name(rootName), func() error {
+			tracker.StartEntry(entry.RelPath)
+			began := time.Now()
+			s.orig, s.sum, s.err = compressFileStreaming(entry, s, opts, tracker, nil, 0)
+			s.took = time.Since(began)
+			if s.err == nil {
+				return nil
+			}
+			return &EntryError{Path: entry.name(rootName), Op: "compress", Err: s.err}
+		})
+		if err == nil {
+			err = write(i, s)
+		}
+		s.release()
+		if err != nil {
+			return err
+		}
+		tracker.FinishEntry(entry.name(rootName))
+		opts.entryDone(entry.result(rootName, s.orig, s.comp, s.took))
+	}
+	return nil
+}
+
+// appendSpill writes a compressed entry at the end of f, aligned, and records
+// it in the entry table
+func appendSpill(f *os.File, tableOffset int64, entry Entry, s *spill, align uint32) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := padToAlignment(f, align); err != nil {
+		return err
+	}
+	if err := s.writeTo(f); err != nil {
+		return fmt.Errorf("write compressed %s: %w", entry.FilePath, err)
+	}
+	entry.attrs.hash = s.sum
+	return updateEntryMetadata(f, tableOffset, entry, s.orig, s.comp)
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Policy chooses the codec and level of each entry by its path. The first
+// rule whose pattern matches an entry applies; entries matching no rule use
+// Options.Codec and Options.Level.
+type Policy struct {
+	Rules []PolicyRule
+}
+
+// PolicyRule is one pattern of a Policy. Patterns without a slash match the
+// entry's base name ("*.mp4"); patterns with one match the whole archive path,
+// where a "**" segment matches any number of directories ("logs/**").
+type PolicyRule struct {
+	Pattern string
+	Store   bool  // Store matching entries uncompressed
+	Codec   Codec // Codec for matching entries; CodecDefault keeps Options.Codec
+	Level   int   // Level for matching entries; zero keeps Options.Level
+}
+
+// LoadPolicy reads a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open policy: %w", err)
+	}
+	defer f.Close()
+	p, err := ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy parses a policy: one "pattern: action" line per rule, in the
+// style of a YAML mapping. An action is "store", a codec name, a codec and
+// level such as "gzip:9", or a level alone. Blank lines and # comments are
+// ignored, and patterns and actions may be quoted.
+//
+//	*.mp4: store
+//	*.csv: gzip:9
+//	"logs/**": 9
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	p := &Policy{}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, action, err := splitPolicyLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rule, err := parsePolicyAction(pattern, action)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	return p, nil
+}
+
+// splitPolicyLine splits a "pattern: action" line, unquoting both parts and
+// dropping a trailing comment
+func splitPolicyLine(line string) (string, string, error) {
+	var pattern, rest string
+	if q := line[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(line[1:], q)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote in %q", line)
+		}
+		pattern, rest = line[1:1+end], strings.TrimSpace(line[2+end:])
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("want pattern: action, got %q", line)
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			return "", "", fmt.Errorf("want pattern: action, got %q", line)
+		}
+		pattern, rest = strings.TrimSpace(line[:i]), line[i+2:]
+	}
+	if i := strings.Index(rest, " #"); i >= 0 {
+		rest = rest[:i]
+	}
+	action := strings.Trim(strings.TrimSpace(rest), `"'`)
+	if pattern == "" || action == "" {
+		return "", "", fmt.Errorf("want pattern: action, got %q", line)
+	}
+	return pattern, action, nil
+}
+
+// parsePolicyAction builds the rule for a pattern and its action
+func parsePolicyAction(pattern, action string) (PolicyRule, error) {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return PolicyRule{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	rule := PolicyRule{Pattern: pattern}
+	if strings.EqualFold(action, "store") {
+		rule.Store = true
+		return rule, nil
+	}
+
+	name, levelStr, hasLevel := strings.Cut(action, ":")
+	if level, err := strconv.Atoi(name); err == nil && !hasLevel {
+		name, levelStr, hasLevel = "", strconv.Itoa(level), true
+	}
+	if name != "" {
+		codec, err := ParseCodec(name)
+		if err != nil {
+			return PolicyRule{}, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		rule.Codec = codec
+	}
+	if hasLevel {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < 1 || level > 9 {
+			return PolicyRule{}, fmt.Errorf("pattern %q: level %q must be 1 to 9", pattern, levelStr)
+		}
+		rule.Level = level
+	}
+	return rule, nil
+}
+
+// policyMatcher is a Policy compiled for matching the entries of an archive
+type policyMatcher struct {
+	rules   []PolicyRule
+	set     *globSet
+	matches []int // Scratch for the set's matches
+}
+
+// compile prepares the policy for matching entries; a nil policy gives a nil
+// matcher, which matches nothing
+func (p *Policy) compile() *policyMatcher {
+	if p == nil {
+		return nil
+	}
+	m := &policyMatcher{rules: p.Rules, set: newGlobSet()}
+	for i, rule := range p.Rules {
+		if strings.Contains(rule.Pattern, "/") {
+			m.set.addPath(i, rule.Pattern)
+		} else {
+			m.set.addBase(i, rule.Pattern)
+		}
+	}
+	return m
+}
+
+// match returns the first rule matching the entry with the given archive path
+func (m *policyMatcher) match(name string) *PolicyRule {
+	if m == nil {
+		return nil
+	}
+	m.matches = m.set.appendMatches(m.matches[:0], filepath.ToSlash(name))
+	if len(m.matches) == 0 {
+		return nil
+	}
+	return &m.rules[m.matches[0]]
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// largeEntrySize is the size from which an entry is scheduled in the large lane
+const largeEntrySize = 4 << 20
+
+// runsPerWorker is how many contiguous runs forEachByOffset cuts the small
+// tasks into per worker, so a worker that finishes early takes another run
+// instead of sitting idle while the others read on
+const runsPerWorker = 4
+
+// forEachByOffset runs fn for every task on at most runtime.NumCPU() workers,
+// waits for all of them and returns the first error. Tasks are sorted by the
+// offset of their data in the archive. Large tasks are scheduled one by one in
+// their own lane, and the small ones between them are cut into contiguous runs
+// of about the same number of bytes in the small lane, so a few giant entries
+// cannot occupy every worker while thousands of small ones wait. Workers take
+// the runs of a lane front to back and work through each in order, so each
+// reads its stretch of the archive sequentially and the stretches in flight
+// are neighbours, which disks and network file systems serve far faster than
+// reads scattered over the archive.
+func forEachByOffset(offsets []int64, sizes []uint64, fn func(i int) error) error {
+	order := make([]int, len(offsets))
+	var smallTotal uint64
+	for i := range order {
+		order[i] = i
+		if sizes[i] < largeEntrySize {
+			smallTotal += sizes[i]
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+
+	workers := runtime.NumCPU()
+	if workers > len(order) {
+		workers = len(order)
+	}
+	target := smallTotal / uint64(max(workers*runsPerWorker, 1))
+	q := newFairQueue()
+	var run []int
+	var runBytes uint64
+	flush := func() {
+		if len(run) > 0 {
+			q.small.runs = append(q.small.runs, run)
+			run, runBytes = nil, 0
+		}
+	}
+	for _, i := range order {
+		if sizes[i] >= largeEntrySize {
+			// A large entry breaks the run, which must stay contiguous
+			flush()
+			q.large.runs = append(q.large.runs, []int{i})
+			continue
+		}
+		run = append(run, i)
+		runBytes += sizes[i]
+		if runBytes > target {
+			flush()
+		}
+	}
+	flush()
+
+	errCh := make(chan error, len(order))
+	q.run(workers, func(run []int) {
+		for _, i := range run {
+			if err := fn(i); err != nil {
+				errCh <- err
+			}
+		}
+	})
+	close(errCh)
+
+	// Return first error if any
+	if len(errCh) > 0 {
+		return <-errCh
+	}
+	return nil
+}
+
+// fairQueue holds the remaining runs of task indices of the two scheduling
+// lanes
+type fairQueue struct {
+	mu           sync.Mutex
+	cond         sync.Cond
+	large, small lane
+	written      int // Tasks below this index are written out, see lane.ahead
+	closed       bool
+}
+
+// lane is one scheduling lane of a fairQueue
+type lane struct {
+	runs  [][]int
+	ahead int // How far past fairQueue.written a run may start; 0 is unbounded
+}
+
+// newFairQueue returns an empty queue with unbounded lanes
+func newFairQueue() *fairQueue {
+	q := &fairQueue{}
+	q.cond.L = &q.mu
+	return q
+}
+
+// run works through the queue on the given number of workers and waits for
+// them. Half of the workers prefer large runs and the rest prefer small ones;
+// a worker whose lane is empty takes from the other lane. A single worker
+// alternates lanes.
+func (q *fairQueue) run(workers int, fn func(run []int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(preferLarge bool) {
+			defer wg.Done()
+			for {
+				run, ok := q.take(preferLarge)
+				if !ok {
+					return
+				}
+				fn(run)
+				if workers == 1 {
+					preferLarge = !preferLarge
+				}
+			}
+		}(w%2 == 1)
+	}
+	wg.Wait()
+}
+
+// take returns the next run, from the preferred lane if it has one it may
+// start. It waits while every remaining run is too far ahead of the writer,
+// and returns false once the queue is empty or closed.
+func (q *fairQueue) take(preferLarge bool) ([]int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	first, second := &q.small, &q.large
+	if preferLarge {
+		first, second = second, first
+	}
+	for {
+		if q.closed || len(first.runs)+len(second.runs) == 0 {
+			return nil, false
+		}
+		for _, l := range []*lane{first, second} {
+			if len(l.runs) > 0 && (l.ahead == 0 || l.runs[0][0] < q.written+l.ahead) {
+				run := l.runs[0]
+				l.runs = l.runs[1:]
+				return run, true
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+// advance records that the tasks below written are written out, letting the
+// runs of bounded lanes start up to their distance past it
+func (q *fairQueue) advance(written int) {
+	q.mu.Lock()
+	q.written = written
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// close makes take return false for every worker, waiting or not
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
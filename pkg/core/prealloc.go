@@ -0,0 +1,21 @@
+package core
+
+import (
+	"fmt"
+	"os"
+)
+
+// preallocate reserves disk space for a file about to be written with size
+// bytes, leaving its size alone, so the file system can lay it out
+// contiguously and an extraction that does not fit fails before writing the
+// file rather than part way through. Where the platform or file system cannot
+// preallocate, the file is written as usual.
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	if err := allocate(f, size); err != nil && isNoSpace(err) {
+		return fmt.Errorf("not enough disk space for %s (%d bytes): %w", f.Name(), size, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build darwin
+
+package core
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// allocate reserves size bytes of disk space for f with F_PREALLOCATE,
+// contiguously if the file system can, otherwise in any layout
+func allocate(f *os.File, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var allocErr error
+	if err := conn.Control(func(fd uintptr) {
+		store := syscall.Fstore_t{Flags: syscall.F_ALLOCATECONTIG | syscall.F_ALLOCATEALL, Posmode: syscall.F_PEOFPOSMODE, Length: size}
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_PREALLOCATE, uintptr(unsafe.Pointer(&store))); errno == 0 {
+			return
+		}
+		store.Flags = syscall.F_ALLOCATEALL
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_PREALLOCATE, uintptr(unsafe.Pointer(&store))); errno != 0 {
+			allocErr = errno
+		}
+	}); err != nil {
+		return err
+	}
+	return allocErr
+}
+
+// isNoSpace reports whether err says the disk or quota is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
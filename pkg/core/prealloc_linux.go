@@ -0,0 +1,32 @@
+//go:build linux
+
+package core
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate without changing the size
+const fallocKeepSize = 0x1
+
+// allocate reserves size bytes of disk space for f with fallocate
+func allocate(f *os.File, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var allocErr error
+	if err := conn.Control(func(fd uintptr) {
+		allocErr = syscall.Fallocate(int(fd), fallocKeepSize, 0, size)
+	}); err != nil {
+		return err
+	}
+	return allocErr
+}
+
+// isNoSpace reports whether err says the disk or quota is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !linux && !windows
+
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+// allocate is not supported on this platform; files are written as usual
+func allocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
+
+// isNoSpace reports whether err says the disk is full; allocate never does
+func isNoSpace(err error) bool {
+	return false
+}
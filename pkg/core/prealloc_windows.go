@@ -0,0 +1,40 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Windows error codes for a full disk
+const (
+	errorHandleDiskFull syscall.Errno = 39  // ERROR_HANDLE_DISK_FULL
+	errorDiskFull       syscall.Errno = 112 // ERROR_DISK_FULL
+)
+
+// fileAllocationInfo is the FileAllocationInfo information class
+const fileAllocationInfo = 5
+
+var procSetFileInformationByHandle = syscall.NewLazyDLL("kernel32.dll").NewProc("SetFileInformationByHandle")
+
+// allocate reserves size bytes of disk space for f by setting its allocation
+// size, which unlike SetEndOfFile leaves the end of the file where it is
+func allocate(f *os.File, size int64) error {
+	if err := procSetFileInformationByHandle.Find(); err != nil {
+		return err
+	}
+	info := struct{ AllocationSize int64 }{size}
+	r, _, err := procSetFileInformationByHandle.Call(f.Fd(), fileAllocationInfo, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// isNoSpace reports whether err says the disk is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
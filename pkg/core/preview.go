@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExtractionPreview summarises what an extraction would write, so it can be
+// confirmed before anything on disk is touched
+type ExtractionPreview struct {
+	Dest       string   // Directory extracted into, or the file written for a single-file archive
+	NonEmpty   bool     // Dest is a directory with something in it, or a file that exists
+	Entries    int      // Entries in the archive
+	TotalSize  uint64   // Uncompressed size of all entries
+	TopLevel   []string // Distinct top-level names the entries are extracted under, sorted
+	Overwrites int      // Entries whose destination already exists
+}
+
+// PreviewExtraction reads the entry table of an archive and reports what
+// extracting it with Decompress would write, without writing
+// anything. Encrypted archives cannot be previewed and give an error wrapping
+// ErrEncrypted.
+func PreviewExtraction(input, decompressedName string, opts Options) (*ExtractionPreview, error) {
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, fmt.Errorf("open input: %w", err)
+	}
+	defer f.Close()
+
+	// Warnings about names are reported by the extraction itself
+	opts.Warn = nil
+	tasks, outputDir, archiveType, err := readArchiveHeader(f, decompressedName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ExtractionPreview{Dest: outputDir, Entries: len(tasks)}
+	if archiveType == ArchiveFile && len(tasks) > 0 {
+		p.Dest = tasks[0].DestPath
+	}
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		p.TotalSize += task.OriginalSize
+		if _, err := os.Lstat(task.DestPath); err == nil {
+			p.Overwrites++
+		}
+		top := filepath.Base(task.DestPath)
+		if archiveType == ArchiveDir {
+			top, _, _ = strings.Cut(filepath.ToSlash(task.RelPath), "/")
+		}
+		if !seen[top] {
+			seen[top] = true
+			p.TopLevel = append(p.TopLevel, top)
+		}
+	}
+	sort.Strings(p.TopLevel)
+
+	if info, err := os.Stat(p.Dest); err == nil {
+		p.NonEmpty = !info.IsDir() || !dirEmpty(p.Dest)
+	}
+	return p, nil
+}
+
+// dirEmpty reports whether the directory at path has no entries; a directory
+// that cannot be read is not known to be empty
+func dirEmpty(path string) bool {
+	d, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+	_, err = d.Readdirnames(1)
+	return err == io.EOF
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Provenance records how an archive was produced, so that a restore months
+// later can tell which command, agcp build and machine wrote it. It is only
+// recorded when asked for with Options.Provenance, since command lines can
+// carry names and paths that should not travel with the archive.
+type Provenance struct {
+	Command  []string // Command line, program first
+	Version  string   // agcp build that wrote the archive
+	Hostname string
+	OS       string // Operating system and architecture, such as "linux/amd64"
+}
+
+// CurrentProvenance describes the running process: its command line, this
+// agcp build, the host name and the OS
+func CurrentProvenance() *Provenance {
+	hostname, _ := os.Hostname()
+	return &Provenance{
+		Command:  append([]string(nil), os.Args...),
+		Version:  buildVersion(),
+		Hostname: hostname,
+		OS:       runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// buildVersion returns the module version and VCS revision this binary was
+// built from, as far as the build recorded them
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var revision string
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	// Pseudo-versions already name the revision
+	if revision = revision[:min(len(revision), 12)]; revision != "" && !strings.Contains(version, revision) {
+		version += " " + revision
+		if modified {
+			version += "+dirty"
+		}
+	}
+	return version
+}
+
+// Fields of the provenance block. Readers skip fields they don't know.
+const (
+	provCommand  = 1 // Arguments separated by NUL bytes
+	provVersion  = 2
+	provHostname = 3
+	provOS       = 4
+)
+
+// maxProvenance bounds the provenance block read from an archive
+const maxProvenance = 1 << 20
+
+// encode returns the provenance block: for each field tag(uvarint) +
+// len(uvarint) + value
+func (p *Provenance) encode() []byte {
+	var b []byte
+	for _, field := range []struct {
+		tag   uint64
+		value string
+	}{
+		{provCommand, strings.Join(p.Command, "\x00")},
+		{provVersion, p.Version},
+		{provHostname, p.Hostname},
+		{provOS, p.OS},
+	} {
+		b = binary.AppendUvarint(b, field.tag)
+		b = binary.AppendUvarint(b, uint64(len(field.value)))
+		b = append(b, field.value...)
+	}
+	return b
+}
+
+// decodeProvenance parses a provenance block
+func decodeProvenance(b []byte) (*Provenance, error) {
+	p := &Provenance{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("truncated field tag")
+		}
+		b = b[n:]
+		size, n := binary.Uvarint(b)
+		if n <= 0 || size > uint64(len(b)-n) {
+			return nil, fmt.Errorf("field %d: length exceeds block", tag)
+		}
+		value := string(b[n : n+int(size)])
+		b = b[n+int(size):]
+		switch tag {
+		case provCommand:
+			p.Command = strings.Split(value, "\x00")
+		case provVersion:
+			p.Version = value
+		case provHostname:
+			p.Hostname = value
+		case provOS:
+			p.OS = value
+		}
+	}
+	return p, nil
+}
+
+// writeProvenance appends the provenance block of a v9+ archive after the
+// entry data: the block, its CRC-32 and its length, so a reader finds it by
+// reading back from the trailer. It is written when the archive is finished,
+// so a resumed archive records the run that finished it.
+func writeProvenance(f io.WriteSeeker, p *Provenance) error {
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek end for provenance: %w", err)
+	}
+	return writeProvenanceBlock(f, p)
+}
+
+// writeProvenanceBlock writes the provenance block at the current position of w
+func writeProvenanceBlock(w io.Writer, p *Provenance) error {
+	block := p.encode()
+	if len(block) > maxProvenance {
+		return fmt.Errorf("provenance is %d bytes, the limit is %d", len(block), maxProvenance)
+	}
+	block = binary.BigEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+	block = binary.BigEndian.AppendUint32(block, uint32(len(block)-4))
+	if _, err := w.Write(block); err != nil {
+		return fmt.Errorf("write provenance: %w", err)
+	}
+	return nil
+}
+
+// readProvenance reads the provenance block ending at offset end, returning it
+// and its size on disk
+func readProvenance(f io.ReaderAt, end int64) (*Provenance, int64, error) {
+	var tail [8]byte
+	if end < int64(len(tail)) {
+		return nil, 0, fmt.Errorf("corrupt archive: no room for the provenance block")
+	}
+	if _, err := f.ReadAt(tail[:], end-8); err != nil {
+		return nil, 0, fmt.Errorf("read provenance: %w", err)
+	}
+	size := int64(binary.BigEndian.Uint32(tail[4:]))
+	if size > maxProvenance || size > end-8 {
+		return nil, 0, fmt.Errorf("corrupt archive: provenance block of %d bytes", size)
+	}
+	block := make([]byte, size)
+	if _, err := f.ReadAt(block, end-8-size); err != nil {
+		return nil, 0, fmt.Errorf("read provenance: %w", err)
+	}
+	if crc32.ChecksumIEEE(block) != binary.BigEndian.Uint32(tail[:4]) {
+		return nil, 0, fmt.Errorf("corrupt archive: provenance checksum mismatch")
+	}
+	p, err := decodeProvenance(block)
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupt archive: provenance: %w", err)
+	}
+	return p, size + 8, nil
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RetentionPolicy says which archives Prune keeps, grandfather-father-son
+// style: for each period kind, the newest archive of each of the most recent
+// periods that have one. An archive kept by any rule is kept.
+type RetentionPolicy struct {
+	Last    int // Newest archives to keep whatever their dates
+	Daily   int // Days to keep the newest archive of
+	Weekly  int // ISO weeks to keep the newest archive of
+	Monthly int // Months to keep the newest archive of
+	Yearly  int // Years to keep the newest archive of
+}
+
+// ArchiveRetention is Prune's decision about one archive
+type ArchiveRetention struct {
+	Path     string    // Path of the archive, under the pruned directory
+	Series   string    // Archives pruned together: the path with its timestamp replaced by "*"
+	Time     time.Time // When the archive was taken; zero if it has no date
+	FromName bool      // Time was parsed from the file name rather than the archive header
+	Size     int64
+	Keep     bool
+	Reasons  []string // Rules that keep the archive ("daily 2024-05-01"), or why it is kept regardless
+	Err      error    // Why a removal failed, if it did
+}
+
+// PruneReport is the result of Prune
+type PruneReport struct {
+	Archives []*ArchiveRetention // Newest first within each series, series in path order
+	Removed  int                 // Archives removed, or that would be with dry run
+	Freed    int64               // Bytes freed, or that would be with dry run
+}
+
+// nameTimestamp matches a date in an archive name, optionally followed by a
+// time of day: 2024-05-01, 20240501, 2024-05-01T03-00, 2024-05-01_030000, ...
+var nameTimestamp = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})(?:[T_ -]?(\d{2})[-:.]?(\d{2})(?:[-:.]?(\d{2}))?)?`)
+
+// Prune applies a retention policy to the archives under dir and removes the
+// ones no rule keeps, with their checksum sidecars. With dryRun nothing is
+// removed; the report says what would be.
+//
+// An archive is dated by a timestamp in its file name, or failing that by the
+// creation time in its header (format v6+, unless reproducible). Archives are
+// pruned in series: those in the same directory whose names differ only in
+// their timestamps, so several backup sets can share a directory. Archives
+// with no date are always kept.
+func Prune(dir string, policy RetentionPolicy, dryRun bool) (*PruneReport, error) {
+	if policy.Last < 0 || policy.Daily < 0 || policy.Weekly < 0 || policy.Monthly < 0 || policy.Yearly < 0 {
+		return nil, fmt.Errorf("retention counts must not be negative")
+	}
+	if policy == (RetentionPolicy{}) {
+		return nil, fmt.Errorf("retention policy keeps nothing; set at least one count")
+	}
+	archives, err := findArchives(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make(map[string][]*ArchiveRetention)
+	var names []string
+	for _, rel := range archives {
+		a := datedArchive(dir, rel)
+		if series[a.Series] == nil {
+			names = append(names, a.Series)
+		}
+		series[a.Series] = append(series[a.Series], a)
+	}
+	sort.Strings(names)
+
+	report := &PruneReport{}
+	for _, name := range names {
+		group := series[name]
+		applyRetention(group, policy)
+		for _, a := range group {
+			report.Archives = append(report.Archives, a)
+			if a.Keep {
+				continue
+			}
+			if !dryRun {
+				if a.Err = removeArchive(filepath.Join(dir, filepath.FromSlash(a.Path))); a.Err != nil {
+					continue
+				}
+			}
+			report.Removed++
+			report.Freed += a.Size
+		}
+	}
+	for _, a := range report.Archives {
+		if a.Err != nil {
+			return report, fmt.Errorf("remove %s: %w", a.Path, a.Err)
+		}
+	}
+	return report, nil
+}
+
+// datedArchive dates the archive at the slash-separated path rel under dir
+func datedArchive(dir, rel string) *ArchiveRetention {
+	a := &ArchiveRetention{Path: rel, Series: rel}
+	archivePath := filepath.Join(dir, filepath.FromSlash(rel))
+	if info, err := os.Stat(archivePath); err == nil {
+		a.Size = info.Size()
+	}
+	base := path.Base(rel)
+	if loc := nameTimestamp.FindStringSubmatchIndex(base); loc != nil {
+		if t, ok := parseNameTimestamp(base, loc); ok {
+			a.Time, a.FromName = t, true
+			a.Series = rel[:len(rel)-len(base)] + base[:loc[0]] + "*" + base[loc[1]:]
+			return a
+		}
+	}
+	if f, idx, err := openIndex(archivePath); err == nil {
+		f.Close()
+		if idx.created != 0 {
+			a.Time = time.Unix(0, idx.created)
+		}
+	}
+	return a
+}
+
+// parseNameTimestamp builds the local time matched by nameTimestamp at loc in
+// name, reporting false for impossible dates such as 2024-13-45
+func parseNameTimestamp(name string, loc []int) (time.Time, bool) {
+	var fields [6]int
+	for i := range fields {
+		start, end := loc[2+2*i], loc[3+2*i]
+		if start < 0 {
+			continue
+		}
+		fields[i], _ = strconv.Atoi(name[start:end])
+	}
+	year, month, day, hour, minute, sec := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || sec > 59 {
+		return time.Time{}, false
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, sec, 0, time.Local)
+	if t.Day() != day {
+		return time.Time{}, false // Normalized past the end of the month
+	}
+	return t, true
+}
+
+// applyRetention decides which archives of one series to keep, sorting them
+// newest first
+func applyRetention(group []*ArchiveRetention, policy RetentionPolicy) {
+	sort.SliceStable(group, func(i, j int) bool {
+		if group[i].Time.Equal(group[j].Time) {
+			return group[i].Path > group[j].Path
+		}
+		return group[i].Time.After(group[j].Time)
+	})
+	var dated []*ArchiveRetention
+	for _, a := range group {
+		if a.Time.IsZero() {
+			a.Keep, a.Reasons = true, []string{"no date"}
+		} else {
+			dated = append(dated, a)
+		}
+	}
+
+	for i, a := range dated {
+		if i < policy.Last {
+			a.Keep, a.Reasons = true, append(a.Reasons, "last")
+		}
+	}
+	rules := []struct {
+		name   string
+		count  int
+		period func(time.Time) string
+	}{
+		{"daily", policy.Daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{"weekly", policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", policy.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, rule := range rules {
+		kept, last := 0, ""
+		for _, a := range dated {
+			if kept == rule.count {
+				break
+			}
+			if period := rule.period(a.Time); period != last {
+				a.Keep, a.Reasons = true, append(a.Reasons, rule.name+" "+period)
+				kept, last = kept+1, period
+			}
+		}
+	}
+}
+
+// removeArchive removes an archive and its checksum sidecar, if any
+func removeArchive(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(SidecarPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrIncompressible is returned when Options.MinRatio is set and the sampled
+// data does not compress well enough to be worth compressing
+var ErrIncompressible = errors.New("data is incompressible")
+
+// defaultRatioSample is the input sampled before checking Options.MinRatio
+const defaultRatioSample = 64 << 20
+
+// ratioGuard checks the compression ratio once, after a sample of the input
+// has been compressed. A nil guard never trips.
+type ratioGuard struct {
+	maxRatio float64
+	sample   uint64
+	orig     uint64 // Sizes of entries finished before the current one
+	comp     uint64
+	checked  bool
+}
+
+// newRatioGuard returns a guard for opts, or nil when MinRatio is not set
+func newRatioGuard(opts Options) *ratioGuard {
+	if opts.MinRatio <= 0 {
+		return nil
+	}
+	sample := uint64(opts.RatioSample)
+	if sample == 0 {
+		sample = defaultRatioSample
+	}
+	return &ratioGuard{maxRatio: opts.MinRatio, sample: sample}
+}
+
+// due reports whether the sample is complete once the current entry has consumed orig bytes
+func (g *ratioGuard) due(orig uint64) bool {
+	return g != nil && !g.checked && g.orig+orig >= g.sample
+}
+
+// check evaluates the ratio including the current entry's sizes so far,
+// returning an error wrapping ErrIncompressible if it is too high
+func (g *ratioGuard) check(orig, comp uint64) error {
+	g.checked = true
+	orig += g.orig
+	comp += g.comp
+	ratio := float64(comp) / float64(orig)
+	if ratio <= g.maxRatio {
+		return nil
+	}
+	return fmt.Errorf("%w: first %d bytes compressed to %.1f%% of their size, above the %.1f%% limit",
+		ErrIncompressible, orig, ratio*100, g.maxRatio*100)
+}
+
+// add records the sizes of a finished entry
+func (g *ratioGuard) add(orig, comp uint64) {
+	if g != nil {
+		g.orig += orig
+		g.comp += comp
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+// Write implements io.Writer
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// nopFlusher adapts a writer to the codec writer interface for stored entries
+type nopFlusher struct{ io.Writer }
+
+// Flush implements the codec writer interface; stored data is not buffered
+func (nopFlusher) Flush() error { return nil }
+
+// Close implements io.Closer
+func (nopFlusher) Close() error { return nil }
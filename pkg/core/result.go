@@ -0,0 +1,63 @@
+package core
+
+import "time"
+
+// EntryResult is what an operation did with one entry, reported through
+// Options.OnEntry as each entry finishes
+type EntryResult struct {
+	Op             string        // "compress", "extract" or "verify"
+	Path           string        // Entry path, including the root name
+	OriginalSize   uint64        // Uncompressed size
+	CompressedSize uint64        // Size of the entry's data in the archive
+	Codec          string        // Codec the entry's data is stored with
+	Elapsed        time.Duration // Time spent on the entry
+	Skipped        bool          // Left alone: unchanged on disk or rejected by the scan
+	Cached         bool          // Compressed data copied from Options.CacheDir rather than compressed
+	Err            error         // Why verification failed, for entries Verify rejected
+}
+
+// entryDone reports a finished entry through the OnEntry callback, if any
+func (o Options) entryDone(r EntryResult) {
+	if o.OnEntry != nil {
+		o.OnEntry(r)
+	}
+}
+
+// result describes a compressed entry
+func (e Entry) result(rootName string, originalSize, compressedSize uint64, elapsed time.Duration) EntryResult {
+	return EntryResult{
+		Op:             "compress",
+		Path:           e.name(rootName),
+		OriginalSize:   originalSize,
+		CompressedSize: compressedSize,
+		Codec:          e.attrs.codec.String(),
+		Elapsed:        elapsed,
+		Cached:         e.kept != nil && e.kept.cached,
+	}
+}
+
+// result describes an extracted entry
+func (t DecompressTask) result(elapsed time.Duration, skipped bool) EntryResult {
+	return EntryResult{
+		Op:             "extract",
+		Path:           t.name,
+		OriginalSize:   t.OriginalSize,
+		CompressedSize: t.CompressedSize,
+		Codec:          t.attrs.codec.String(),
+		Elapsed:        elapsed,
+		Skipped:        skipped,
+	}
+}
+
+// result describes a verified entry
+func (idx *archiveIndex) result(entry indexEntry, elapsed time.Duration, err error) EntryResult {
+	return EntryResult{
+		Op:             "verify",
+		Path:           idx.name(entry),
+		OriginalSize:   entry.originalSize,
+		CompressedSize: entry.compressedSize,
+		Codec:          entry.attrs.codec.String(),
+		Elapsed:        elapsed,
+		Err:            err,
+	}
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how transient read errors (EIO, ESTALE and similar errors
+// seen on NFS/SMB mounts) are retried. The zero value disables retries.
+type RetryPolicy struct {
+	MaxRetries     int           // Maximum retries per file; 0 disables retrying
+	InitialBackoff time.Duration // Delay before the first retry; doubles on each attempt
+	MaxBackoff     time.Duration // Upper bound for the delay between retries
+	Log            *RetryLog     // Optional log collecting every retried read
+}
+
+// Backoff returns the delay before the given retry attempt (1-based)
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = 100 * time.Millisecond
+	}
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// RetryLog records retried reads. It is safe for concurrent use.
+type RetryLog struct {
+	mu      sync.Mutex
+	retries map[string]int
+	failed  map[string]bool
+}
+
+// record notes one retry of a read from path
+func (l *RetryLog) record(path string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.retries == nil {
+		l.retries = make(map[string]int)
+	}
+	l.retries[path]++
+}
+
+// recordFailure notes that retries for path were exhausted
+func (l *RetryLog) recordFailure(path string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed == nil {
+		l.failed = make(map[string]bool)
+	}
+	l.failed[path] = true
+}
+
+// Retries returns the number of retries per file path
+func (l *RetryLog) Retries() map[string]int {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]int, len(l.retries))
+	for path, n := range l.retries {
+		out[path] = n
+	}
+	return out
+}
+
+// Summary returns a human-readable summary of retried reads, or "" if there were none
+func (l *RetryLog) Summary() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.retries) == 0 {
+		return ""
+	}
+
+	paths := make([]string, 0, len(l.retries))
+	total := 0
+	for path, n := range l.retries {
+		paths = append(paths, path)
+		total += n
+	}
+	sort.Strings(paths)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Retried %d transient read error(s) on %d file(s):\n", total, len(paths))
+	for _, path := range paths {
+		status := "recovered"
+		if l.failed[path] {
+			status = "gave up"
+		}
+		fmt.Fprintf(&sb, "  %s: %d retr%s, %s\n", path, l.retries[path], plural(l.retries[path], "y", "ies"), status)
+	}
+	return sb.String()
+}
+
+// plural picks the singular or plural suffix for n
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// retryFile reads a file sequentially, reopening it and resuming from the last
+// good offset when a transient error occurs
+type retryFile struct {
+	path     string
+	policy   RetryPolicy
+	f        *os.File
+	offset   int64
+	attempts int
+}
+
+// openRetryFile opens path for reading under the given retry policy
+func openRetryFile(path string, policy RetryPolicy) (*retryFile, error) {
+	rf := &retryFile{path: path, policy: policy}
+	err := rf.retry(func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		rf.f = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Read implements io.Reader
+func (rf *retryFile) Read(p []byte) (int, error) {
+	var n int
+	err := rf.retry(func() error {
+		var err error
+		n, err = rf.f.Read(p)
+		rf.offset += int64(n)
+		if n > 0 {
+			return nil // Deliver the data; a persistent error resurfaces on the next read
+		}
+		if err != nil && err != io.EOF && IsTransientError(err) {
+			rf.reopen()
+		}
+		return err
+	})
+	return n, err
+}
+
+// Stat returns the file info of the underlying file
+func (rf *retryFile) Stat() (os.FileInfo, error) {
+	return rf.f.Stat()
+}
+
+// Close closes the underlying file
+func (rf *retryFile) Close() error {
+	return rf.f.Close()
+}
+
+// reopen replaces the file handle and seeks back to the current offset; stale
+// NFS handles cannot recover without reopening
+func (rf *retryFile) reopen() {
+	f, err := os.Open(rf.path)
+	if err != nil {
+		return
+	}
+	if _, err := f.Seek(rf.offset, io.SeekStart); err != nil {
+		f.Close()
+		return
+	}
+	rf.f.Close()
+	rf.f = f
+}
+
+// retry runs op, retrying transient errors until the per-file cap is reached
+func (rf *retryFile) retry(op func() error) error {
+	return retryTransient(rf.path, rf.policy, &rf.attempts, op)
+}
+
+// RetryReaderAt wraps an io.ReaderAt over a file, retrying transient errors.
+// Like retryFile it reopens the file before retrying, since stale NFS handles
+// cannot recover otherwise; Close closes the handles it opened, if any. Reads
+// run concurrently; only swapping the handle is serialized.
+type RetryReaderAt struct {
+	path     string
+	policy   RetryPolicy
+	mu       sync.Mutex  // Guards the fields below, never held during a read
+	r        io.ReaderAt // Reader in use, replaced by reopen
+	gen      int         // Bumped each time reopen replaces r
+	reopened []*os.File  // Handles opened by reopen, closed by Close
+	attempts int
+}
+
+// NewRetryReaderAt returns a reader over r, an open handle on the file at
+// path, that retries transient errors under policy
+func NewRetryReaderAt(path string, r io.ReaderAt, policy RetryPolicy) *RetryReaderAt {
+	return &RetryReaderAt{path: path, policy: policy, r: r}
+}
+
+// ReadAt implements io.ReaderAt
+func (ra *RetryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	for {
+		ra.mu.Lock()
+		r, gen := ra.r, ra.gen
+		ra.mu.Unlock()
+
+		n, err := r.ReadAt(p, off)
+		if err == nil || err == io.EOF || !IsTransientError(err) {
+			return n, err
+		}
+		ra.mu.Lock()
+		attempt, ok := nextRetry(ra.path, ra.policy, &ra.attempts)
+		if ok {
+			ra.reopen(gen)
+		}
+		ra.mu.Unlock()
+		if !ok {
+			return n, err
+		}
+		time.Sleep(ra.policy.Backoff(attempt))
+	}
+}
+
+// reopen replaces the reader with a new handle on the file, unless another
+// read already replaced the one of generation gen, keeping the old one if the
+// file cannot be opened. Old handles stay open, as reads may still use them.
+// The caller holds ra.mu.
+func (ra *RetryReaderAt) reopen(gen int) {
+	if ra.gen != gen {
+		return
+	}
+	f, err := os.Open(ra.path)
+	if err != nil {
+		return
+	}
+	ra.r = f
+	ra.gen++
+	ra.reopened = append(ra.reopened, f)
+}
+
+// Close closes the handles opened by retries; the wrapped reader is left open
+func (ra *RetryReaderAt) Close() error {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	var firstErr error
+	for _, f := range ra.reopened {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	ra.reopened = nil
+	return firstErr
+}
+
+// retryTransient runs op, sleeping with exponential backoff and retrying while it
+// fails with a transient error and the shared attempt counter is under the cap
+func retryTransient(path string, policy RetryPolicy, attempts *int, op func() error) error {
+	for {
+		err := op()
+		if err == nil || err == io.EOF || !IsTransientError(err) {
+			return err
+		}
+		attempt, ok := nextRetry(path, policy, attempts)
+		if !ok {
+			return err
+		}
+		time.Sleep(policy.Backoff(attempt))
+	}
+}
+
+// nextRetry counts another retry of a read from path against the attempt
+// counter and returns its 1-based number, or records that path gave up and
+// returns false once the counter reaches the cap
+func nextRetry(path string, policy RetryPolicy, attempts *int) (int, bool) {
+	if *attempts >= policy.MaxRetries {
+		if policy.MaxRetries > 0 {
+			policy.Log.recordFailure(path)
+		}
+		return 0, false
+	}
+	*attempts++
+	policy.Log.record(path)
+	return *attempts, true
+}
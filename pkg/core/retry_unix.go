@@ -0,0 +1,22 @@
+//go:build !windows
+
+package core
+
+import (
+	"errors"
+	"syscall"
+)
+
+// IsTransientError reports whether err is likely a temporary network
+// filesystem failure, which a RetryPolicy retries
+func IsTransientError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EIO, syscall.ESTALE, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		return true
+	}
+	return false
+}
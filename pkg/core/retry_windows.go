@@ -0,0 +1,29 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes reported by SMB shares on transient network failures
+const (
+	errorUnexpNetErr    syscall.Errno = 59  // ERROR_UNEXP_NET_ERR
+	errorNetnameDeleted syscall.Errno = 64  // ERROR_NETNAME_DELETED
+	errorSemTimeout     syscall.Errno = 121 // ERROR_SEM_TIMEOUT
+)
+
+// IsTransientError reports whether err is likely a temporary network
+// filesystem failure, which a RetryPolicy retries
+func IsTransientError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case errorUnexpNetErr, errorNetnameDeleted, errorSemTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ScanFunc inspects the content of an entry while it is extracted, such as a
+// virus scanner fed from the decompressed stream. relPath is the entry's path
+// within the archive (the file name for a single-file archive) and r yields its
+// content, concurrently with the extraction; content the function does not
+// read is discarded. A non-nil error rejects the entry: it is not extracted,
+// and a WarnScanRejected warning is reported instead.
+type ScanFunc func(relPath string, r io.Reader) error
+
+// scanRejection is returned by an extraction whose content the ScanFunc rejected
+type scanRejection struct {
+	err error
+}
+
+// Error implements error
+func (e *scanRejection) Error() string {
+	return fmt.Sprintf("rejected by scan: %v", e.err)
+}
+
+// Unwrap returns the scan's error
+func (e *scanRejection) Unwrap() error {
+	return e.err
+}
+
+// scanEmpty runs scan over an entry with no content
+func scanEmpty(scan ScanFunc, relPath string) error {
+	if err := scan(relPath, bytes.NewReader(nil)); err != nil {
+		return &scanRejection{err: err}
+	}
+	return nil
+}
+
+// scanTee feeds the data written to it to a ScanFunc running alongside the
+// extraction. The extracted data goes to a temporary file next to its
+// destination, which is only renamed into place once the scan has accepted it,
+// so rejected content never appears under its own name.
+type scanTee struct {
+	pw     *io.PipeWriter
+	result chan error
+}
+
+// newScanTee starts scan on the data that will be written to the tee
+func newScanTee(scan ScanFunc, relPath string) *scanTee {
+	pr, pw := io.Pipe()
+	t := &scanTee{pw: pw, result: make(chan error, 1)}
+	go func() {
+		err := scan(relPath, pr)
+		io.Copy(io.Discard, pr) // Let the extraction finish whatever the scan left unread
+		t.result <- err
+	}()
+	return t
+}
+
+// Write implements io.Writer
+func (t *scanTee) Write(p []byte) (int, error) {
+	return t.pw.Write(p)
+}
+
+// wait ends the scan's input and returns its verdict. err, if set, is the
+// extraction's own error, which the scan sees as a read error.
+func (t *scanTee) wait(err error) error {
+	if err != nil {
+		t.pw.CloseWithError(err)
+		<-t.result
+		return err
+	}
+	t.pw.Close()
+	if err := <-t.result; err != nil {
+		return &scanRejection{err: err}
+	}
+	return nil
+}
+
+// scanTempFile creates the hidden temporary file a scanned entry is extracted
+// to, with the permissions os.Create would give the file itself
+func scanTempFile(destPath string) (*os.File, error) {
+	dir, base := filepath.Split(destPath)
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, fmt.Sprintf(".%s.%d.scan", base, i))
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Schedule makes a compression or extraction run on a fixed schedule, for
+// reproducing hangs and corruption that depend on how entries are spread over
+// workers. Entries run one at a time, in entry table order when compressing
+// and in archive order when extracting, each on a worker picked by a random
+// generator seeded with Seed; an LZ4 entry's blocks are decoded on its worker
+// alone. Two runs with the same input, Seed and Workers give the same schedule.
+type Schedule struct {
+	Seed int64
+
+	// Log, if set, receives the schedule: a header naming the operation, seed
+	// and worker count, then a line as each entry starts and ends. It holds no
+	// times, so the logs of two runs can be compared line by line.
+	Log io.Writer
+}
+
+// scheduler runs the steps of an operation one at a time on the workers its
+// schedule picks
+type scheduler struct {
+	op      string
+	rng     *rand.Rand
+	workers []chan func()
+	log     io.Writer
+	step    int
+	wg      sync.WaitGroup
+}
+
+// newScheduler starts workers goroutines running op on opts.Schedule, or
+// returns nil if there is no schedule
+func newScheduler(opts Options, op string, workers int) *scheduler {
+	if opts.Schedule == nil {
+		return nil
+	}
+	s := &scheduler{
+		op:      op,
+		rng:     rand.New(rand.NewSource(opts.Schedule.Seed)),
+		workers: make([]chan func(), max(workers, 1)),
+		log:     opts.Schedule.Log,
+	}
+	for w := range s.workers {
+		s.workers[w] = make(chan func())
+		s.wg.Add(1)
+		go func(steps chan func()) {
+			defer s.wg.Done()
+			for step := range steps {
+				step()
+			}
+		}(s.workers[w])
+	}
+	s.logf("%s: seed %d, %d workers", op, opts.Schedule.Seed, len(s.workers))
+	return s
+}
+
+// run runs fn for entry i, named name, on the next worker of the schedule and
+// waits for it to return
+func (s *scheduler) run(i int, name string, fn func() error) error {
+	s.step++
+	w := s.rng.Intn(len(s.workers))
+	s.logf("step %d: worker %d %s entry %d %s", s.step, w, s.op, i, name)
+	done := make(chan error)
+	s.workers[w] <- func() { done <- fn() }
+	err := <-done
+	if err != nil {
+		s.logf("step %d: failed: %v", s.step, err)
+	} else {
+		s.logf("step %d: done", s.step)
+	}
+	return err
+}
+
+// forEachByOffset is forEachByOffset on the schedule: it runs fn for every
+// task in the order of their data in the archive and returns the first error
+func (s *scheduler) forEachByOffset(offsets []int64, names []string, fn func(i int) error) error {
+	order := make([]int, len(offsets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+	var first error
+	for _, i := range order {
+		if err := s.run(i, names[i], func() error { return fn(i) }); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// stop waits for the workers to exit
+func (s *scheduler) stop() {
+	for _, steps := range s.workers {
+		close(steps)
+	}
+	s.wg.Wait()
+}
+
+// logf writes a line to the schedule's log, if any
+func (s *scheduler) logf(format string, args ...any) {
+	if s.log != nil {
+		fmt.Fprintf(s.log, format+"\n", args...)
+	}
+}
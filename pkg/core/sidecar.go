@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSidecarMismatch is returned when an archive does not match the checksum
+// in its sidecar: it was damaged or truncated since the sidecar was written
+var ErrSidecarMismatch = errors.New("archive does not match its checksum sidecar")
+
+// SidecarPath returns the path of the checksum sidecar of an archive. The
+// sidecar is in the format of sha256sum, so `sha256sum -c` checks it too.
+func SidecarPath(archivePath string) string {
+	return archivePath + ".sha256"
+}
+
+// writeSidecar hashes the finished archive and writes its sidecar, replacing
+// any previous one atomically
+func writeSidecar(archivePath string, fsync FsyncPolicy) error {
+	sum, err := hashArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	sidecar := SidecarPath(archivePath)
+	tmp := sidecar + ".tmp"
+	defer trackFile(tmp, cleanupTemp)()
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(archivePath))
+	if err := os.WriteFile(tmp, []byte(line), 0644); err != nil {
+		return fmt.Errorf("write checksum sidecar: %w", err)
+	}
+	if err := os.Rename(tmp, sidecar); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename checksum sidecar: %w", err)
+	}
+	return syncFiles([]string{sidecar}, fsync)
+}
+
+// updateSidecar writes the sidecar of a newly written archive if opts.Sidecar
+// asks for one, or if the archive it replaced had one, which would otherwise
+// no longer match
+func updateSidecar(archivePath string, opts Options) error {
+	if !opts.Sidecar {
+		if _, err := os.Stat(SidecarPath(archivePath)); err != nil {
+			return nil
+		}
+	}
+	return writeSidecar(archivePath, opts.Fsync)
+}
+
+// CheckSidecar checks an archive against its checksum sidecar, if it has one,
+// reporting whether it found one. A mismatch gives an error wrapping
+// ErrSidecarMismatch.
+func CheckSidecar(archivePath string) (bool, error) {
+	data, err := os.ReadFile(SidecarPath(archivePath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read checksum sidecar: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return true, fmt.Errorf("checksum sidecar %s is empty", SidecarPath(archivePath))
+	}
+	want, err := hex.DecodeString(fields[0])
+	if err != nil || len(want) != sha256.Size {
+		return true, fmt.Errorf("checksum sidecar %s does not hold a SHA-256 checksum", SidecarPath(archivePath))
+	}
+	got, err := hashArchive(archivePath)
+	if err != nil {
+		return true, err
+	}
+	if !bytes.Equal(got, want) {
+		return true, fmt.Errorf("%w: %s has SHA-256 %x, but %s records %x",
+			ErrSidecarMismatch, archivePath, got, SidecarPath(archivePath), want)
+	}
+	return true, nil
+}
+
+// hashArchive returns the SHA-256 of a whole archive file
+func hashArchive(archivePath string) ([]byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("open archive to checksum: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("checksum archive: %w", err)
+	}
+	return h.Sum(nil), nil
+}
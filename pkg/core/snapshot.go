@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotMode selects whether and how the input is snapshotted before it is
+// compressed, so files being written to during a long compression are still
+// captured as they were at one instant
+type SnapshotMode string
+
+const (
+	SnapshotNone  SnapshotMode = ""      // Read the live input
+	SnapshotAuto  SnapshotMode = "auto"  // Use whichever snapshot the input's file system supports, or read it live with a warning
+	SnapshotBtrfs SnapshotMode = "btrfs" // Read-only snapshot of the Btrfs subvolume holding the input
+	SnapshotZFS   SnapshotMode = "zfs"   // Snapshot of the ZFS dataset holding the input
+	SnapshotLVM   SnapshotMode = "lvm"   // LVM snapshot volume of the logical volume holding the input, mounted read-only
+	SnapshotVSS   SnapshotMode = "vss"   // Windows Volume Shadow Copy of the input's volume
+)
+
+// ErrSnapshotUnavailable is returned when the requested snapshot cannot be
+// taken of the input's file system
+var ErrSnapshotUnavailable = errors.New("snapshot not available")
+
+// ParseSnapshotMode parses a snapshot mode: none, auto, btrfs, zfs, lvm or vss
+func ParseSnapshotMode(s string) (SnapshotMode, error) {
+	switch mode := SnapshotMode(strings.ToLower(s)); mode {
+	case "none", SnapshotNone:
+		return SnapshotNone, nil
+	case SnapshotAuto, SnapshotBtrfs, SnapshotZFS, SnapshotLVM, SnapshotVSS:
+		return mode, nil
+	}
+	return SnapshotNone, fmt.Errorf("invalid snapshot mode %q: want none, auto, btrfs, zfs, lvm or vss", s)
+}
+
+// snapshot is a snapshot taken of a live path, removed once compression is done
+type snapshot struct {
+	mode   SnapshotMode
+	live   string // Absolute path of the snapshotted input
+	frozen string // The same path inside the snapshot
+	remove func() error
+}
+
+// takeSnapshot snapshots the file system holding input as opts.Snapshot asks.
+// It returns nil, reading the live input, for SnapshotNone and, with a
+// warning, for SnapshotAuto when no snapshot is available. The snapshot is
+// registered for Cleanup until it is released.
+func takeSnapshot(input string, opts Options) (*snapshot, error) {
+	if opts.Snapshot == SnapshotNone {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return nil, fmt.Errorf("resolve input: %w", err)
+	}
+	live := abs
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		live = resolved
+	}
+
+	s, err := platformSnapshot(live, opts.Snapshot)
+	if errors.Is(err, ErrSnapshotUnavailable) && opts.Snapshot == SnapshotAuto {
+		opts.warn(WarnSnapshotUnavailable, input, fmt.Sprintf("%v; reading the live input", err))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.live = abs // Map paths as the caller spells them
+	release := trackRemoval(s.frozen, s.remove)
+	remove := s.remove
+	s.remove = func() error {
+		defer release()
+		return remove()
+	}
+	return s, nil
+}
+
+// path returns where path, the snapshotted input or a path below it, is found
+// in the snapshot. Other paths, and all paths of a nil snapshot, are returned
+// unchanged.
+func (s *snapshot) path(path string) string {
+	if s == nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(s.live, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(s.frozen, rel)
+}
+
+// release removes the snapshot
+func (s *snapshot) release() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.remove(); err != nil {
+		return fmt.Errorf("remove %s snapshot of %s: %w", s.mode, s.live, err)
+	}
+	return nil
+}
+
+// runSnapshotCommand runs a snapshot tool and returns its output, including
+// its error output in any error
+func runSnapshotCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, msg)
+		}
+		return "", fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,289 @@
+//go:build linux
+
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// File system magic numbers reported by statfs(2)
+const (
+	btrfsSuperMagic = 0x9123683e
+	zfsSuperMagic   = 0x2fc12fc1
+)
+
+// btrfsSubvolumeIno is the inode number of the root directory of every Btrfs subvolume
+const btrfsSubvolumeIno = 256
+
+// platformSnapshot snapshots the file system holding live, an absolute path
+// with symlinks resolved, with Btrfs, ZFS or LVM
+func platformSnapshot(live string, mode SnapshotMode) (*snapshot, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(live, &st); err != nil {
+		return nil, fmt.Errorf("stat file system of %s: %w", live, err)
+	}
+	fsType := uint32(st.Type)
+
+	if mode == SnapshotAuto {
+		switch {
+		case fsType == btrfsSuperMagic:
+			mode = SnapshotBtrfs
+		case fsType == zfsSuperMagic:
+			mode = SnapshotZFS
+		default:
+			mode = SnapshotLVM
+		}
+	}
+	switch mode {
+	case SnapshotBtrfs:
+		if fsType != btrfsSuperMagic {
+			return nil, fmt.Errorf("%w: %s is not on Btrfs", ErrSnapshotUnavailable, live)
+		}
+		return btrfsSnapshot(live)
+	case SnapshotZFS:
+		if fsType != zfsSuperMagic {
+			return nil, fmt.Errorf("%w: %s is not on ZFS", ErrSnapshotUnavailable, live)
+		}
+		return zfsSnapshot(live)
+	case SnapshotLVM:
+		return lvmSnapshot(live)
+	}
+	return nil, fmt.Errorf("%w: %s snapshots are not supported on Linux", ErrSnapshotUnavailable, mode)
+}
+
+// requireTool checks that a snapshot tool is on the PATH
+func requireTool(name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotUnavailable, err)
+	}
+	return nil
+}
+
+// btrfsSnapshot takes a read-only snapshot of the subvolume holding live,
+// next to the subvolume's root so it is on the same file system
+func btrfsSnapshot(live string) (*snapshot, error) {
+	if err := requireTool("btrfs"); err != nil {
+		return nil, err
+	}
+	root, err := btrfsSubvolumeRoot(live)
+	if err != nil {
+		return nil, err
+	}
+	dest := filepath.Join(root, fmt.Sprintf(".agcp-snapshot-%d", os.Getpid()))
+	if _, err := runSnapshotCommand("btrfs", "subvolume", "snapshot", "-r", root, dest); err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, live)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{
+		mode:   SnapshotBtrfs,
+		frozen: filepath.Join(dest, rel),
+		remove: func() error {
+			_, err := runSnapshotCommand("btrfs", "subvolume", "delete", dest)
+			return err
+		},
+	}, nil
+}
+
+// btrfsSubvolumeRoot returns the root of the Btrfs subvolume holding path: the
+// nearest directory at or above it with the subvolume root inode number
+func btrfsSubvolumeRoot(path string) (string, error) {
+	dir := path
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	for {
+		var st syscall.Stat_t
+		if err := syscall.Stat(dir, &st); err != nil {
+			return "", fmt.Errorf("stat %s: %w", dir, err)
+		}
+		if st.Ino == btrfsSubvolumeIno {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%w: no Btrfs subvolume holds %s", ErrSnapshotUnavailable, path)
+		}
+		dir = parent
+	}
+}
+
+// zfsSnapshot snapshots the ZFS dataset holding live, reading it through the
+// dataset's .zfs/snapshot directory
+func zfsSnapshot(live string) (*snapshot, error) {
+	if err := requireTool("zfs"); err != nil {
+		return nil, err
+	}
+	out, err := runSnapshotCommand("zfs", "list", "-H", "-o", "name,mountpoint", "-t", "filesystem")
+	if err != nil {
+		return nil, err
+	}
+	var dataset, mountpoint string
+	for _, line := range strings.Split(out, "\n") {
+		name, point, ok := strings.Cut(line, "\t")
+		if ok && filepath.IsAbs(point) && pathWithin(live, point) && len(point) > len(mountpoint) {
+			dataset, mountpoint = name, point
+		}
+	}
+	if dataset == "" {
+		return nil, fmt.Errorf("%w: no mounted ZFS dataset holds %s", ErrSnapshotUnavailable, live)
+	}
+
+	name := fmt.Sprintf("agcp-%d-%d", os.Getpid(), time.Now().Unix())
+	if _, err := runSnapshotCommand("zfs", "snapshot", dataset+"@"+name); err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(mountpoint, live)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{
+		mode:   SnapshotZFS,
+		frozen: filepath.Join(mountpoint, ".zfs", "snapshot", name, rel),
+		remove: func() error {
+			_, err := runSnapshotCommand("zfs", "destroy", dataset+"@"+name)
+			return err
+		},
+	}, nil
+}
+
+// lvmSnapshot creates a snapshot volume of the logical volume holding live
+// and mounts it read-only in a temporary directory. The snapshot is given 10%
+// of the origin's size for changes made while it exists.
+func lvmSnapshot(live string) (*snapshot, error) {
+	mount, err := findMount(live)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireTool("lvs"); err != nil {
+		return nil, err
+	}
+	out, err := runSnapshotCommand("lvs", "--noheadings", "-o", "vg_name,lv_name", mount.source)
+	fields := strings.Fields(out)
+	if err != nil || len(fields) != 2 {
+		return nil, fmt.Errorf("%w: %s is not on an LVM logical volume", ErrSnapshotUnavailable, live)
+	}
+	vg, lv := fields[0], fields[1]
+
+	name := fmt.Sprintf("agcp-snapshot-%d", os.Getpid())
+	if _, err := runSnapshotCommand("lvcreate", "--snapshot", "--extents", "10%ORIGIN", "--name", name, vg+"/"+lv); err != nil {
+		return nil, err
+	}
+	removeVolume := func() error {
+		_, err := runSnapshotCommand("lvremove", "--force", vg+"/"+name)
+		return err
+	}
+	dir, err := os.MkdirTemp("", "agcp-snapshot-")
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("create mount point: %w", err), removeVolume())
+	}
+	options := "ro"
+	if mount.fsType == "xfs" {
+		options += ",nouuid" // The snapshot has the same UUID as the mounted origin
+	}
+	if _, err := runSnapshotCommand("mount", "-o", options, "/dev/"+vg+"/"+name, dir); err != nil {
+		return nil, errors.Join(err, removeVolume(), os.Remove(dir))
+	}
+
+	rel, err := filepath.Rel(mount.point, live)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{
+		mode:   SnapshotLVM,
+		frozen: filepath.Join(dir, mount.root, rel),
+		remove: func() error {
+			if _, err := runSnapshotCommand("umount", dir); err != nil {
+				return err
+			}
+			return errors.Join(removeVolume(), os.Remove(dir))
+		},
+	}, nil
+}
+
+// mountInfo is one line of /proc/self/mountinfo
+type mountInfo struct {
+	root   string // Directory of the file system mounted at point
+	point  string
+	fsType string
+	source string
+}
+
+// findMount returns the mount holding path, the one with the longest mount
+// point containing it
+func findMount(path string) (mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return mountInfo{}, fmt.Errorf("%w: %v", ErrSnapshotUnavailable, err)
+	}
+	defer f.Close()
+
+	var best mountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root point options [optional...] - type source superoptions
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+3 {
+			continue
+		}
+		m := mountInfo{
+			root:   unescapeMountField(fields[3]),
+			point:  unescapeMountField(fields[4]),
+			fsType: fields[sep+1],
+			source: unescapeMountField(fields[sep+2]),
+		}
+		if pathWithin(path, m.point) && len(m.point) >= len(best.point) {
+			best = m
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mountInfo{}, fmt.Errorf("read mounts: %w", err)
+	}
+	if best.point == "" {
+		return mountInfo{}, fmt.Errorf("%w: no mount holds %s", ErrSnapshotUnavailable, path)
+	}
+	return best, nil
+}
+
+// unescapeMountField decodes the octal escapes (\040 for a space) of a mountinfo field
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// pathWithin reports whether path is dir or below it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package core
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// platformSnapshot reports that no snapshots are supported on this platform
+func platformSnapshot(live string, mode SnapshotMode) (*snapshot, error) {
+	return nil, fmt.Errorf("%w: snapshots are not supported on %s", ErrSnapshotUnavailable, runtime.GOOS)
+}
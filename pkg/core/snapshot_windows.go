@@ -0,0 +1,50 @@
+//go:build windows
+
+package core
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// platformSnapshot snapshots the volume holding live, an absolute path with
+// symlinks resolved, with the Volume Shadow Copy Service. Creating shadow
+// copies needs an elevated process.
+func platformSnapshot(live string, mode SnapshotMode) (*snapshot, error) {
+	if mode != SnapshotAuto && mode != SnapshotVSS {
+		return nil, fmt.Errorf("%w: %s snapshots are not supported on Windows", ErrSnapshotUnavailable, mode)
+	}
+	volume := filepath.VolumeName(live)
+	if len(volume) != 2 || volume[1] != ':' {
+		return nil, fmt.Errorf("%w: %s is not on a lettered volume", ErrSnapshotUnavailable, live)
+	}
+	if _, err := exec.LookPath("powershell"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotUnavailable, err)
+	}
+
+	script := fmt.Sprintf(`$r = (Get-WmiObject -List Win32_ShadowCopy).Create('%s\', 'ClientAccessible'); `+
+		`if ($r.ReturnValue -ne 0) { Write-Error "Win32_ShadowCopy.Create returned $($r.ReturnValue)"; exit 1 }; `+
+		`$s = Get-WmiObject Win32_ShadowCopy -Filter "ID='$($r.ShadowID)'"; `+
+		`Write-Output $s.ID; Write-Output $s.DeviceObject`, volume)
+	out, err := runSnapshotCommand("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotUnavailable, err)
+	}
+	lines := strings.Fields(out)
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("create shadow copy of %s: unexpected output %q", volume, out)
+	}
+	id, device := lines[0], lines[1]
+
+	return &snapshot{
+		mode:   SnapshotVSS,
+		frozen: device + live[len(volume):],
+		remove: func() error {
+			_, err := runSnapshotCommand("powershell", "-NoProfile", "-NonInteractive", "-Command",
+				fmt.Sprintf(`Get-WmiObject Win32_ShadowCopy -Filter "ID='%s'" | ForEach-Object { $_.Delete() }`, id))
+			return err
+		},
+	}, nil
+}
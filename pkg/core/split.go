@@ -0,0 +1,345 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"agcp/pkg/progress"
+)
+
+// splitJob describes one archive produced by CompressSplit, CompressBySize or
+// CompressEach
+type splitJob struct {
+	output      string
+	archiveType ArchiveType
+	rootName    string
+	entries     []Entry
+	level       int // Compression level overriding Options.Level, if non-zero
+}
+
+// CompressSplit compresses every immediate subdirectory of input into its own
+// archive. The pattern must contain a single %s which is replaced by the
+// subdirectory name; it is not a format string, so other % signs are kept. Regular files at the top level are collected into one
+// additional archive named after the input directory itself.
+func CompressSplit(input, pattern string, opts Options) error {
+	if opts.Snapshot != SnapshotNone {
+		return fmt.Errorf("snapshots are not supported when splitting by top-level directory")
+	}
+	if strings.Count(pattern, "%s") != 1 {
+		return fmt.Errorf("split pattern %q must contain exactly one %%s", pattern)
+	}
+
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("stat input: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("split input %s is not a directory", input)
+	}
+
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	jobs, err := planSplitJobs(input, pattern, opts)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("nothing to compress in %s", input)
+	}
+
+	return runSplitJobs(jobs, opts, tracker)
+}
+
+// runSplitJobs writes the archives of a split in parallel with a shared tracker
+func runSplitJobs(jobs []splitJob, opts Options, tracker *progress.Tracker) error {
+	var allEntries []Entry
+	for _, job := range jobs {
+		allEntries = append(allEntries, job.entries...)
+	}
+	tracker.SetTotals(calculateTotalSize(allEntries), uint64(len(allEntries)))
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+
+	// Use a semaphore to limit concurrent archive writers
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(jobs))
+
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job splitJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			jobOpts := opts
+			if job.level != 0 {
+				jobOpts.Level = job.level
+			}
+			if err := compressFiles(job.entries, job.output, job.archiveType, job.rootName, jobOpts, tracker); err != nil {
+				errCh <- fmt.Errorf("split archive %s: %w", job.output, err)
+			}
+		}(job)
+	}
+	wg.Wait()
+	close(errCh)
+
+	// Return first error if any
+	if len(errCh) > 0 {
+		return <-errCh
+	}
+	return nil
+}
+
+// CompressEach compresses each input, a file or directory, into its own
+// archive named after it with the .agcp extension, next to it. The archives are
+// written concurrently with one progress tracker, sharing a single budget of
+// opts.Workers entries compressed at a time (one per CPU when zero).
+func CompressEach(inputs []string, opts Options) error {
+	if opts.Snapshot != SnapshotNone {
+		return fmt.Errorf("snapshots are not supported when compressing each input")
+	}
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	var jobs []splitJob
+	seen := make(map[string]string)
+	for _, input := range inputs {
+		input = filepath.Clean(input)
+		output := input + ".agcp"
+		if other, ok := seen[output]; ok {
+			return fmt.Errorf("inputs %s and %s would both be written to %s", other, input, output)
+		}
+		seen[output] = input
+
+		info, err := os.Stat(input)
+		if err != nil {
+			return fmt.Errorf("stat input: %w", err)
+		}
+		job := splitJob{output: output, rootName: rootNameOf(input)}
+		if info.IsDir() {
+			job.archiveType = ArchiveDir
+			if job.entries, err = collectDirEntries(input, opts); err != nil {
+				return fmt.Errorf("collect entries: %w", err)
+			}
+		} else {
+			job.archiveType = ArchiveFile
+			job.entries = []Entry{newEntry("", input, info)}
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("nothing to compress")
+	}
+
+	// Keep every archive being written out of every job
+	for _, job := range jobs {
+		output := job.output
+		var excluded bool
+		for i := range jobs {
+			var found bool
+			jobs[i].entries, found = excludeOutput(jobs[i].entries, output)
+			excluded = excluded || found
+			jobs[i].entries, _ = excludeOutput(jobs[i].entries, PartialPath(output))
+			jobs[i].entries, _ = excludeOutput(jobs[i].entries, LockPath(output))
+		}
+		if excluded {
+			opts.warn(WarnOutputInInput, output, "output is inside an input directory; excluding it from the archives")
+		}
+	}
+
+	opts.Workers = compressWorkers(opts)
+	opts.workerSlots = make(chan struct{}, opts.Workers)
+	return runSplitJobs(jobs, opts, tracker)
+}
+
+// CompressBySize splits the files under input by size: files smaller than
+// threshold go into smallOutput at level 9, and the rest into largeOutput at
+// opts.Level for speed. smallOutput is not a solid archive: like every
+// archive, it compresses each entry on its own, so small files gain only from
+// the higher level. Both archives are rooted at input, so extracting both into
+// the same place restores the whole tree. Both are always written, even if
+// empty.
+func CompressBySize(input string, threshold int64, smallOutput, largeOutput string, opts Options) (err error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("stat input: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("split input %s is not a directory", input)
+	}
+	if isSamePath(smallOutput, largeOutput) {
+		return fmt.Errorf("small and large outputs must differ")
+	}
+	snap, err := takeSnapshot(input, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := snap.release(); err == nil {
+			err = releaseErr
+		}
+	}()
+
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	entries, err := collectDirEntries(snap.path(input), opts)
+	if err != nil {
+		return fmt.Errorf("collect entries: %w", err)
+	}
+	for _, output := range []string{smallOutput, largeOutput} {
+		var excluded bool
+		entries, excluded = excludeOutput(entries, snap.path(output))
+		if excluded {
+			opts.warn(WarnOutputInInput, output, "output is inside the input directory; excluding it from the archives")
+		}
+		entries, _ = excludeOutput(entries, snap.path(PartialPath(output)))
+		entries, _ = excludeOutput(entries, snap.path(LockPath(output)))
+	}
+
+	var small, large []Entry
+	for _, entry := range entries {
+		if entry.Size < threshold {
+			small = append(small, entry)
+		} else {
+			large = append(large, entry)
+		}
+	}
+
+	rootName := rootNameOf(input)
+	jobs := []splitJob{
+		{output: smallOutput, archiveType: ArchiveDir, rootName: rootName, entries: small, level: 9},
+		{output: largeOutput, archiveType: ArchiveDir, rootName: rootName, entries: large},
+	}
+	return runSplitJobs(jobs, opts, tracker)
+}
+
+// splitOutput returns the archive name pattern gives for name: the pattern
+// with its %s replaced. Unlike fmt.Sprintf it leaves any other % alone.
+func splitOutput(pattern, name string) string {
+	return strings.Replace(pattern, "%s", name, 1)
+}
+
+// planSplitJobs builds one job per top-level subdirectory plus one for loose top-level files
+func planSplitJobs(input, pattern string, opts Options) ([]splitJob, error) {
+	dirEntries, err := os.ReadDir(input)
+	if err != nil {
+		return nil, fmt.Errorf("read directory %s: %w", input, err)
+	}
+
+	exclude, err := compileExclude(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []splitJob
+	var looseFiles []Entry
+	var hidden hiddenCount
+	seen := make(map[string]bool)
+	for _, de := range dirEntries {
+		if exclude.match(de.Name()) {
+			continue
+		}
+		path := filepath.Join(input, de.Name())
+		info, err := de.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", path, err)
+		}
+		if isHidden(info) {
+			switch {
+			case opts.SkipHidden && de.IsDir():
+				hidden.dirs++
+				continue
+			case opts.SkipHidden:
+				hidden.files++
+				continue
+			case !de.IsDir():
+				hidden.files++
+			}
+		}
+		if !de.IsDir() {
+			if kind := specialFileKind(info.Mode()); kind != "" {
+				opts.warn(WarnSkippedSpecialFile, path, "skipping "+kind)
+				continue
+			}
+			looseFiles = append(looseFiles, newEntry(de.Name(), path, info))
+			continue
+		}
+
+		var dirHidden hiddenCount
+		entries, err := walkDirEntries(path, opts, &dirHidden)
+		if err != nil {
+			return nil, fmt.Errorf("collect entries: %w", err)
+		}
+		output := splitOutput(pattern, de.Name())
+		seen[output] = true
+		jobs = append(jobs, splitJob{
+			output:      output,
+			archiveType: ArchiveDir,
+			rootName:    de.Name(),
+			entries:     entries,
+		})
+
+		// Everything in a hidden top-level directory is hidden
+		if isHidden(info) {
+			dirHidden.files = len(entries)
+		}
+		hidden.files += dirHidden.files
+		hidden.dirs += dirHidden.dirs
+	}
+	hidden.report(input, opts)
+
+	// Keep every archive being written out of every job, wherever the pattern points
+	outputs := make([]string, 0, len(jobs)+1)
+	for _, job := range jobs {
+		outputs = append(outputs, job.output)
+	}
+	if len(looseFiles) > 0 {
+		outputs = append(outputs, splitOutput(pattern, rootNameOf(input)))
+	}
+	for _, output := range outputs {
+		var excluded bool
+		for i := range jobs {
+			var found bool
+			jobs[i].entries, found = excludeOutput(jobs[i].entries, output)
+			excluded = excluded || found
+		}
+		var found bool
+		looseFiles, found = excludeOutput(looseFiles, output)
+		if excluded || found {
+			opts.warn(WarnOutputInInput, output, "output is inside the input directory; excluding it from the archives")
+		}
+
+		// A partial archive left by an interrupted run is never input either,
+		// nor is a lock file
+		for i := range jobs {
+			jobs[i].entries, _ = excludeOutput(jobs[i].entries, PartialPath(output))
+			jobs[i].entries, _ = excludeOutput(jobs[i].entries, LockPath(output))
+		}
+		looseFiles, _ = excludeOutput(looseFiles, PartialPath(output))
+		looseFiles, _ = excludeOutput(looseFiles, LockPath(output))
+	}
+
+	if len(looseFiles) > 0 {
+		rootName := rootNameOf(input)
+		output := splitOutput(pattern, rootName)
+		if seen[output] {
+			return nil, fmt.Errorf("split output %s for top-level files collides with a subdirectory archive", output)
+		}
+		jobs = append(jobs, splitJob{
+			output:      output,
+			archiveType: ArchiveDir,
+			rootName:    rootName,
+			entries:     looseFiles,
+		})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// CompressToWriter compresses a file or directory into an archive written to
+// w front to back, without seeking, so it can go down a pipe or a network
+// connection. The header and entry table come first and record every entry's
+// sizes and hash, so all of the compressed data is held (in memory, then in
+// Options.TempDir) until the last entry is done; nothing reaches w before
+// then. Archives written to a stream cannot be encrypted, resumed or given a
+// sidecar.
+func CompressToWriter(input string, w io.Writer, opts Options) (err error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("stat input: %w", err)
+	}
+	if isStream(info) && opts.Snapshot != SnapshotNone {
+		return fmt.Errorf("cannot snapshot %s: it is a pipe", input)
+	}
+	snap, err := takeSnapshot(input, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := snap.release(); err == nil {
+			err = releaseErr
+		}
+	}()
+
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetPhase(progress.PhaseScanning)
+
+	archiveType, rootName, entries, err := collectInput(input, "", info, snap, opts)
+	if err != nil {
+		return err
+	}
+	tracker.SetTotals(calculateTotalSize(entries), uint64(len(entries)))
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+
+	return writeStream(w, entries, archiveType, rootName, opts, tracker)
+}
+
+// CompressReader compresses the content read from r, such as standard input,
+// into a single-file archive written to w as CompressToWriter does. name is
+// the name the content is extracted under.
+func CompressReader(r io.Reader, name string, w io.Writer, opts Options) error {
+	tracker := opts.newTracker(0)
+	defer tracker.Stop()
+	entries := []Entry{newReaderEntry(r)}
+	tracker.SetTotals(calculateTotalSize(entries), 1)
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+
+	return writeStream(w, entries, ArchiveFile, name, opts, tracker)
+}
+
+// writeStream compresses entries into an archive written sequentially to w.
+// Each entry's data is appended, aligned, to one spill standing for the data
+// area, whose offset in the archive is known once the entries are prepared.
+func writeStream(w io.Writer, entries []Entry, archiveType ArchiveType, rootName string, opts Options, tracker *progress.Tracker) error {
+	if len(opts.Recipients) > 0 {
+		return fmt.Errorf("an archive written to a stream cannot be encrypted; pipe it through age or gpg instead")
+	}
+	if opts.Sidecar {
+		return fmt.Errorf("an archive written to a stream has no path to write a sidecar next to")
+	}
+	guard := newRatioGuard(opts)
+	if err := prepareEntries(entries, rootName, opts, guard); err != nil {
+		return err
+	}
+	prov := provenance(opts)
+	_, headerLen := entryTableLayout(rootName, entries, prov)
+
+	data := &spill{dir: opts.TempDir}
+	defer data.release()
+	sizes := make([][2]uint64, len(entries))
+	add := func(i int, s *spill) error {
+		if s.err != nil {
+			return &EntryError{Path: entries[i].name(rootName), Op: "compress", Err: s.err}
+		}
+		end := headerLen + int64(data.comp)
+		if pad := alignOffset(end, uint32(opts.Align)) - end; pad > 0 {
+			if _, err := data.Write(make([]byte, pad)); err != nil {
+				return err
+			}
+		}
+		if err := s.writeTo(data); err != nil {
+			return fmt.Errorf("hold compressed %s: %w", entries[i].FilePath, err)
+		}
+		entries[i].attrs.hash = s.sum
+		sizes[i] = [2]uint64{s.orig, s.comp}
+		return nil
+	}
+
+	// The ratio guard samples the stream in order, so it needs the sequential path
+	var err error
+	if guard == nil {
+		err = compressParallel(entries, 0, rootName, opts, tracker, add)
+	} else {
+		err = compressStreamSequential(entries, rootName, opts, tracker, guard, add)
+	}
+	if err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	if err := writeArchiveHeader(&header, archiveType, rootName, entries, uint32(opts.Align), creationTime(opts), prov); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if err := writeEntryRecord(&header, entry, sizes[i][0], sizes[i][1]); err != nil {
+			return err
+		}
+	}
+	crc := crc32.ChecksumIEEE(header.Bytes())
+	if _, err := header.WriteTo(w); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := data.writeTo(w); err != nil {
+		return fmt.Errorf("write entry data: %w", err)
+	}
+	if prov != nil {
+		if err := writeProvenanceBlock(w, prov); err != nil {
+			return err
+		}
+	}
+	return writeTrailer(w, headerLen, crc)
+}
+
+// compressStreamSequential compresses entries one at a time into spills passed
+// to add, checking them against the ratio guard. A tripped guard with
+// StoreIncompressible stores the current entry and all later ones, as
+// compressSequential does.
+func compressStreamSequential(entries []Entry, rootName string, opts Options, tracker *progress.Tracker, guard *ratioGuard, add func(i int, s *spill) error) error {
+	store := false
+	for i := range entries {
+		entry := &entries[i]
+		tracker.StartEntry(entry.RelPath)
+		began := time.Now()
+		if store && entry.attrs.codec != codecInline && entry.kept == nil {
+			entry.attrs.codec = codecStore
+		}
+		s := &spill{dir: opts.TempDir}
+		s.orig, s.sum, s.err = compressFileStreaming(*entry, s, opts, tracker, guard, 0)
+		if errors.Is(s.err, ErrIncompressible) && opts.StoreIncompressible && entry.stream {
+			s.err = fmt.Errorf("%w; the data read from a stream cannot be read again to store it", s.err)
+		} else if errors.Is(s.err, ErrIncompressible) && opts.StoreIncompressible {
+			opts.warn(WarnStoredIncompressible, entry.name(rootName), fmt.Sprintf("%v; storing remaining entries uncompressed", s.err))
+			credited := s.orig
+			s.release()
+			store = true
+			entry.attrs.codec = codecStore
+			s = &spill{dir: opts.TempDir}
+			s.orig, s.sum, s.err = compressFileStreaming(*entry, s, opts, tracker, nil, credited)
+		}
+		s.took = time.Since(began)
+		err := add(i, s)
+		s.release()
+		if err != nil {
+			return err
+		}
+		if entry.attrs.codec != codecInline {
+			guard.add(s.orig, s.comp)
+		}
+		tracker.FinishEntry(entry.name(rootName))
+		opts.entryDone(entry.result(rootName, s.orig, s.comp, s.took))
+	}
+	return nil
+}
+
+// DecompressToWriter writes the content of a single-file archive to w, such
+// as standard output, through opts.Transform if set. The archive itself must
+// be a file: its trailer is checked before any content is written.
+func DecompressToWriter(input string, w io.Writer, opts Options) error {
+	if opts.Scan != nil {
+		return fmt.Errorf("content written to a stream cannot be scanned before it is released")
+	}
+	if _, err := CheckSidecar(input); err != nil {
+		return err
+	}
+	backend, err := encryptedBackend(input)
+	if err != nil {
+		return err
+	}
+	if backend != "" {
+		decrypted, remove, err := decryptToTemp(backend, input, opts)
+		if err != nil {
+			return err
+		}
+		defer remove()
+		input = decrypted
+	}
+
+	f, idx, err := openIndex(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if idx.archiveType != ArchiveFile || len(idx.entries) != 1 {
+		return fmt.Errorf("only a single-file archive can be written to a stream, not a directory archive")
+	}
+	entry := idx.entries[0]
+	task := DecompressTask{
+		RelPath:        entry.relPath,
+		OriginalSize:   entry.originalSize,
+		CompressedSize: entry.compressedSize,
+		DestPath:       "-",
+		name:           idx.name(entry),
+		attrs:          entry.attrs,
+		transform:      opts.Transform,
+		pacer:          progress.NewPacer(opts.MaxWriteRate),
+	}
+
+	tracker := opts.newTracker(max(entry.originalSize, 1))
+	tracker.SetTotals(max(entry.originalSize, 1), 1)
+	tracker.SetReadTotal(entry.compressedSize)
+	tracker.SetPhase(progress.PhaseWriting)
+	tracker.Start()
+	defer tracker.Stop()
+
+	var zr io.Reader = bytes.NewReader(nil) // An empty entry may have no data to decode
+	if entry.originalSize > 0 {
+		ra := NewRetryReaderAt(input, f, opts.Retry)
+		defer ra.Close()
+		if zr, err = entryDecoder(io.NewSectionReader(ra, entry.offset, int64(entry.compressedSize)), entry.attrs); err != nil {
+			return &EntryError{Path: task.name, Op: "extract", Err: err}
+		}
+	}
+	tracker.StartEntry(task.name)
+	began := time.Now()
+	if err := copyEntry(w, zr, task, tracker, nil); err != nil {
+		return &EntryError{Path: task.name, Op: "extract", Err: err}
+	}
+	tracker.FinishEntry(task.name)
+	opts.entryDone(task.result(time.Since(began), false))
+	return nil
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrSystemPath is returned when RefuseSystemPaths stops an extraction that
+// would write into a system directory
+var ErrSystemPath = errors.New("refusing to extract into a system directory")
+
+// checkSystemPaths fails with ErrSystemPath if any task would be written into
+// a file system root or into or below one of the platform's system directories
+func checkSystemPaths(tasks []DecompressTask) error {
+	checked := make(map[string]bool)
+	for _, task := range tasks {
+		dir := filepath.Dir(task.DestPath)
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+
+		resolved, err := resolveExisting(dir)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", dir, err)
+		}
+		if isFileSystemRoot(resolved) {
+			return fmt.Errorf("%w (a file system root): %s", ErrSystemPath, dir)
+		}
+		for _, sys := range systemDirs() {
+			if withinDir(resolved, sys) {
+				return fmt.Errorf("%w (%s): %s", ErrSystemPath, sys, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveExisting returns the absolute form of path with symlinks resolved in
+// the part of it that exists, so a link cannot hide a system directory
+func resolveExisting(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return abs, nil
+		}
+		missing = append(missing, filepath.Base(abs))
+		abs = parent
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package core
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// unixSystemDirs are refused by RefuseSystemPaths, with everything below them.
+// /private/etc is where /etc resolves to on macOS.
+var unixSystemDirs = []string{
+	"/bin", "/boot", "/dev", "/etc", "/lib", "/lib32", "/lib64", "/libx32",
+	"/proc", "/sbin", "/sys", "/usr",
+	"/Library", "/System", "/private/etc",
+}
+
+// systemDirs returns the directories RefuseSystemPaths refuses to write into
+func systemDirs() []string {
+	return unixSystemDirs
+}
+
+// isFileSystemRoot reports whether path is /
+func isFileSystemRoot(path string) bool {
+	return path == "/"
+}
+
+// withinDir reports whether path is dir or below it
+func withinDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
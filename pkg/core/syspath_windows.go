@@ -0,0 +1,40 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemDirs returns the directories RefuseSystemPaths refuses to write into:
+// the Windows directory, Program Files and ProgramData, taken from the
+// environment with the usual locations as fallbacks
+func systemDirs() []string {
+	dirs := make([]string, 0, 4)
+	for _, d := range []struct{ key, fallback string }{
+		{"SystemRoot", `C:\Windows`},
+		{"ProgramFiles", `C:\Program Files`},
+		{"ProgramFiles(x86)", `C:\Program Files (x86)`},
+		{"ProgramData", `C:\ProgramData`},
+	} {
+		dir := os.Getenv(d.key)
+		if dir == "" {
+			dir = d.fallback
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs
+}
+
+// isFileSystemRoot reports whether path is the root of a drive or share
+func isFileSystemRoot(path string) bool {
+	return len(path) == len(filepath.VolumeName(path))+1 && os.IsPathSeparator(path[len(path)-1])
+}
+
+// withinDir reports whether path is dir or below it, ignoring case
+func withinDir(path, dir string) bool {
+	path, dir = strings.ToLower(path), strings.ToLower(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TagRule attaches a key/value tag to the entries whose archive path matches
+// Pattern. Patterns use forward slashes and filepath.Match syntax per path
+// segment; a "**" segment matches any number of segments, so "logs/**" tags
+// everything under logs.
+type TagRule struct {
+	Pattern string
+	Key     string
+	Value   string
+}
+
+// maxTagLen bounds tag keys and values so a full tag set fits the entry table
+const maxTagLen = 1024
+
+// ParseTagRule parses a rule of the form "pattern=key:value" or "pattern=key"
+func ParseTagRule(s string) (TagRule, error) {
+	pattern, tag, ok := strings.Cut(s, "=")
+	if !ok || pattern == "" {
+		return TagRule{}, fmt.Errorf("tag %q: want pattern=key:value", s)
+	}
+	key, value, _ := strings.Cut(tag, ":")
+	if key == "" {
+		return TagRule{}, fmt.Errorf("tag %q: empty key", s)
+	}
+	if len(key) > maxTagLen || len(value) > maxTagLen {
+		return TagRule{}, fmt.Errorf("tag %q: key and value are limited to %d bytes", s, maxTagLen)
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return TagRule{}, fmt.Errorf("tag %q: invalid pattern: %w", s, err)
+		}
+	}
+	return TagRule{Pattern: pattern, Key: key, Value: value}, nil
+}
+
+// applyTagRules records the tags of every matching rule on the entries. Later
+// rules override earlier ones for the same key.
+func applyTagRules(entries []Entry, rootName string, rules []TagRule) {
+	if len(rules) == 0 {
+		return
+	}
+	set := newGlobSet()
+	for i, rule := range rules {
+		set.addPath(i, rule.Pattern)
+	}
+	var matches []int
+	for i := range entries {
+		matches = set.appendMatches(matches[:0], filepath.ToSlash(entries[i].name(rootName)))
+		for _, r := range matches {
+			rule := rules[r]
+			if entries[i].attrs.tags == nil {
+				entries[i].attrs.tags = make(map[string]string)
+			}
+			entries[i].attrs.tags[rule.Key] = rule.Value
+		}
+	}
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// segment matches zero or more whole segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,515 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Constants for tape volumes
+const (
+	VolumeMagic       = "AGCPVOL1" // Identifies the header block at the start of each volume
+	VolumeIndexMagic  = "AGCPVIDX" // Identifies the volume index written after the archive
+	recordSize        = 512        // Size of one record; a block is BlockingFactor records
+	defaultBlocking   = 20         // Records per block, as in tar
+	volumeHeaderBytes = 36         // magic(8) + archiveID(8) + volume(4) + blockSize(4) + archiveLen(8) + flags(4)
+	headerReadSize    = 1 << 20    // Smallest read of a volume header block, larger than any usual tape block
+)
+
+// Volume header flags
+const (
+	// volumeDigests says a volume index follows the archive: the SHA-256 of
+	// the archive blocks on each volume, so a damaged volume can be named
+	// without extracting the archive. Volumes written before it have no flags.
+	volumeDigests uint32 = 1 << 0
+)
+
+// ErrVolumeDigest is returned when a volume's content does not match the
+// digest recorded for it in the volume index
+var ErrVolumeDigest = errors.New("volume does not match its recorded digest")
+
+// TapeReport describes the volumes of an archive read from a sequential device
+type TapeReport struct {
+	Volumes    int   // Volumes holding archive data
+	Digests    bool  // Whether the volumes carry a volume index; those written before agcp recorded one do not
+	Mismatched []int // Volumes, numbered from 1, whose content does not match their recorded digest
+}
+
+// TapeOptions configures writing archives to and reading them from sequential
+// devices such as tape drives and pipes, which cannot seek
+type TapeOptions struct {
+	BlockingFactor int   // 512-byte records per block; 20 when zero
+	VolumeSize     int64 // Bytes per volume including headers; 0 writes until the device reports it is full
+
+	// NextVolume is called when a volume is full (writing) or exhausted (reading)
+	// and must return once volume n (1-based) is ready. Without it, archives that
+	// need more than one volume fail.
+	NextVolume func(volume int) error
+}
+
+// blockSize returns the device block size in bytes
+func (o TapeOptions) blockSize() int {
+	if o.BlockingFactor <= 0 {
+		return defaultBlocking * recordSize
+	}
+	return o.BlockingFactor * recordSize
+}
+
+// volumeHeader is written as the first block of every volume
+type volumeHeader struct {
+	archiveID  [8]byte
+	volume     uint32
+	blockSize  uint32
+	archiveLen uint64
+	flags      uint32
+}
+
+// encode writes the header padded to a full block
+func (h volumeHeader) encode(blockSize int) []byte {
+	block := make([]byte, blockSize)
+	copy(block, VolumeMagic)
+	copy(block[8:16], h.archiveID[:])
+	binary.BigEndian.PutUint32(block[16:20], h.volume)
+	binary.BigEndian.PutUint32(block[20:24], h.blockSize)
+	binary.BigEndian.PutUint64(block[24:32], h.archiveLen)
+	binary.BigEndian.PutUint32(block[32:36], h.flags)
+	return block
+}
+
+// decodeVolumeHeader parses a volume header block
+func decodeVolumeHeader(block []byte) (volumeHeader, error) {
+	var h volumeHeader
+	if len(block) < volumeHeaderBytes || string(block[:8]) != VolumeMagic {
+		return h, fmt.Errorf("not an agcp volume: missing volume header")
+	}
+	copy(h.archiveID[:], block[8:16])
+	h.volume = binary.BigEndian.Uint32(block[16:20])
+	h.blockSize = binary.BigEndian.Uint32(block[20:24])
+	h.archiveLen = binary.BigEndian.Uint64(block[24:32])
+	h.flags = binary.BigEndian.Uint32(block[32:36])
+	return h, nil
+}
+
+// encodeVolumeIndex returns the volume index written after the archive, padded
+// to whole blocks: the magic, the number of volumes, the SHA-256 of the archive
+// blocks on each volume, and a CRC-32 of all of that
+func encodeVolumeIndex(sums [][sha256.Size]byte, blockSize int) []byte {
+	b := append([]byte(VolumeIndexMagic), binary.BigEndian.AppendUint32(nil, uint32(len(sums)))...)
+	for _, sum := range sums {
+		b = append(b, sum[:]...)
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	return append(b, make([]byte, volumeIndexLen(len(sums), blockSize)-len(b))...)
+}
+
+// volumeIndexLen returns the size of the volume index of n volumes in whole blocks
+func volumeIndexLen(n, blockSize int) int {
+	size := len(VolumeIndexMagic) + 4 + n*sha256.Size + 4
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
+// decodeVolumeIndex parses a volume index. next returns the index's following
+// blocks, for an index of more volumes than the first block holds.
+func decodeVolumeIndex(first []byte, next func() ([]byte, error)) ([][sha256.Size]byte, error) {
+	if len(first) < len(VolumeIndexMagic)+4 || string(first[:len(VolumeIndexMagic)]) != VolumeIndexMagic {
+		return nil, fmt.Errorf("missing volume index after the archive")
+	}
+	n := int(binary.BigEndian.Uint32(first[len(VolumeIndexMagic):]))
+	b := append([]byte(nil), first...)
+	size := len(VolumeIndexMagic) + 4 + n*sha256.Size
+	for len(b) < size+4 {
+		block, err := next()
+		if err != nil {
+			return nil, fmt.Errorf("read volume index: %w", err)
+		}
+		b = append(b, block...)
+	}
+	if crc32.ChecksumIEEE(b[:size]) != binary.BigEndian.Uint32(b[size:]) {
+		return nil, fmt.Errorf("volume index is corrupt")
+	}
+	sums := make([][sha256.Size]byte, n)
+	for i := range sums {
+		copy(sums[i][:], b[len(VolumeIndexMagic)+4+i*sha256.Size:])
+	}
+	return sums, nil
+}
+
+// WriteTape copies an archive file to a sequential device in fixed-size blocks,
+// spanning as many volumes as needed, followed by the volume index recording
+// the digest of each volume. device "-" writes to standard output.
+func WriteTape(archivePath, device string, opts TapeOptions) error {
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat archive: %w", err)
+	}
+
+	blockSize := opts.blockSize()
+	if opts.VolumeSize > 0 && opts.VolumeSize < int64(2*blockSize) {
+		return fmt.Errorf("volume size %d is smaller than two %d-byte blocks", opts.VolumeSize, blockSize)
+	}
+
+	header := volumeHeader{volume: 1, blockSize: uint32(blockSize), archiveLen: uint64(info.Size()), flags: volumeDigests}
+	if _, err := rand.Read(header.archiveID[:]); err != nil {
+		return fmt.Errorf("generate archive id: %w", err)
+	}
+
+	dev, err := openDevice(device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { dev.Close() }()
+
+	block := make([]byte, blockSize)
+	written := int64(0) // Bytes written to the current volume
+	var sums [][sha256.Size]byte
+	h := sha256.New()
+	// finishVolume records the digest of the current volume's archive blocks,
+	// once: a volume index spilling onto a new volume adds none
+	finishVolume := func() {
+		if len(sums) < int(header.volume) {
+			sums = append(sums, sum256(h))
+			h.Reset()
+		}
+	}
+	startVolume := func() error {
+		if _, err := dev.Write(header.encode(blockSize)); err != nil {
+			return fmt.Errorf("write volume %d header: %w", header.volume, err)
+		}
+		written = int64(blockSize)
+		return nil
+	}
+	nextVolume := func() error {
+		if opts.NextVolume == nil || device == "-" {
+			return fmt.Errorf("volume %d is full and no further volumes can be requested", header.volume)
+		}
+		dev.Close()
+		finishVolume()
+		header.volume++
+		if err := opts.NextVolume(int(header.volume)); err != nil {
+			return err
+		}
+		if dev, err = openDevice(device, true); err != nil {
+			return err
+		}
+		return startVolume()
+	}
+
+	writeBlock := func(block []byte) error {
+		if opts.VolumeSize > 0 && written+int64(len(block)) > opts.VolumeSize {
+			if err := nextVolume(); err != nil {
+				return err
+			}
+		}
+		for {
+			_, err := dev.Write(block)
+			if err == nil {
+				break
+			}
+			if !isEndOfMedium(err) {
+				return fmt.Errorf("write volume %d: %w", header.volume, err)
+			}
+			// The block did not fit; retry it at the start of the next volume
+			if err := nextVolume(); err != nil {
+				return err
+			}
+		}
+		written += int64(len(block))
+		return nil
+	}
+
+	if err := startVolume(); err != nil {
+		return err
+	}
+	for {
+		n, err := io.ReadFull(src, block)
+		if n == 0 {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		clear(block[n:]) // Zero-pad the final block
+		if err := writeBlock(block); err != nil {
+			return err
+		}
+		h.Write(block)
+	}
+
+	finishVolume()
+	index := encodeVolumeIndex(sums, blockSize)
+	for len(index) > 0 {
+		if err := writeBlock(index[:blockSize]); err != nil {
+			return fmt.Errorf("write volume index: %w", err)
+		}
+		index = index[blockSize:]
+	}
+	return dev.Close()
+}
+
+// sum256 returns the SHA-256 digest accumulated by h
+func sum256(h hash.Hash) [sha256.Size]byte {
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// ReadTape reads an archive written by WriteTape from a sequential device into a
+// regular file, prompting for each further volume. device "-" reads standard
+// input. A volume that does not match its recorded digest fails with
+// ErrVolumeDigest, naming it.
+func ReadTape(device, archivePath string, opts TapeOptions) error {
+	dst, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer dst.Close()
+
+	report, err := readTape(device, dst, opts)
+	if err != nil {
+		return err
+	}
+	if len(report.Mismatched) > 0 {
+		return fmt.Errorf("%w: volume %s", ErrVolumeDigest, joinVolumes(report.Mismatched))
+	}
+	return dst.Close()
+}
+
+// VerifyTape reads the volumes of an archive written by WriteTape and checks
+// each against the digest recorded for it in the volume index, without
+// extracting or even storing the archive. Volumes that do not match are
+// listed in the report rather than failing it.
+func VerifyTape(device string, opts TapeOptions) (TapeReport, error) {
+	return readTape(device, io.Discard, opts)
+}
+
+// readTape copies the archive on the volumes of device to dst, hashing the
+// archive blocks of each volume, and checks the digests against the volume
+// index if the volumes carry one
+func readTape(device string, dst io.Writer, opts TapeOptions) (TapeReport, error) {
+	r := &volumeReader{device: device, opts: opts}
+	defer r.close()
+
+	var report TapeReport
+	var sums [][sha256.Size]byte
+	h := sha256.New()
+	block, err := r.next()
+	if err != nil {
+		return report, err
+	}
+	for remaining := r.first.archiveLen; remaining > 0; {
+		for len(sums) < r.volume-1 {
+			sums = append(sums, sum256(h))
+			h.Reset()
+		}
+		h.Write(block)
+		keep := min(uint64(len(block)), remaining)
+		if _, err := dst.Write(block[:keep]); err != nil {
+			return report, fmt.Errorf("write archive: %w", err)
+		}
+		if remaining -= keep; remaining > 0 {
+			if block, err = r.next(); err != nil {
+				return report, err
+			}
+		}
+	}
+	sums = append(sums, sum256(h))
+	report.Volumes = len(sums)
+	if r.first.flags&volumeDigests == 0 {
+		return report, nil
+	}
+
+	first, err := r.next()
+	if err != nil {
+		return report, fmt.Errorf("read volume index: %w", err)
+	}
+	recorded, err := decodeVolumeIndex(first, r.next)
+	if err != nil {
+		return report, fmt.Errorf("volume %d: %w", r.volume, err)
+	}
+	if len(recorded) != len(sums) {
+		return report, fmt.Errorf("volume index lists %d volumes, the archive was read from %d", len(recorded), len(sums))
+	}
+	report.Digests = true
+	for i := range sums {
+		if sums[i] != recorded[i] {
+			report.Mismatched = append(report.Mismatched, i+1)
+		}
+	}
+	return report, nil
+}
+
+// volumeReader reads the blocks of an archive's volumes in order, loading each
+// further volume through TapeOptions.NextVolume and checking that it is the
+// next volume of the same archive
+type volumeReader struct {
+	device string
+	opts   TapeOptions
+	dev    io.ReadWriteCloser // Current volume; nil between volumes
+	src    io.Reader          // Data of the current volume: dev, after any read along with the header
+	first  volumeHeader       // Header of the first volume
+	volume int                // Number of the current volume, from 1
+	block  []byte
+}
+
+// next returns the next block, moving on to the next volume when the current
+// one ends. The block is reused by the following call.
+func (r *volumeReader) next() ([]byte, error) {
+	for {
+		if r.dev == nil {
+			if err := r.open(r.volume + 1); err != nil {
+				return nil, err
+			}
+		}
+		n, err := io.ReadFull(r.src, r.block)
+		if n == 0 && (err == io.EOF || isEndOfMedium(err)) {
+			r.close()
+			continue
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("read volume %d: %w", r.volume, err)
+		}
+		return r.block[:n], nil
+	}
+}
+
+// open loads and opens volume, checking its header
+func (r *volumeReader) open(volume int) error {
+	if volume > 1 {
+		if r.opts.NextVolume == nil || r.device == "-" {
+			return fmt.Errorf("archive continues on volume %d but no further volumes can be requested", volume)
+		}
+		if err := r.opts.NextVolume(volume); err != nil {
+			return err
+		}
+	}
+	dev, err := openDevice(r.device, false)
+	if err != nil {
+		return err
+	}
+	header, blockSize, rest, err := readVolumeHeader(dev, r.opts.blockSize())
+	if err != nil {
+		dev.Close()
+		return fmt.Errorf("volume %d: %w", volume, err)
+	}
+	if volume == 1 {
+		r.first = header
+	} else if header.archiveID != r.first.archiveID {
+		dev.Close()
+		return fmt.Errorf("volume %d belongs to a different archive", volume)
+	}
+	if int(header.volume) != volume {
+		dev.Close()
+		return fmt.Errorf("expected volume %d, found volume %d", volume, header.volume)
+	}
+	r.dev, r.src, r.volume, r.block = dev, dev, volume, make([]byte, blockSize)
+	if len(rest) > 0 {
+		r.src = io.MultiReader(bytes.NewReader(rest), dev)
+	}
+	return nil
+}
+
+// close closes the current volume, if any
+func (r *volumeReader) close() {
+	if r.dev != nil {
+		r.dev.Close()
+		r.dev = nil
+	}
+}
+
+// joinVolumes lists volume numbers for a message, such as "2, 5"
+func joinVolumes(volumes []int) string {
+	s := make([]string, len(volumes))
+	for i, v := range volumes {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// readVolumeHeader reads the header block, using the block size recorded in it
+// when it differs from the configured one. A tape drive in variable block mode
+// returns one block per read and drops whatever does not fit the buffer, so
+// the header is read with a single read of at least headerReadSize bytes and
+// the block size is taken from what that read returned. Pipes and files may
+// return more than the header block in that read; the rest is returned as the
+// start of the data.
+func readVolumeHeader(dev io.Reader, blockSize int) (volumeHeader, int, []byte, error) {
+	buf := make([]byte, max(blockSize, headerReadSize))
+	n, err := dev.Read(buf)
+	if n < recordSize && err == nil {
+		// A pipe may deliver the header record in pieces
+		var more int
+		more, err = io.ReadFull(dev, buf[n:recordSize])
+		n += more
+	}
+	if n < recordSize {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return volumeHeader{}, 0, nil, fmt.Errorf("read volume header: %w", err)
+	}
+	header, err := decodeVolumeHeader(buf[:n])
+	if err != nil {
+		return header, 0, nil, err
+	}
+	blockSize = int(header.blockSize)
+	if blockSize < recordSize || blockSize%recordSize != 0 {
+		return header, 0, nil, fmt.Errorf("invalid block size %d in volume header", blockSize)
+	}
+	if n > blockSize {
+		return header, blockSize, buf[blockSize:n], nil
+	}
+	// Skip the rest of the header block
+	if _, err := io.CopyN(io.Discard, dev, int64(blockSize-n)); err != nil {
+		return header, 0, nil, fmt.Errorf("read volume header: %w", err)
+	}
+	return header, blockSize, nil, nil
+}
+
+// stdout is standard output as of startup, so that archive data still goes
+// there when a program points os.Stdout at stderr to keep its messages out of it
+var stdout = os.Stdout
+
+// openDevice opens a sequential device for writing or reading; "-" is stdout/stdin
+func openDevice(device string, write bool) (io.ReadWriteCloser, error) {
+	if device == "-" {
+		if write {
+			return nopCloser{stdout}, nil
+		}
+		return nopCloser{os.Stdin}, nil
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(device, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open device %s: %w", device, err)
+	}
+	return f, nil
+}
+
+// nopCloser keeps standard streams open when a volume is closed
+type nopCloser struct{ *os.File }
+
+// Close implements io.Closer without closing the file
+func (nopCloser) Close() error { return nil }
+
+// isEndOfMedium reports whether err means the device has no room for more data
+func isEndOfMedium(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, io.ErrShortWrite)
+}
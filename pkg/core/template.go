@@ -0,0 +1,79 @@
+package core
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExpandOutputTemplate expands {token} placeholders in an output name, such as
+// "backup-{name}-{date:2006-01-02}-{host}.agcp". Supported tokens:
+//
+//	{name}         base name of the input
+//	{date}         current date, 2006-01-02; {date:layout} uses a Go time layout
+//	{time}         current time, 150405; {time:layout} uses a Go time layout
+//	{host}         hostname ({hostname} is accepted too)
+//	{uuid}         random UUID (version 4)
+//
+// Names without placeholders are returned unchanged.
+func ExpandOutputTemplate(template, input string, now time.Time) (string, error) {
+	var sb strings.Builder
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			sb.WriteString(rest)
+			return sb.String(), nil
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("output template %q: unclosed {", template)
+		}
+		sb.WriteString(rest[:open])
+
+		token := rest[open+1 : open+end]
+		value, err := expandToken(token, input, now)
+		if err != nil {
+			return "", fmt.Errorf("output template %q: %w", template, err)
+		}
+		sb.WriteString(value)
+		rest = rest[open+end+1:]
+	}
+}
+
+// expandToken returns the value of one template token
+func expandToken(token, input string, now time.Time) (string, error) {
+	name, layout, hasLayout := strings.Cut(token, ":")
+	switch name {
+	case "name":
+		return filepath.Base(filepath.Clean(input)), nil
+	case "date":
+		if !hasLayout {
+			layout = "2006-01-02"
+		}
+		return now.Format(layout), nil
+	case "time":
+		if !hasLayout {
+			layout = "150405"
+		}
+		return now.Format(layout), nil
+	case "host", "hostname":
+		host, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("hostname: %w", err)
+		}
+		return host, nil
+	case "uuid":
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", fmt.Errorf("uuid: %w", err)
+		}
+		b[6] = b[6]&0x0f | 0x40 // Version 4
+		b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+	}
+	return "", fmt.Errorf("unknown token {%s}", token)
+}
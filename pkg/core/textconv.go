@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// textSniffLen is how much of an entry's content is inspected to decide
+// whether it is text. Like git, content with a NUL byte there is binary.
+const textSniffLen = 8000
+
+// LineEnding is the line ending text entries are converted to on extraction
+type LineEnding uint8
+
+const (
+	LineEndingKeep LineEnding = iota // Leave content untouched (the default)
+	LineEndingLF                     // Unix: CRLF becomes LF
+	LineEndingCRLF                   // Windows: LF becomes CRLF
+)
+
+// ParseLineEnding parses a line ending name: "none", "lf" or "crlf"
+func ParseLineEnding(name string) (LineEnding, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return LineEndingKeep, nil
+	case "lf":
+		return LineEndingLF, nil
+	case "crlf":
+		return LineEndingCRLF, nil
+	}
+	return 0, fmt.Errorf("unknown line ending %q (want none, lf or crlf)", name)
+}
+
+// String returns the line ending name
+func (e LineEnding) String() string {
+	switch e {
+	case LineEndingLF:
+		return "lf"
+	case LineEndingCRLF:
+		return "crlf"
+	}
+	return "none"
+}
+
+// ConvertLineEndings returns a TransformFunc converting the line endings of
+// text entries to e. Archives record no text flag, so an entry counts as text
+// unless its first 8000 bytes contain a NUL byte, the heuristic git uses;
+// other entries are extracted as they are. Line endings already in the target
+// form are left alone, and a lone CR is never treated as a line ending.
+func ConvertLineEndings(e LineEnding) TransformFunc {
+	return func(relPath string, r io.Reader, w io.Writer) error {
+		br := bufio.NewReaderSize(r, textSniffLen)
+		head, err := br.Peek(textSniffLen)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if e == LineEndingKeep || bytes.IndexByte(head, 0) >= 0 {
+			_, err := io.Copy(w, br)
+			return err
+		}
+		lw := &lineEndingWriter{w: w, crlf: e == LineEndingCRLF}
+		if _, err := io.Copy(lw, br); err != nil {
+			return err
+		}
+		return lw.flush()
+	}
+}
+
+// lineEndingWriter converts the line endings of the text written to it
+type lineEndingWriter struct {
+	w    io.Writer
+	crlf bool // Convert LF to CRLF; otherwise CRLF to LF
+	cr   bool // The last byte written was a CR (held back when converting to LF)
+	buf  []byte
+}
+
+// Write implements io.Writer
+func (lw *lineEndingWriter) Write(p []byte) (int, error) {
+	out := lw.buf[:0]
+	for _, b := range p {
+		switch {
+		case lw.crlf:
+			if b == '\n' && !lw.cr {
+				out = append(out, '\r')
+			}
+			out = append(out, b)
+		case lw.cr && b == '\n':
+			out = append(out, '\n')
+		default:
+			if lw.cr {
+				out = append(out, '\r')
+			}
+			if b != '\r' {
+				out = append(out, b)
+			}
+		}
+		lw.cr = b == '\r'
+	}
+	lw.buf = out
+	if _, err := lw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes a CR held back at the end of the content
+func (lw *lineEndingWriter) flush() error {
+	if lw.crlf || !lw.cr {
+		return nil
+	}
+	lw.cr = false
+	_, err := lw.w.Write([]byte{'\r'})
+	return err
+}
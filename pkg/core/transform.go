@@ -0,0 +1,31 @@
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"agcp/pkg/progress"
+)
+
+// TransformFunc rewrites the content of an entry as it is extracted, such as
+// decrypting it, converting its line endings or filtering it. relPath is the
+// entry's path within the archive (the file name for a single-file archive), r
+// yields its decoded content and w writes the file. Content the function does
+// not read is still decoded, so a corrupt entry fails all the same. A non-nil
+// error fails the extraction of the entry.
+type TransformFunc func(relPath string, r io.Reader, w io.Writer) error
+
+// transformEntry runs an entry's decoded content from zr through its transform
+// into w, returning the number of content bytes decoded. Progress and the write
+// rate limit follow the decoded content, since the transform may change its size.
+func transformEntry(w io.Writer, zr io.Reader, task DecompressTask, tracker *progress.Tracker) (int64, error) {
+	content := &io.LimitedReader{R: zr, N: int64(task.OriginalSize)}
+	src := io.TeeReader(content, &progress.Writer{W: io.Discard, T: tracker, P: task.pacer})
+	if err := task.transform(task.name, src, w); err != nil {
+		return 0, fmt.Errorf("transform %s: %w", task.DestPath, err)
+	}
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return 0, fmt.Errorf("copy %s: %w", task.DestPath, err)
+	}
+	return int64(task.OriginalSize) - content.N, nil
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// InputUnchanged reports whether input, a file or directory, holds exactly the
+// files archived in archivePath: the same root name, the same paths and the
+// same content, compared against the SHA-256 hashes in the archive's entry
+// table. Modes and times are not compared. Sizes are checked before anything
+// is hashed, and hashing stops at the first difference. Archives that record
+// no hashes (before format v4) never match.
+func InputUnchanged(input, archivePath string, opts Options) (bool, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return false, fmt.Errorf("stat input: %w", err)
+	}
+	f, idx, err := openIndex(archivePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	archiveType := ArchiveFile
+	if info.IsDir() {
+		archiveType = ArchiveDir
+		if entries, err = collectDirEntries(input, opts); err != nil {
+			return false, fmt.Errorf("collect entries: %w", err)
+		}
+		entries, _ = excludeOutput(entries, archivePath)
+	} else {
+		entries = []Entry{newEntry("", input, info)}
+	}
+	if idx.archiveType != archiveType || idx.rootName != rootNameOf(input) || len(idx.entries) != len(entries) {
+		return false, nil
+	}
+
+	archived := make(map[string]indexEntry, len(idx.entries))
+	for _, e := range idx.entries {
+		archived[e.relPath] = e
+	}
+	for _, entry := range entries {
+		e, ok := archived[entry.RelPath]
+		if !ok || !e.attrs.hasHash || !e.attrs.regular() || e.originalSize != uint64(entry.Size) {
+			return false, nil
+		}
+	}
+	for _, entry := range entries {
+		same, err := hashMatches(entry.FilePath, archived[entry.RelPath].attrs.hash, opts)
+		if err != nil || !same {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// hashMatches reports whether the SHA-256 of the file at path is sum
+func hashMatches(path string, sum [sha256.Size]byte, opts Options) (bool, error) {
+	f, err := openRetryFile(path, opts.Retry)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return bytes.Equal(h.Sum(nil), sum[:]), nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// unchangedOnDisk reports whether the file at task's destination already holds
+// the entry's content, for Options.Update: it must have the entry's size and
+// either its recorded modification time or, failing that, its recorded hash.
+// A file that matched by hash is given the recorded modification time, so the
+// next update can skip it by size and time alone.
+func unchangedOnDisk(task DecompressTask) (bool, error) {
+	info, err := os.Lstat(task.DestPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() || uint64(info.Size()) != task.OriginalSize {
+		return false, nil
+	}
+	if task.attrs.hasMtime && info.ModTime().UnixNano() == task.attrs.mtime {
+		return true, nil
+	}
+	if !task.attrs.hasHash {
+		return false, nil // Archives before v4 record no hash to compare
+	}
+
+	if same, err := hashMatches(task.DestPath, task.attrs.hash, Options{}); err != nil || !same {
+		return false, err
+	}
+	return true, setUpdateTime(task)
+}
+
+// setUpdateTime gives an extracted file the entry's recorded modification time,
+// if any, so later updates recognise it as unchanged without hashing it
+func setUpdateTime(task DecompressTask) error {
+	if !task.attrs.hasMtime {
+		return nil
+	}
+	if err := os.Chtimes(task.DestPath, time.Time{}, time.Unix(0, task.attrs.mtime)); err != nil {
+		return fmt.Errorf("set modification time of %s: %w", task.DestPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"agcp/pkg/progress"
+)
+
+// verifySegmentSize is the span of an entry's decoded data that a decoding
+// failure is localized to. It matches the LZ4 block size: block checksums catch
+// corruption in the block it occurs in.
+const verifySegmentSize = 4 << 20
+
+// SegmentError localizes a failure to decode an entry to one segment of its
+// decoded data, so the corrupt region of a very large entry can be found
+type SegmentError struct {
+	Segment  uint64 // 1-based index of the segment that failed
+	Segments uint64 // Segments in the entry
+	Offset   uint64 // Offset of the segment in the entry's decoded data
+	Err      error  // Underlying error
+}
+
+// Error implements error
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("segment %d of %d (from byte %d): %v", e.Segment, e.Segments, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// localizeError wraps a decoding error that occurred after decoded bytes of an
+// entry of size bytes in a SegmentError. An error at the very end, such as a
+// whole-content checksum mismatch, could lie anywhere and is returned as is.
+func localizeError(err error, decoded, size uint64) error {
+	if decoded >= size {
+		return err
+	}
+	segment := decoded / verifySegmentSize
+	return &SegmentError{
+		Segment:  segment + 1,
+		Segments: (size + verifySegmentSize - 1) / verifySegmentSize,
+		Offset:   segment * verifySegmentSize,
+		Err:      err,
+	}
+}
+
+// VerifyReport summarizes the result of Verify
+type VerifyReport struct {
+	Entries  int           // Entries checked
+	Decoded  int           // Entries decoded: all regular files, unless fast or sampling
+	Bytes    uint64        // Uncompressed bytes decoded (zero in fast mode)
+	Sidecar  bool          // The archive has a checksum sidecar, and matches it
+	Failures []*EntryError // Entries that failed a check, in archive order
+}
+
+// Verify checks an archive without extracting it. The header, trailer and entry
+// table are always checked: sizes, offsets, entry paths and that the entry data
+// exactly fills the archive. Unless fast is set, every entry is also decoded in
+// parallel, checking its content checksum and decompressed size.
+//
+// Entries are decoded as a stream, so memory use does not depend on entry size.
+// A corrupt entry's failure is localized to a segment of it (see SegmentError),
+// and transient read errors are retried for the failing read only.
+//
+// An archive with a checksum sidecar (see SidecarPath) must match it first.
+// Problems with individual entries are collected in the report; an error is
+// returned only when the archive as a whole cannot be read or fails its sidecar.
+func Verify(archivePath string, fast bool, opts Options) (*VerifyReport, error) {
+	fraction := 1.0
+	if fast {
+		fraction = 0
+	}
+	return VerifySample(archivePath, fraction, opts)
+}
+
+// VerifySample is Verify decoding a random sample of the entries: fraction is
+// the share of them to decode, from 0 (structure only, like fast mode) to 1
+// (every entry). A positive fraction decodes at least one entry.
+func VerifySample(archivePath string, fraction float64, opts Options) (*VerifyReport, error) {
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("sample fraction %g is outside 0 to 1", fraction)
+	}
+	sidecar, err := CheckSidecar(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	f, idx, err := openIndex(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	report := &VerifyReport{Entries: len(idx.entries), Sidecar: sidecar}
+	failures := make([]*EntryError, len(idx.entries))
+	seen := make(map[string]bool, len(idx.entries))
+	for i, entry := range idx.entries {
+		name := idx.name(entry)
+		if err := checkEntryStructure(entry); err != nil {
+			failures[i] = &EntryError{Path: name, Op: "verify", Err: err}
+		} else if seen[name] {
+			failures[i] = &EntryError{Path: name, Op: "verify", Err: fmt.Errorf("duplicate entry path")}
+		}
+		seen[name] = true
+	}
+
+	// Entries whose structure is bad are already known to fail, and entries of
+	// types this agcp cannot restore have only their structure checked
+	decode := sampleEntries(len(idx.entries), fraction)
+	var picked []int
+	for i := range idx.entries {
+		if decode[i] && failures[i] == nil && idx.entries[i].attrs.regular() {
+			picked = append(picked, i)
+		} else {
+			var err error
+			if failures[i] != nil {
+				err = failures[i].Err
+			}
+			opts.entryDone(idx.result(idx.entries[i], 0, err))
+		}
+	}
+	report.Decoded = len(picked)
+
+	if len(picked) > 0 {
+		var totalSize, totalRead uint64
+		offsets := make([]int64, len(picked))
+		sizes := make([]uint64, len(picked))
+		for n, i := range picked {
+			totalSize += idx.entries[i].originalSize
+			totalRead += idx.entries[i].compressedSize
+			offsets[n], sizes[n] = idx.entries[i].offset, idx.entries[i].compressedSize
+		}
+		tracker := opts.newTracker(totalSize)
+		tracker.SetTotals(totalSize, uint64(len(picked)))
+		tracker.SetReadTotal(totalRead)
+		tracker.SetPhase(progress.PhaseVerifying)
+		tracker.Start()
+		defer tracker.Stop()
+
+		var mu sync.Mutex
+		ra := NewRetryReaderAt(archivePath, f, opts.Retry)
+		defer ra.Close()
+		forEachByOffset(offsets, sizes, func(n int) error {
+			i := picked[n]
+			entry := idx.entries[i]
+			tracker.StartEntry(entry.relPath)
+			began := time.Now()
+			decoded, err := decodeEntry(ra, entry, tracker)
+			mu.Lock()
+			report.Bytes += decoded
+			mu.Unlock()
+			opts.entryDone(idx.result(entry, time.Since(began), err))
+			if err != nil {
+				failures[i] = &EntryError{Path: idx.name(entry), Op: "verify", Err: err}
+				return nil
+			}
+			tracker.FinishEntry(idx.name(entry))
+			return nil
+		})
+	}
+
+	for _, failure := range failures {
+		if failure != nil {
+			report.Failures = append(report.Failures, failure)
+		}
+	}
+	return report, nil
+}
+
+// sampleEntries picks which of n entries to decode for a sample of fraction
+// of them, at random
+func sampleEntries(n int, fraction float64) []bool {
+	decode := make([]bool, n)
+	count := int(math.Ceil(float64(n) * fraction))
+	if count >= n {
+		for i := range decode {
+			decode[i] = true
+		}
+		return decode
+	}
+	for _, i := range rand.Perm(n)[:count] {
+		decode[i] = true
+	}
+	return decode
+}
+
+// checkEntryStructure checks an entry's metadata without reading its data
+func checkEntryStructure(entry indexEntry) error {
+	if err := checkEntryPath(entry.relPath); err != nil {
+		return err
+	}
+	if entry.attrs.codec == codecStore && entry.compressedSize != entry.originalSize {
+		return fmt.Errorf("stored entry is %d bytes but records an original size of %d", entry.compressedSize, entry.originalSize)
+	}
+	if entry.attrs.codec == codecInline && (entry.compressedSize != 0 || uint64(len(entry.attrs.inline)) != entry.originalSize) {
+		return fmt.Errorf("inline entry holds %d bytes inline and %d in the data area but records an original size of %d", len(entry.attrs.inline), entry.compressedSize, entry.originalSize)
+	}
+	return nil
+}
+
+// checkEntryPath checks that an entry's path stays inside the extraction
+// directory: it must be relative and have no ".." element
+func checkEntryPath(relPath string) error {
+	if filepath.IsAbs(relPath) || filepath.VolumeName(relPath) != "" || strings.HasPrefix(relPath, "/") || strings.HasPrefix(relPath, "\\") {
+		return fmt.Errorf("absolute entry path")
+	}
+	for _, part := range strings.FieldsFunc(relPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("entry path escapes the extraction directory")
+		}
+	}
+	return nil
+}
+
+// checkRootName checks that the root name recorded in an archive's header is
+// a single file or directory name, so extracting under it stays inside the
+// destination
+func checkRootName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("root name %q is not a plain file or directory name", name)
+	}
+	return nil
+}
+
+// decodeEntry decodes an entry to the end of its data, so the codec validates
+// its checksums, and checks the decoded size. It returns the bytes decoded.
+func decodeEntry(r io.ReaderAt, entry indexEntry, tracker *progress.Tracker) (uint64, error) {
+	if entry.compressedSize == 0 && entry.attrs.codec != codecInline {
+		if entry.originalSize != 0 {
+			return 0, fmt.Errorf("no data for %d-byte entry", entry.originalSize)
+		}
+		return 0, nil
+	}
+	sr := io.NewSectionReader(r, entry.offset, int64(entry.compressedSize))
+	dec, err := entryDecoder(&progress.Reader{R: sr, T: tracker}, entry.attrs)
+	if err != nil {
+		return 0, fmt.Errorf("decode: %w", err)
+	}
+	n, err := io.Copy(&progress.Writer{W: io.Discard, T: tracker}, dec)
+	if err != nil {
+		return uint64(n), fmt.Errorf("decode: %w", localizeError(err, uint64(n), entry.originalSize))
+	}
+	if uint64(n) != entry.originalSize {
+		return uint64(n), fmt.Errorf("decoded %d bytes, expected %d", n, entry.originalSize)
+	}
+	return uint64(n), nil
+}
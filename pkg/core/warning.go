@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// WarningCode identifies the kind of a Warning, so callers can filter or count
+// warnings without parsing their messages
+type WarningCode string
+
+const (
+	WarnOutputInInput        WarningCode = "output-in-input"       // The output was inside the input tree and left out
+	WarnSkippedSpecialFile   WarningCode = "skipped-special-file"  // A device, pipe or socket was not archived
+	WarnSkippedMountPoint    WarningCode = "skipped-mount-point"   // A directory on another file system was not descended into
+	WarnPartialResumed       WarningCode = "partial-resumed"       // Entries were kept from an interrupted run
+	WarnStoredIncompressible WarningCode = "stored-incompressible" // The ratio guard switched to storing entries
+	WarnScanRejected         WarningCode = "scan-rejected"         // Options.Scan rejected an entry, which was not extracted
+	WarnOwnerNotRestored     WarningCode = "owner-not-restored"    // Archived ownership could not be applied
+	WarnNestedNotUnpacked    WarningCode = "nested-not-unpacked"   // A nested archive was left packed
+	WarnNestedNotRemoved     WarningCode = "nested-not-removed"    // An unpacked nested archive could not be removed
+	WarnSnapshotUnavailable  WarningCode = "snapshot-unavailable"  // SnapshotAuto found no snapshot support and read the live input
+	WarnNotLocked            WarningCode = "not-locked"            // The output could not be locked against concurrent runs
+	WarnSkippedHidden        WarningCode = "skipped-hidden"        // Options.SkipHidden left hidden files out; one summary per walk
+	WarnIncludedHidden       WarningCode = "included-hidden"       // Hidden files were archived; one summary per walk
+	WarnEscapedName          WarningCode = "escaped-name"          // A name that is not valid UTF-8 was escaped for Windows
+	WarnIgnoredFeature       WarningCode = "ignored-feature"       // The archive uses an optional feature newer than this agcp
+	WarnSkippedEntryType     WarningCode = "skipped-entry-type"    // An entry of a type this agcp cannot restore was not extracted
+	WarnCacheUnavailable     WarningCode = "cache-unavailable"     // Options.CacheDir could not be used; files were compressed as usual
+)
+
+// Warning is a non-fatal problem an operation reported and carried on past
+type Warning struct {
+	Code    WarningCode `json:"code"`
+	Path    string      `json:"path,omitempty"` // File or entry concerned, if any
+	Message string      `json:"message"`
+}
+
+// String formats the warning as "path: message"
+func (w Warning) String() string {
+	if w.Path == "" {
+		return w.Message
+	}
+	return w.Path + ": " + w.Message
+}
+
+// WarningLog collects the warnings of one or more operations. It is safe for
+// concurrent use; its Add method can be used directly as Options.Warn.
+type WarningLog struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+// Add records a warning
+func (l *WarningLog) Add(w Warning) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, w)
+}
+
+// Warnings returns the recorded warnings in the order they were reported
+func (l *WarningLog) Warnings() []Warning {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Warning(nil), l.warnings...)
+}
+
+// Summary returns a human-readable list of the warnings, or "" if there were none
+func (l *WarningLog) Summary() string {
+	warnings := l.Warnings()
+	if len(warnings) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d warning%s:\n", len(warnings), plural(len(warnings), "", "s"))
+	for _, w := range warnings {
+		fmt.Fprintf(&sb, "  [%s] %s\n", w.Code, w)
+	}
+	return sb.String()
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"agcp/pkg/progress"
+)
+
+// ArchiveWriter builds a new directory archive from entries of other
+// archives, copying their compressed data as is instead of decompressing and
+// compressing it again. It is the building block for merging and splitting
+// archives, removing entries from them and compacting them. Nothing is
+// written until Close, which writes the archive the same way Compress does;
+// the source archives must stay open until then.
+type ArchiveWriter struct {
+	output   string
+	rootName string
+	opts     Options
+	entries  []Entry
+	paths    map[string]bool
+	closed   bool
+}
+
+// CreateArchive returns a writer for a directory archive at output whose
+// entries extract under rootName. Options apply as for Compress, except those
+// choosing how data is compressed: copied entries keep their codec.
+func CreateArchive(output, rootName string, opts Options) (*ArchiveWriter, error) {
+	if rootName == "" || strings.ContainsAny(rootName, `/\`) {
+		return nil, fmt.Errorf("invalid root name %q: want a single path element", rootName)
+	}
+	return &ArchiveWriter{output: output, rootName: rootName, opts: opts, paths: make(map[string]bool)}, nil
+}
+
+// Close writes the archive with the entries copied to it
+func (w *ArchiveWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("archive writer for %s is already closed", w.output)
+	}
+	w.closed = true
+
+	tracker := w.opts.newTracker(0)
+	defer tracker.Stop()
+	tracker.SetTotals(calculateTotalSize(w.entries), uint64(len(w.entries)))
+	tracker.SetPhase(progress.PhaseCompressing)
+	tracker.Start()
+	return compressFiles(w.entries, w.output, ArchiveDir, w.rootName, w.opts, tracker)
+}
+
+// CopyEntries copies the entries of src for which filter returns true, or all
+// of them if filter is nil, to dst, keeping their paths and attributes. The
+// entries of a single-file archive are copied under its file name. Entries
+// recorded without a content hash, before format v4, are decoded once to hash
+// them; the data written is still the compressed data of src. Copying a path
+// dst already holds is an error.
+func CopyEntries(dst *ArchiveWriter, src *Archive, filter func(EntryInfo) bool) error {
+	if dst.closed {
+		return fmt.Errorf("archive writer for %s is already closed", dst.output)
+	}
+	ra := NewRetryReaderAt(src.f.Name(), src.f, src.retry)
+	defer ra.Close()
+	for i, e := range src.idx.entries {
+		if filter != nil && !filter(src.info(i)) {
+			continue
+		}
+		name := src.idx.name(e)
+		if dst.paths[name] {
+			return &EntryError{Path: name, Op: "copy", Err: fmt.Errorf("%s already holds an entry with this path", dst.output)}
+		}
+		attrs := e.attrs
+		if !attrs.hasHash {
+			sum, err := hashEntry(src, e)
+			if err != nil {
+				return &EntryError{Path: name, Op: "copy", Err: err}
+			}
+			attrs.hasHash, attrs.hash = true, sum
+		}
+		dst.paths[name] = true
+		dst.entries = append(dst.entries, Entry{
+			RelPath:  name,
+			FilePath: src.f.Name() + ":" + name,
+			Size:     int64(e.originalSize),
+			attrs:    attrs,
+			kept:     &keptData{r: ra, offset: e.offset, size: e.compressedSize},
+		})
+	}
+	return nil
+}
+
+// hashEntry returns the SHA-256 of an entry's decompressed content
+func hashEntry(a *Archive, entry indexEntry) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if entry.originalSize > 0 {
+		sr, done := a.section(entry)
+		defer done()
+		zr, err := entryDecoder(sr, entry.attrs)
+		if err != nil {
+			return sum, err
+		}
+		if _, err := io.CopyN(h, zr, int64(entry.originalSize)); err != nil {
+			return sum, fmt.Errorf("decode: %w", err)
+		}
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,124 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// tickInterval is how often a running tracker samples progress and emits events
+const tickInterval = 250 * time.Millisecond
+
+// Clock is the source of time for a Tracker. The default is the system clock;
+// tests can substitute a ManualClock to control ticks and elapsed time.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the Clock backed by the time package
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time { return time.Now() }
+
+// NewTicker implements Clock
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+// systemTicker adapts a *time.Ticker to Ticker
+type systemTicker struct{ t *time.Ticker }
+
+// C implements Ticker
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+
+// Stop implements Ticker
+func (t systemTicker) Stop() { t.t.Stop() }
+
+// ManualClock is a Clock that only moves when Advance is called. Ticks are
+// delivered synchronously: Advance returns once every tick that fell due has
+// been received, so a test can assert on the events a tick produced.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManualClock returns a ManualClock reading start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now implements Clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker implements Clock
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{c: make(chan time.Time), interval: d, next: c.now.Add(d), stopped: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, delivering each tick that falls due in
+// order and waiting for it to be received. Ticks of stopped tickers are dropped.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		var due *manualTicker
+		for _, t := range c.tickers {
+			if !t.isStopped() && !t.next.After(target) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		c.now = due.next
+		due.next = due.next.Add(due.interval)
+		now := c.now
+		c.mu.Unlock()
+		select {
+		case due.c <- now:
+		case <-due.stopped:
+		}
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// manualTicker is a Ticker driven by a ManualClock
+type manualTicker struct {
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// C implements Ticker
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+// Stop implements Ticker
+func (t *manualTicker) Stop() { t.once.Do(func() { close(t.stopped) }) }
+
+// isStopped reports whether Stop has been called
+func (t *manualTicker) isStopped() bool {
+	select {
+	case <-t.stopped:
+		return true
+	default:
+		return false
+	}
+}
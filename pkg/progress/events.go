@@ -0,0 +1,185 @@
+package progress
+
+import (
+	"math"
+	"time"
+)
+
+// Phase identifies the stage an operation is in
+type Phase string
+
+const (
+	PhaseScanning    Phase = "scanning"    // Walking the input to find entries
+	PhaseCompressing Phase = "compressing" // Compressing entries into the archive
+	PhaseWriting     Phase = "writing"     // Extracting entries to disk
+	PhaseVerifying   Phase = "verifying"   // Checking archive contents
+	PhaseDone        Phase = "done"        // Operation finished; sent once at the end
+)
+
+// Event is a snapshot of an operation's progress, delivered on the channel set
+// with SetEvents whenever the phase changes and periodically while it runs
+type Event struct {
+	Phase      Phase
+	EntryPath  string        // Entry most recently started
+	BytesDone  uint64        // Bytes processed so far
+	BytesTotal uint64        // Total bytes, 0 while scanning
+	FilesDone  uint64        // Entries finished so far
+	FilesTotal uint64        // Total entries, 0 while scanning
+	Rate       uint64        // Current throughput in bytes per second
+	ReadDone   uint64        // Compressed bytes read from the archive, when tracked
+	ReadTotal  uint64        // Compressed bytes to read, 0 unless reads are tracked
+	ReadRate   uint64        // Current compressed read throughput in bytes per second
+	ETA        time.Duration // Estimated time remaining, 0 when unknown
+	Elapsed    time.Duration // Time since the tracker was created
+}
+
+// SetEvents sets the channel receiving progress events. Events are sent without
+// blocking, so a consumer that falls behind misses intermediate snapshots; use a
+// buffered channel. The channel is never closed by the tracker.
+func (t *Tracker) SetEvents(ch chan<- Event) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = ch
+}
+
+// SetTotals sets the total bytes and entries of the operation once they are known.
+// Call it before Start so the console output uses the right total.
+func (t *Tracker) SetTotals(bytes, files uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bytes == 0 {
+		bytes = 1 // Avoid division by zero
+	}
+	t.total = bytes
+	t.filesTotal = files
+}
+
+// SetReadTotal starts tracking the compressed bytes read from the archive
+// alongside the bytes processed, for operations whose throughput may be bound
+// by reading rather than writing. ETAs then follow whichever of the two streams
+// is further behind.
+func (t *Tracker) SetReadTotal(bytes uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readTotal = bytes
+}
+
+// SetPhase moves the operation into a new phase and emits an event
+func (t *Tracker) SetPhase(p Phase) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.phase = p
+	t.mu.Unlock()
+	t.emit()
+}
+
+// StartEntry records the entry currently being processed
+func (t *Tracker) StartEntry(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry = path
+}
+
+// FinishEntry counts the entry with the given path as done and passes it to
+// the Reporter
+func (t *Tracker) FinishEntry(path string) {
+	if t == nil {
+		return
+	}
+	t.filesDone.Add(1)
+	t.mu.Lock()
+	reporter := t.reporter
+	t.mu.Unlock()
+	reporter.OnFileDone(path)
+}
+
+// emit sends a snapshot event as of the current time, without rates
+func (t *Tracker) emit() {
+	t.mu.Lock()
+	now := t.clock.Now()
+	t.mu.Unlock()
+	t.send(t.snapshot(now, 0, 0))
+}
+
+// snapshot returns the tracker's state at now, with the given rates and the
+// ETA they give
+func (t *Tracker) snapshot(now time.Time, rate, readRate uint64) Event {
+	t.mu.Lock()
+	ev := Event{
+		Phase:      t.phase,
+		EntryPath:  t.entry,
+		BytesDone:  t.processed.Load(),
+		FilesDone:  t.filesDone.Load(),
+		FilesTotal: t.filesTotal,
+		Rate:       rate,
+		ReadDone:   t.read.Load(),
+		ReadTotal:  t.readTotal,
+		ReadRate:   readRate,
+		Elapsed:    now.Sub(t.startTime),
+	}
+	total := t.total
+	if t.phase != PhaseScanning {
+		ev.BytesTotal = total
+	}
+	t.mu.Unlock()
+
+	if rate > 0 {
+		ev.ETA = eta(remaining(total, ev.BytesDone), rate, remaining(ev.ReadTotal, ev.ReadDone), readRate)
+	}
+	return ev
+}
+
+// send sends ev if a channel is set, dropping it if the consumer is not ready
+func (t *Tracker) send(ev Event) {
+	t.mu.Lock()
+	ch := t.events
+	t.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- ev:
+	default:
+	}
+}
+
+// finish emits the final event of the operation
+func (t *Tracker) finish() {
+	t.mu.Lock()
+	t.phase = PhaseDone
+	t.mu.Unlock()
+	t.emit()
+}
+
+// eta estimates the time until bytesRemaining are processed at rate bytes per
+// second, or, if reads are tracked and would take longer, until readRemaining
+// are read at readRate. rate must not be zero.
+func eta(bytesRemaining, rate, readRemaining, readRate uint64) time.Duration {
+	seconds := float64(bytesRemaining) / float64(rate)
+	if readRate > 0 {
+		seconds = math.Max(seconds, float64(readRemaining)/float64(readRate))
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// remaining returns the part of total not yet done
+func remaining(total, done uint64) uint64 {
+	if done > total {
+		return 0
+	}
+	return total - done
+}
@@ -0,0 +1,166 @@
+package progress
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Units selects how byte quantities are rendered
+type Units int
+
+const (
+	UnitsIEC   Units = iota // 1024-based units: KiB, MiB, GiB
+	UnitsSI                 // 1000-based units: kB, MB, GB
+	UnitsBytes              // Raw byte counts and whole seconds, for machine consumption
+)
+
+// Format controls how sizes, rates, percentages, durations and times are rendered
+type Format struct {
+	Units            Units
+	DecimalSeparator string // Defaults to "." when empty
+	UTC              bool   // Render times in UTC instead of the local time zone
+	RawNames         bool   // Print entry names as stored, control characters and all
+}
+
+// DefaultFormat is the format used when none has been set
+var DefaultFormat = Format{Units: UnitsIEC, DecimalSeparator: "."}
+
+// commaLocales lists language codes that conventionally use a decimal comma
+var commaLocales = map[string]bool{
+	"bg": true, "cs": true, "da": true, "de": true, "el": true, "es": true,
+	"fi": true, "fr": true, "hr": true, "hu": true, "id": true, "it": true,
+	"lt": true, "lv": true, "nb": true, "nl": true, "nn": true, "pl": true,
+	"pt": true, "ro": true, "ru": true, "sk": true, "sl": true, "sr": true,
+	"sv": true, "tr": true, "uk": true, "vi": true,
+}
+
+// LocaleDecimalSeparator returns the decimal separator for the locale named
+// by LC_ALL, LC_NUMERIC or LANG, in that order of precedence
+func LocaleDecimalSeparator() string {
+	for _, key := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		locale := os.Getenv(key)
+		if locale == "" {
+			continue
+		}
+		lang := strings.ToLower(locale)
+		if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+			lang = lang[:i]
+		}
+		if commaLocales[lang] {
+			return ","
+		}
+		return "."
+	}
+	return "."
+}
+
+// Number formats a float with the given precision and the configured decimal separator
+func (f Format) Number(v float64, precision int) string {
+	s := fmt.Sprintf("%.*f", precision, v)
+	if f.DecimalSeparator != "" && f.DecimalSeparator != "." {
+		s = strings.Replace(s, ".", f.DecimalSeparator, 1)
+	}
+	return s
+}
+
+// Size returns a size string in the configured units
+func (f Format) Size(bytes uint64) string {
+	switch f.Units {
+	case UnitsBytes:
+		return fmt.Sprintf("%d", bytes)
+	case UnitsSI:
+		return f.scaled(bytes, 1000, "kMGTPE", "B")
+	default:
+		return f.scaled(bytes, 1024, "KMGTPE", "iB")
+	}
+}
+
+// Rate returns a throughput string in the configured units
+func (f Format) Rate(bytesPerSec uint64) string {
+	return f.Size(bytesPerSec) + "/s"
+}
+
+// Duration returns a duration string for the given number of seconds; negative
+// durations, as a clock stepping back can produce, render as zero
+func (f Format) Duration(seconds float64) string {
+	seconds = max(seconds, 0)
+	if f.Units == UnitsBytes {
+		return fmt.Sprintf("%.0fs", seconds)
+	}
+	if seconds < 60 {
+		return fmt.Sprintf("%s seconds", f.Number(seconds, 0))
+	} else if seconds < 3600 {
+		return fmt.Sprintf("%s minutes", f.Number(seconds/60, 1))
+	}
+	return fmt.Sprintf("%s hours", f.Number(seconds/3600, 1))
+}
+
+// Time returns a timestamp in the local time zone, or UTC if configured, with
+// its offset so it is unambiguous; "-" for the zero time. UnitsBytes renders
+// RFC 3339 with nanoseconds.
+func (f Format) Time(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if f.UTC {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+	if f.Units == UnitsBytes {
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format("2006-01-02 15:04:05 -0700")
+}
+
+// Name returns an entry name safe to print to a terminal. Names of printable
+// characters are returned as they are; others are escaped like Go string
+// literals without the quotes, as tar does: "a\nb" for a newline, "\x1b" for
+// an escape character, "\\" for a backslash and "\xff" for invalid UTF-8.
+func (f Format) Name(name string) string {
+	if f.RawNames || printableName(name) {
+		return name
+	}
+	quoted := strconv.Quote(name)
+	return strings.ReplaceAll(quoted[1:len(quoted)-1], `\"`, `"`)
+}
+
+// printableName reports whether name is valid UTF-8 of printable characters
+// other than a backslash, so it needs no escaping
+func printableName(name string) bool {
+	if !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r == '\\' || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// scaled renders bytes with the largest prefix that keeps the value above one
+// unit, moving to the next prefix when rounding would print a full unit
+// ("1.0 MiB" rather than "1024.0 KiB")
+func (f Format) scaled(bytes uint64, unit uint64, prefixes, suffix string) string {
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := unit, 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	v := float64(bytes) / float64(div)
+	if math.Round(v*10)/10 >= float64(unit) && exp+1 < len(prefixes) {
+		v /= float64(unit)
+		exp++
+	}
+	return fmt.Sprintf("%s %c%s", f.Number(v, 1), prefixes[exp], suffix)
+}
@@ -0,0 +1,40 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// Pacer limits the rate of writes shared by any number of Writers, so
+// concurrent workers together stay under it. A nil Pacer does not limit.
+type Pacer struct {
+	mu   sync.Mutex
+	rate float64   // Bytes per second
+	next time.Time // When the bytes paced so far are due
+}
+
+// NewPacer returns a Pacer allowing bytesPerSecond, or nil for no limit if it
+// is not positive
+func NewPacer(bytesPerSecond int64) *Pacer {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Pacer{rate: float64(bytesPerSecond)}
+}
+
+// Wait blocks until n bytes just written are due under the rate. Time spent
+// idle is not banked: a writer that pauses does not get to burst after it.
+func (p *Pacer) Wait(n int) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+	due := p.next
+	p.mu.Unlock()
+	time.Sleep(time.Until(due))
+}
@@ -0,0 +1,204 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the progress publishing protocol, sent in
+// every message
+const ProtocolVersion = 1
+
+// Message is one line of the progress publishing protocol. A publisher writes
+// each message as a single JSON object terminated by a newline, in one write,
+// so messages from processes sharing a FIFO do not interleave as long as they
+// stay under PIPE_BUF (4096 bytes on Linux). A job's messages end with exactly
+// one "done" message, whose Error is set if the job failed.
+type Message struct {
+	Version       int    `json:"v"`               // ProtocolVersion
+	Job           string `json:"job,omitempty"`   // Name given by the supervisor
+	PID           int    `json:"pid"`             // Process publishing the job
+	Operation     string `json:"op"`              // "compress" or "decompress"
+	Time          string `json:"time"`            // When the snapshot was taken, RFC 3339 in UTC
+	Phase         Phase  `json:"phase"`           // See the Phase constants
+	Entry         string `json:"entry,omitempty"` // Entry most recently started
+	BytesDone     uint64 `json:"bytes_done"`
+	BytesTotal    uint64 `json:"bytes_total"`
+	FilesDone     uint64 `json:"files_done"`
+	FilesTotal    uint64 `json:"files_total"`
+	Rate          uint64 `json:"rate"` // Bytes per second
+	ReadDone      uint64 `json:"read_done,omitempty"`
+	ReadTotal     uint64 `json:"read_total,omitempty"`
+	ReadRate      uint64 `json:"read_rate,omitempty"`
+	ETAMillis     int64  `json:"eta_ms"` // 0 when unknown
+	ElapsedMillis int64  `json:"elapsed_ms"`
+	Error         string `json:"error,omitempty"` // Why the job failed; only in the done message
+}
+
+// Publisher writes the progress events sent on its channel to a supervisor,
+// as Message lines
+type Publisher struct {
+	w      io.WriteCloser
+	job    string
+	op     string
+	events chan Event
+	next   chan<- Event
+	done   chan struct{}
+	exited chan struct{}
+
+	mu   sync.Mutex
+	last Event
+	err  error // First write error; publishing stops after it
+}
+
+// DialPublisher connects to a supervisor listening on a Unix socket, or opens
+// a named pipe (a FIFO, or \\.\pipe\name on Windows), at path and starts a
+// publisher on it. Events are also forwarded, without blocking, to next if it
+// is not nil.
+func DialPublisher(path, job, op string, next chan<- Event) (*Publisher, error) {
+	var w io.WriteCloser
+	var err error
+	if info, statErr := os.Stat(path); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+		w, err = net.Dial("unix", path)
+	} else {
+		w, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPublisher(w, job, op, next), nil
+}
+
+// NewPublisher starts a publisher writing messages to w, which it closes on
+// Close. Events are also forwarded, without blocking, to next if it is not nil.
+func NewPublisher(w io.WriteCloser, job, op string, next chan<- Event) *Publisher {
+	p := &Publisher{
+		w:      w,
+		job:    job,
+		op:     op,
+		events: make(chan Event, 64),
+		next:   next,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Events returns the channel to pass to the operation, e.g. as Options.Events
+func (p *Publisher) Events() chan<- Event {
+	return p.events
+}
+
+// Close publishes the job's done message, carrying err if the job failed, and
+// closes the connection. It returns the first error writing to the supervisor.
+func (p *Publisher) Close(jobErr error) error {
+	close(p.done)
+	<-p.exited
+
+	p.mu.Lock()
+	ev := p.last
+	p.mu.Unlock()
+	ev.Phase = PhaseDone
+	msg := p.message(ev)
+	if jobErr != nil {
+		msg.Error = jobErr.Error()
+	}
+	p.write(msg)
+
+	closeErr := p.w.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return closeErr
+}
+
+// run publishes events until Close, then publishes those still buffered
+func (p *Publisher) run() {
+	defer close(p.exited)
+	for {
+		select {
+		case ev := <-p.events:
+			p.publish(ev)
+		case <-p.done:
+			for {
+				select {
+				case ev := <-p.events:
+					p.publish(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish records an event, forwards it and writes it. Done events of the
+// trackers are held back: an operation may run several, and the job is done
+// only when Close is called.
+func (p *Publisher) publish(ev Event) {
+	if p.next != nil {
+		select {
+		case p.next <- ev:
+		default:
+		}
+	}
+	if ev.Phase == PhaseDone {
+		ev.Phase = p.last.Phase
+		if ev.Phase == "" {
+			ev.Phase = PhaseScanning
+		}
+	}
+	p.mu.Lock()
+	p.last = ev
+	p.mu.Unlock()
+	p.write(p.message(ev))
+}
+
+// message converts an event into a protocol message
+func (p *Publisher) message(ev Event) Message {
+	return Message{
+		Version:       ProtocolVersion,
+		Job:           p.job,
+		PID:           os.Getpid(),
+		Operation:     p.op,
+		Time:          time.Now().UTC().Format(time.RFC3339Nano),
+		Phase:         ev.Phase,
+		Entry:         ev.EntryPath,
+		BytesDone:     ev.BytesDone,
+		BytesTotal:    ev.BytesTotal,
+		FilesDone:     ev.FilesDone,
+		FilesTotal:    ev.FilesTotal,
+		Rate:          ev.Rate,
+		ReadDone:      ev.ReadDone,
+		ReadTotal:     ev.ReadTotal,
+		ReadRate:      ev.ReadRate,
+		ETAMillis:     ev.ETA.Milliseconds(),
+		ElapsedMillis: ev.Elapsed.Milliseconds(),
+	}
+}
+
+// write sends one message line, unless an earlier write failed
+func (p *Publisher) write(msg Message) {
+	p.mu.Lock()
+	failed := p.err != nil
+	p.mu.Unlock()
+	if failed {
+		return
+	}
+	line, err := json.Marshal(msg)
+	if err == nil {
+		_, err = p.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+	}
+}
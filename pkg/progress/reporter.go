@@ -0,0 +1,158 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reporter receives the progress of an operation from its Tracker, for
+// programs embedding agcp that show progress their own way. The default,
+// used by trackers without one set, prints progress lines to standard output
+// (see SetReporter). OnFileDone is called from the goroutines processing
+// entries, possibly several at once, and the other methods from the tracker's
+// own goroutine, so implementations must be safe for concurrent use and should
+// return quickly.
+type Reporter interface {
+	// OnStart is called once as the tracker starts, with a snapshot of the
+	// operation's totals
+	OnStart(ev Event)
+
+	// OnBytes is called on every tick while the operation runs, with a
+	// snapshot of the bytes done, rates and ETA
+	OnBytes(ev Event)
+
+	// OnFileDone is called as each entry is finished, with its path
+	OnFileDone(path string)
+
+	// OnFinish is called once when a started tracker stops, with the final
+	// snapshot
+	OnFinish(ev Event)
+}
+
+// console is the default Reporter, printing progress lines to standard output
+type console struct {
+	name     string // Operation name; "Processing" if empty
+	testMode bool
+	quiet    bool
+	format   Format
+
+	mu             sync.Mutex
+	start          time.Duration // Elapsed time at OnStart
+	lastOutput     time.Duration // Elapsed time of the last progress line
+	prevPercentage float64
+}
+
+// printf prints to standard output unless the reporter is quiet
+func (c *console) printf(format string, args ...any) {
+	if !c.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// op returns the operation's description
+func (c *console) op() string {
+	if c.name != "" {
+		return c.name
+	}
+	return "Processing"
+}
+
+// OnStart implements Reporter
+func (c *console) OnStart(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start, c.lastOutput = ev.Elapsed, ev.Elapsed
+	if c.testMode {
+		c.printf("%s%s▶ Starting %s...%s\n", colorBold, colorBlue, c.op(), colorReset)
+	} else {
+		c.printf("Starting %s...\n", c.op())
+	}
+}
+
+// OnBytes implements Reporter, printing a line when there's significant change
+// or enough time has passed
+func (c *console) OnBytes(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op, f := c.op(), c.format
+	totalSize := max(ev.BytesTotal, 1)
+	bytesRemaining := remaining(totalSize, ev.BytesDone)
+	currentPercentage := float64(ev.BytesDone) / float64(totalSize) * 100
+
+	percentageDiff := currentPercentage - c.prevPercentage
+	shouldUpdate := ev.Elapsed-c.lastOutput >= time.Second ||
+		percentageDiff >= 10 ||
+		(currentPercentage >= 100 && c.prevPercentage < 100)
+
+	if shouldUpdate {
+		c.lastOutput = ev.Elapsed
+
+		// Show different output for test mode vs normal mode
+		if c.testMode {
+			// Only show progress at key percentages for tests
+			if currentPercentage >= 100 && c.prevPercentage < 100 {
+				pb := progressBar(100, 20)
+				c.printf("%s%s✓ %s complete! %s 100%%%s\n",
+					colorBold, colorGreen, op, pb, colorReset)
+			} else if percentageDiff >= 25 || currentPercentage >= 100 {
+				pb := progressBar(currentPercentage, 20)
+				c.printf("%s%s• %s progress: %s %.0f%%%s\n",
+					colorBold, colorBlue, op, pb, currentPercentage, colorReset)
+			}
+		} else {
+			// Normal mode - more detailed output
+			sizeInfo := f.Size(ev.BytesDone)
+			rateInfo := f.Rate(ev.Rate)
+
+			if totalSize > 1 {
+				totalSizeInfo := f.Size(totalSize)
+				etaInfo := calculateETA(f, bytesRemaining, ev.Rate)
+				pb := progressBar(currentPercentage, 20)
+
+				var readInfo string
+				if ev.ReadTotal > 0 {
+					readInfo = fmt.Sprintf(" | Read: %s of %s at %s", f.Size(ev.ReadDone), f.Size(ev.ReadTotal), f.Rate(ev.ReadRate))
+					if ev.Rate > 0 {
+						etaInfo = f.Duration(ev.ETA.Seconds())
+					}
+				}
+
+				c.printf("%s %s of %s %s %s%% | Rate: %s%s | ETA: %s\n",
+					op, sizeInfo, totalSizeInfo, pb, f.Number(currentPercentage, 1), rateInfo, readInfo, etaInfo)
+			} else {
+				c.printf("%s %s | Rate: %s\n", op, sizeInfo, rateInfo)
+			}
+		}
+	}
+
+	c.prevPercentage = currentPercentage
+	os.Stdout.Sync()
+}
+
+// OnFileDone implements Reporter; the console reports bytes, not files
+func (c *console) OnFileDone(string) {}
+
+// OnFinish implements Reporter, printing the totals
+func (c *console) OnFinish(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op, f := c.op(), c.format
+	totalTime := (ev.Elapsed - c.start).Seconds()
+	sizeInfo := f.Size(ev.BytesDone)
+
+	if c.testMode {
+		c.printf("%s%s✓ %s completed: %s in %.1f seconds%s\n",
+			colorBold, colorGreen, op, sizeInfo, totalTime, colorReset)
+		return
+	}
+	var avgRate string
+	if totalTime > 0 {
+		avgRate = f.Rate(uint64(float64(ev.BytesDone) / totalTime))
+	} else {
+		avgRate = f.Rate(0)
+	}
+	c.printf("%s completed: %s in %s seconds (avg rate: %s)\n",
+		op, sizeInfo, f.Number(totalTime, 1), avgRate)
+}
@@ -1,253 +1,333 @@
-package progress
-
-import (
-	"fmt"
-	"io"
-	"os"
-	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
-)
-
-// Colors for terminal output
-const (
-	colorReset  = "\033[0m"
-	colorBold   = "\033[1m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorCyan   = "\033[36m"
-)
-
-// Global variables for progress tracking
-var (
-	totalBytesProcessed atomic.Uint64
-	totalSize           uint64
-	done                chan struct{}
-	progressRunning     bool
-	progressMutex       sync.Mutex
-	isTestMode          bool   // Flag to indicate test mode
-	operationName       string // Operation name for output
-)
-
-// Init initializes the progress tracking system
-func Init(size uint64) {
-	progressMutex.Lock()
-	defer progressMutex.Unlock()
-
-	if progressRunning {
-		return
-	}
-
-	totalBytesProcessed.Store(0)
-	totalSize = size
-	if totalSize == 0 {
-		totalSize = 1 // Avoid division by zero
-	}
-
-	done = make(chan struct{})
-	progressRunning = true
-	go logger()
-}
-
-// SetTestMode enables or disables test mode
-// In test mode, progress output is formatted for better readability in tests
-func SetTestMode(enabled bool) {
-	progressMutex.Lock()
-	defer progressMutex.Unlock()
-	isTestMode = enabled
-}
-
-// SetOperationName sets the current operation name for output
-func SetOperationName(name string) {
-	progressMutex.Lock()
-	defer progressMutex.Unlock()
-	operationName = name
-}
-
-// Stop stops the progress tracking
-func Stop() {
-	progressMutex.Lock()
-	defer progressMutex.Unlock()
-
-	if progressRunning {
-		close(done)
-		progressRunning = false
-	}
-}
-
-// AddBytes adds processed bytes to the counter
-func AddBytes(n uint64) {
-	if n > 0 {
-		totalBytesProcessed.Add(n)
-	}
-}
-
-// formatSize returns a human-readable size string
-func formatSize(bytes uint64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := uint64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
-
-// formatRate returns a human-readable rate string
-func formatRate(bytesPerSec uint64) string {
-	const unit = 1024
-	if bytesPerSec < unit {
-		return fmt.Sprintf("%d B/s", bytesPerSec)
-	}
-	div, exp := uint64(unit), 0
-	for n := bytesPerSec / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %ciB/s", float64(bytesPerSec)/float64(div), "KMGTPE"[exp])
-}
-
-// progressBar returns a visual progress bar
-func progressBar(percentage float64, width int) string {
-	completed := int(percentage * float64(width) / 100)
-	if completed > width {
-		completed = width
-	}
-
-	bar := "["
-	bar += strings.Repeat("█", completed)
-	bar += strings.Repeat("░", width-completed)
-	bar += "]"
-
-	return bar
-}
-
-// calculateETA calculates the estimated time remaining
-func calculateETA(bytesRemaining uint64, rate uint64) string {
-	if rate == 0 {
-		return "calculating..."
-	}
-
-	secondsRemaining := float64(bytesRemaining) / float64(rate)
-
-	if secondsRemaining < 60 {
-		return fmt.Sprintf("%.0f seconds", secondsRemaining)
-	} else if secondsRemaining < 3600 {
-		return fmt.Sprintf("%.1f minutes", secondsRemaining/60)
-	} else {
-		return fmt.Sprintf("%.1f hours", secondsRemaining/3600)
-	}
-}
-
-// logger logs processing progress periodically
-func logger() {
-	ticker := time.NewTicker(250 * time.Millisecond)
-	defer ticker.Stop()
-	var prevBytes uint64
-	var prevPercentage float64
-	startTime := time.Now()
-	lastOutputTime := time.Now()
-
-	// Operation description
-	op := "Processing"
-	if operationName != "" {
-		op = operationName
-	}
-
-	// Initial output
-	if isTestMode {
-		fmt.Printf("%s%s▶ Starting %s...%s\n", colorBold, colorBlue, op, colorReset)
-	} else {
-		fmt.Printf("Starting %s...\n", op)
-	}
-
-	for {
-		select {
-		case <-ticker.C:
-			currentBytes := totalBytesProcessed.Load()
-			rate := (currentBytes - prevBytes) * 4 // Bytes per second (250ms interval)
-			prevBytes = currentBytes
-
-			bytesRemaining := totalSize - currentBytes
-			currentPercentage := float64(currentBytes) / float64(totalSize) * 100
-
-			// Only show update if there's significant change or enough time has passed
-			timeSinceLastOutput := time.Since(lastOutputTime)
-			percentageDiff := currentPercentage - prevPercentage
-			shouldUpdate := timeSinceLastOutput >= time.Second ||
-				percentageDiff >= 10 ||
-				(currentPercentage >= 100 && prevPercentage < 100)
-
-			if shouldUpdate {
-				lastOutputTime = time.Now()
-
-				// Show different output for test mode vs normal mode
-				if isTestMode {
-					// Only show progress at key percentages for tests
-					if currentPercentage >= 100 && prevPercentage < 100 {
-						pb := progressBar(100, 20)
-						fmt.Printf("%s%s✓ %s complete! %s 100%%%s\n",
-							colorBold, colorGreen, op, pb, colorReset)
-					} else if percentageDiff >= 25 || currentPercentage >= 100 {
-						pb := progressBar(currentPercentage, 20)
-						fmt.Printf("%s%s• %s progress: %s %.0f%%%s\n",
-							colorBold, colorBlue, op, pb, currentPercentage, colorReset)
-					}
-				} else {
-					// Normal mode - more detailed output
-					sizeInfo := formatSize(currentBytes)
-					rateInfo := formatRate(rate)
-
-					if totalSize > 1 {
-						totalSizeInfo := formatSize(totalSize)
-						etaInfo := calculateETA(bytesRemaining, rate)
-						pb := progressBar(currentPercentage, 20)
-
-						fmt.Printf("%s %s of %s %s %.1f%% | Rate: %s | ETA: %s\n",
-							op, sizeInfo, totalSizeInfo, pb, currentPercentage, rateInfo, etaInfo)
-					} else {
-						fmt.Printf("%s %s | Rate: %s\n", op, sizeInfo, rateInfo)
-					}
-				}
-			}
-
-			prevPercentage = currentPercentage
-			os.Stdout.Sync()
-
-		case <-done:
-			// Final output on completion
-			processedBytes := totalBytesProcessed.Load()
-			totalTime := time.Since(startTime).Seconds()
-			sizeInfo := formatSize(processedBytes)
-
-			if isTestMode {
-				fmt.Printf("%s%s✓ %s completed: %s in %.1f seconds%s\n",
-					colorBold, colorGreen, op, sizeInfo, totalTime, colorReset)
-			} else {
-				avgRate := formatRate(uint64(float64(processedBytes) / totalTime))
-				fmt.Printf("%s completed: %s in %.1f seconds (avg rate: %s)\n",
-					op, sizeInfo, totalTime, avgRate)
-			}
-			return
-		}
-	}
-}
-
-// Writer is a writer that tracks bytes written for progress reporting
-type Writer struct {
-	W io.Writer
-}
-
-// Write implements io.Writer and tracks bytes written
-func (pw *Writer) Write(p []byte) (n int, err error) {
-	n, err = pw.W.Write(p)
-	if err == nil && n > 0 {
-		AddBytes(uint64(n))
-	}
-	return
-}
+package progress
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Colors for terminal output
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+	colorCyan   = "\033[36m"
+)
+
+// Global settings applied to trackers when they are created
+var (
+	progressMutex  sync.Mutex
+	isTestMode     bool   // Flag to indicate test mode
+	isQuiet        bool   // Print no progress lines
+	operationName  string // Operation name for output
+	outputFormat   = DefaultFormat
+	defaultTracker *Tracker // Tracker driven by the package-level Init/Stop/AddBytes
+)
+
+// Tracker tracks and reports the progress of a single operation. Each
+// compression or decompression owns its own Tracker, so concurrent operations
+// never share counters. A nil *Tracker is valid and discards all updates.
+type Tracker struct {
+	processed atomic.Uint64
+	read      atomic.Uint64 // Compressed bytes read, see SetReadTotal
+	filesDone atomic.Uint64
+
+	mu         sync.Mutex
+	running    bool
+	done       chan struct{}
+	exited     chan struct{} // Closed when the logger has reported its final snapshot
+	total      uint64
+	filesTotal uint64
+	readTotal  uint64
+	phase      Phase
+	entry      string
+	events     chan<- Event
+	reporter   Reporter
+	clock      Clock
+	startTime  time.Time
+}
+
+// NewTracker creates a tracker for an operation of the given total size,
+// reporting to the console with the current test mode, quiet, operation name
+// and format settings
+func NewTracker(size uint64) *Tracker {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+
+	if size == 0 {
+		size = 1 // Avoid division by zero
+	}
+	return &Tracker{
+		total: size,
+		reporter: &console{
+			name:     operationName,
+			testMode: isTestMode,
+			quiet:    isQuiet,
+			format:   outputFormat,
+		},
+		clock:     systemClock{},
+		startTime: time.Now(),
+	}
+}
+
+// SetReporter sets the Reporter receiving the tracker's progress instead of
+// the console. A nil Reporter keeps the current one. Call it before Start.
+func (t *Tracker) SetReporter(r Reporter) {
+	if t == nil || r == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reporter = r
+}
+
+// SetClock sets the clock the tracker reads time and ticks from, restarting its
+// elapsed time. Call it before Start.
+func (t *Tracker) SetClock(c Clock) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+	t.startTime = c.Now()
+}
+
+// Start begins periodic progress reporting
+func (t *Tracker) Start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running {
+		return
+	}
+	t.done = make(chan struct{})
+	t.exited = make(chan struct{})
+	t.running = true
+	go func(done, exited chan struct{}, ticker Ticker) {
+		defer close(exited)
+		t.logger(done, ticker)
+	}(t.done, t.exited, t.clock.NewTicker(tickInterval))
+}
+
+// Stop stops progress reporting, waiting for the final progress line, and
+// emits the final event
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	finished := t.phase == PhaseDone
+	var exited chan struct{}
+	if t.running {
+		close(t.done)
+		t.running = false
+		exited = t.exited
+	}
+	t.mu.Unlock()
+	if exited != nil {
+		<-exited
+	}
+
+	if !finished {
+		t.finish()
+	}
+}
+
+// AddBytes adds processed bytes to the counter
+func (t *Tracker) AddBytes(n uint64) {
+	if t != nil && n > 0 {
+		t.processed.Add(n)
+	}
+}
+
+// AddRead adds compressed bytes read from the archive to the read counter
+func (t *Tracker) AddRead(n uint64) {
+	if t != nil && n > 0 {
+		t.read.Add(n)
+	}
+}
+
+// Processed returns the number of bytes processed so far
+func (t *Tracker) Processed() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.processed.Load()
+}
+
+// Init initializes the package-level progress tracker.
+//
+// Deprecated: core operations create their own Tracker; use NewTracker for
+// independent progress reporting.
+func Init(size uint64) {
+	progressMutex.Lock()
+	running := defaultTracker != nil
+	progressMutex.Unlock()
+	if running {
+		return
+	}
+
+	t := NewTracker(size)
+	t.Start()
+
+	progressMutex.Lock()
+	defaultTracker = t
+	progressMutex.Unlock()
+}
+
+// SetTestMode enables or disables test mode
+// In test mode, progress output is formatted for better readability in tests
+func SetTestMode(enabled bool) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	isTestMode = enabled
+}
+
+// SetQuiet enables or disables quiet mode, in which trackers print no
+// progress lines; events are still emitted
+func SetQuiet(enabled bool) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	isQuiet = enabled
+}
+
+// SetOperationName sets the current operation name for output
+func SetOperationName(name string) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	operationName = name
+}
+
+// SetFormat sets how sizes, rates and durations are rendered in progress output
+func SetFormat(f Format) {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	outputFormat = f
+}
+
+// CurrentFormat returns the format used for progress output
+func CurrentFormat() Format {
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	return outputFormat
+}
+
+// Stop stops the package-level progress tracker
+func Stop() {
+	progressMutex.Lock()
+	t := defaultTracker
+	defaultTracker = nil
+	progressMutex.Unlock()
+
+	t.Stop()
+}
+
+// AddBytes adds processed bytes to the package-level tracker
+func AddBytes(n uint64) {
+	progressMutex.Lock()
+	t := defaultTracker
+	progressMutex.Unlock()
+
+	t.AddBytes(n)
+}
+
+// progressBar returns a visual progress bar
+func progressBar(percentage float64, width int) string {
+	completed := int(percentage * float64(width) / 100)
+	if completed > width {
+		completed = width
+	}
+
+	bar := "["
+	bar += strings.Repeat("█", completed)
+	bar += strings.Repeat("░", width-completed)
+	bar += "]"
+
+	return bar
+}
+
+// calculateETA calculates the estimated time remaining
+func calculateETA(f Format, bytesRemaining uint64, rate uint64) string {
+	if rate == 0 {
+		return "calculating..."
+	}
+
+	return f.Duration(float64(bytesRemaining) / float64(rate))
+}
+
+// logger reports progress to the tracker's events channel and Reporter on
+// every tick until done is closed
+func (t *Tracker) logger(done chan struct{}, ticker Ticker) {
+	defer ticker.Stop()
+	var prevBytes, prevRead uint64
+	t.mu.Lock()
+	clock, reporter := t.clock, t.reporter
+	t.mu.Unlock()
+	reporter.OnStart(t.snapshot(clock.Now(), 0, 0))
+
+	for {
+		select {
+		case now := <-ticker.C():
+			currentBytes := t.processed.Load()
+			rate := uint64(float64(currentBytes-prevBytes) / tickInterval.Seconds()) // Bytes per second
+			prevBytes = currentBytes
+			currentRead := t.read.Load()
+			readRate := uint64(float64(currentRead-prevRead) / tickInterval.Seconds())
+			prevRead = currentRead
+
+			ev := t.snapshot(now, rate, readRate)
+			t.send(ev)
+			reporter.OnBytes(ev)
+
+		case <-done:
+			ev := t.snapshot(clock.Now(), 0, 0)
+			ev.Phase = PhaseDone
+			reporter.OnFinish(ev)
+			return
+		}
+	}
+}
+
+// Reader is a reader that tracks compressed bytes read for progress reporting
+type Reader struct {
+	R io.Reader
+	T *Tracker // Tracker to credit with AddRead; nil credits nothing
+}
+
+// Read implements io.Reader and tracks bytes read
+func (pr *Reader) Read(p []byte) (n int, err error) {
+	n, err = pr.R.Read(p)
+	if n > 0 {
+		pr.T.AddRead(uint64(n))
+	}
+	return
+}
+
+// Writer is a writer that tracks bytes written for progress reporting
+type Writer struct {
+	W io.Writer
+	T *Tracker // Tracker to credit; nil credits the package-level tracker
+	P *Pacer   // Pacer to hold writes to its rate; nil writes at full speed
+}
+
+// Write implements io.Writer and tracks bytes written
+func (pw *Writer) Write(p []byte) (n int, err error) {
+	n, err = pw.W.Write(p)
+	if err == nil && n > 0 {
+		if pw.T != nil {
+			pw.T.AddBytes(uint64(n))
+		} else {
+			AddBytes(uint64(n))
+		}
+		pw.P.Wait(n)
+	}
+	return
+}
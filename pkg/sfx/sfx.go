@@ -0,0 +1,179 @@
+// Package sfx builds and runs self-extracting archives. A self-extracting
+// archive is an agcp executable with an AGCP archive appended to it, followed
+// by a small footer recording where the archive starts.
+//
+// The extractor is the whole agcp binary rather than a separate minimal stub:
+// it needs the codecs, the archive reader and the extraction code anyway, which
+// make up most of the binary, and reusing it keeps one build and one set of
+// release artifacts. agcp therefore checks for a payload at every start, which
+// costs a stat and a 16-byte read of its own executable.
+package sfx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Constants for the appended payload footer
+const (
+	PayloadMagic = "AGCPSFX1" // Marks an executable carrying an embedded archive
+	footerSize   = 16         // archiveLen(8) + PayloadMagic(8)
+)
+
+// Payload reports whether the executable at exePath carries an embedded archive
+// and returns the offset and length of the archive within the file
+func Payload(exePath string) (offset, size int64, ok bool, err error) {
+	f, err := os.Open(exePath)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("open executable: %w", err)
+	}
+	defer f.Close()
+	return payloadOf(f)
+}
+
+// payloadOf locates the embedded archive in an open executable
+func payloadOf(f *os.File) (offset, size int64, ok bool, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("stat executable: %w", err)
+	}
+	if info.Size() < footerSize {
+		return 0, 0, false, nil
+	}
+
+	var footer [footerSize]byte
+	if _, err := f.ReadAt(footer[:], info.Size()-footerSize); err != nil {
+		return 0, 0, false, fmt.Errorf("read payload footer: %w", err)
+	}
+	if string(footer[8:]) != PayloadMagic {
+		return 0, 0, false, nil
+	}
+
+	size = int64(binary.BigEndian.Uint64(footer[:8]))
+	offset = info.Size() - footerSize - size
+	if size <= 0 || offset < 0 {
+		return 0, 0, false, fmt.Errorf("corrupt payload footer: archive length %d", size)
+	}
+	return offset, size, true, nil
+}
+
+// Build writes a self-extracting executable to outPath consisting of the stub
+// executable followed by the archive. A payload already present in the stub is
+// dropped, so any self-extracting archive can serve as a stub.
+func Build(stubPath, archivePath, outPath string) error {
+	stub, err := os.Open(stubPath)
+	if err != nil {
+		return fmt.Errorf("open stub: %w", err)
+	}
+	defer stub.Close()
+
+	stubInfo, err := stub.Stat()
+	if err != nil {
+		return fmt.Errorf("stat stub: %w", err)
+	}
+	stubLen := stubInfo.Size()
+	if offset, _, ok, err := payloadOf(stub); err != nil {
+		return err
+	} else if ok {
+		stubLen = offset
+	}
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer archive.Close()
+
+	if outInfo, err := os.Stat(outPath); err == nil && os.SameFile(outInfo, stubInfo) {
+		return fmt.Errorf("output %s would overwrite the stub executable", outPath)
+	}
+
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, io.NewSectionReader(stub, 0, stubLen)); err != nil {
+		return fmt.Errorf("write stub: %w", err)
+	}
+	archiveLen, err := io.Copy(out, archive)
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := binary.Write(out, binary.BigEndian, uint64(archiveLen)); err != nil {
+		return fmt.Errorf("write archive length: %w", err)
+	}
+	if _, err := out.Write([]byte(PayloadMagic)); err != nil {
+		return fmt.Errorf("write payload magic: %w", err)
+	}
+	return out.Close()
+}
+
+// ExtractPayload copies the archive embedded in exePath to a new temporary file
+// in dir (or the OS default when empty) and returns its path. The caller removes it.
+func ExtractPayload(exePath, dir string) (string, error) {
+	f, err := os.Open(exePath)
+	if err != nil {
+		return "", fmt.Errorf("open executable: %w", err)
+	}
+	defer f.Close()
+
+	offset, size, ok, err := payloadOf(f)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s does not contain an embedded archive", exePath)
+	}
+
+	tmp, err := os.CreateTemp(dir, "agcp-sfx-*.agcp")
+	if err != nil {
+		return "", fmt.Errorf("create temp archive: %w", err)
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(f, offset, size)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("copy embedded archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("close temp archive: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// StubFor returns the path of an agcp executable built for target ("os/arch"),
+// to be used whole as the stub (see the package documentation). The running
+// executable is used for the host platform; other targets are looked up next to
+// it using the release artifact names (agcp-windows-amd64.exe).
+func StubFor(target string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("locate executable: %w", err)
+	}
+
+	host := runtime.GOOS + "/" + runtime.GOARCH
+	if target == "" || target == host {
+		return exe, nil
+	}
+
+	goos, goarch, found := strings.Cut(target, "/")
+	if !found || goos == "" || goarch == "" {
+		return "", fmt.Errorf("invalid target %q: expected os/arch, e.g. windows/amd64", target)
+	}
+	name := fmt.Sprintf("agcp-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	stub := filepath.Join(filepath.Dir(exe), name)
+	if _, err := os.Stat(stub); err != nil {
+		return "", fmt.Errorf("no stub for %s: place %s next to agcp or pass --stub", target, name)
+	}
+	return stub, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// defaultNice is the nice value --nice sets when given without a value
+const defaultNice = 10
+
+// errPriorityUnsupported is returned where a platform cannot lower a priority
+var errPriorityUnsupported = errors.New("not supported on this platform")
+
+// niceValue is the --nice flag: a nice value from 1 to 19, or defaultNice when
+// the flag is given alone
+type niceValue int
+
+// String implements flag.Value
+func (v *niceValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+// Set implements flag.Value
+func (v *niceValue) Set(s string) error {
+	if s == "true" {
+		*v = defaultNice
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 19 {
+		return fmt.Errorf("nice value %q must be from 1 to 19", s)
+	}
+	*v = niceValue(n)
+	return nil
+}
+
+// IsBoolFlag lets --nice be given without a value
+func (v *niceValue) IsBoolFlag() bool {
+	return true
+}
+
+// addPriorityFlags registers the scheduling priority flags on fs and returns a
+// function that, once flags are parsed, lowers the process's CPU and IO
+// priority as asked, so a backup doesn't slow down interactive work. A priority
+// the platform cannot lower is reported with a warning, not an error.
+func addPriorityFlags(fs *flag.FlagSet) func() error {
+	var nice niceValue
+	fs.Var(&nice, "nice", fmt.Sprintf("lower CPU priority: --nice for nice %d, or --nice=N for 1-19 (below normal on Windows)", defaultNice))
+	ionice := fs.String("ionice", "", "lower IO priority: idle reads and writes only when the disk is otherwise idle (background mode on macOS and Windows)")
+	return func() error {
+		if *ionice != "" && *ionice != "idle" {
+			return fmt.Errorf("unknown --ionice class %q: want idle", *ionice)
+		}
+		if nice > 0 {
+			if err := lowerCPUPriority(int(nice)); err != nil {
+				printWarning(fmt.Sprintf("cannot lower CPU priority: %v", err))
+			}
+		}
+		if *ionice == "idle" {
+			if err := lowerIOPriority(); err != nil {
+				printWarning(fmt.Sprintf("cannot lower IO priority: %v", err))
+			}
+		}
+		return nil
+	}
+}
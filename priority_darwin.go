@@ -0,0 +1,23 @@
+//go:build darwin
+
+package main
+
+import "syscall"
+
+// setpriority arguments for the Darwin background band
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+// lowerCPUPriority sets the nice value of the process
+func lowerCPUPriority(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
+
+// lowerIOPriority moves the process into the background band, the QoS of
+// Time Machine and Spotlight indexing: its disk and network IO is throttled
+// and it runs on the CPU at the lowest priority
+func lowerIOPriority() error {
+	return syscall.Setpriority(prioDarwinProcess, 0, prioDarwinBG)
+}
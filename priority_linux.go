@@ -0,0 +1,56 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set arguments: the target is a thread ID, and the idle class takes
+// no priority level
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerCPUPriority sets the nice value of every thread of the process. Linux
+// keeps a nice value per thread, and threads the Go runtime starts later
+// inherit it from the thread that starts them.
+func lowerCPUPriority(nice int) error {
+	return forEachThread(func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// lowerIOPriority puts every thread of the process in the idle IO scheduling
+// class, which is served only when no other process uses the disk
+func lowerIOPriority() error {
+	return forEachThread(func(tid int) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// forEachThread calls fn with the ID of each thread of the process
+func forEachThread(fn func(tid int) error) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fn(0) // Without /proc, at least the calling thread
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil && err != syscall.ESRCH { // ESRCH: the thread exited meanwhile
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !(darwin || linux || windows)
+
+package main
+
+// lowerCPUPriority reports that this platform is not supported; run agcp
+// under nice instead
+func lowerCPUPriority(nice int) error {
+	return errPriorityUnsupported
+}
+
+// lowerIOPriority reports that this platform is not supported
+func lowerIOPriority() error {
+	return errPriorityUnsupported
+}
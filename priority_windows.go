@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+const (
+	belowNormalPriorityClass   = 0x00004000
+	processModeBackgroundBegin = 0x00100000
+)
+
+// lowerCPUPriority puts the process in the below normal priority class.
+// Windows has no finer levels for it, so the nice value is not used.
+func lowerCPUPriority(nice int) error {
+	return setPriorityClass(belowNormalPriorityClass)
+}
+
+// lowerIOPriority puts the process in background processing mode, which
+// lowers its IO, memory and CPU priority
+func lowerIOPriority() error {
+	return setPriorityClass(processModeBackgroundBegin)
+}
+
+// setPriorityClass calls SetPriorityClass on the current process
+func setPriorityClass(class uintptr) error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if r, _, err := procSetPriorityClass.Call(uintptr(process), class); r == 0 {
+		return err
+	}
+	return nil
+}
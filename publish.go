@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// addPublishFlags registers the progress publishing flags on fs and returns a
+// function that, once flags are parsed, starts publishing the operation's
+// progress if asked to. Call the returned stop function with the operation's
+// result once it has returned.
+func addPublishFlags(fs *flag.FlagSet) func(op string, opts *core.Options) (stop func(err error), err error) {
+	socket := fs.String("progress-socket", "", "publish progress as JSON lines to this Unix socket or named pipe, for a supervisor")
+	job := fs.String("progress-job", "", "job name sent with published progress (default none; messages carry the process ID)")
+
+	return func(op string, opts *core.Options) (func(error), error) {
+		if *socket == "" {
+			return func(error) {}, nil
+		}
+		p, err := progress.DialPublisher(*socket, *job, op, opts.Events)
+		if err != nil {
+			return nil, fmt.Errorf("connect to progress socket: %w", err)
+		}
+		opts.Events = p.Events()
+		return func(err error) {
+			if err := p.Close(err); err != nil {
+				printWarning(fmt.Sprintf("publish progress to %s: %v", *socket, err))
+			}
+		}, nil
+	}
+}
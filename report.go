@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// reportSampleInterval is the least time between two throughput samples in a
+// report; phase changes are always sampled
+const reportSampleInterval = time.Second
+
+// runReport is the content of a --report file
+type runReport struct {
+	Operation  string            `json:"operation"`
+	Command    []string          `json:"command"`
+	Settings   map[string]string `json:"settings"` // Every flag of the operation, with the value used
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Seconds    float64           `json:"duration_seconds"`
+	Result     string            `json:"result"` // "ok" or "failed"
+	Error      string            `json:"error,omitempty"`
+	Totals     reportTotals      `json:"totals"`
+	Entries    []reportEntry     `json:"entries"`
+	Warnings   []core.Warning    `json:"warnings"`
+	Throughput []reportSample    `json:"throughput"`
+}
+
+// reportTotals sums up the entries of a report
+type reportTotals struct {
+	Entries         int     `json:"entries"`
+	Skipped         int     `json:"skipped"`
+	Failed          int     `json:"failed"`
+	OriginalBytes   uint64  `json:"original_bytes"`
+	CompressedBytes uint64  `json:"compressed_bytes"`
+	Ratio           float64 `json:"ratio"` // Compressed over original bytes
+	BytesPerSecond  float64 `json:"bytes_per_second"`
+}
+
+// reportEntry is one entry's result
+type reportEntry struct {
+	Path            string  `json:"path"`
+	Codec           string  `json:"codec"`
+	OriginalBytes   uint64  `json:"original_bytes"`
+	CompressedBytes uint64  `json:"compressed_bytes"`
+	Seconds         float64 `json:"seconds"`
+	Skipped         bool    `json:"skipped,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// reportSample is a point of the throughput chart
+type reportSample struct {
+	Seconds        float64        `json:"seconds"` // Since the operation started
+	Phase          progress.Phase `json:"phase"`
+	BytesDone      uint64         `json:"bytes_done"`
+	FilesDone      uint64         `json:"files_done"`
+	BytesPerSecond uint64         `json:"bytes_per_second"`
+}
+
+// reportRecorder collects an operation's entry results, warnings and progress
+// events for its report
+type reportRecorder struct {
+	report runReport
+	path   string
+	events chan progress.Event
+	next   chan<- progress.Event
+	done   chan struct{}
+	exited chan struct{}
+
+	mu         sync.Mutex
+	lastSample time.Duration
+}
+
+// addReportFlags registers the report flag on fs and returns a function that,
+// once flags are parsed, starts recording the operation if a report was asked
+// for. Call the returned finish function with the operation's result once it
+// has returned; it returns that result, or the error writing the report.
+func addReportFlags(fs *flag.FlagSet) func(op string, opts *core.Options) (finish func(err error) error, err error) {
+	path := fs.String("report", "", "write a report of the run (settings, entries, warnings, timings, throughput) to this .json or .html file")
+
+	return func(op string, opts *core.Options) (func(error) error, error) {
+		if *path == "" {
+			return func(err error) error { return err }, nil
+		}
+		switch strings.ToLower(filepath.Ext(*path)) {
+		case ".json", ".html", ".htm":
+		default:
+			return nil, fmt.Errorf("invalid --report %s: the name must end in .json or .html", *path)
+		}
+
+		r := &reportRecorder{
+			report: runReport{
+				Operation: op,
+				Command:   os.Args,
+				Settings:  make(map[string]string),
+				Started:   time.Now(),
+				Entries:   []reportEntry{},
+				Warnings:  []core.Warning{},
+			},
+			path:   *path,
+			events: make(chan progress.Event, 64),
+			next:   opts.Events,
+			done:   make(chan struct{}),
+			exited: make(chan struct{}),
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			r.report.Settings[f.Name] = f.Value.String()
+		})
+		opts.Events = r.events
+		warn := opts.Warn
+		opts.Warn = func(w core.Warning) {
+			r.mu.Lock()
+			r.report.Warnings = append(r.report.Warnings, w)
+			r.mu.Unlock()
+			if warn != nil {
+				warn(w)
+			}
+		}
+		onEntry := opts.OnEntry
+		opts.OnEntry = func(e core.EntryResult) {
+			r.addEntry(e)
+			if onEntry != nil {
+				onEntry(e)
+			}
+		}
+		go r.run()
+		return r.finish, nil
+	}
+}
+
+// run samples progress events until finish, forwarding them to next
+func (r *reportRecorder) run() {
+	defer close(r.exited)
+	for {
+		select {
+		case ev := <-r.events:
+			r.sample(ev)
+		case <-r.done:
+			// Take in the final events sent as the operation stopped
+			for {
+				select {
+				case ev := <-r.events:
+					r.sample(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sample records ev as a throughput sample, at most one per
+// reportSampleInterval within a phase, and forwards it without blocking
+func (r *reportRecorder) sample(ev progress.Event) {
+	if r.next != nil {
+		select {
+		case r.next <- ev:
+		default:
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := r.report.Throughput
+	if n := len(samples); n > 0 && samples[n-1].Phase == ev.Phase && ev.Phase != progress.PhaseDone && ev.Elapsed-r.lastSample < reportSampleInterval {
+		return
+	}
+	r.lastSample = ev.Elapsed
+	r.report.Throughput = append(samples, reportSample{
+		Seconds:        ev.Elapsed.Seconds(),
+		Phase:          ev.Phase,
+		BytesDone:      ev.BytesDone,
+		FilesDone:      ev.FilesDone,
+		BytesPerSecond: ev.Rate,
+	})
+}
+
+// addEntry records an entry result
+func (r *reportRecorder) addEntry(e core.EntryResult) {
+	entry := reportEntry{
+		Path:            e.Path,
+		Codec:           e.Codec,
+		OriginalBytes:   e.OriginalSize,
+		CompressedBytes: e.CompressedSize,
+		Seconds:         e.Elapsed.Seconds(),
+		Skipped:         e.Skipped,
+	}
+	if e.Err != nil {
+		entry.Error = e.Err.Error()
+	}
+	r.mu.Lock()
+	r.report.Entries = append(r.report.Entries, entry)
+	r.mu.Unlock()
+}
+
+// finish stops recording and writes the report for an operation that returned
+// err. It returns err, or the error writing the report if err is nil.
+func (r *reportRecorder) finish(err error) error {
+	close(r.done)
+	<-r.exited
+
+	rep := &r.report
+	rep.Finished = time.Now()
+	rep.Seconds = rep.Finished.Sub(rep.Started).Seconds()
+	rep.Result = "ok"
+	if err != nil {
+		rep.Result = "failed"
+		rep.Error = err.Error()
+	}
+	if rep.Throughput == nil {
+		rep.Throughput = []reportSample{}
+	}
+	sort.Slice(rep.Entries, func(i, j int) bool { return rep.Entries[i].Path < rep.Entries[j].Path })
+	for _, e := range rep.Entries {
+		rep.Totals.Entries++
+		switch {
+		case e.Error != "":
+			rep.Totals.Failed++
+		case e.Skipped:
+			rep.Totals.Skipped++
+		}
+		rep.Totals.OriginalBytes += e.OriginalBytes
+		rep.Totals.CompressedBytes += e.CompressedBytes
+	}
+	if rep.Totals.OriginalBytes > 0 {
+		rep.Totals.Ratio = float64(rep.Totals.CompressedBytes) / float64(rep.Totals.OriginalBytes)
+	}
+	if rep.Seconds > 0 {
+		rep.Totals.BytesPerSecond = float64(rep.Totals.OriginalBytes) / rep.Seconds
+	}
+
+	if werr := r.write(); werr != nil && err == nil {
+		return fmt.Errorf("write report: %w", werr)
+	}
+	return err
+}
+
+// write writes the report as JSON or HTML, depending on the file name
+func (r *reportRecorder) write() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(r.path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(&r.report)
+	} else {
+		err = writeHTMLReport(f, &r.report)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Chart dimensions of the HTML report, in SVG user units
+const (
+	chartWidth  = 800
+	chartHeight = 200
+)
+
+// writeHTMLReport renders rep as a self-contained HTML page, with the throughput
+// samples drawn as an SVG chart
+func writeHTMLReport(w io.Writer, rep *runReport) error {
+	f := progress.CurrentFormat()
+	var maxRate uint64
+	for _, s := range rep.Throughput {
+		if s.BytesPerSecond > maxRate {
+			maxRate = s.BytesPerSecond
+		}
+	}
+	var points []string
+	if rep.Seconds > 0 && maxRate > 0 {
+		for _, s := range rep.Throughput {
+			x := s.Seconds / rep.Seconds * chartWidth
+			y := chartHeight - float64(s.BytesPerSecond)/float64(maxRate)*chartHeight
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+	}
+	settings := make([]string, 0, len(rep.Settings))
+	for name := range rep.Settings {
+		settings = append(settings, name)
+	}
+	sort.Strings(settings)
+
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"R":        rep,
+		"Settings": settings,
+		"Points":   strings.Join(points, " "),
+		"MaxRate":  f.Rate(maxRate),
+		"Width":    chartWidth,
+		"Height":   chartHeight,
+	})
+}
+
+// reportTemplate is the HTML report page
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size":     func(n uint64) string { return progress.CurrentFormat().Size(n) },
+	"rate":     func(n float64) string { return progress.CurrentFormat().Rate(uint64(n)) },
+	"seconds":  func(s float64) string { return progress.CurrentFormat().Duration(s) },
+	"percent":  func(v float64) string { return fmt.Sprintf("%.1f%%", v*100) },
+	"datetime": func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>agcp {{.R.Operation}} report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+td.n { text-align: right; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>agcp {{.R.Operation}}: <span{{if .R.Error}} class="failed"{{end}}>{{.R.Result}}</span></h1>
+{{if .R.Error}}<p class="failed">{{.R.Error}}</p>{{end}}
+<table>
+<tr><th>Command</th><td>{{range .R.Command}}{{.}} {{end}}</td></tr>
+<tr><th>Started</th><td>{{datetime .R.Started}}</td></tr>
+<tr><th>Finished</th><td>{{datetime .R.Finished}}</td></tr>
+<tr><th>Duration</th><td>{{seconds .R.Seconds}}</td></tr>
+<tr><th>Entries</th><td>{{.R.Totals.Entries}} ({{.R.Totals.Skipped}} skipped, {{.R.Totals.Failed}} failed)</td></tr>
+<tr><th>Original size</th><td>{{size .R.Totals.OriginalBytes}}</td></tr>
+<tr><th>Compressed size</th><td>{{size .R.Totals.CompressedBytes}} ({{percent .R.Totals.Ratio}})</td></tr>
+<tr><th>Throughput</th><td>{{rate .R.Totals.BytesPerSecond}}</td></tr>
+</table>
+
+<h2>Throughput</h2>
+{{if .Points}}<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" style="border: 1px solid #ccc">
+<polyline fill="none" stroke="#36c" stroke-width="2" points="{{.Points}}"/>
+</svg>
+<p>Peak {{.MaxRate}} over {{seconds .R.Seconds}}</p>{{else}}<p>No throughput samples.</p>{{end}}
+
+<h2>Warnings</h2>
+{{if .R.Warnings}}<ul>{{range .R.Warnings}}<li>[{{.Code}}] {{.}}</li>{{end}}</ul>{{else}}<p>None.</p>{{end}}
+
+<h2>Settings</h2>
+<table>
+{{range .Settings}}<tr><th>{{.}}</th><td>{{index $.R.Settings .}}</td></tr>
+{{end}}</table>
+
+<h2>Entries</h2>
+<table>
+<tr><th>Path</th><th>Codec</th><th>Original</th><th>Compressed</th><th>Time</th><th>Result</th></tr>
+{{range .R.Entries}}<tr><td>{{.Path}}</td><td>{{.Codec}}</td><td class="n">{{size .OriginalBytes}}</td><td class="n">{{size .CompressedBytes}}</td><td class="n">{{seconds .Seconds}}</td><td{{if .Error}} class="failed"{{end}}>{{if .Error}}{{.Error}}{{else if .Skipped}}skipped{{else}}ok{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
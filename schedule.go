@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// addScheduleFlags registers the deterministic scheduling flags on fs and
+// returns a function that, once flags are parsed, gives the schedule they set
+// (nil without --deterministic-scheduling) and the function that closes its
+// log. Without --schedule-seed, each run picks a seed and logs it, so a run
+// that fails can be repeated with the same schedule.
+func addScheduleFlags(fs *flag.FlagSet) func() (*core.Schedule, func() error, error) {
+	enabled := fs.Bool("deterministic-scheduling", false, "process entries one at a time on a fixed, seeded schedule, to reproduce hangs and corruption")
+	seed := fs.Int64("schedule-seed", 0, "seed for --deterministic-scheduling, from the schedule log of an earlier run (default: random)")
+	logPath := fs.String("schedule-log", "", "write the schedule of --deterministic-scheduling to this file, to attach to bug reports (default: stderr)")
+	return func() (*core.Schedule, func() error, error) {
+		seeded := false
+		fs.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "schedule-seed" })
+		if !*enabled {
+			if seeded || *logPath != "" {
+				return nil, nil, fmt.Errorf("--schedule-seed and --schedule-log need --deterministic-scheduling")
+			}
+			return nil, func() error { return nil }, nil
+		}
+		schedule := &core.Schedule{Seed: *seed, Log: os.Stderr}
+		if !seeded {
+			schedule.Seed = rand.New(rand.NewSource(time.Now().UnixNano())).Int63()
+		}
+		if *logPath == "" {
+			return schedule, func() error { return nil }, nil
+		}
+		f, err := os.Create(*logPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create schedule log: %w", err)
+		}
+		schedule.Log = f
+		return schedule, func() error {
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("close schedule log: %w", err)
+			}
+			return nil
+		}, nil
+	}
+}
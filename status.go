@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// statusReporter follows the progress events of a running operation and writes
+// a full status snapshot whenever the process receives the status signal
+// (SIGUSR1), like dd does for long batch jobs
+type statusReporter struct {
+	path   string // File to write snapshots to; empty prints to stdout
+	retry  core.RetryPolicy
+	events chan progress.Event
+	sig    chan os.Signal
+	done   chan struct{}
+
+	mu       sync.Mutex
+	latest   progress.Event
+	warnings []core.Warning
+}
+
+// addStatusFlags registers the status snapshot flag on fs
+func addStatusFlags(fs *flag.FlagSet) *string {
+	return fs.String("status-file", "", "write the SIGUSR1 status snapshot to this file instead of stdout")
+}
+
+// startStatusReporter wires a reporter into opts: it receives the operation's
+// progress events and warnings. Call stop once the operation has returned.
+func startStatusReporter(path string, opts *core.Options) (stop func()) {
+	r := &statusReporter{
+		path:   path,
+		retry:  opts.Retry,
+		events: make(chan progress.Event, 64),
+		sig:    make(chan os.Signal, 1),
+		done:   make(chan struct{}),
+	}
+	opts.Events = r.events
+	warn := opts.Warn
+	opts.Warn = func(w core.Warning) {
+		r.mu.Lock()
+		r.warnings = append(r.warnings, w)
+		r.mu.Unlock()
+		if warn != nil {
+			warn(w)
+		}
+	}
+
+	if len(statusSignals) > 0 {
+		signal.Notify(r.sig, statusSignals...)
+	}
+	go r.run()
+
+	return func() {
+		signal.Stop(r.sig)
+		close(r.done)
+	}
+}
+
+// run records events and writes a snapshot on each signal until stopped
+func (r *statusReporter) run() {
+	for {
+		select {
+		case ev := <-r.events:
+			r.mu.Lock()
+			r.latest = ev
+			r.mu.Unlock()
+		case <-r.sig:
+			if err := r.dump(); err != nil {
+				printWarning(fmt.Sprintf("write status snapshot: %v", err))
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// dump writes the current snapshot to the status file or stdout
+func (r *statusReporter) dump() error {
+	if r.path == "" {
+		r.write(os.Stdout)
+		return nil
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.write(f)
+	return f.Close()
+}
+
+// write renders the snapshot
+func (r *statusReporter) write(w io.Writer) {
+	r.mu.Lock()
+	ev := r.latest
+	warnings := append([]core.Warning(nil), r.warnings...)
+	r.mu.Unlock()
+	f := progress.CurrentFormat()
+
+	fmt.Fprintln(w, "--- agcp status ---")
+	fmt.Fprintf(w, "Phase:        %s\n", ev.Phase)
+	fmt.Fprintf(w, "Current file: %s\n", f.Name(ev.EntryPath))
+	if ev.FilesTotal > 0 {
+		fmt.Fprintf(w, "Files:        %d done, %d remaining of %d\n", ev.FilesDone, ev.FilesTotal-ev.FilesDone, ev.FilesTotal)
+	} else {
+		fmt.Fprintf(w, "Files:        %d done\n", ev.FilesDone)
+	}
+	if ev.BytesTotal > 1 {
+		percentage := float64(ev.BytesDone) / float64(ev.BytesTotal) * 100
+		fmt.Fprintf(w, "Bytes:        %s of %s (%s%%)\n", f.Size(ev.BytesDone), f.Size(ev.BytesTotal), f.Number(percentage, 1))
+	} else {
+		fmt.Fprintf(w, "Bytes:        %s\n", f.Size(ev.BytesDone))
+	}
+	fmt.Fprintf(w, "Rate:         %s\n", f.Rate(ev.Rate))
+	if ev.ReadTotal > 0 {
+		percentage := float64(ev.ReadDone) / float64(ev.ReadTotal) * 100
+		fmt.Fprintf(w, "Read:         %s of %s (%s%%) at %s\n", f.Size(ev.ReadDone), f.Size(ev.ReadTotal), f.Number(percentage, 1), f.Rate(ev.ReadRate))
+	}
+	if ev.ETA > 0 {
+		fmt.Fprintf(w, "ETA:          %s\n", f.Duration(ev.ETA.Seconds()))
+	} else {
+		fmt.Fprintln(w, "ETA:          unknown")
+	}
+	fmt.Fprintf(w, "Elapsed:      %s\n", f.Duration(ev.Elapsed.Seconds()))
+
+	summary := r.retry.Log.Summary()
+	if summary == "" && len(warnings) == 0 {
+		fmt.Fprintln(w, "Errors:       none")
+		return
+	}
+	fmt.Fprintln(w, "Errors:")
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  [%s] %s\n", warning.Code, warning)
+	}
+	for _, line := range strings.Split(strings.TrimRight(summary, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
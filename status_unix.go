@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals request a status snapshot from a running operation
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// statusSignals is empty on Windows, which has no SIGUSR1
+var statusSignals []os.Signal
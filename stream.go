@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"agcp/pkg/core"
+)
+
+// stdout is the process's standard output. When it carries archive data or
+// extracted content, os.Stdout is pointed at stderr so that messages and
+// progress stay out of the data.
+var stdout = os.Stdout
+
+// usesStdio reports whether the arguments of compress or decompress name
+// standard input or output ("-") as an input, an output or the --tape device
+func usesStdio(args []string) bool {
+	return slices.Contains(args, "-")
+}
+
+// compressStdio compresses input, or standard input if it is "-", into an
+// archive written front to back to standard output, or to output unless that
+// is "-" too
+func compressStdio(input, output string, opts core.Options) error {
+	if opts.Sidecar {
+		return fmt.Errorf("--sidecar cannot be combined with standard input or output")
+	}
+	if len(opts.Recipients) > 0 {
+		return fmt.Errorf("--recipient cannot be combined with standard input or output; pipe the archive through age or gpg instead")
+	}
+	if output == "-" {
+		labelOperation("Compressing", stdioName(input), "stdout")
+		if input == "-" {
+			return core.CompressReader(os.Stdin, "stdin", stdout, opts)
+		}
+		return core.CompressToWriter(input, stdout, opts)
+	}
+
+	// Standard input into a file: the content is named after the archive, as
+	// for a pipe
+	labelOperation("Compressing", "stdin", output)
+	tmp := core.PartialPath(output)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	release := core.RegisterTemp(tmp)
+	defer release()
+	name := strings.TrimSuffix(filepath.Base(output), ".agcp")
+	if err := core.CompressReader(os.Stdin, name, f, opts); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("close output: %w", err)
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		return fmt.Errorf("rename finished archive: %w", err)
+	}
+	return nil
+}
+
+// stageStdin copies an archive read from standard input to a temporary file
+// in dir, since extraction needs to seek in it, returning its path and the
+// function that removes it
+func stageStdin(dir string) (string, func(), error) {
+	staged, removeStaged, err := stageFile(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := os.OpenFile(staged, os.O_WRONLY, 0)
+	if err != nil {
+		removeStaged()
+		return "", nil, fmt.Errorf("open staging file: %w", err)
+	}
+	_, err = io.Copy(f, os.Stdin)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeStaged()
+		return "", nil, fmt.Errorf("read archive from stdin: %w", err)
+	}
+	return staged, removeStaged, nil
+}
+
+// stdioName returns the name to show for path, which is "stdin" for "-"
+func stdioName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
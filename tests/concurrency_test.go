@@ -0,0 +1,814 @@
+// tests/concurrency_test.go
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// TestConcurrentLibraryUsage checks that Compress and Decompress can run concurrently
+// in one process, as backup agents embedding the library do. Run with -race to
+// verify the race-free guarantee.
+func TestConcurrentLibraryUsage(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Concurrent Library Usage")
+
+	StartSection("Preparing Test Environment")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-concurrent-lib-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	const numTrees = 6
+	Action(fmt.Sprintf("Creating %d independent directory trees", numTrees))
+	trees := make([]string, numTrees)
+	for i := range trees {
+		trees[i] = filepath.Join(testDir, fmt.Sprintf("tree%d", i))
+		for j := 0; j < 5; j++ {
+			path := filepath.Join(trees[i], fmt.Sprintf("sub%d", j%2), fmt.Sprintf("file%d.txt", j))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			content := bytes.Repeat([]byte(fmt.Sprintf("tree %d file %d\n", i, j)), 2000*(j+1))
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+		}
+	}
+	Success("Test trees created successfully")
+	EndSection()
+
+	// ─── ROUND-TRIP ─────────────────────────────────────────────────
+	StartSection("Running Concurrent Round-Trips")
+	Action("Compressing and decompressing every tree twice, all at once")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numTrees*2)
+	for i := 0; i < numTrees*2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Shared progress settings may be changed while operations run
+			progress.SetOperationName(fmt.Sprintf("job %d", i))
+
+			tree := trees[i%numTrees]
+			archive := filepath.Join(testDir, fmt.Sprintf("job%d.agcp", i))
+			if err := Compress(tree, archive); err != nil {
+				errs <- fmt.Errorf("job %d compress: %w", i, err)
+				return
+			}
+			out := filepath.Join(testDir, fmt.Sprintf("out%d", i))
+			if err := Decompress(archive, out); err != nil {
+				errs <- fmt.Errorf("job %d decompress: %w", i, err)
+				return
+			}
+			if err := compareTrees(tree, out); err != nil {
+				errs <- fmt.Errorf("job %d verify: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	progress.SetOperationName("")
+
+	for err := range errs {
+		Error(err.Error())
+		t.Error(err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+	Success(fmt.Sprintf("All %d concurrent round-trips produced identical trees", numTrees*2))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// compareTrees checks that every file under want exists under got with the same content
+func compareTrees(want, got string) error {
+	return filepath.Walk(want, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(want, path)
+		if err != nil {
+			return err
+		}
+		wantData, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		gotData, err := os.ReadFile(filepath.Join(got, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(wantData, gotData) {
+			return fmt.Errorf("content mismatch for %s", rel)
+		}
+		return nil
+	})
+}
+
+// TestIOBudgetExtraction checks that extraction under a tiny in-flight byte budget
+// completes without deadlocking and restores every file intact
+func TestIOBudgetExtraction(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Extraction With I/O Budget")
+
+	StartSection("Preparing Test Archive")
+	testDir, err := os.MkdirTemp("", "agcp-io-budget-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("line %d of a large entry\n", i)), 20000)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("large%d.txt", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := Compress(srcDir, archive); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive with 8 large entries created")
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Decompressing With 16 KiB Budget")
+	Action("Extracting all entries concurrently under the budget")
+	out := filepath.Join(testDir, "out")
+	if err := core.DecompressWithOptions(archive, out, core.Options{IOBudget: 16 * 1024}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, out); err != nil {
+		Error(fmt.Sprintf("Verification failed: %v", err))
+		t.Fatalf("Verification failed: %v", err)
+	}
+	Success("All entries restored intact under the budget")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestMaxWriteRate checks that extraction under a write rate limit restores
+// every file intact and takes as long as the limit requires across all workers
+func TestMaxWriteRate(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Extraction With Write Rate Limit")
+
+	StartSection("Preparing Test Archive")
+	testDir, err := os.MkdirTemp("", "agcp-write-rate-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	var total int
+	for i := 0; i < 4; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("line %d of a paced entry\n", i)), 10000)
+		total += len(content)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("paced%d.txt", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := Compress(srcDir, archive); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success(fmt.Sprintf("Archive with %d bytes in 4 entries created", total))
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Decompressing At 2 MiB/s")
+	const rate = 2 << 20
+	out := filepath.Join(testDir, "out")
+	began := time.Now()
+	if err := core.DecompressWithOptions(archive, out, core.Options{MaxWriteRate: rate}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	elapsed := time.Since(began)
+	if err := compareTrees(srcDir, out); err != nil {
+		Error(fmt.Sprintf("Verification failed: %v", err))
+		t.Fatalf("Verification failed: %v", err)
+	}
+	if want := time.Duration(float64(total) / rate * 0.9 * float64(time.Second)); elapsed < want {
+		Error(fmt.Sprintf("Extraction took %v, want at least %v", elapsed, want))
+		t.Fatalf("Extraction took %v, want at least %v under the limit", elapsed, want)
+	}
+	Success(fmt.Sprintf("All entries restored intact in %v", elapsed.Round(time.Millisecond)))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestReproducibleWorkerCounts checks that the ordered writer makes archives of
+// the same tree byte-identical whatever the number of compression workers,
+// including entries large enough to spill to temporary files
+func TestReproducibleWorkerCounts(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Reproducible Parallel Compression")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-reproducible-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	large := make([]byte, 5<<20) // Incompressible, so its compressed data spills
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+	files := map[string][]byte{"big/random.bin": large, "empty.txt": nil}
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("dir%d/file%d.txt", i%5, i)] = bytes.Repeat([]byte(fmt.Sprintf("line %d\n", i)), 100*(i+1))
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Compressing With Different Worker Counts")
+	var want []byte
+	for _, workers := range []int{1, 2, 8} {
+		archive := filepath.Join(testDir, fmt.Sprintf("w%d.agcp", workers))
+		if err := core.CompressWithOptions(srcDir, archive, core.Options{Workers: workers, Reproducible: true}); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression with %d workers failed: %v", workers, err)
+		}
+		got, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if want == nil {
+			want = got
+		} else if !bytes.Equal(got, want) {
+			t.Fatalf("archive with %d workers differs from the single-worker archive", workers)
+		}
+		Success(fmt.Sprintf("%d workers: identical archive", workers))
+	}
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), filepath.Join(testDir, "w8.agcp"), outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Parallel archive restores the tree")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCompressEach checks that several inputs are compressed into their own
+// archives at once, with one progress tracker covering all of them
+func TestCompressEach(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Compress Each Input")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-each-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var inputs []string
+	totalFiles := 0
+	for d := 0; d < 3; d++ {
+		dir := filepath.Join(testDir, fmt.Sprintf("dir%d", d))
+		for i := 0; i <= d*3; i++ {
+			path := filepath.Join(dir, fmt.Sprintf("sub%d/file%d.txt", i%2, i))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(path, bytes.Repeat([]byte(path), 500), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			totalFiles++
+		}
+		inputs = append(inputs, dir)
+	}
+	single := filepath.Join(testDir, "single.txt")
+	if err := os.WriteFile(single, []byte("a lone file"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	inputs = append(inputs, single)
+	totalFiles++
+	Success(fmt.Sprintf("%d inputs created", len(inputs)))
+	EndSection()
+
+	StartSection("Compressing All Inputs")
+	events := make(chan progress.Event, 1024)
+	if err := core.CompressEach(inputs, core.Options{Workers: 2, Events: events}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	close(events)
+	var last progress.Event
+	for ev := range events {
+		last = ev
+	}
+	if last.FilesDone != uint64(totalFiles) || last.FilesTotal != uint64(totalFiles) {
+		t.Fatalf("final event reports %d/%d files, want %d/%d", last.FilesDone, last.FilesTotal, totalFiles, totalFiles)
+	}
+	Success("One tracker covered every archive")
+
+	for _, input := range inputs {
+		out := filepath.Join(testDir, "out", filepath.Base(input))
+		if err := core.Decompress(context.Background(), input+".agcp", out, core.Options{}); err != nil {
+			t.Fatalf("Decompressing %s.agcp failed: %v", input, err)
+		}
+		if input == single {
+			got, err := os.ReadFile(out)
+			if err != nil || string(got) != "a lone file" {
+				t.Fatalf("single file restored as %q: %v", got, err)
+			}
+		} else if err := compareTrees(input, out); err != nil {
+			t.Fatalf("%s restored differently: %v", input, err)
+		}
+	}
+	Success("Each archive restores its input")
+
+	if err := core.CompressEach([]string{inputs[0], inputs[0] + "/"}, core.Options{}); err == nil {
+		t.Fatalf("the same input twice should be rejected")
+	}
+	Success("Inputs writing the same archive are rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestConcurrentRunsLocked checks that a second run writing the same archive,
+// or extracting into the same destination, fails fast while the first holds
+// the lock, naming the process that holds it
+func TestConcurrentRunsLocked(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Locked Outputs")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-lock-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(srcDir, fmt.Sprintf("file%d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("locked\n"), 1000), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "out.agcp")
+	outDir := filepath.Join(testDir, "out")
+	Success("Test files created")
+	EndSection()
+
+	// holding runs op with an OnEntry callback that pauses it after its first
+	// entry, runs second while it is paused, and then lets it finish
+	holding := func(op func(core.Options) error, second func() error) (error, error) {
+		paused, resume := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		opts := core.Options{Workers: 1, OnEntry: func(core.EntryResult) {
+			once.Do(func() {
+				close(paused)
+				<-resume
+			})
+		}}
+		done := make(chan error, 1)
+		go func() { done <- op(opts) }()
+		<-paused
+		secondErr := second()
+		close(resume)
+		return <-done, secondErr
+	}
+	checkLocked := func(what, path string, err error) {
+		var locked *core.LockedError
+		if !errors.As(err, &locked) {
+			t.Fatalf("%s while locked: got %v, want a LockedError", what, err)
+		}
+		if locked.PID != os.Getpid() || locked.Path != path {
+			t.Fatalf("%s while locked: got %+v, want %s locked by PID %d", what, locked, path, os.Getpid())
+		}
+		Success(fmt.Sprintf("%s failed: %v", what, err))
+	}
+
+	StartSection("Compressing to a Locked Archive")
+	first, second := holding(func(opts core.Options) error {
+		return core.CompressWithOptions(srcDir, archive, opts)
+	}, func() error {
+		return core.CompressWithOptions(srcDir, archive, core.Options{Partial: core.PartialOverwrite})
+	})
+	if first != nil {
+		t.Fatalf("Compression holding the lock failed: %v", first)
+	}
+	checkLocked("Second compression", archive, second)
+	if _, err := os.Stat(core.LockPath(archive)); !os.IsNotExist(err) {
+		t.Fatalf("Lock file left behind: %v", err)
+	}
+	Success("Lock file removed once the archive was written")
+	EndSection()
+
+	StartSection("Extracting Into a Locked Destination")
+	first, second = holding(func(opts core.Options) error {
+		return core.DecompressWithOptions(archive, outDir, opts)
+	}, func() error {
+		return core.DecompressWithOptions(archive, outDir, core.Options{})
+	})
+	if first != nil {
+		t.Fatalf("Extraction holding the lock failed: %v", first)
+	}
+	checkLocked("Second extraction", outDir, second)
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Extracted tree differs: %v", err)
+	}
+	Success("First extraction completed intact")
+	EndSection()
+
+	StartSection("Running Again Once Released")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression after release failed: %v", err)
+	}
+	if err := core.DecompressWithOptions(archive, filepath.Join(testDir, "again"), core.Options{}); err != nil {
+		t.Fatalf("Extraction after release failed: %v", err)
+	}
+	Success("Outputs can be written again once released")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestContextCancel checks that canceling the context of a compression or
+// extraction stops it partway, stops its workers and removes the output it was
+// writing, leaving only complete files behind
+func TestContextCancel(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Context Cancellation")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-cancel-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	data := make([]byte, 2<<20)
+	for i := 0; i < 16; i++ {
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%02d.bin", i)), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "out.agcp")
+	Success("Test files created")
+	EndSection()
+
+	// canceling returns options whose OnEntry callback cancels ctx once the
+	// first entry is done, with the other workers mid-entry
+	canceling := func() (context.Context, core.Options) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, core.Options{Workers: 4, OnEntry: func(core.EntryResult) { cancel() }}
+	}
+	// settled waits for the goroutines started by an operation to exit
+	settled := func(before int) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if runtime.NumGoroutine() <= before {
+				return true
+			}
+		}
+		return false
+	}
+
+	StartSection("Canceling a Compression")
+	before := runtime.NumGoroutine()
+	ctx, opts := canceling()
+	err = core.Compress(ctx, srcDir, archive, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Canceled compression returned %v, want context.Canceled", err)
+	}
+	for _, path := range []string{archive, core.PartialPath(archive), core.LockPath(archive)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind by a canceled compression: %v", filepath.Base(path), err)
+		}
+	}
+	if !settled(before) {
+		t.Errorf("Goroutines still running after the canceled compression: %d, %d before", runtime.NumGoroutine(), before)
+	}
+	Success(fmt.Sprintf("Compression stopped, partial archive removed: %v", err))
+	EndSection()
+
+	StartSection("Canceling an Extraction")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	outDir := filepath.Join(testDir, "out")
+	before = runtime.NumGoroutine()
+	ctx, opts = canceling()
+	err = core.Decompress(ctx, archive, outDir, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Canceled extraction returned %v, want context.Canceled", err)
+	}
+	written, _ := os.ReadDir(outDir)
+	for _, entry := range written {
+		got, _ := os.ReadFile(filepath.Join(outDir, entry.Name()))
+		want, _ := os.ReadFile(filepath.Join(srcDir, entry.Name()))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Canceled extraction left %s incomplete (%d of %d bytes)", entry.Name(), len(got), len(want))
+		}
+	}
+	if len(written) == 16 {
+		t.Errorf("Canceled extraction wrote every file")
+	}
+	if !settled(before) {
+		t.Errorf("Goroutines still running after the canceled extraction: %d, %d before", runtime.NumGoroutine(), before)
+	}
+	Success(fmt.Sprintf("Extraction stopped with %d complete files and no partial ones", len(written)))
+	EndSection()
+
+	StartSection("Already Canceled")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fresh := filepath.Join(testDir, "fresh.agcp")
+	if err := core.Compress(ctx, srcDir, fresh, core.Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Compression with a canceled context returned %v", err)
+	}
+	if _, err := os.Stat(core.PartialPath(fresh)); !os.IsNotExist(err) {
+		t.Errorf("Compression with a canceled context started writing: %v", err)
+	}
+	Success("Nothing written with a context canceled up front")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestDeterministicScheduling checks that a scheduled run gives the same
+// schedule log for the same seed, and the same archive and extracted tree as
+// an unscheduled run
+func TestDeterministicScheduling(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Deterministic Scheduling")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-schedule-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	data := make([]byte, 256<<10)
+	for i := 0; i < 12; i++ {
+		rand.Read(data[:(i+1)*16<<10])
+		if err := os.WriteFile(filepath.Join(srcDir, "sub", fmt.Sprintf("file%02d.bin", i)), data[:(i+1)*16<<10], 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created")
+	EndSection()
+
+	compress := func(name string, schedule *core.Schedule) []byte {
+		archive := filepath.Join(testDir, name)
+		opts := core.Options{Workers: 4, Reproducible: true, Schedule: schedule}
+		if err := core.Compress(context.Background(), srcDir, archive, opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		b, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		return b
+	}
+
+	StartSection("Repeating a Schedule")
+	var first, second bytes.Buffer
+	scheduled := compress("first.agcp", &core.Schedule{Seed: 42, Log: &first})
+	compress("second.agcp", &core.Schedule{Seed: 42, Log: &second})
+	if first.String() != second.String() {
+		t.Fatalf("Schedule logs differ for the same seed:\n%s\n---\n%s", first.String(), second.String())
+	}
+	if !strings.HasPrefix(first.String(), "compress: seed 42, 4 workers\n") {
+		t.Errorf("Unexpected schedule log header: %q", strings.SplitN(first.String(), "\n", 2)[0])
+	}
+	if got := strings.Count(first.String(), ": done\n"); got != 12 {
+		t.Errorf("Schedule log has %d finished steps, want 12", got)
+	}
+	Success(fmt.Sprintf("Same seed, same %d-line schedule", strings.Count(first.String(), "\n")))
+	EndSection()
+
+	StartSection("Comparing with an Unscheduled Run")
+	if !bytes.Equal(scheduled, compress("plain.agcp", nil)) {
+		t.Fatal("Scheduled archive differs from the unscheduled one")
+	}
+	if !bytes.Equal(scheduled, compress("other.agcp", &core.Schedule{Seed: 7})) {
+		t.Fatal("Archive depends on the schedule's seed")
+	}
+	var log bytes.Buffer
+	outDir := filepath.Join(testDir, "out")
+	opts := core.Options{Workers: 3, Schedule: &core.Schedule{Seed: 1, Log: &log}}
+	if err := core.Decompress(context.Background(), filepath.Join(testDir, "first.agcp"), outDir, opts); err != nil {
+		t.Fatalf("Scheduled extraction failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Scheduled extraction differs from the source: %v", err)
+	}
+	if !strings.HasPrefix(log.String(), "extract: seed 1, 3 workers\n") {
+		t.Errorf("Unexpected extraction log header: %q", strings.SplitN(log.String(), "\n", 2)[0])
+	}
+	Success("Scheduling changes neither the archive nor the extracted tree")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestLargeEntriesDoNotStarve checks that parallel compression keeps working
+// through small entries while every large one it has started is stalled, so a
+// few giant files cannot hold up the small ones behind them
+func TestLargeEntriesDoNotStarve(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Large Entries Do Not Starve Small Ones")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-lanes-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The large entries sort first, so compressing in plain table order puts
+	// both workers on them
+	const numSmall = 6
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		content := bytes.Repeat([]byte("L"), 4<<20)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("a-large%d.bin", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	for i := 0; i < numSmall; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("small %d\n", i)), 8<<10)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("b-small%d.txt", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	gate := &gateCodec{small: numSmall, release: make(chan struct{})}
+	codec, err := core.RegisterCodec(gate)
+	if err != nil {
+		t.Fatalf("RegisterCodec failed: %v", err)
+	}
+	Success("Two large and several small files created")
+	EndSection()
+
+	StartSection("Compressing with Stalled Large Entries")
+	archive := filepath.Join(testDir, "lanes.agcp")
+	opts := core.Options{Workers: 2, Codec: codec}
+	if err := core.Compress(context.Background(), srcDir, archive, opts); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if gate.starved() {
+		t.Fatal("Small entries waited behind the stalled large ones")
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("All small entries were compressed while the large ones were stalled")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// gateCodec is a registered codec that stores data as is, but stalls every
+// entry whose data starts with 'L' until the given number of other entries
+// are complete, or a timeout passes
+type gateCodec struct {
+	small   int
+	release chan struct{}
+
+	mu       sync.Mutex
+	done     int
+	timedOut bool
+}
+
+func (*gateCodec) ID() uint8    { return 202 }
+func (*gateCodec) Name() string { return "gate" }
+
+func (g *gateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return &gateWriter{g: g, w: w}, nil
+}
+
+func (*gateCodec) NewReader(r io.Reader) (io.Reader, error) { return r, nil }
+
+// starved reports whether a large entry gave up waiting for the small ones
+func (g *gateCodec) starved() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.timedOut
+}
+
+type gateWriter struct {
+	g       *gateCodec
+	w       io.Writer
+	started bool
+	large   bool
+}
+
+func (x *gateWriter) Write(p []byte) (int, error) {
+	if !x.started && len(p) > 0 {
+		x.started, x.large = true, p[0] == 'L'
+		if x.large {
+			select {
+			case <-x.g.release:
+			case <-time.After(10 * time.Second):
+				x.g.mu.Lock()
+				x.g.timedOut = true
+				x.g.mu.Unlock()
+			}
+		}
+	}
+	return x.w.Write(p)
+}
+
+func (x *gateWriter) Close() error {
+	if x.started && !x.large {
+		x.g.mu.Lock()
+		x.g.done++
+		if x.g.done == x.g.small {
+			close(x.g.release)
+		}
+		x.g.mu.Unlock()
+	}
+	return nil
+}
@@ -1,560 +1,6441 @@
-// tests/edge_cases_test.go
-
-package tests
-
-import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"sync"
-	"testing"
-	"time"
-)
-
-// TestEmptyFile tests handling of empty files
-func TestEmptyFile(t *testing.T) {
-	// ─── SETUP ──────────────────────────────────────────────────────
-	startTime := time.Now()
-	ReportStart("Empty File Handling")
-
-	// Create a temporary directory for testing
-	StartSection("Preparing Test Environment")
-	Action("Creating temporary directory for test files")
-	testDir, err := os.MkdirTemp("", "agcp-empty-test")
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(testDir)
-
-	// Create an empty file
-	Action("Creating empty test file (0 bytes)")
-	emptyFile := filepath.Join(testDir, "empty.txt")
-	err = os.WriteFile(emptyFile, []byte{}, 0644)
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create empty file: %v", err))
-		t.Fatalf("Failed to create empty file: %v", err)
-	}
-	Success("Empty file created successfully")
-	EndSection()
-
-	// ─── COMPRESS ───────────────────────────────────────────────────
-	StartSection("Compressing Empty File")
-	Action("Compressing the empty file")
-	compressedFile := filepath.Join(testDir, "empty.agcp")
-	err = Compress(emptyFile, compressedFile)
-	if err != nil {
-		Error(fmt.Sprintf("Failed to compress empty file: %v", err))
-		t.Fatalf("Failed to compress empty file: %v", err)
-	}
-
-	// Verify the compressed file exists and has some content (headers)
-	info, err := os.Stat(compressedFile)
-	if err != nil {
-		Error(fmt.Sprintf("Compressed file does not exist: %v", err))
-		t.Fatalf("Compressed file does not exist: %v", err)
-	}
-	if info.Size() == 0 {
-		Error("Compressed file is empty, expected header data")
-		t.Fatalf("Compressed file is empty, expected header data")
-	}
-
-	Success("Empty file compressed successfully")
-	Info(fmt.Sprintf("Archive size: %s (metadata and headers only)", HumanReadableSize(info.Size())))
-	EndSection()
-
-	// ─── DECOMPRESS ─────────────────────────────────────────────────
-	StartSection("Decompressing Empty File Archive")
-	Action("Creating output directory for decompression")
-	decompressedDir := filepath.Join(testDir, "decompressed")
-	err = os.MkdirAll(decompressedDir, 0755)
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
-		t.Fatalf("Failed to create decompression directory: %v", err)
-	}
-
-	Action("Decompressing the archive")
-	err = Decompress(compressedFile, decompressedDir)
-	if err != nil {
-		Error(fmt.Sprintf("Decompression failed: %v", err))
-		t.Fatalf("Failed to decompress empty file: %v", err)
-	}
-
-	// ─── VERIFY ─────────────────────────────────────────────────────
-	StartSection("Verifying Decompressed File")
-	Action("Checking that the decompressed file exists and is empty")
-
-	// Verify the decompressed file exists and is empty
-	decompressedFile := filepath.Join(decompressedDir, "empty.txt")
-	info, err = os.Stat(decompressedFile)
-	if err != nil {
-		Error(fmt.Sprintf("Decompressed file does not exist: %v", err))
-		t.Fatalf("Decompressed file does not exist: %v", err)
-	}
-
-	Success("Empty file decompressed successfully")
-	Info(fmt.Sprintf("Decompressed file size: %s", HumanReadableSize(info.Size())))
-	EndSection()
-
-	// ─── CONCLUSION ─────────────────────────────────────────────────
-	ReportEnd(true, time.Since(startTime))
-}
-
-// TestLargeNumberOfFiles tests handling of directories with many files
-func TestLargeNumberOfFiles(t *testing.T) {
-	// Skip in short mode as this creates many files
-	if testing.Short() {
-		t.Skip("Skipping test in short mode")
-	}
-
-	// ─── SETUP ──────────────────────────────────────────────────────
-	startTime := time.Now()
-	ReportStart("Multiple Files Handling")
-
-	// Create a temporary directory for testing
-	StartSection("Preparing Test Environment")
-	Action("Creating temporary directory for test files")
-	testDir, err := os.MkdirTemp("", "agcp-many-files-test")
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(testDir)
-
-	// Create a large number of small files
-	numFiles := 100 // Adjust as needed for testing
-	Action(fmt.Sprintf("Creating %d small text files in nested directories", numFiles))
-
-	var totalSize int64
-	for i := 0; i < numFiles; i++ {
-		filename := filepath.Join(testDir, filepath.FromSlash(filepath.Join("files", filepath.Join("subfolder", filepath.FromSlash(filepath.Join("depth3", filepath.FromSlash(filepath.Join("depth4", fmt.Sprintf("file%d.txt", i)))))))))
-
-		// Create all parent directories
-		err = os.MkdirAll(filepath.Dir(filename), 0755)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create directory structure: %v", err))
-			t.Fatalf("Failed to create directory structure: %v", err)
-		}
-
-		// Create a small file with content
-		content := []byte(fmt.Sprintf("This is file %d with some content.", i))
-		err = os.WriteFile(filename, content, 0644)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create file %s: %v", filename, err))
-			t.Fatalf("Failed to create file %s: %v", filename, err)
-		}
-
-		totalSize += int64(len(content))
-	}
-
-	Success("Created nested directory structure with multiple files")
-	Info(fmt.Sprintf("Total files created: %d", numFiles))
-	Info(fmt.Sprintf("Total data size: %s", HumanReadableSize(totalSize)))
-	Info("Directory structure depth: 5 levels")
-	EndSection()
-
-	// ─── COMPRESS ───────────────────────────────────────────────────
-	StartSection("Compressing Multiple Files")
-	Action("Compressing directory containing multiple files")
-	compressedFile := filepath.Join(testDir, "many-files.agcp")
-	err = Compress(filepath.Join(testDir, "files"), compressedFile)
-	if err != nil {
-		Error(fmt.Sprintf("Compression failed: %v", err))
-		t.Fatalf("Failed to compress directory with many files: %v", err)
-	}
-
-	// Get compression stats
-	compressedInfo, err := os.Stat(compressedFile)
-	if err != nil {
-		Warning(fmt.Sprintf("Could not get compressed file info: %v", err))
-	} else {
-		compressedSize := compressedInfo.Size()
-		compressionRatio := float64(compressedSize) / float64(totalSize) * 100
-
-		Success("Directory compressed successfully")
-		Info(fmt.Sprintf("Original size: %s", HumanReadableSize(totalSize)))
-		Info(fmt.Sprintf("Compressed size: %s", HumanReadableSize(compressedSize)))
-		Info(fmt.Sprintf("Compression ratio: %.1f%%", compressionRatio))
-	}
-	EndSection()
-
-	// ─── DECOMPRESS ─────────────────────────────────────────────────
-	StartSection("Decompressing Multiple Files")
-	Action("Creating output directory for decompression")
-	decompressedDir := filepath.Join(testDir, "decompressed")
-	err = os.MkdirAll(decompressedDir, 0755)
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
-		t.Fatalf("Failed to create decompression directory: %v", err)
-	}
-
-	Action("Decompressing the archive")
-	err = Decompress(compressedFile, decompressedDir)
-	if err != nil {
-		Error(fmt.Sprintf("Decompression failed: %v", err))
-		t.Fatalf("Failed to decompress directory with many files: %v", err)
-	}
-
-	Success("Archive decompressed successfully")
-	EndSection()
-
-	// ─── VERIFY ─────────────────────────────────────────────────────
-	StartSection("Verifying Decompressed Files")
-	Action("Scanning files to verify correct decompression")
-
-	// Verify that all files were decompressed correctly
-	expectedDir := filepath.Join(testDir, "files")
-	var checkError error
-	var checkedFiles int
-
-	err = filepath.Walk(expectedDir, func(origPath string, origInfo os.FileInfo, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-
-		// Skip directories, we only check files
-		if origInfo.IsDir() {
-			return nil
-		}
-
-		// Calculate relative path
-		relPath, err := filepath.Rel(expectedDir, origPath)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path: %v", err)
-		}
-
-		// Get path in decompressed directory
-		decompPath := filepath.Join(decompressedDir, relPath)
-
-		// Check if decompressed file exists
-		decompInfo, err := os.Stat(decompPath)
-		if err != nil {
-			checkError = fmt.Errorf("file %s not found in decompressed directory: %v", relPath, err)
-			return filepath.SkipDir
-		}
-
-		// Check if sizes match
-		if decompInfo.Size() != origInfo.Size() {
-			checkError = fmt.Errorf("size mismatch for %s: original %d, decompressed %d",
-				relPath, origInfo.Size(), decompInfo.Size())
-			return filepath.SkipDir
-		}
-
-		// File exists and size matches
-		checkedFiles++
-		return nil
-	})
-
-	if err != nil {
-		Error(fmt.Sprintf("Error walking directory: %v", err))
-		t.Fatalf("Error walking original directory: %v", err)
-	}
-
-	if checkError != nil {
-		Error(fmt.Sprintf("Verification failed: %v", checkError))
-		t.Fatalf("Verification failed: %v", checkError)
-	}
-
-	Success(fmt.Sprintf("Successfully verified %d files", checkedFiles))
-	if checkedFiles == numFiles {
-		Info("All files verified - file count matches exactly")
-	} else {
-		Warning(fmt.Sprintf("File count mismatch: expected %d, got %d", numFiles, checkedFiles))
-	}
-	EndSection()
-
-	// ─── CONCLUSION ─────────────────────────────────────────────────
-	ReportEnd(true, time.Since(startTime))
-}
-
-// TestConcurrentProcessing tests concurrent compression and decompression
-func TestConcurrentProcessing(t *testing.T) {
-	// ─── SETUP ──────────────────────────────────────────────────────
-	startTime := time.Now()
-	ReportStart("Concurrent Processing")
-
-	// Create a temporary directory for testing
-	StartSection("Preparing Test Environment")
-	Action("Creating temporary directory for test files")
-	testDir, err := os.MkdirTemp("", "agcp-concurrent-test")
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(testDir)
-
-	// Create multiple test files
-	numFiles := 5
-	Action(fmt.Sprintf("Creating %d test files of varying sizes", numFiles))
-	filePaths := make([]string, numFiles)
-	compressedPaths := make([]string, numFiles)
-	decompressedDirs := make([]string, numFiles)
-
-	var totalSize int64
-	for i := 0; i < numFiles; i++ {
-		// Create a test file with unique content
-		fileName := fmt.Sprintf("test-file-%d.dat", i)
-		filePath := filepath.Join(testDir, fileName)
-		filePaths[i] = filePath
-
-		// Create content of varying sizes
-		size := 100 * 1024 * (i + 1) // 100KB * (i+1)
-		content := make([]byte, size)
-		for j := 0; j < size; j++ {
-			content[j] = byte((j + i) % 256)
-		}
-
-		err = os.WriteFile(filePath, content, 0644)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create test file %s: %v", filePath, err))
-			t.Fatalf("Failed to create test file %s: %v", filePath, err)
-		}
-
-		totalSize += int64(size)
-
-		// Set up paths for compressed and decompressed files
-		compressedPaths[i] = filepath.Join(testDir, fmt.Sprintf("compressed-%d.agcp", i))
-		decompressedDirs[i] = filepath.Join(testDir, fmt.Sprintf("decompressed-%d", i))
-
-		// Create decompression directories
-		err = os.MkdirAll(decompressedDirs[i], 0755)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
-			t.Fatalf("Failed to create decompression directory: %v", err)
-		}
-	}
-
-	Success("Test files created successfully")
-	Info(fmt.Sprintf("Created %d files with sizes from 100KB to %dKB", numFiles, 100*numFiles))
-	Info(fmt.Sprintf("Total data size: %s", HumanReadableSize(totalSize)))
-	EndSection()
-
-	// ─── CONCURRENT COMPRESSION ──────────────────────────────────────
-	StartSection("Concurrent Compression")
-	Action(fmt.Sprintf("Compressing %d files simultaneously with goroutines", numFiles))
-	var wg sync.WaitGroup
-	errors := make(chan error, numFiles*2)
-
-	// Compress concurrently
-	for i := 0; i < numFiles; i++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			err := Compress(filePaths[idx], compressedPaths[idx])
-			if err != nil {
-				errors <- err
-			}
-		}(i)
-	}
-	wg.Wait()
-
-	// Check for errors
-	select {
-	case err := <-errors:
-		Error(fmt.Sprintf("Compression error: %v", err))
-		t.Fatalf("Error during concurrent compression: %v", err)
-	default:
-		Success("All files compressed successfully in parallel")
-	}
-	EndSection()
-
-	// ─── CONCURRENT DECOMPRESSION ────────────────────────────────────
-	StartSection("Concurrent Decompression")
-	Action(fmt.Sprintf("Decompressing %d archives simultaneously with goroutines", numFiles))
-
-	// Decompress concurrently
-	for i := 0; i < numFiles; i++ {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			err := Decompress(compressedPaths[idx], decompressedDirs[idx])
-			if err != nil {
-				errors <- err
-			}
-		}(i)
-	}
-	wg.Wait()
-
-	// Check for errors
-	select {
-	case err := <-errors:
-		Error(fmt.Sprintf("Decompression error: %v", err))
-		t.Fatalf("Error during concurrent decompression: %v", err)
-	default:
-		Success("All archives decompressed successfully in parallel")
-	}
-	EndSection()
-
-	// ─── VERIFY ─────────────────────────────────────────────────────
-	StartSection("Verifying Decompressed Files")
-	Action("Checking all files for size and integrity")
-
-	// Verify all files were processed correctly
-	for i := 0; i < numFiles; i++ {
-		// Original file information
-		origInfo, err := os.Stat(filePaths[i])
-		if err != nil {
-			Error(fmt.Sprintf("Failed to access original file %s: %v", filePaths[i], err))
-			t.Fatalf("Failed to access original file %s: %v", filePaths[i], err)
-		}
-
-		// Get the decompressed file path
-		fileName := filepath.Base(filePaths[i])
-		decompressedPath := filepath.Join(decompressedDirs[i], fileName)
-
-		// Decompressed file information
-		decompInfo, err := os.Stat(decompressedPath)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to access decompressed file %s: %v", decompressedPath, err))
-			t.Fatalf("Failed to access decompressed file %s: %v", decompressedPath, err)
-		}
-
-		// Check if sizes match
-		if origInfo.Size() != decompInfo.Size() {
-			Error(fmt.Sprintf("Size mismatch for %s: original %s, decompressed %s",
-				fileName, HumanReadableSize(origInfo.Size()), HumanReadableSize(decompInfo.Size())))
-			t.Fatalf("Size mismatch for %s: original %d, decompressed %d",
-				fileName, origInfo.Size(), decompInfo.Size())
-		}
-
-		Info(fmt.Sprintf("Verified file %d of %d: %s (%s)", i+1, numFiles, fileName,
-			HumanReadableSize(origInfo.Size())))
-	}
-
-	Success(fmt.Sprintf("Successfully processed and verified %d files concurrently", numFiles))
-	EndSection()
-
-	// ─── CONCLUSION ─────────────────────────────────────────────────
-	ReportEnd(true, time.Since(startTime))
-}
-
-// TestUnicodePaths tests handling of files and directories with Unicode characters in paths
-func TestUnicodePaths(t *testing.T) {
-	// ─── SETUP ──────────────────────────────────────────────────────
-	startTime := time.Now()
-	ReportStart("Unicode Path Handling")
-
-	// Create a temporary directory for testing
-	StartSection("Preparing Test Environment")
-	Action("Creating temporary directory for test files")
-	testDir, err := os.MkdirTemp("", "agcp-unicode-test")
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(testDir)
-
-	// Create directories and files with Unicode characters
-	Action("Creating directories with Unicode characters in names")
-	unicodeDirs := []string{
-		filepath.Join(testDir, "😀-emoji-dir"),
-		filepath.Join(testDir, "中文目录"),          // Chinese directory
-		filepath.Join(testDir, "Русская-папка"), // Russian directory
-	}
-
-	for _, dir := range unicodeDirs {
-		err = os.MkdirAll(dir, 0755)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create Unicode directory %s: %v", dir, err))
-			t.Fatalf("Failed to create Unicode directory %s: %v", dir, err)
-		}
-		Info(fmt.Sprintf("Created directory: %s", filepath.Base(dir)))
-	}
-
-	// Create files with Unicode names
-	Action("Creating files with Unicode names and content")
-	unicodeFiles := map[string]string{
-		filepath.Join(unicodeDirs[0], "emoji-file-😎.txt"): "This is an emoji file.",
-		filepath.Join(unicodeDirs[1], "文件.txt"):           "This is a Chinese filename.",
-		filepath.Join(unicodeDirs[2], "файл.txt"):         "This is a Russian filename.",
-	}
-
-	for filePath, content := range unicodeFiles {
-		err = os.WriteFile(filePath, []byte(content), 0644)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to create Unicode file %s: %v", filePath, err))
-			t.Fatalf("Failed to create Unicode file %s: %v", filePath, err)
-		}
-		Info(fmt.Sprintf("Created file: %s", filepath.Base(filePath)))
-	}
-
-	Success("Successfully created directories and files with Unicode names")
-	EndSection()
-
-	// ─── COMPRESS ───────────────────────────────────────────────────
-	StartSection("Compressing Files with Unicode Paths")
-	Action("Compressing directory tree with Unicode paths")
-	compressedFile := filepath.Join(testDir, "unicode-test.agcp")
-	err = Compress(testDir, compressedFile)
-	if err != nil {
-		Error(fmt.Sprintf("Compression failed: %v", err))
-		t.Fatalf("Failed to compress directory with Unicode paths: %v", err)
-	}
-
-	Success("Directory with Unicode paths compressed successfully")
-	EndSection()
-
-	// ─── DECOMPRESS ─────────────────────────────────────────────────
-	StartSection("Decompressing Files with Unicode Paths")
-	Action("Creating output directory for decompression")
-	decompressedDir := filepath.Join(testDir, "decompressed")
-	err = os.MkdirAll(decompressedDir, 0755)
-	if err != nil {
-		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
-		t.Fatalf("Failed to create decompression directory: %v", err)
-	}
-
-	Action("Decompressing the archive with Unicode paths")
-	err = Decompress(compressedFile, decompressedDir)
-	if err != nil {
-		Error(fmt.Sprintf("Decompression failed: %v", err))
-		t.Fatalf("Failed to decompress directory with Unicode paths: %v", err)
-	}
-
-	Success("Archive with Unicode paths decompressed successfully")
-	EndSection()
-
-	// ─── VERIFY ─────────────────────────────────────────────────────
-	StartSection("Verifying Files with Unicode Paths")
-	Action("Checking that Unicode filenames and content are preserved")
-
-	// Verify all files were decompressed correctly
-	for filePath, content := range unicodeFiles {
-		// Calculate relative path
-		relPath, err := filepath.Rel(testDir, filePath)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to calculate relative path: %v", err))
-			t.Fatalf("Failed to calculate relative path: %v", err)
-		}
-
-		// Decompressed file path
-		decompPath := filepath.Join(decompressedDir, relPath)
-
-		// Check if file exists
-		_, err = os.Stat(decompPath)
-		if err != nil {
-			Error(fmt.Sprintf("Decompressed Unicode file not found: %s", filepath.Base(decompPath)))
-			t.Fatalf("Decompressed Unicode file not found: %s, error: %v", decompPath, err)
-		}
-
-		// Check file content
-		decompContent, err := os.ReadFile(decompPath)
-		if err != nil {
-			Error(fmt.Sprintf("Failed to read decompressed Unicode file: %v", err))
-			t.Fatalf("Failed to read decompressed Unicode file: %v", err)
-		}
-
-		if string(decompContent) != content {
-			Error(fmt.Sprintf("Content mismatch for Unicode file %s", filepath.Base(filePath)))
-			t.Fatalf("Content mismatch for Unicode file %s", relPath)
-		}
-
-		Success(fmt.Sprintf("Verified Unicode file: %s", filepath.Base(filePath)))
-	}
-
-	Info("All Unicode filenames and content were preserved correctly")
-	EndSection()
-
-	// ─── CONCLUSION ─────────────────────────────────────────────────
-	ReportEnd(true, time.Since(startTime))
-}
+// tests/edge_cases_test.go
+
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"agcp/pkg/core"
+)
+
+// TestEmptyFile tests handling of empty files
+func TestEmptyFile(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Empty File Handling")
+
+	// Create a temporary directory for testing
+	StartSection("Preparing Test Environment")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-empty-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create an empty file
+	Action("Creating empty test file (0 bytes)")
+	emptyFile := filepath.Join(testDir, "empty.txt")
+	err = os.WriteFile(emptyFile, []byte{}, 0644)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create empty file: %v", err))
+		t.Fatalf("Failed to create empty file: %v", err)
+	}
+	Success("Empty file created successfully")
+	EndSection()
+
+	// ─── COMPRESS ───────────────────────────────────────────────────
+	StartSection("Compressing Empty File")
+	Action("Compressing the empty file")
+	compressedFile := filepath.Join(testDir, "empty.agcp")
+	err = Compress(emptyFile, compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to compress empty file: %v", err))
+		t.Fatalf("Failed to compress empty file: %v", err)
+	}
+
+	// Verify the compressed file exists and has some content (headers)
+	info, err := os.Stat(compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Compressed file does not exist: %v", err))
+		t.Fatalf("Compressed file does not exist: %v", err)
+	}
+	if info.Size() == 0 {
+		Error("Compressed file is empty, expected header data")
+		t.Fatalf("Compressed file is empty, expected header data")
+	}
+
+	Success("Empty file compressed successfully")
+	Info(fmt.Sprintf("Archive size: %s (metadata and headers only)", HumanReadableSize(info.Size())))
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Decompressing Empty File Archive")
+	Action("Creating output directory for decompression")
+	decompressedDir := filepath.Join(testDir, "decompressed")
+	err = os.MkdirAll(decompressedDir, 0755)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
+		t.Fatalf("Failed to create decompression directory: %v", err)
+	}
+
+	Action("Decompressing the archive")
+	err = Decompress(compressedFile, decompressedDir)
+	if err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Failed to decompress empty file: %v", err)
+	}
+
+	// ─── VERIFY ─────────────────────────────────────────────────────
+	StartSection("Verifying Decompressed File")
+	Action("Checking that the decompressed file exists and is empty")
+
+	// Verify the decompressed file exists and is empty
+	decompressedFile := filepath.Join(decompressedDir, "empty.txt")
+	info, err = os.Stat(decompressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Decompressed file does not exist: %v", err))
+		t.Fatalf("Decompressed file does not exist: %v", err)
+	}
+
+	Success("Empty file decompressed successfully")
+	Info(fmt.Sprintf("Decompressed file size: %s", HumanReadableSize(info.Size())))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestLargeNumberOfFiles tests handling of directories with many files
+func TestLargeNumberOfFiles(t *testing.T) {
+	// Skip in short mode as this creates many files
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Multiple Files Handling")
+
+	// Create a temporary directory for testing
+	StartSection("Preparing Test Environment")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-many-files-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create a large number of small files
+	numFiles := 100 // Adjust as needed for testing
+	Action(fmt.Sprintf("Creating %d small text files in nested directories", numFiles))
+
+	var totalSize int64
+	for i := 0; i < numFiles; i++ {
+		filename := filepath.Join(testDir, filepath.FromSlash(filepath.Join("files", filepath.Join("subfolder", filepath.FromSlash(filepath.Join("depth3", filepath.FromSlash(filepath.Join("depth4", fmt.Sprintf("file%d.txt", i)))))))))
+
+		// Create all parent directories
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create directory structure: %v", err))
+			t.Fatalf("Failed to create directory structure: %v", err)
+		}
+
+		// Create a small file with content
+		content := []byte(fmt.Sprintf("This is file %d with some content.", i))
+		err = os.WriteFile(filename, content, 0644)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create file %s: %v", filename, err))
+			t.Fatalf("Failed to create file %s: %v", filename, err)
+		}
+
+		totalSize += int64(len(content))
+	}
+
+	Success("Created nested directory structure with multiple files")
+	Info(fmt.Sprintf("Total files created: %d", numFiles))
+	Info(fmt.Sprintf("Total data size: %s", HumanReadableSize(totalSize)))
+	Info("Directory structure depth: 5 levels")
+	EndSection()
+
+	// ─── COMPRESS ───────────────────────────────────────────────────
+	StartSection("Compressing Multiple Files")
+	Action("Compressing directory containing multiple files")
+	compressedFile := filepath.Join(testDir, "many-files.agcp")
+	err = Compress(filepath.Join(testDir, "files"), compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Failed to compress directory with many files: %v", err)
+	}
+
+	// Get compression stats
+	compressedInfo, err := os.Stat(compressedFile)
+	if err != nil {
+		Warning(fmt.Sprintf("Could not get compressed file info: %v", err))
+	} else {
+		compressedSize := compressedInfo.Size()
+		compressionRatio := float64(compressedSize) / float64(totalSize) * 100
+
+		Success("Directory compressed successfully")
+		Info(fmt.Sprintf("Original size: %s", HumanReadableSize(totalSize)))
+		Info(fmt.Sprintf("Compressed size: %s", HumanReadableSize(compressedSize)))
+		Info(fmt.Sprintf("Compression ratio: %.1f%%", compressionRatio))
+	}
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Decompressing Multiple Files")
+	Action("Creating output directory for decompression")
+	decompressedDir := filepath.Join(testDir, "decompressed")
+	err = os.MkdirAll(decompressedDir, 0755)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
+		t.Fatalf("Failed to create decompression directory: %v", err)
+	}
+
+	Action("Decompressing the archive")
+	err = Decompress(compressedFile, decompressedDir)
+	if err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Failed to decompress directory with many files: %v", err)
+	}
+
+	Success("Archive decompressed successfully")
+	EndSection()
+
+	// ─── VERIFY ─────────────────────────────────────────────────────
+	StartSection("Verifying Decompressed Files")
+	Action("Scanning files to verify correct decompression")
+
+	// Verify that all files were decompressed correctly
+	expectedDir := filepath.Join(testDir, "files")
+	var checkError error
+	var checkedFiles int
+
+	err = filepath.Walk(expectedDir, func(origPath string, origInfo os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		// Skip directories, we only check files
+		if origInfo.IsDir() {
+			return nil
+		}
+
+		// Calculate relative path
+		relPath, err := filepath.Rel(expectedDir, origPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %v", err)
+		}
+
+		// Get path in decompressed directory
+		decompPath := filepath.Join(decompressedDir, relPath)
+
+		// Check if decompressed file exists
+		decompInfo, err := os.Stat(decompPath)
+		if err != nil {
+			checkError = fmt.Errorf("file %s not found in decompressed directory: %v", relPath, err)
+			return filepath.SkipDir
+		}
+
+		// Check if sizes match
+		if decompInfo.Size() != origInfo.Size() {
+			checkError = fmt.Errorf("size mismatch for %s: original %d, decompressed %d",
+				relPath, origInfo.Size(), decompInfo.Size())
+			return filepath.SkipDir
+		}
+
+		// File exists and size matches
+		checkedFiles++
+		return nil
+	})
+
+	if err != nil {
+		Error(fmt.Sprintf("Error walking directory: %v", err))
+		t.Fatalf("Error walking original directory: %v", err)
+	}
+
+	if checkError != nil {
+		Error(fmt.Sprintf("Verification failed: %v", checkError))
+		t.Fatalf("Verification failed: %v", checkError)
+	}
+
+	Success(fmt.Sprintf("Successfully verified %d files", checkedFiles))
+	if checkedFiles == numFiles {
+		Info("All files verified - file count matches exactly")
+	} else {
+		Warning(fmt.Sprintf("File count mismatch: expected %d, got %d", numFiles, checkedFiles))
+	}
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestConcurrentProcessing tests concurrent compression and decompression
+func TestConcurrentProcessing(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Concurrent Processing")
+
+	// Create a temporary directory for testing
+	StartSection("Preparing Test Environment")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-concurrent-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create multiple test files
+	numFiles := 5
+	Action(fmt.Sprintf("Creating %d test files of varying sizes", numFiles))
+	filePaths := make([]string, numFiles)
+	compressedPaths := make([]string, numFiles)
+	decompressedDirs := make([]string, numFiles)
+
+	var totalSize int64
+	for i := 0; i < numFiles; i++ {
+		// Create a test file with unique content
+		fileName := fmt.Sprintf("test-file-%d.dat", i)
+		filePath := filepath.Join(testDir, fileName)
+		filePaths[i] = filePath
+
+		// Create content of varying sizes
+		size := 100 * 1024 * (i + 1) // 100KB * (i+1)
+		content := make([]byte, size)
+		for j := 0; j < size; j++ {
+			content[j] = byte((j + i) % 256)
+		}
+
+		err = os.WriteFile(filePath, content, 0644)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create test file %s: %v", filePath, err))
+			t.Fatalf("Failed to create test file %s: %v", filePath, err)
+		}
+
+		totalSize += int64(size)
+
+		// Set up paths for compressed and decompressed files
+		compressedPaths[i] = filepath.Join(testDir, fmt.Sprintf("compressed-%d.agcp", i))
+		decompressedDirs[i] = filepath.Join(testDir, fmt.Sprintf("decompressed-%d", i))
+
+		// Create decompression directories
+		err = os.MkdirAll(decompressedDirs[i], 0755)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
+			t.Fatalf("Failed to create decompression directory: %v", err)
+		}
+	}
+
+	Success("Test files created successfully")
+	Info(fmt.Sprintf("Created %d files with sizes from 100KB to %dKB", numFiles, 100*numFiles))
+	Info(fmt.Sprintf("Total data size: %s", HumanReadableSize(totalSize)))
+	EndSection()
+
+	// ─── CONCURRENT COMPRESSION ──────────────────────────────────────
+	StartSection("Concurrent Compression")
+	Action(fmt.Sprintf("Compressing %d files simultaneously with goroutines", numFiles))
+	var wg sync.WaitGroup
+	errors := make(chan error, numFiles*2)
+
+	// Compress concurrently
+	for i := 0; i < numFiles; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			err := Compress(filePaths[idx], compressedPaths[idx])
+			if err != nil {
+				errors <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Check for errors
+	select {
+	case err := <-errors:
+		Error(fmt.Sprintf("Compression error: %v", err))
+		t.Fatalf("Error during concurrent compression: %v", err)
+	default:
+		Success("All files compressed successfully in parallel")
+	}
+	EndSection()
+
+	// ─── CONCURRENT DECOMPRESSION ────────────────────────────────────
+	StartSection("Concurrent Decompression")
+	Action(fmt.Sprintf("Decompressing %d archives simultaneously with goroutines", numFiles))
+
+	// Decompress concurrently
+	for i := 0; i < numFiles; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			err := Decompress(compressedPaths[idx], decompressedDirs[idx])
+			if err != nil {
+				errors <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Check for errors
+	select {
+	case err := <-errors:
+		Error(fmt.Sprintf("Decompression error: %v", err))
+		t.Fatalf("Error during concurrent decompression: %v", err)
+	default:
+		Success("All archives decompressed successfully in parallel")
+	}
+	EndSection()
+
+	// ─── VERIFY ─────────────────────────────────────────────────────
+	StartSection("Verifying Decompressed Files")
+	Action("Checking all files for size and integrity")
+
+	// Verify all files were processed correctly
+	for i := 0; i < numFiles; i++ {
+		// Original file information
+		origInfo, err := os.Stat(filePaths[i])
+		if err != nil {
+			Error(fmt.Sprintf("Failed to access original file %s: %v", filePaths[i], err))
+			t.Fatalf("Failed to access original file %s: %v", filePaths[i], err)
+		}
+
+		// Get the decompressed file path
+		fileName := filepath.Base(filePaths[i])
+		decompressedPath := filepath.Join(decompressedDirs[i], fileName)
+
+		// Decompressed file information
+		decompInfo, err := os.Stat(decompressedPath)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to access decompressed file %s: %v", decompressedPath, err))
+			t.Fatalf("Failed to access decompressed file %s: %v", decompressedPath, err)
+		}
+
+		// Check if sizes match
+		if origInfo.Size() != decompInfo.Size() {
+			Error(fmt.Sprintf("Size mismatch for %s: original %s, decompressed %s",
+				fileName, HumanReadableSize(origInfo.Size()), HumanReadableSize(decompInfo.Size())))
+			t.Fatalf("Size mismatch for %s: original %d, decompressed %d",
+				fileName, origInfo.Size(), decompInfo.Size())
+		}
+
+		Info(fmt.Sprintf("Verified file %d of %d: %s (%s)", i+1, numFiles, fileName,
+			HumanReadableSize(origInfo.Size())))
+	}
+
+	Success(fmt.Sprintf("Successfully processed and verified %d files concurrently", numFiles))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestUnicodePaths tests handling of files and directories with Unicode characters in paths
+func TestUnicodePaths(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Unicode Path Handling")
+
+	// Create a temporary directory for testing
+	StartSection("Preparing Test Environment")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-unicode-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create directories and files with Unicode characters
+	Action("Creating directories with Unicode characters in names")
+	unicodeDirs := []string{
+		filepath.Join(testDir, "😀-emoji-dir"),
+		filepath.Join(testDir, "中文目录"),          // Chinese directory
+		filepath.Join(testDir, "Русская-папка"), // Russian directory
+	}
+
+	for _, dir := range unicodeDirs {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create Unicode directory %s: %v", dir, err))
+			t.Fatalf("Failed to create Unicode directory %s: %v", dir, err)
+		}
+		Info(fmt.Sprintf("Created directory: %s", filepath.Base(dir)))
+	}
+
+	// Create files with Unicode names
+	Action("Creating files with Unicode names and content")
+	unicodeFiles := map[string]string{
+		filepath.Join(unicodeDirs[0], "emoji-file-😎.txt"): "This is an emoji file.",
+		filepath.Join(unicodeDirs[1], "文件.txt"):           "This is a Chinese filename.",
+		filepath.Join(unicodeDirs[2], "файл.txt"):         "This is a Russian filename.",
+	}
+
+	for filePath, content := range unicodeFiles {
+		err = os.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create Unicode file %s: %v", filePath, err))
+			t.Fatalf("Failed to create Unicode file %s: %v", filePath, err)
+		}
+		Info(fmt.Sprintf("Created file: %s", filepath.Base(filePath)))
+	}
+
+	Success("Successfully created directories and files with Unicode names")
+	EndSection()
+
+	// ─── COMPRESS ───────────────────────────────────────────────────
+	StartSection("Compressing Files with Unicode Paths")
+	Action("Compressing directory tree with Unicode paths")
+	compressedFile := filepath.Join(testDir, "unicode-test.agcp")
+	err = Compress(testDir, compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Failed to compress directory with Unicode paths: %v", err)
+	}
+
+	Success("Directory with Unicode paths compressed successfully")
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Decompressing Files with Unicode Paths")
+	Action("Creating output directory for decompression")
+	decompressedDir := filepath.Join(testDir, "decompressed")
+	err = os.MkdirAll(decompressedDir, 0755)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create decompression directory: %v", err))
+		t.Fatalf("Failed to create decompression directory: %v", err)
+	}
+
+	Action("Decompressing the archive with Unicode paths")
+	err = Decompress(compressedFile, decompressedDir)
+	if err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Failed to decompress directory with Unicode paths: %v", err)
+	}
+
+	Success("Archive with Unicode paths decompressed successfully")
+	EndSection()
+
+	// ─── VERIFY ─────────────────────────────────────────────────────
+	StartSection("Verifying Files with Unicode Paths")
+	Action("Checking that Unicode filenames and content are preserved")
+
+	// Verify all files were decompressed correctly
+	for filePath, content := range unicodeFiles {
+		// Calculate relative path
+		relPath, err := filepath.Rel(testDir, filePath)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to calculate relative path: %v", err))
+			t.Fatalf("Failed to calculate relative path: %v", err)
+		}
+
+		// Decompressed file path
+		decompPath := filepath.Join(decompressedDir, relPath)
+
+		// Check if file exists
+		_, err = os.Stat(decompPath)
+		if err != nil {
+			Error(fmt.Sprintf("Decompressed Unicode file not found: %s", filepath.Base(decompPath)))
+			t.Fatalf("Decompressed Unicode file not found: %s, error: %v", decompPath, err)
+		}
+
+		// Check file content
+		decompContent, err := os.ReadFile(decompPath)
+		if err != nil {
+			Error(fmt.Sprintf("Failed to read decompressed Unicode file: %v", err))
+			t.Fatalf("Failed to read decompressed Unicode file: %v", err)
+		}
+
+		if string(decompContent) != content {
+			Error(fmt.Sprintf("Content mismatch for Unicode file %s", filepath.Base(filePath)))
+			t.Fatalf("Content mismatch for Unicode file %s", relPath)
+		}
+
+		Success(fmt.Sprintf("Verified Unicode file: %s", filepath.Base(filePath)))
+	}
+
+	Info("All Unicode filenames and content were preserved correctly")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCorruptArchiveDetection tests that truncated archives and damaged headers are rejected on open
+func TestCorruptArchiveDetection(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Corrupt Archive Detection")
+
+	StartSection("Preparing Test Archive")
+	Action("Creating temporary directory for test files")
+	testDir, err := os.MkdirTemp("", "agcp-corrupt-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	testFile := filepath.Join(testDir, "data.txt")
+	err = os.WriteFile(testFile, []byte("some data that will be archived and then damaged"), 0644)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to write test file: %v", err))
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	compressedFile := filepath.Join(testDir, "data.agcp")
+	err = Compress(testFile, compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	archive, err := os.ReadFile(compressedFile)
+	if err != nil {
+		Error(fmt.Sprintf("Failed to read archive: %v", err))
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	Success("Test archive created successfully")
+	EndSection()
+
+	// ─── CORRUPT ────────────────────────────────────────────────────
+	StartSection("Decompressing Damaged Archives")
+	flipped := append([]byte(nil), archive...)
+	flipped[len(Magic)+3] ^= 0xFF // inside the root name
+
+	cases := map[string][]byte{
+		"truncated": archive[:len(archive)-5],
+		"bit-flip":  flipped,
+	}
+	for name, data := range cases {
+		Action(fmt.Sprintf("Decompressing %s archive", name))
+		damaged := filepath.Join(testDir, name+".agcp")
+		if err := os.WriteFile(damaged, data, 0644); err != nil {
+			Error(fmt.Sprintf("Failed to write damaged archive: %v", err))
+			t.Fatalf("Failed to write damaged archive: %v", err)
+		}
+
+		err := Decompress(damaged, filepath.Join(testDir, name+"-out"))
+		if err == nil {
+			Error(fmt.Sprintf("Damaged archive (%s) was accepted", name))
+			t.Fatalf("Expected error decompressing %s archive", name)
+		}
+		if _, statErr := os.Stat(filepath.Join(testDir, name+"-out")); statErr == nil {
+			Error("Output was created for a damaged archive")
+			t.Fatalf("Output should not be created for %s archive", name)
+		}
+		Success(fmt.Sprintf("Rejected %s archive: %v", name, err))
+	}
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestOutputInsideInput tests that an archive written inside its own input tree is never included in itself
+func TestOutputInsideInput(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Output Inside Input Tree")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-self-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("payload"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Source directory created")
+	EndSection()
+
+	// ─── COMPRESS ───────────────────────────────────────────────────
+	StartSection("Compressing Into the Input Directory Twice")
+	archive := filepath.Join(srcDir, "backup.agcp")
+	for run := 1; run <= 2; run++ {
+		Action(fmt.Sprintf("Compression run %d", run))
+		if err := Compress(srcDir, archive); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression run %d failed: %v", run, err)
+		}
+	}
+
+	out := filepath.Join(testDir, "out")
+	if err := Decompress(archive, out); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "backup.agcp")); err == nil {
+		Error("Archive contains a copy of itself")
+		t.Fatalf("Second run included the previous archive in itself")
+	}
+	Success("Previous archive was excluded from the new one")
+
+	Action("Compressing a file onto itself")
+	if err := Compress(filepath.Join(srcDir, "data.txt"), filepath.Join(srcDir, "data.txt")); err == nil {
+		Error("Compressing a file into itself was accepted")
+		t.Fatalf("Expected an error compressing a file into itself")
+	}
+	Success("Compressing a file into itself was refused")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestIncompressibleGuard tests that --min-ratio aborts or switches to storing on incompressible data
+func TestIncompressibleGuard(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Incompressible Data Guard")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-ratio-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	random := make([]byte, 2<<20)
+	rand.Read(random)
+	files := map[string][]byte{
+		"a-text.txt":   bytes.Repeat([]byte("compressible "), 1000),
+		"b-random.bin": random,
+		"c-more.txt":   bytes.Repeat([]byte("more compressible text "), 1000),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	// ─── ABORT ──────────────────────────────────────────────────────
+	StartSection("Aborting On Incompressible Data")
+	opts := core.Options{MinRatio: 0.95, RatioSample: 1 << 20}
+	err = core.CompressWithOptions(srcDir, filepath.Join(testDir, "abort.agcp"), opts)
+	if !errors.Is(err, core.ErrIncompressible) {
+		Error(fmt.Sprintf("Unexpected result: %v", err))
+		t.Fatalf("Expected ErrIncompressible, got %v", err)
+	}
+	var entryErr *core.EntryError
+	if !errors.As(err, &entryErr) || entryErr.Path != "b-random.bin" || entryErr.Op != "compress" {
+		Error(fmt.Sprintf("Error does not identify the entry: %#v", entryErr))
+		t.Fatalf("Expected an EntryError for b-random.bin, got %v", err)
+	}
+	Success(fmt.Sprintf("Compression aborted: %v", err))
+	EndSection()
+
+	// ─── STORE ──────────────────────────────────────────────────────
+	StartSection("Storing Incompressible Data")
+	var warnings []string
+	opts.StoreIncompressible = true
+	opts.Warn = func(w core.Warning) { warnings = append(warnings, w.String()) }
+	archive := filepath.Join(testDir, "store.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Expected one warning about storing, got %q", warnings)
+	}
+	Info(warnings[0])
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Extracted tree differs: %v", err)
+	}
+	Success("Stored entries extracted intact")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestVerify tests full and fast verification of intact and damaged archives
+func TestVerify(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Archive Verification")
+
+	StartSection("Preparing Test Archive")
+	testDir, err := os.MkdirTemp("", "agcp-verify-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("entry %d line\n", i)), 2000)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Test archive created successfully")
+	EndSection()
+
+	// ─── INTACT ─────────────────────────────────────────────────────
+	StartSection("Verifying Intact Archive")
+	for _, fast := range []bool{false, true} {
+		report, err := core.Verify(archive, fast, core.Options{})
+		if err != nil {
+			Error(fmt.Sprintf("Verify failed: %v", err))
+			t.Fatalf("Verify(fast=%v) failed: %v", fast, err)
+		}
+		if report.Entries != 8 || len(report.Failures) != 0 {
+			t.Fatalf("Verify(fast=%v): %d entries, failures %v", fast, report.Entries, report.Failures)
+		}
+		if !fast && report.Bytes == 0 {
+			t.Fatal("Full verification decoded no data")
+		}
+	}
+	Success("Full and fast verification pass")
+	EndSection()
+
+	// ─── DAMAGED ────────────────────────────────────────────────────
+	StartSection("Verifying Damaged Archive")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data[len(data)-30] ^= 0xFF // inside the last entry's data
+	damaged := filepath.Join(testDir, "damaged.agcp")
+	if err := os.WriteFile(damaged, data, 0644); err != nil {
+		t.Fatalf("Failed to write damaged archive: %v", err)
+	}
+
+	report, err := core.Verify(damaged, false, core.Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(report.Failures) != 1 {
+		Error(fmt.Sprintf("Unexpected failures: %v", report.Failures))
+		t.Fatalf("Expected exactly one failing entry, got %v", report.Failures)
+	}
+	Success(fmt.Sprintf("Damaged entry reported: %v", report.Failures[0]))
+
+	Action("Fast mode skips entry data")
+	report, err = core.Verify(damaged, true, core.Options{})
+	if err != nil || len(report.Failures) != 0 {
+		t.Fatalf("Expected fast verification to pass data damage, got %v / %v", err, report)
+	}
+
+	Action("Truncated archive")
+	truncated := filepath.Join(testDir, "truncated.agcp")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Failed to write truncated archive: %v", err)
+	}
+	if _, err := core.Verify(truncated, true, core.Options{}); err == nil {
+		t.Fatal("Expected fast verification to reject a truncated archive")
+	}
+	Success("Fast verification rejects truncation")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestOutputTemplate tests expansion of template tokens in output names
+func TestOutputTemplate(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Output Name Templates")
+
+	StartSection("Expanding Templates")
+	now := time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC)
+	host, _ := os.Hostname()
+	cases := map[string]string{
+		"plain.agcp":                         "plain.agcp",
+		"backup-{name}-{date}.agcp":          "backup-project-2024-03-09.agcp",
+		"{name}-{date:20060102}-{time}.agcp": "project-20240309-140507.agcp",
+		"{name}-{time:15h04}-{host}.agcp":    "project-14h05-" + host + ".agcp",
+		"nested/{date:2006}/{name}.agcp":     "nested/2024/project.agcp",
+	}
+	for template, want := range cases {
+		got, err := core.ExpandOutputTemplate(template, "/data/project/", now)
+		if err != nil || got != want {
+			Error(fmt.Sprintf("%s expanded to %q (%v)", template, got, err))
+			t.Fatalf("ExpandOutputTemplate(%q) = %q, %v; want %q", template, got, err, want)
+		}
+	}
+	a, _ := core.ExpandOutputTemplate("{uuid}", "x", now)
+	b, _ := core.ExpandOutputTemplate("{uuid}", "x", now)
+	if len(a) != 36 || a == b {
+		t.Fatalf("Expected distinct 36-character UUIDs, got %q and %q", a, b)
+	}
+	Success("Tokens expanded correctly")
+
+	Action("Rejecting malformed templates")
+	for _, bad := range []string{"{unknown}.agcp", "{name.agcp"} {
+		if _, err := core.ExpandOutputTemplate(bad, "x", now); err == nil {
+			t.Fatalf("Expected an error for %q", bad)
+		}
+	}
+	Success("Malformed templates rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestPartialOutput tests that a partial archive left by an interrupted run is detected and can be resumed or overwritten
+func TestPartialOutput(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Partial Output Detection")
+
+	StartSection("Interrupting A Compression")
+	testDir, err := os.MkdirTemp("", "agcp-partial-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	random := make([]byte, 2<<20)
+	rand.Read(random)
+	files := map[string][]byte{
+		"a.txt":      bytes.Repeat([]byte("first entry "), 1000),
+		"b.txt":      bytes.Repeat([]byte("second entry "), 1000),
+		"c-rand.bin": random,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	// The ratio guard trips inside the last entry, leaving the first two complete.
+	// The partial archive is removed unless it is asked to be kept.
+	archive := filepath.Join(testDir, "src.agcp")
+	err = core.CompressWithOptions(srcDir, archive, core.Options{MinRatio: 0.95, RatioSample: 1 << 20})
+	if !errors.Is(err, core.ErrIncompressible) {
+		t.Fatalf("Expected the compression to be interrupted, got %v", err)
+	}
+	if _, err := os.Stat(core.PartialPath(archive)); !os.IsNotExist(err) {
+		t.Fatalf("Failed run should remove its partial archive: %v", err)
+	}
+	err = core.CompressWithOptions(srcDir, archive, core.Options{MinRatio: 0.95, RatioSample: 1 << 20, KeepPartial: true})
+	if !errors.Is(err, core.ErrIncompressible) {
+		t.Fatalf("Expected the compression to be interrupted, got %v", err)
+	}
+	if _, err := os.Stat(core.PartialPath(archive)); err != nil {
+		t.Fatalf("Expected a partial archive: %v", err)
+	}
+	if _, err := os.Stat(archive); err == nil {
+		t.Fatal("Interrupted run must not leave a finished-looking archive")
+	}
+	Success("Interrupted run left only the partial archive")
+	EndSection()
+
+	// ─── DETECT ─────────────────────────────────────────────────────
+	StartSection("Detecting And Resuming")
+	err = core.Compress(context.Background(), srcDir, archive, core.Options{})
+	if !errors.Is(err, core.ErrPartialOutput) {
+		Error(fmt.Sprintf("Unexpected result: %v", err))
+		t.Fatalf("Expected ErrPartialOutput, got %v", err)
+	}
+	Success(fmt.Sprintf("Partial archive detected: %v", err))
+
+	var warnings []string
+	opts := core.Options{Partial: core.PartialResume, Warn: func(w core.Warning) { warnings = append(warnings, w.String()) }}
+	if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+		Error(fmt.Sprintf("Resume failed: %v", err))
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "2 of 3") {
+		t.Fatalf("Expected a warning about 2 of 3 resumed entries, got %q", warnings)
+	}
+	Info(warnings[0])
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompressing resumed archive failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Resumed archive differs: %v", err)
+	}
+	Success("Resumed archive is complete and correct")
+
+	Action("Overwriting a partial archive")
+	if err := os.WriteFile(core.PartialPath(archive), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to write partial archive: %v", err)
+	}
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Partial: core.PartialOverwrite}); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if _, err := os.Stat(core.PartialPath(archive)); !os.IsNotExist(err) {
+		t.Fatalf("Partial archive should be gone after a successful run: %v", err)
+	}
+	Success("Partial archive overwritten")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCompressBySize tests splitting small and large files into two archives that together restore the tree
+func TestCompressBySize(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Size-Tiered Split")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-tier-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string]int{
+		"small-1.txt":      100,
+		"nested/small.txt": 4 << 10,
+		"large.bin":        256 << 10,
+		"nested/large.bin": 300 << 10,
+	}
+	for name, size := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Compressing By Size")
+	small := filepath.Join(testDir, "small.agcp")
+	large := filepath.Join(testDir, "large.agcp")
+	if err := core.CompressBySize(srcDir, 64<<10, small, large, core.Options{}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("CompressBySize failed: %v", err)
+	}
+	for archive, want := range map[string][]string{
+		small: {"nested/small.txt", "small-1.txt"},
+		large: {"large.bin", "nested/large.bin"},
+	} {
+		report, err := core.Verify(archive, true, core.Options{})
+		if err != nil || report.Entries != len(want) {
+			t.Fatalf("%s: expected %d entries, got %v (%v)", filepath.Base(archive), len(want), report, err)
+		}
+	}
+	Success("Each archive holds its size tier")
+
+	outDir := filepath.Join(testDir, "out")
+	for _, archive := range []string{small, large} {
+		if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+			t.Fatalf("Decompression of %s failed: %v", archive, err)
+		}
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Extracting both archives restores the tree")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestGzipCodec tests that archives written with the standard library gzip codec round-trip
+func TestGzipCodec(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Gzip Codec")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-gzip-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string][]byte{
+		"text.txt":       bytes.Repeat([]byte("gzip codec test line\n"), 5000),
+		"nested/empty":   nil,
+		"nested/tiny.md": []byte("# tiny\n"),
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Round Trip")
+	archive := filepath.Join(testDir, "gzip.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Codec: core.CodecGzip, Level: 9}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	report, err := core.Verify(archive, false, core.Options{})
+	if err != nil || len(report.Failures) > 0 {
+		t.Fatalf("Verify failed: %v %v", err, report)
+	}
+	Success("Gzip archive verifies")
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Gzip archive restores the tree")
+
+	if _, err := core.ParseCodec("brotli"); err == nil {
+		t.Fatalf("expected an error for an unknown codec")
+	}
+	Success("Unknown codec names are rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestZstdCodec(t *testing.T) {
+	requireCodec(t, "zstd")
+	startTime := time.Now()
+	ReportStart("Zstd Codec")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-zstd-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	var text bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&text, "2026-10-15T03:%02d:%02d host%d request handled in %dms\n", i/60%60, i%60, i%7, i%250)
+	}
+	files := map[string][]byte{
+		"access.log":     text.Bytes(),
+		"nested/empty":   nil,
+		"nested/tiny.md": []byte("# tiny\n"),
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Round Trip")
+	for _, level := range []int{0, 9} {
+		archive := filepath.Join(testDir, fmt.Sprintf("zstd-%d.agcp", level))
+		if err := core.CompressWithOptions(srcDir, archive, core.Options{Codec: core.CodecZstd, Level: level}); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression failed: %v", err)
+		}
+		report, err := core.Verify(archive, false, core.Options{})
+		if err != nil || len(report.Failures) > 0 {
+			t.Fatalf("Verify failed: %v %v", err, report)
+		}
+		entry, err := func() (core.EntryInfo, error) {
+			a, err := core.OpenArchive(archive, core.Options{})
+			if err != nil {
+				return core.EntryInfo{}, err
+			}
+			defer a.Close()
+			return a.Stat("access.log")
+		}()
+		if err != nil || entry.Codec != "zstd" {
+			t.Fatalf("access.log = %+v, %v; want zstd", entry, err)
+		}
+		if ratio := entry.Ratio(); ratio <= 0 || ratio >= 0.5 {
+			t.Fatalf("access.log compressed to %.1f%% of its size; want well under half", ratio*100)
+		}
+		outDir := filepath.Join(testDir, fmt.Sprintf("out-%d", level))
+		if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if err := compareTrees(srcDir, outDir); err != nil {
+			t.Fatalf("Restored tree differs: %v", err)
+		}
+	}
+	Success("Zstd archives verify and restore the tree at levels 0 and 9")
+	EndSection()
+
+	StartSection("Comparing With LZ4")
+	lz4Archive := filepath.Join(testDir, "lz4.agcp")
+	if err := core.CompressWithOptions(srcDir, lz4Archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	lz4Info, err := os.Stat(lz4Archive)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	zstdInfo, err := os.Stat(filepath.Join(testDir, "zstd-0.agcp"))
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if zstdInfo.Size() >= lz4Info.Size() {
+		t.Fatalf("zstd archive is %d bytes, LZ4 archive %d; want zstd smaller on text", zstdInfo.Size(), lz4Info.Size())
+	}
+	Success(fmt.Sprintf("Text compresses to %s with zstd and %s with LZ4", HumanReadableSize(zstdInfo.Size()), HumanReadableSize(lz4Info.Size())))
+	EndSection()
+
+	StartSection("Recording the Minimum Reader Version")
+	data, err := os.ReadFile(filepath.Join(testDir, "zstd-0.agcp"))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if data[4] != core.Version || data[5] != 8 {
+		t.Fatalf("header records version %d, minimum reader %d; want %d and 8", data[4], data[5], core.Version)
+	}
+	Success("Archives using zstd need a reader of format v8")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// xorCodec is a toy registered codec that flips every bit of the data
+type xorCodec struct{}
+
+func (xorCodec) ID() uint8    { return 200 }
+func (xorCodec) Name() string { return "xor" }
+
+func (xorCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return xorWriter{w}, nil
+}
+
+func (xorCodec) NewReader(r io.Reader) (io.Reader, error) {
+	return xorReader{r}, nil
+}
+
+type xorWriter struct{ w io.Writer }
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = ^b
+	}
+	return x.w.Write(buf)
+}
+
+func (xorWriter) Close() error { return nil }
+
+type xorReader struct{ r io.Reader }
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+// TestCustomCodec tests that a registered codec round-trips, and that archives
+// using a codec that is not registered list but fail to extract clearly
+func TestCustomCodec(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Custom Codecs")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-custom-codec-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "nested"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), bytes.Repeat([]byte("custom codec\n"), 1000), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "nested", "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Registering")
+	xor, err := core.RegisterCodec(xorCodec{})
+	if err != nil {
+		t.Fatalf("RegisterCodec failed: %v", err)
+	}
+	if parsed, err := core.ParseCodec("XOR"); err != nil || parsed != xor || xor.String() != "xor" {
+		t.Fatalf("expected ParseCodec to find the registered codec, got %v %v", parsed, err)
+	}
+	if _, err := core.RegisterCodec(xorCodec{}); err == nil {
+		t.Fatal("expected registering the same ID twice to fail")
+	}
+	Success("Codec registered and selectable by name; duplicates are refused")
+	EndSection()
+
+	StartSection("Round Trip")
+	archive := filepath.Join(testDir, "xor.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Codec: xor}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Codec != "xor" {
+			t.Fatalf("expected %s to use xor, got %s", entry.Path, entry.Codec)
+		}
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Archive written with the custom codec restores the tree")
+	EndSection()
+
+	StartSection("Unregistered Codec")
+	// Point the entries at codec 201, which is never registered, and re-seal the header
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	trailer := data[len(data)-len(core.TrailerMagic)-12:]
+	headerLen := binary.BigEndian.Uint64(trailer[0:8])
+	header := data[:headerLen]
+	codecAttr := []byte{2, 0, 1, 200}
+	if bytes.Count(header, codecAttr) != len(entries) {
+		t.Fatalf("expected %d codec attributes in the header", len(entries))
+	}
+	copy(header, bytes.ReplaceAll(header, codecAttr, []byte{2, 0, 1, 201}))
+	binary.BigEndian.PutUint32(trailer[8:12], crc32.ChecksumIEEE(header))
+	unknown := filepath.Join(testDir, "unknown.agcp")
+	if err := os.WriteFile(unknown, data, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	entries, err = core.ListEntries(unknown)
+	if err != nil || entries[0].Codec != "codec 201" {
+		t.Fatalf("expected the archive to list with codec 201, got %v %v", entries, err)
+	}
+	err = core.Decompress(context.Background(), unknown, filepath.Join(testDir, "unknown"), core.Options{})
+	if !errors.Is(err, core.ErrCodecUnavailable) || !strings.Contains(err.Error(), "codec 201 not available") {
+		t.Fatalf("expected codec 201 not available, got %v", err)
+	}
+	Success(fmt.Sprintf("Extraction refused: %v", err))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestEntryTags tests that tags attached by glob rules are stored per entry and listed
+func TestEntryTags(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Entry Tags")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-tags-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	for _, name := range []string{"logs/app.log", "logs/old/app.log", "data/blob.bin", "readme.txt"} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Tagging Entries")
+	var rules []core.TagRule
+	for _, spec := range []string{"logs/**=retention:30d", "logs/old/*=retention:7d", "**/*.bin=binary"} {
+		rule, err := core.ParseTagRule(spec)
+		if err != nil {
+			t.Fatalf("ParseTagRule(%q) failed: %v", spec, err)
+		}
+		rules = append(rules, rule)
+	}
+	for _, bad := range []string{"logs/**", "=retention:1d", "logs/**=:1d", "[=x:y"} {
+		if _, err := core.ParseTagRule(bad); err == nil {
+			t.Fatalf("ParseTagRule(%q) should fail", bad)
+		}
+	}
+	Success("Tag rules parsed; malformed rules rejected")
+
+	archive := filepath.Join(testDir, "tags.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Tags: rules}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+
+	want := map[string]map[string]string{
+		"logs/app.log":     {"retention": "30d"},
+		"logs/old/app.log": {"retention": "7d"},
+		"data/blob.bin":    {"binary": ""},
+		"readme.txt":       nil,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(entries))
+	}
+	for _, entry := range entries {
+		wantTags, ok := want[filepath.ToSlash(entry.Path)]
+		if !ok {
+			t.Fatalf("unexpected entry %s", entry.Path)
+		}
+		if fmt.Sprint(entry.Tags) != fmt.Sprint(wantTags) {
+			t.Errorf("%s: tags %v, want %v", entry.Path, entry.Tags, wantTags)
+		}
+	}
+	Success("Each entry carries the tags of its matching rules")
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Tagged archive extracts normally")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestArchiveHandle tests repeated lookups and reads through an open Archive with a content cache
+func TestArchiveHandle(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Archive Handle")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-handle-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string][]byte{
+		"a.txt":        bytes.Repeat([]byte("a"), 600),
+		"sub/b.txt":    bytes.Repeat([]byte("b"), 600),
+		"sub/deep/c":   bytes.Repeat([]byte("c"), 5000),
+		"sub/empty.md": nil,
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "handle.agcp")
+	before := time.Now()
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	after := time.Now()
+	Success("Archive created successfully")
+	EndSection()
+
+	StartSection("Reading Entries")
+	a, err := core.OpenArchive(archive, core.Options{CacheSize: 1000})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	defer a.Close()
+
+	if created := a.Created(); created.Before(before) || created.After(after) || created.Location() != time.UTC {
+		t.Fatalf("Created() = %v, want a UTC time between %v and %v", created, before, after)
+	}
+	if mtime := a.Entries()[0].ModTime; mtime.IsZero() || mtime.Location() != time.UTC {
+		t.Fatalf("expected a UTC modification time, got %v", mtime)
+	}
+	Success("Creation and modification times are recorded in UTC")
+
+	if n := len(a.Entries()); n != len(files) {
+		t.Fatalf("expected %d entries, got %d", len(files), n)
+	}
+	// Read every entry twice; the cache holds one 600-byte entry at a time and skips the large one
+	for round := 0; round < 2; round++ {
+		for name, want := range files {
+			info, err := a.Stat(name)
+			if err != nil {
+				t.Fatalf("Stat(%s) failed: %v", name, err)
+			}
+			if info.OriginalSize != uint64(len(want)) {
+				t.Fatalf("Stat(%s): size %d, want %d", name, info.OriginalSize, len(want))
+			}
+			got, err := a.ReadEntry(name)
+			if err != nil {
+				t.Fatalf("ReadEntry(%s) failed: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("ReadEntry(%s): content differs", name)
+			}
+		}
+	}
+	Success("Stat and ReadEntry return every entry, cached or not")
+
+	if _, err := a.Stat("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist for a missing entry, got %v", err)
+	}
+	Success("Missing entries report fs.ErrNotExist")
+
+	for _, name := range []string{"a.txt", "sub/deep/c"} {
+		dest := filepath.Join(testDir, "out", name)
+		if err := a.ExtractEntry(name, dest); err != nil {
+			t.Fatalf("ExtractEntry(%s) failed: %v", name, err)
+		}
+		got, err := os.ReadFile(dest)
+		if err != nil || !bytes.Equal(got, files[name]) {
+			t.Fatalf("extracted %s differs: %v", name, err)
+		}
+	}
+	Success("ExtractEntry writes single entries")
+
+	for _, tc := range []struct {
+		name string
+		n    int64
+		want int
+	}{
+		{"sub/deep/c", 100, 100},  // Decoded only up to n
+		{"a.txt", 100, 100},       // Served from the cache
+		{"sub/b.txt", 4096, 600},  // Shorter than n
+		{"sub/empty.md", 4096, 0}, // Empty entry
+		{"sub/deep/c", 0, 0},      // Nothing asked for
+	} {
+		got, err := a.HeadEntry(tc.name, tc.n)
+		if err != nil {
+			t.Fatalf("HeadEntry(%s, %d) failed: %v", tc.name, tc.n, err)
+		}
+		if !bytes.Equal(got, files[tc.name][:tc.want]) {
+			t.Fatalf("HeadEntry(%s, %d): got %d bytes, want the first %d", tc.name, tc.n, len(got), tc.want)
+		}
+	}
+	if _, err := a.HeadEntry("missing.txt", 10); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist for a missing entry, got %v", err)
+	}
+	Success("HeadEntry returns the start of an entry")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestRecursiveUnpack tests unpacking nested agcp, zip and tar.gz archives in place up to a depth limit
+func TestRecursiveUnpack(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Recursive Unpacking")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-recursive-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	writeFile := func(path string, content []byte) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	// innermost.agcp holds deep.txt; inner.agcp holds innermost.agcp
+	writeFile(filepath.Join(testDir, "innermost", "deep.txt"), []byte("two levels down"))
+	bundle := filepath.Join(testDir, "bundle")
+	writeFile(filepath.Join(testDir, "inner", "top.txt"), []byte("one level down"))
+	if err := core.Compress(context.Background(), filepath.Join(testDir, "innermost"), filepath.Join(testDir, "inner", "innermost.agcp"), core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if err := core.Compress(context.Background(), filepath.Join(testDir, "inner"), filepath.Join(bundle, "inner.agcp"), core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for name, content := range map[string]string{"docs/readme.txt": "from zip", "../escape.txt": "evil"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	writeFile(filepath.Join(bundle, "evil.zip"), zipBuf.Bytes())
+
+	zipBuf.Reset()
+	zw = zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("docs/readme.txt")
+	w.Write([]byte("from zip"))
+	zw.Close()
+	writeFile(filepath.Join(bundle, "docs.zip"), zipBuf.Bytes())
+
+	var tgzBuf bytes.Buffer
+	gz := gzip.NewWriter(&tgzBuf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "logs/app.log", Mode: 0644, Size: 8, Typeflag: tar.TypeReg})
+	tw.Write([]byte("from tgz"))
+	tw.Close()
+	gz.Close()
+	writeFile(filepath.Join(bundle, "logs.tar.gz"), tgzBuf.Bytes())
+
+	archive := filepath.Join(testDir, "bundle.agcp")
+	if err := core.Compress(context.Background(), bundle, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Bundle with nested archives created")
+	EndSection()
+
+	StartSection("Unpacking")
+	for _, tc := range []struct {
+		depth   int
+		present []string
+		absent  []string
+	}{
+		{1, []string{"inner/top.txt", "inner/innermost.agcp", "docs/docs/readme.txt", "logs/logs/app.log", "evil.zip"},
+			[]string{"inner.agcp", "docs.zip", "logs.tar.gz", "inner/innermost/deep.txt"}},
+		{5, []string{"inner/top.txt", "inner/innermost/deep.txt", "evil.zip"},
+			[]string{"inner/innermost.agcp", "evil"}},
+	} {
+		outDir := filepath.Join(testDir, fmt.Sprintf("out%d", tc.depth))
+		var warnings []string
+		opts := core.Options{Recursive: tc.depth, Warn: func(w core.Warning) { warnings = append(warnings, w.String()) }}
+		if err := core.DecompressWithOptions(archive, outDir, opts); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		for _, name := range tc.present {
+			if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+				t.Errorf("depth %d: expected %s: %v", tc.depth, name, err)
+			}
+		}
+		for _, name := range tc.absent {
+			if _, err := os.Stat(filepath.Join(outDir, name)); err == nil {
+				t.Errorf("depth %d: %s should not exist", tc.depth, name)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(testDir, "escape.txt")); err == nil {
+			t.Fatalf("zip entry escaped the extraction directory")
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "evil.zip") {
+			t.Errorf("depth %d: expected one warning about evil.zip, got %q", tc.depth, warnings)
+		}
+		Success(fmt.Sprintf("Depth %d unpacks the expected levels", tc.depth))
+	}
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestNestedArchiveEscape tests that entries whose paths leave the extraction
+// directory are refused, both at the top level and in a nested agcp archive
+func TestNestedArchiveEscape(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Nested Archive Escape")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-nested-escape-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	payload := filepath.Join(testDir, "payload")
+	if err := os.MkdirAll(filepath.Join(payload, "xx", "yy", "zz"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(payload, "xx", "yy", "zz", "escape.txt"), []byte("evil"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(payload, "fine.txt"), []byte("fine"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	bundle := filepath.Join(testDir, "bundle")
+	evil := filepath.Join(bundle, "evil.agcp")
+	if err := core.Compress(context.Background(), payload, evil, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// Rewrite xx/yy/zz/escape.txt as ../../../escape.txt and re-seal the header
+	data, err := os.ReadFile(evil)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	trailer := data[len(data)-len(core.TrailerMagic)-12:]
+	header := data[:binary.BigEndian.Uint64(trailer[0:8])]
+	if bytes.Count(header, []byte("xx/yy/zz/escape.txt")) != 1 {
+		t.Fatal("expected the entry path once in the header")
+	}
+	copy(header, bytes.ReplaceAll(header, []byte("xx/yy/zz/escape.txt"), []byte("../../../escape.txt")))
+	binary.BigEndian.PutUint32(trailer[8:12], crc32.ChecksumIEEE(header))
+	if err := os.WriteFile(evil, data, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	archive := filepath.Join(testDir, "bundle.agcp")
+	if err := core.Compress(context.Background(), bundle, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive with an escaping entry created and nested in a bundle")
+	EndSection()
+
+	StartSection("Top-Level Extraction")
+	outDir := filepath.Join(testDir, "a", "b", "c", "top")
+	err = core.Decompress(context.Background(), evil, outDir, core.Options{})
+	var entryErr *core.EntryError
+	if !errors.As(err, &entryErr) || !strings.Contains(err.Error(), "escapes the extraction directory") {
+		t.Fatalf("expected an escaping entry error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "a", "escape.txt")); err == nil {
+		t.Fatal("entry escaped the extraction directory")
+	}
+	Success("Extraction refuses the escaping entry")
+	EndSection()
+
+	StartSection("Nested Extraction")
+	outDir = filepath.Join(testDir, "d", "e", "nested")
+	var warnings []string
+	opts := core.Options{Recursive: 1, Warn: func(w core.Warning) { warnings = append(warnings, w.String()) }}
+	if err := core.Decompress(context.Background(), archive, outDir, opts); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "d", "escape.txt")); err == nil {
+		t.Fatal("nested entry escaped the extraction directory")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "evil.agcp")); err != nil {
+		t.Errorf("expected the nested archive to be left in place: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "escapes the extraction directory") {
+		t.Errorf("expected one warning about the escaping entry, got %q", warnings)
+	}
+	Success("The nested archive is left packed with a warning")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestForgedRootName tests that a root name forged to leave the extraction
+// directory is refused for directory and file archives, and that naming the
+// output explicitly still extracts the archive
+func TestForgedRootName(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Forged Root Name")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-root-name-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// forge compresses input and rewrites its root name, zzzzzzzzz, to forged
+	forge := func(input, forged string) string {
+		archive := filepath.Join(testDir, fmt.Sprintf("forged-%d.agcp", len(forged)+len(input)))
+		os.Remove(archive)
+		if err := core.Compress(context.Background(), input, archive, core.Options{}); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		data, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		trailer := data[len(data)-len(core.TrailerMagic)-12:]
+		header := data[:binary.BigEndian.Uint64(trailer[0:8])]
+		if bytes.Count(header, []byte("zzzzzzzzz")) != 1 {
+			t.Fatal("expected the root name once in the header")
+		}
+		copy(header, bytes.ReplaceAll(header, []byte("zzzzzzzzz"), []byte(forged)))
+		binary.BigEndian.PutUint32(trailer[8:12], crc32.ChecksumIEEE(header))
+		if err := os.WriteFile(archive, data, 0644); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		return archive
+	}
+
+	dirInput := filepath.Join(testDir, "src", "zzzzzzzzz")
+	if err := os.MkdirAll(dirInput, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dirInput, "f.txt"), []byte("escaped"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	fileInput := filepath.Join(testDir, "file", "zzzzzzzzz")
+	if err := os.MkdirAll(filepath.Dir(fileInput), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(fileInput, []byte("escaped"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Directory and file inputs created")
+	EndSection()
+
+	StartSection("Extracting Forged Archives")
+	for _, input := range []string{dirInput, fileInput} {
+		for _, forged := range []string{"../../zzz", "zz/../zzz", "zzzz/zzzz"} {
+			archive := forge(input, forged)
+			dir := filepath.Join(testDir, "a", "b", "out")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			err := core.Decompress(context.Background(), archive, "", core.Options{Dir: dir})
+			if err == nil || !strings.Contains(err.Error(), "root name") {
+				t.Errorf("%s as %q: expected the root name to be refused, got %v", filepath.Base(filepath.Dir(input)), forged, err)
+			}
+			if _, err := os.Stat(filepath.Join(testDir, "a", "zzz")); err == nil {
+				t.Fatalf("%s as %q: extraction escaped the output directory", filepath.Base(filepath.Dir(input)), forged)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s as %q: extraction wrote %d files", filepath.Base(filepath.Dir(input)), forged, len(entries))
+			}
+
+			out := filepath.Join(testDir, "named")
+			os.RemoveAll(out)
+			if err := core.Decompress(context.Background(), archive, out, core.Options{}); err != nil {
+				t.Errorf("%s as %q: extraction to a named output failed: %v", filepath.Base(filepath.Dir(input)), forged, err)
+			}
+		}
+	}
+	Success("Forged root names are refused unless the output is named")
+	EndSection()
+
+	StartSection("Compressing the Current Directory")
+	// "." is recorded as the directory's own name, so it extracts under it
+	archive := filepath.Join(testDir, "dot.agcp")
+	if err := core.Compress(context.Background(), dirInput+string(filepath.Separator)+".", archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	dir := filepath.Join(testDir, "dot")
+	if err := core.Decompress(context.Background(), archive, "", core.Options{Dir: dir}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(dirInput, filepath.Join(dir, "zzzzzzzzz")); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("An archive of \".\" extracts under the directory's name")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCompressionPolicy tests that a policy file picks each entry's codec and is recorded per entry
+func TestCompressionPolicy(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Compression Policy")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-policy-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	text := bytes.Repeat([]byte("policy test row,1,2,3\n"), 2000)
+	for _, name := range []string{"clip.mp4", "table.csv", "logs/app.log", "logs/nested/clip.mp4", "notes.txt"} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, text, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Parsing Policies")
+	policy, err := core.ParsePolicy(strings.NewReader(`# media is already compressed
+"*.mp4": store
+*.csv: gzip:9   # tabular data
+'logs/**': 9
+`))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	if len(policy.Rules) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(policy.Rules))
+	}
+	for _, bad := range []string{"*.mp4 store", "*.csv: zstd:19", "*.log: lz4:12", "[: store"} {
+		if _, err := core.ParsePolicy(strings.NewReader(bad)); err == nil {
+			t.Fatalf("ParsePolicy(%q) should fail", bad)
+		}
+	}
+	Success("Policy parsed; malformed lines rejected")
+	EndSection()
+
+	StartSection("Compressing With a Policy")
+	archive := filepath.Join(testDir, "policy.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Policy: policy}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	want := map[string]string{
+		"clip.mp4":             "store",
+		"table.csv":            "gzip",
+		"logs/app.log":         "lz4",
+		"logs/nested/clip.mp4": "store", // First matching rule wins
+		"notes.txt":            "lz4",
+	}
+	for _, entry := range entries {
+		name := filepath.ToSlash(entry.Path)
+		if entry.Codec != want[name] {
+			t.Errorf("%s: codec %s, want %s", name, entry.Codec, want[name])
+		}
+		if entry.Codec == "store" && entry.CompressedSize != entry.OriginalSize {
+			t.Errorf("%s: stored entry is %d bytes, want %d", name, entry.CompressedSize, entry.OriginalSize)
+		}
+	}
+	Success("Each entry records the codec chosen by the policy")
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Mixed-codec archive restores the tree")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestAlignedArchive(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Aligned Archive")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-align-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	contents := map[string][]byte{}
+	for i, size := range []int{64, 100, 4095, 4096, 5000, 0, 12345} {
+		name := filepath.Join(srcDir, fmt.Sprintf("dir%d/file%d.bin", i%2, i))
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("Failed to generate data: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		contents[name] = data
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Rejecting Bad Alignments")
+	for _, align := range []int64{-1, 3, 1000} {
+		err := core.CompressWithOptions(srcDir, filepath.Join(testDir, "bad.agcp"), core.Options{Align: align})
+		if err == nil {
+			t.Fatalf("alignment %d should be rejected", align)
+		}
+	}
+	Success("Alignments that are not powers of two are rejected")
+	EndSection()
+
+	// Storing the entries makes their data findable in the archive bytes
+	policy := &core.Policy{Rules: []core.PolicyRule{{Pattern: "*", Store: true}}}
+	for _, workers := range []int{1, 4} {
+		StartSection(fmt.Sprintf("Compressing With %d Worker(s)", workers))
+		archive := filepath.Join(testDir, fmt.Sprintf("aligned%d.agcp", workers))
+		opts := core.Options{Align: 4096, Policy: policy, Workers: workers}
+		if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression failed: %v", err)
+		}
+		data, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		for name, content := range contents {
+			if len(content) == 0 {
+				continue
+			}
+			offset := bytes.Index(data, content)
+			if offset < 0 || offset%4096 != 0 {
+				t.Fatalf("%s: data at offset %d, want a multiple of 4096", name, offset)
+			}
+		}
+		Success("Every entry's data starts on a 4096-byte boundary")
+
+		report, err := core.Verify(archive, false, core.Options{})
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if len(report.Failures) != 0 {
+			t.Fatalf("Verify reported failures: %v", report.Failures)
+		}
+		outDir := filepath.Join(testDir, fmt.Sprintf("out%d", workers))
+		if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if err := compareTrees(srcDir, outDir); err != nil {
+			t.Fatalf("Restored tree differs: %v", err)
+		}
+		Success("Aligned archive verifies and restores the tree")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestStructuredWarnings(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Structured Warnings")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-warn-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("warning test"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	socket := filepath.Join(srcDir, "sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	defer listener.Close()
+	Success("Test tree with a socket created")
+	EndSection()
+
+	StartSection("Collecting Warnings")
+	archive := filepath.Join(testDir, "warn.agcp")
+	log := &core.WarningLog{}
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Warn: log.Add}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	warnings := log.Warnings()
+	if len(warnings) != 1 || warnings[0].Code != core.WarnSkippedSpecialFile || warnings[0].Path != socket {
+		t.Fatalf("expected a warning about the skipped socket, got %v", warnings)
+	}
+	summary := log.Summary()
+	if !strings.HasPrefix(summary, "1 warning:") || !strings.Contains(summary, "[skipped-special-file] "+socket+": skipping socket") {
+		t.Fatalf("unexpected summary:\n%s", summary)
+	}
+	Info(strings.TrimSpace(summary))
+	Success("Each warning carries its code and path")
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outDir, "sock")); err == nil {
+		t.Fatalf("socket should not have been archived")
+	}
+	Success("Special file left out of the archive")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestCheckPaths(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Target Path Check")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-check-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	names := []string{"ok.txt", "docs/aux.txt", "docs/notes.", "Readme", "readme", "a:b", strings.Repeat("n", 200) + "/" + strings.Repeat("m", 100)}
+	for _, name := range names {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Skipf("Cannot create %q on this filesystem: %v", name, err)
+		}
+	}
+	archive := filepath.Join(testDir, "check.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive with awkward names created")
+	EndSection()
+
+	StartSection("Checking Each Target")
+	for _, tc := range []struct {
+		target string
+		issues int
+	}{
+		{"linux", 0},
+		{"macos", 1},   // Readme/readme
+		{"windows", 5}, // aux.txt, notes., readme, a:b and the 300-character path
+	} {
+		target, err := core.ParseTarget(tc.target)
+		if err != nil {
+			t.Fatalf("ParseTarget(%q) failed: %v", tc.target, err)
+		}
+		report, err := core.CheckPaths(archive, target)
+		if err != nil {
+			t.Fatalf("CheckPaths failed: %v", err)
+		}
+		if report.Entries != len(names) || len(report.Issues) != tc.issues {
+			t.Fatalf("%s: %d issues in %d entries, want %d: %v", tc.target, len(report.Issues), report.Entries, tc.issues, report.Issues)
+		}
+		for _, issue := range report.Issues {
+			if issue.Op != "check" {
+				t.Fatalf("%s: issue has op %q, want check", tc.target, issue.Op)
+			}
+			Info(issue.Error())
+		}
+		Success(fmt.Sprintf("%s: %d issue(s) found", tc.target, tc.issues))
+	}
+	if _, err := core.ParseTarget("amiga"); err == nil {
+		t.Fatalf("unknown target should be rejected")
+	}
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Encrypted Archive")
+
+	StartSection("Parsing Recipients")
+	for s, want := range map[string]core.EncryptBackend{
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p": core.BackendAge,
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA":                           core.BackendAge,
+		"backup@example.com":                                             core.BackendGPG,
+		"age:keys.example":                                               core.BackendAge,
+		"gpg:DA5057EBCF47E0C5":                                           core.BackendGPG,
+	} {
+		r, err := core.ParseRecipient(s)
+		if err != nil || r.Backend != want {
+			t.Fatalf("ParseRecipient(%q) = %v, %v; want backend %s", s, r, err, want)
+		}
+	}
+	if _, err := core.ParseRecipient("gpg:"); err == nil {
+		t.Fatalf("empty recipient should be rejected")
+	}
+	Success("Recipients map to their backends")
+	EndSection()
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-encrypt-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// A throwaway keyring with one passphrase-less key
+	gnupgHome := filepath.Join(testDir, "gnupg")
+	if err := os.Mkdir(gnupgHome, 0700); err != nil {
+		t.Fatalf("Failed to create keyring directory: %v", err)
+	}
+	t.Setenv("GNUPGHOME", gnupgHome)
+	defer exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+	if out, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Backup Test <backup@example.com>", "default", "default", "never").CombinedOutput(); err != nil {
+		t.Skipf("Cannot generate a test key: %v: %s", err, out)
+	}
+
+	srcDir := filepath.Join(testDir, "src")
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(srcDir, fmt.Sprintf("dir%d/secret%d.txt", i%2, i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("top secret payload\n"), 100*(i+1)), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files and key created successfully")
+	EndSection()
+
+	StartSection("Encrypting to a Recipient")
+	recipient, err := core.ParseRecipient("backup@example.com")
+	if err != nil {
+		t.Fatalf("ParseRecipient failed: %v", err)
+	}
+	archive := filepath.Join(testDir, "secret.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Recipients: []core.Recipient{recipient}}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte(core.Magic)) || bytes.Contains(data, []byte("secret0.txt")) {
+		t.Fatalf("archive is not encrypted")
+	}
+	if _, err := os.Stat(core.PartialPath(archive)); !os.IsNotExist(err) {
+		t.Fatalf("unencrypted partial archive left behind: %v", err)
+	}
+	if _, err := core.ListEntries(archive); !errors.Is(err, core.ErrEncrypted) {
+		t.Fatalf("listing an encrypted archive should fail with ErrEncrypted, got %v", err)
+	}
+	Success("Archive is encrypted and the plaintext copy is gone")
+
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Encrypted archive decrypts and restores the tree")
+
+	mixed := []core.Recipient{recipient, {Backend: core.BackendAge, Key: "age1example"}}
+	if err := core.CompressWithOptions(srcDir, filepath.Join(testDir, "mixed.agcp"), core.Options{Recipients: mixed}); err == nil {
+		t.Fatalf("recipients of different backends should be rejected")
+	}
+	Success("Mixed backends rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestManifest(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Manifest Export")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-manifest-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	mtime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	files := map[string][]byte{
+		"empty.txt":      nil,
+		"docs/a,b.txt":   []byte("a name with a comma"),
+		"data/table.csv": bytes.Repeat([]byte("1,2,3\n"), 5000),
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0640); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if err := os.Chmod(path, 0640); err != nil {
+			t.Fatalf("Failed to chmod test file: %v", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	for _, workers := range []int{1, 4} {
+		StartSection(fmt.Sprintf("Manifest With %d Worker(s)", workers))
+		archive := filepath.Join(testDir, fmt.Sprintf("m%d.agcp", workers))
+		if err := core.CompressWithOptions(srcDir, archive, core.Options{Workers: workers}); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression failed: %v", err)
+		}
+		manifest, err := core.BuildManifest(archive)
+		if err != nil {
+			t.Fatalf("BuildManifest failed: %v", err)
+		}
+		if len(manifest.Files) != len(files) {
+			t.Fatalf("manifest lists %d files, want %d", len(manifest.Files), len(files))
+		}
+		for _, f := range manifest.Files {
+			sum := sha256.Sum256(files[f.Path])
+			if f.SHA256 != hex.EncodeToString(sum[:]) || f.Size != uint64(len(files[f.Path])) {
+				t.Fatalf("%s: size %d sha256 %s, want %d %x", f.Path, f.Size, f.SHA256, len(files[f.Path]), sum)
+			}
+			if runtime.GOOS != "windows" && f.Mode != "0640" {
+				t.Fatalf("%s: mode %s, want 0640", f.Path, f.Mode)
+			}
+			if f.MTime != "2024-03-01T12:30:00Z" {
+				t.Fatalf("%s: mtime %s, want 2024-03-01T12:30:00Z", f.Path, f.MTime)
+			}
+		}
+		Success("Sizes, hashes, modes and times match the input")
+
+		var jsonOut bytes.Buffer
+		if err := manifest.WriteJSON(&jsonOut); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+		var decoded core.Manifest
+		if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || len(decoded.Files) != len(files) {
+			t.Fatalf("JSON manifest does not round-trip: %v\n%s", err, jsonOut.String())
+		}
+		var csvOut bytes.Buffer
+		if err := manifest.WriteCSV(&csvOut); err != nil {
+			t.Fatalf("WriteCSV failed: %v", err)
+		}
+		records, err := csv.NewReader(&csvOut).ReadAll()
+		if err != nil || len(records) != len(files)+1 || strings.Join(records[0], ",") != "path,size,sha256,mode,mtime" {
+			t.Fatalf("unexpected CSV manifest: %v %q", err, records)
+		}
+		Success("JSON and CSV exports parse back")
+		EndSection()
+	}
+
+	StartSection("Manifest of an Old Archive")
+	manifest, err := core.BuildManifest("testdir.agcp")
+	if err != nil {
+		t.Fatalf("BuildManifest of a v1 archive failed: %v", err)
+	}
+	for _, f := range manifest.Files {
+		if f.SHA256 != "" || f.Mode != "" || f.MTime != "" {
+			t.Fatalf("%s: v1 archive cannot record hashes, modes or times: %+v", f.Path, f)
+		}
+	}
+	Success(fmt.Sprintf("%d files listed with sizes only", len(manifest.Files)))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestSegmentedVerify(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Segmented Verification")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-segment-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Incompressible, so compressed offsets track decoded offsets
+	const size = 24 << 20
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+	input := filepath.Join(testDir, "large.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "large.agcp")
+	if err := core.CompressWithOptions(input, archive, core.Options{Codec: core.CodecLZ4}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("24 MiB entry compressed")
+	EndSection()
+
+	StartSection("Corrupting the Middle of the Entry")
+	raw, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	// The entry's data ends just before the 16-byte trailer; corrupt a byte
+	// about 10 MiB into it, in the third 4 MiB segment
+	corruptAt := len(raw) - 16 - size + 10<<20
+	raw[corruptAt] ^= 0xff
+	if err := os.WriteFile(archive, raw, 0644); err != nil {
+		t.Fatalf("Failed to write corrupted archive: %v", err)
+	}
+
+	report, err := core.Verify(archive, false, core.Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(report.Failures) != 1 {
+		t.Fatalf("expected one failure, got %v", report.Failures)
+	}
+	var segErr *core.SegmentError
+	if !errors.As(report.Failures[0], &segErr) {
+		t.Fatalf("failure is not localized to a segment: %v", report.Failures[0])
+	}
+	if segErr.Segment != 3 || segErr.Segments != 6 || segErr.Offset != 8<<20 {
+		t.Fatalf("corruption localized to %v, want segment 3 of 6", segErr)
+	}
+	Info(report.Failures[0].Error())
+	Success("Corruption localized to the segment it occurred in")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestParallelBlockExtraction(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Parallel Block Extraction")
+
+	// Blocks of a large entry are decoded on as many goroutines as Go may run
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-blocks-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Half random, half repetitive, so blocks come out both stored and compressed
+	const size = 24 << 20
+	data := make([]byte, size)
+	if _, err := rand.Read(data[:size/2]); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+	for i := size / 2; i < size; i++ {
+		data[i] = byte(i % 251)
+	}
+	input := filepath.Join(testDir, "large.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("24 MiB test file created")
+	EndSection()
+
+	StartSection("Round Trip")
+	// A ratio check flushes the frame mid-block, leaving a short block
+	for _, opts := range []core.Options{{}, {MinRatio: 1.5, RatioSample: 5<<20 + 12345}} {
+		archive := filepath.Join(testDir, "large.agcp")
+		if err := core.CompressWithOptions(input, archive, opts); err != nil {
+			Error(fmt.Sprintf("Compression failed: %v", err))
+			t.Fatalf("Compression failed: %v", err)
+		}
+		output := filepath.Join(testDir, "restored.bin")
+		if err := core.Decompress(context.Background(), archive, output, core.Options{}); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		restored, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("Failed to read restored file: %v", err)
+		}
+		if !bytes.Equal(restored, data) {
+			t.Fatalf("restored file differs from the original (options %+v)", opts)
+		}
+		os.Remove(output)
+	}
+	Success("Entries restored intact, including a frame with a short block")
+	EndSection()
+
+	StartSection("Corrupting a Block")
+	random := make([]byte, size)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+	if err := os.WriteFile(input, random, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "random.agcp")
+	if err := core.CompressWithOptions(input, archive, core.Options{Codec: core.CodecLZ4}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	raw, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	// Incompressible blocks are stored, so 10 MiB into the data is block 3
+	raw[len(raw)-16-size+10<<20] ^= 0xff
+	if err := os.WriteFile(archive, raw, 0644); err != nil {
+		t.Fatalf("Failed to write corrupted archive: %v", err)
+	}
+	output := filepath.Join(testDir, "corrupt.bin")
+	err = core.Decompress(context.Background(), archive, output, core.Options{})
+	if err == nil || !strings.Contains(err.Error(), "block 3 of 6") || !strings.Contains(err.Error(), "invalid block checksum") {
+		t.Fatalf("expected a checksum failure in block 3 of 6, got %v", err)
+	}
+	if _, statErr := os.Stat(output); !os.IsNotExist(statErr) {
+		t.Fatalf("corrupt entry left behind: %v", statErr)
+	}
+	Info(err.Error())
+	Success("Corrupt block reported and the partial file removed")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestExtractDir(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Extraction Directory")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-chdir-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "www", "html")
+	for _, name := range []string{"index.html", "css/site.css"} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	dirArchive := filepath.Join(testDir, "html.agcp")
+	if err := core.Compress(context.Background(), srcDir, dirArchive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	fileArchive := filepath.Join(testDir, "index.agcp")
+	if err := core.Compress(context.Background(), filepath.Join(srcDir, "index.html"), fileArchive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Directory and file archives created")
+	EndSection()
+
+	StartSection("Extracting Under a Directory")
+	root := filepath.Join(testDir, "restore")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	opts := core.Options{Dir: root}
+	absolute := filepath.Join(testDir, "absolute")
+	for _, tc := range []struct {
+		archive, name, want string
+	}{
+		{dirArchive, "", filepath.Join(root, "html")},        // Root name under Dir
+		{dirArchive, "site", filepath.Join(root, "site")},    // Relative name under Dir
+		{dirArchive, absolute, absolute},                     // Absolute name as given
+		{fileArchive, "", filepath.Join(root, "index.html")}, // File archive into Dir
+	} {
+		if err := core.DecompressWithOptions(tc.archive, tc.name, opts); err != nil {
+			t.Fatalf("Decompressing %s as %q failed: %v", filepath.Base(tc.archive), tc.name, err)
+		}
+		if tc.archive == fileArchive {
+			if got, err := os.ReadFile(tc.want); err != nil || string(got) != "index.html" {
+				t.Fatalf("file archive not restored to %s: %v", tc.want, err)
+			}
+		} else if err := compareTrees(srcDir, tc.want); err != nil {
+			t.Fatalf("tree not restored to %s: %v", tc.want, err)
+		}
+	}
+	Success("Default and relative outputs land under Dir; absolute ones don't")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestScanHook tests that Options.Scan sees every entry's content during
+// extraction and that rejected entries never reach the output tree
+func TestScanHook(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Scan Hook")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-scan-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	marker := []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*")
+	files := map[string][]byte{
+		"clean.txt":        bytes.Repeat([]byte("harmless "), 100000),
+		"uploads/evil.bin": append(bytes.Repeat([]byte{0}, 200000), marker...),
+		"uploads/empty":    nil,
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "scan.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive with a test signature created")
+	EndSection()
+
+	StartSection("Extracting Through a Scanner")
+	var mu sync.Mutex
+	scanned := make(map[string]int)
+	scan := func(relPath string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		scanned[filepath.ToSlash(relPath)] = len(data)
+		mu.Unlock()
+		if bytes.Contains(data, marker) {
+			return errors.New("EICAR test signature found")
+		}
+		return nil
+	}
+	log := &core.WarningLog{}
+	out := filepath.Join(testDir, "out")
+	if err := core.DecompressWithOptions(archive, out, core.Options{Scan: scan, Warn: log.Add}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name, content := range files {
+		if scanned[name] != len(content) {
+			t.Fatalf("scan saw %d bytes of %s, want %d", scanned[name], name, len(content))
+		}
+	}
+	Success("Every entry's full content was scanned")
+
+	if _, err := os.Stat(filepath.Join(out, "uploads", "evil.bin")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("rejected entry was extracted: %v", err)
+	}
+	for _, name := range []string{"clean.txt", "uploads/empty"} {
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil || !bytes.Equal(got, files[name]) {
+			t.Fatalf("accepted entry %s not extracted intact: %v", name, err)
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(out, "uploads", ".*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+	warnings := log.Warnings()
+	if len(warnings) != 1 || warnings[0].Code != core.WarnScanRejected || warnings[0].Path != filepath.Join("uploads", "evil.bin") {
+		t.Fatalf("expected one scan-rejected warning for uploads/evil.bin, got %v", warnings)
+	}
+	Success("Rejected entry skipped with a warning, the rest extracted")
+
+	// A scanner that stops reading early must not stall the extraction
+	out = filepath.Join(testDir, "out-peek")
+	peek := func(relPath string, r io.Reader) error {
+		_, err := r.Read(make([]byte, 16))
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	if err := core.DecompressWithOptions(archive, out, core.Options{Scan: peek}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, out); err != nil {
+		t.Fatalf("tree not extracted intact: %v", err)
+	}
+	Success("A scanner reading only a prefix still gets complete files")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCleanup tests that failed extractions remove what they wrote unless asked
+// to keep it, and that Cleanup removes registered temporary files
+func TestCleanup(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Cleanup")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-cleanup-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	const size = 12 << 20
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("Failed to generate data: %v", err)
+	}
+	input := filepath.Join(testDir, "large.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "large.agcp")
+	if err := core.CompressWithOptions(input, archive, core.Options{Codec: core.CodecLZ4}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	// Corrupt the entry halfway, so extraction fails after writing part of it
+	raw, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	raw[len(raw)-16-size/2] ^= 0xff
+	if err := os.WriteFile(archive, raw, 0644); err != nil {
+		t.Fatalf("Failed to write corrupted archive: %v", err)
+	}
+	Success("Archive corrupted halfway through its entry")
+	EndSection()
+
+	StartSection("Failing Extractions")
+	out := filepath.Join(testDir, "out.bin")
+	if err := core.Decompress(context.Background(), archive, out, core.Options{}); err == nil {
+		t.Fatal("expected extraction of a corrupt entry to fail")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("failed extraction left its output behind: %v", err)
+	}
+	Success("Half-written file removed")
+
+	if err := core.DecompressWithOptions(archive, out, core.Options{KeepPartial: true}); err == nil {
+		t.Fatal("expected extraction of a corrupt entry to fail")
+	}
+	if info, err := os.Stat(out); err != nil || info.Size() == 0 || info.Size() >= size {
+		t.Fatalf("expected a partially written file with KeepPartial: %v", err)
+	}
+	Success("Half-written file kept with KeepPartial")
+	EndSection()
+
+	StartSection("Cleaning Up Registered Files")
+	temp := filepath.Join(testDir, "staging.tmp")
+	released := filepath.Join(testDir, "released.tmp")
+	for _, path := range []string{temp, released} {
+		if err := os.WriteFile(path, []byte("scratch"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	defer core.RegisterTemp(temp)()
+	core.RegisterTemp(released)()
+	removed := core.Cleanup(false)
+	if len(removed) != 1 || removed[0] != temp {
+		t.Fatalf("expected Cleanup to remove only %s, got %v", temp, removed)
+	}
+	if _, err := os.Stat(released); err != nil {
+		t.Fatalf("released file was removed: %v", err)
+	}
+	Success("Registered files removed, released ones left alone")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestFormatLimits tests that input beyond the archive format's limits is
+// refused when compressing, and that headers claiming more than fits are
+// rejected when reading
+func TestFormatLimits(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Format Limits")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-limits-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	input := filepath.Join(testDir, "tagged.txt")
+	if err := os.WriteFile(input, []byte("limits"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Test file created successfully")
+	EndSection()
+
+	StartSection("Compressing Beyond the Attribute Limit")
+	// 40 tags of about 2KB each encode to more than MaxAttrsLen bytes
+	var opts core.Options
+	value := strings.Repeat("v", 1024)
+	for i := 0; i < 40; i++ {
+		rule, err := core.ParseTagRule(fmt.Sprintf("*=key%04d%s:%s", i, strings.Repeat("k", 1000), value))
+		if err != nil {
+			t.Fatalf("Failed to parse tag rule: %v", err)
+		}
+		opts.Tags = append(opts.Tags, rule)
+	}
+	archive := filepath.Join(testDir, "tagged.agcp")
+	err = core.CompressWithOptions(input, archive, opts)
+	if !errors.Is(err, core.ErrFormatLimit) {
+		t.Fatalf("expected ErrFormatLimit, got %v", err)
+	}
+	if _, statErr := os.Stat(archive); !os.IsNotExist(statErr) {
+		t.Fatalf("archive was written despite the limit: %v", statErr)
+	}
+	Success(fmt.Sprintf("Refused: %v", err))
+	EndSection()
+
+	StartSection("Reading an Impossible Entry Count")
+	// A well-formed header with a valid trailer claiming 2^40 entries
+	header := []byte(core.Magic)
+	header = append(header, core.Version, core.MinReaderVersion, 0, byte(core.ArchiveDir))
+	header = binary.AppendUvarint(header, 4)
+	header = append(header, "root"...)
+	header = binary.AppendUvarint(header, 1<<40)
+	header = binary.BigEndian.AppendUint32(header, 0)
+	forged := binary.BigEndian.AppendUint64(append([]byte(nil), header...), uint64(len(header)))
+	forged = binary.BigEndian.AppendUint32(forged, crc32.ChecksumIEEE(header))
+	forged = append(forged, core.TrailerMagic...)
+	forgedPath := filepath.Join(testDir, "forged.agcp")
+	if err := os.WriteFile(forgedPath, forged, 0644); err != nil {
+		t.Fatalf("Failed to write forged archive: %v", err)
+	}
+	if _, err := core.ListEntries(forgedPath); err == nil || !strings.Contains(err.Error(), "cannot fit") {
+		t.Fatalf("expected the entry count to be rejected, got %v", err)
+	}
+	Success("Entry count larger than the file can hold was rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestFsyncPolicies tests that archives are written and extracted intact under
+// every sync policy, in sequence and in parallel
+func TestFsyncPolicies(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Fsync Policies")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-fsync-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	for i := 0; i < 6; i++ {
+		path := filepath.Join(srcDir, fmt.Sprintf("d%d", i%2), fmt.Sprintf("f%d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte{byte('a' + i)}, 1000*i), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Round Trips")
+	for _, name := range []string{"none", "per-file", "per-archive"} {
+		policy, err := core.ParseFsyncPolicy(name)
+		if err != nil || policy.String() != name {
+			t.Fatalf("ParseFsyncPolicy(%q) = %v, %v", name, policy, err)
+		}
+		for _, workers := range []int{1, 4} {
+			archive := filepath.Join(testDir, fmt.Sprintf("%s-%d.agcp", name, workers))
+			if err := core.CompressWithOptions(srcDir, archive, core.Options{Fsync: policy, Workers: workers}); err != nil {
+				t.Fatalf("Compression with fsync %s failed: %v", name, err)
+			}
+			out := filepath.Join(testDir, fmt.Sprintf("out-%s-%d", name, workers))
+			if err := core.DecompressWithOptions(archive, out, core.Options{Fsync: policy}); err != nil {
+				t.Fatalf("Decompression with fsync %s failed: %v", name, err)
+			}
+			if err := compareTrees(srcDir, out); err != nil {
+				t.Fatalf("Restored tree differs with fsync %s: %v", name, err)
+			}
+		}
+		Success(fmt.Sprintf("fsync %s: archive and extracted tree intact", name))
+	}
+	if _, err := core.ParseFsyncPolicy("always"); err == nil {
+		t.Fatal("expected an unknown policy to be rejected")
+	}
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestUpdateExtraction tests that extraction with Update skips unchanged files
+// and rewrites only changed or missing ones
+func TestUpdateExtraction(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Incremental Update")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-update-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(srcDir, "sub", fmt.Sprintf("f%d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte{byte('a' + i)}, 100+i), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	reproducible := filepath.Join(testDir, "reproducible.agcp")
+	if err := core.CompressWithOptions(srcDir, reproducible, core.Options{Reproducible: true}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archives created successfully")
+	EndSection()
+
+	// update extracts archive into out with Update, returning the entries written
+	update := func(archive, out string) []string {
+		var mu sync.Mutex
+		var written []string
+		opts := core.Options{Update: true, Scan: func(relPath string, r io.Reader) error {
+			mu.Lock()
+			defer mu.Unlock()
+			written = append(written, filepath.ToSlash(relPath))
+			return nil
+		}}
+		if err := core.DecompressWithOptions(archive, out, opts); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if err := compareTrees(srcDir, out); err != nil {
+			t.Fatalf("Updated tree differs: %v", err)
+		}
+		sort.Strings(written)
+		return written
+	}
+
+	for _, a := range []string{archive, reproducible} {
+		name := filepath.Base(a)
+		StartSection("Updating From " + name)
+		out := filepath.Join(testDir, "out-"+name)
+		if written := update(a, out); len(written) != 5 {
+			t.Fatalf("expected every entry written into an empty tree, got %v", written)
+		}
+		if written := update(a, out); len(written) != 0 {
+			t.Fatalf("expected nothing written into an unchanged tree, got %v", written)
+		}
+		Success("Unchanged tree left alone")
+
+		// Same size, different content; a missing file; a truncated file
+		if err := os.WriteFile(filepath.Join(out, "sub", "f1.txt"), bytes.Repeat([]byte("z"), 101), 0644); err != nil {
+			t.Fatalf("Failed to modify file: %v", err)
+		}
+		if err := os.Remove(filepath.Join(out, "sub", "f2.txt")); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(out, "sub", "f3.txt"), []byte("d"), 0644); err != nil {
+			t.Fatalf("Failed to truncate file: %v", err)
+		}
+		want := []string{"sub/f1.txt", "sub/f2.txt", "sub/f3.txt"}
+		if written := update(a, out); fmt.Sprint(written) != fmt.Sprint(want) {
+			t.Fatalf("expected %v written, got %v", want, written)
+		}
+		Success(fmt.Sprintf("Only changed and missing files rewritten: %v", want))
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestInlineSmallFiles tests that small files stored in the entry table make an
+// archive of tiny files smaller and restore exactly, sequentially and in parallel
+func TestInlineSmallFiles(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Inline Small Files")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-inline-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "tiny"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	const numTiny = 200
+	for i := 0; i < numTiny; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, "tiny", fmt.Sprintf("f%03d.txt", i)), []byte(fmt.Sprintf("tiny file %d\n", i)), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "empty"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "large.txt"), bytes.Repeat([]byte("large file line\n"), 1000), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Compressing")
+	plain := filepath.Join(testDir, "plain.agcp")
+	if err := core.CompressWithOptions(srcDir, plain, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	var inlined []string
+	for _, workers := range []int{1, 4} {
+		archive := filepath.Join(testDir, fmt.Sprintf("inline-%d.agcp", workers))
+		if err := core.CompressWithOptions(srcDir, archive, core.Options{InlineMax: 512, Workers: workers}); err != nil {
+			t.Fatalf("Compression with %d workers failed: %v", workers, err)
+		}
+		inlined = append(inlined, archive)
+	}
+	plainInfo, _ := os.Stat(plain)
+	inlineInfo, _ := os.Stat(inlined[0])
+	if inlineInfo.Size() >= plainInfo.Size() {
+		t.Fatalf("expected inlining to shrink the archive, got %d bytes against %d", inlineInfo.Size(), plainInfo.Size())
+	}
+	Success(fmt.Sprintf("Inlined archive is %d bytes against %d", inlineInfo.Size(), plainInfo.Size()))
+
+	if err := core.CompressWithOptions(srcDir, filepath.Join(testDir, "huge.agcp"), core.Options{InlineMax: core.MaxInline + 1}); err == nil {
+		t.Fatal("expected a threshold above MaxInline to be refused")
+	}
+	Success("Threshold above MaxInline refused")
+	EndSection()
+
+	for _, archive := range inlined {
+		name := filepath.Base(archive)
+		StartSection("Reading " + name)
+		entries, err := core.ListEntries(archive)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, entry := range entries {
+			wantCodec := "inline"
+			if entry.Path == "large.txt" || entry.Path == "empty" {
+				wantCodec = "lz4"
+			}
+			if entry.Codec != wantCodec || (wantCodec == "inline" && entry.CompressedSize != 0) {
+				t.Fatalf("%s: expected codec %s, got %s with %d compressed bytes", entry.Path, wantCodec, entry.Codec, entry.CompressedSize)
+			}
+		}
+		report, err := core.Verify(archive, false, core.Options{})
+		if err != nil || len(report.Failures) > 0 {
+			t.Fatalf("Verify failed: %v %v", err, report)
+		}
+		outDir := filepath.Join(testDir, "out-"+name)
+		if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if err := compareTrees(srcDir, outDir); err != nil {
+			t.Fatalf("Restored tree differs: %v", err)
+		}
+		a, err := core.OpenArchive(archive, core.Options{})
+		if err != nil {
+			t.Fatalf("OpenArchive failed: %v", err)
+		}
+		data, err := a.ReadEntry("tiny/f007.txt")
+		a.Close()
+		if err != nil || string(data) != "tiny file 7\n" {
+			t.Fatalf("ReadEntry returned %q, %v", data, err)
+		}
+		Success("Inline entries list, verify, extract and read back")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestInputUnchanged tests that an input is recognised as unchanged against its
+// archive until a file's content, a file's presence or the root changes
+func TestInputUnchanged(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Detecting Unchanged Input")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-unchanged-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/empty": ""} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	// The archive sits inside the input, as a nightly backup into the tree might
+	archive := filepath.Join(srcDir, "last.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive created successfully")
+	EndSection()
+
+	unchanged := func(input string) bool {
+		same, err := core.InputUnchanged(input, archive, core.Options{})
+		if err != nil {
+			t.Fatalf("InputUnchanged failed: %v", err)
+		}
+		return same
+	}
+
+	StartSection("Comparing")
+	if !unchanged(srcDir) {
+		t.Fatal("expected the freshly archived tree to be unchanged")
+	}
+	if err := os.Chtimes(filepath.Join(srcDir, "a.txt"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	if !unchanged(srcDir) {
+		t.Fatal("expected a new modification time alone to leave the tree unchanged")
+	}
+	Success("Same content is unchanged, whatever the times")
+
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("BRAVO"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if unchanged(srcDir) {
+		t.Fatal("expected same-size different content to be a change")
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("bravo"), 0644); err != nil {
+		t.Fatalf("Failed to restore file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "new.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if unchanged(srcDir) {
+		t.Fatal("expected an added file to be a change")
+	}
+	if err := os.Remove(filepath.Join(srcDir, "new.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if !unchanged(srcDir) {
+		t.Fatal("expected the restored tree to be unchanged again")
+	}
+	if unchanged(filepath.Join(srcDir, "a.txt")) {
+		t.Fatal("expected a single file not to match a directory archive")
+	}
+	Success("Changed content, added files and a different root are changes")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestSnapshotUnavailable(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Snapshot Fallback")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-snapshot-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created")
+	EndSection()
+
+	StartSection("Parsing Modes")
+	for _, s := range []string{"none", "auto", "BTRFS", "zfs", "lvm", "vss"} {
+		if _, err := core.ParseSnapshotMode(s); err != nil {
+			t.Fatalf("ParseSnapshotMode(%q) failed: %v", s, err)
+		}
+	}
+	if _, err := core.ParseSnapshotMode("ext4"); err == nil {
+		t.Fatal("expected an unknown snapshot mode to be rejected")
+	}
+	Success("Snapshot modes parsed")
+	EndSection()
+
+	StartSection("Requiring an Unavailable Snapshot")
+	// VSS exists only on Windows and Btrfs never does there
+	unavailable := core.SnapshotVSS
+	if runtime.GOOS == "windows" {
+		unavailable = core.SnapshotBtrfs
+	}
+	archive := filepath.Join(testDir, "explicit.agcp")
+	err = core.CompressWithOptions(srcDir, archive, core.Options{Snapshot: unavailable})
+	if !errors.Is(err, core.ErrSnapshotUnavailable) {
+		t.Fatalf("expected ErrSnapshotUnavailable for a %s snapshot, got %v", unavailable, err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Fatal("expected no archive when the requested snapshot is unavailable")
+	}
+	Success("Naming an unavailable snapshot fails before writing anything")
+	EndSection()
+
+	StartSection("Falling Back to the Live Input")
+	// A temporary directory is rarely on a file system agcp can snapshot, and
+	// the test rarely runs as root; either way the archive must be complete
+	warnings := &core.WarningLog{}
+	archive = filepath.Join(testDir, "auto.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Snapshot: core.SnapshotAuto, Warn: warnings.Add}); err != nil {
+		t.Fatalf("Compression with --snapshot auto failed: %v", err)
+	}
+	for _, w := range warnings.Warnings() {
+		if w.Code != core.WarnSnapshotUnavailable {
+			t.Fatalf("unexpected warning: %v", w)
+		}
+	}
+	if len(warnings.Warnings()) == 0 {
+		Info("A snapshot was taken")
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Auto mode archives the input whether or not a snapshot is available")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestInMemoryArchive(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("In-Memory Archives")
+
+	StartSection("Building")
+	files := map[string][]byte{
+		"a.txt":          []byte("alpha"),
+		"empty":          {},
+		"sub/deep/b.bin": bytes.Repeat([]byte("bravo "), 10000),
+	}
+	data, err := core.BuildArchive(files)
+	if err != nil {
+		Error(fmt.Sprintf("BuildArchive failed: %v", err))
+		t.Fatalf("BuildArchive failed: %v", err)
+	}
+	again, err := core.BuildArchive(files)
+	if err != nil {
+		t.Fatalf("BuildArchive failed: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatal("expected building the same files twice to give the same bytes")
+	}
+	Success(fmt.Sprintf("Built a %d-byte archive, identical on every build", len(data)))
+	EndSection()
+
+	StartSection("Reading Back")
+	got, err := core.ReadAll(data)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("ReadAll returned %d entries, want %d", len(got), len(files))
+	}
+	for name, content := range files {
+		if !bytes.Equal(got[name], content) {
+			t.Fatalf("entry %s: got %d bytes, want %d", name, len(got[name]), len(content))
+		}
+	}
+	Success("Every entry round-trips")
+
+	testDir, err := os.MkdirTemp("", "agcp-memory-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	archive := filepath.Join(testDir, "built.agcp")
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	report, err := core.Verify(archive, false, core.Options{})
+	if err != nil || len(report.Failures) != 0 {
+		t.Fatalf("Verify failed: %v / %v", err, report)
+	}
+	if err := core.DecompressWithOptions(archive, "", core.Options{Dir: testDir}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	extracted, err := os.ReadFile(filepath.Join(testDir, core.MemoryRootName, "sub", "deep", "b.bin"))
+	if err != nil || !bytes.Equal(extracted, files["sub/deep/b.bin"]) {
+		t.Fatalf("extracted file differs: %v", err)
+	}
+	Success("Built archives verify and extract like any other")
+
+	// A file archive is keyed by its root name
+	single := filepath.Join(testDir, "single.agcp")
+	if err := core.CompressWithOptions(archive, single, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	singleData, err := os.ReadFile(single)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	got, err = core.ReadAll(singleData)
+	if err != nil || !bytes.Equal(got["built.agcp"], data) {
+		t.Fatalf("ReadAll of a file archive: %v, keys %v", err, got)
+	}
+	Success("File archives read back under their root name")
+	EndSection()
+
+	StartSection("Rejecting Bad Input")
+	for _, bad := range []map[string][]byte{
+		{"/abs": nil},
+		{"../up": nil},
+		{"a//b": nil},
+		{".": nil},
+		{"dir": nil, "dir/file": nil},
+	} {
+		if _, err := core.BuildArchive(bad); err == nil {
+			t.Fatalf("expected BuildArchive to reject %v", bad)
+		}
+	}
+	damaged := append([]byte(nil), data...)
+	damaged[len(damaged)-30] ^= 0xFF // Inside the last entry's data
+	if _, err := core.ReadAll(damaged); err == nil {
+		t.Fatal("expected ReadAll to reject damaged entry data")
+	}
+	if _, err := core.ReadAll(data[:len(data)/2]); err == nil {
+		t.Fatal("expected ReadAll to reject a truncated archive")
+	}
+	Success("Invalid paths and damaged archives are rejected")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestEntryHashes(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Entry Hashes")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-hash-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Sizes around the read chunk size, and random data to trip the ratio guard
+	random := make([]byte, 3<<20)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+	files := map[string][]byte{
+		"empty":      {},
+		"chunk.txt":  bytes.Repeat([]byte("x"), 32*1024),
+		"odd.txt":    bytes.Repeat([]byte("0123456789"), 100001),
+		"random.bin": random,
+	}
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created")
+	EndSection()
+
+	for _, tc := range []struct {
+		name string
+		opts core.Options
+	}{
+		{"Sequential", core.Options{Workers: 1}},
+		{"Parallel", core.Options{Workers: 4}},
+		{"Storing Incompressible Data", core.Options{MinRatio: 0.9, RatioSample: 1 << 20, StoreIncompressible: true}},
+	} {
+		StartSection(tc.name)
+		archive := filepath.Join(testDir, strings.ReplaceAll(tc.name, " ", "-")+".agcp")
+		if err := core.CompressWithOptions(srcDir, archive, tc.opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		a, err := core.OpenArchive(archive, core.Options{})
+		if err != nil {
+			t.Fatalf("OpenArchive failed: %v", err)
+		}
+		for _, entry := range a.Entries() {
+			sum := sha256.Sum256(files[entry.Path])
+			if !bytes.Equal(entry.SHA256, sum[:]) {
+				a.Close()
+				t.Fatalf("%s: recorded SHA-256 %x, want %x", entry.Path, entry.SHA256, sum)
+			}
+		}
+		a.Close()
+		Success("Every entry records the SHA-256 of its content")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestUpdateArchive(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Updating an Archive From Its Directory")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-update-archive-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string]string{
+		"keep.txt":     strings.Repeat("kept content ", 5000),
+		"touched.txt":  "same content, new time",
+		"change.txt":   "before",
+		"sub/gone.txt": "deleted later",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive created successfully")
+	EndSection()
+
+	StartSection("Updating an Unchanged Tree")
+	before, err := os.Stat(archive)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	report, err := core.UpdateArchive(archive, srcDir, core.Options{})
+	if err != nil {
+		t.Fatalf("UpdateArchive failed: %v", err)
+	}
+	if report.Rewritten || report.Unchanged != 4 {
+		t.Fatalf("expected an untouched archive with 4 unchanged entries, got %+v", report)
+	}
+	if after, err := os.Stat(archive); err != nil || !after.ModTime().Equal(before.ModTime()) {
+		t.Fatal("expected an up-to-date archive to be left alone")
+	}
+	Success("An up-to-date archive is not rewritten")
+	EndSection()
+
+	StartSection("Updating a Changed Tree")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(srcDir, "touched.txt"), later, later); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	files["change.txt"] = "after!"
+	files["new/added.txt"] = "added"
+	delete(files, "sub/gone.txt")
+	for _, name := range []string{"change.txt", "new/added.txt"} {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(srcDir, "sub", "gone.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	report, err = core.UpdateArchive(archive, srcDir, core.Options{Workers: 4})
+	if err != nil {
+		t.Fatalf("UpdateArchive failed: %v", err)
+	}
+	if !report.Rewritten || report.Added != 1 || report.Changed != 1 || report.Removed != 1 || report.Unchanged != 2 {
+		t.Fatalf("unexpected update report %+v", report)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	got, err := core.ReadAll(data)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("updated archive holds %d entries, want %d", len(got), len(files))
+	}
+	for name, content := range files {
+		if string(got[name]) != content {
+			t.Fatalf("entry %s: got %q, want %q", name, got[name], content)
+		}
+	}
+	a, err := core.OpenArchive(archive, core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	info, err := a.Stat("touched.txt")
+	a.Close()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	touched, err := os.Stat(filepath.Join(srcDir, "touched.txt"))
+	if err != nil || !info.ModTime.Equal(touched.ModTime()) {
+		t.Fatalf("expected the kept entry to take the new modification time, got %v", info.ModTime)
+	}
+	for _, leftover := range []string{archive + ".update", archive + ".update.tmp"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("expected no %s after the update", leftover)
+		}
+	}
+	Success("New and changed files are compressed, deleted ones dropped, the rest kept")
+	EndSection()
+
+	StartSection("Rejecting a Different Input Type")
+	if _, err := core.UpdateArchive(archive, filepath.Join(srcDir, "keep.txt"), core.Options{}); err == nil {
+		t.Fatal("expected updating a directory archive from a file to fail")
+	}
+	Success("A file cannot update a directory archive")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestCorruptDataRegion(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Corrupt Entry Sizes")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-region-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	data, err := core.BuildArchive(map[string][]byte{
+		"a": bytes.Repeat([]byte("alpha "), 1000),
+		"b": bytes.Repeat([]byte("bravo "), 1000),
+	})
+	if err != nil {
+		t.Fatalf("BuildArchive failed: %v", err)
+	}
+	// The first record follows the 29-byte header: its path length, the path
+	// "a", the original size and then the compressed size
+	const compressedAt = 29 + 1 + 1 + 8
+	if data[29] != 1 || data[30] != 'a' {
+		t.Fatalf("unexpected header layout")
+	}
+	Success("Test archive built")
+	EndSection()
+
+	// forge rewrites entry a's compressed size and re-seals the header, as a
+	// buggy writer would have
+	forge := func(size uint64) []byte {
+		forged := append([]byte(nil), data...)
+		binary.BigEndian.PutUint64(forged[compressedAt:], size)
+		trailer := forged[len(forged)-len(core.TrailerMagic)-12:]
+		headerLen := binary.BigEndian.Uint64(trailer[0:8])
+		binary.BigEndian.PutUint32(trailer[8:12], crc32.ChecksumIEEE(forged[:headerLen]))
+		return forged
+	}
+	size := binary.BigEndian.Uint64(data[compressedAt:])
+
+	for _, tc := range []struct {
+		name string
+		size uint64
+		want string
+	}{
+		{"Size Past the End", size + 1<<20, "entry 0 (a) records"},
+		{"Size Overflowing an Offset", 1<<63 + 5, "entry 0 (a) records"},
+		{"Size Leaving a Gap", size - 1, "leaving 1 unaccounted bytes"},
+	} {
+		StartSection(tc.name)
+		forged := forge(tc.size)
+		if _, err := core.ReadAll(forged); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("ReadAll: expected an error containing %q, got %v", tc.want, err)
+		}
+		archive := filepath.Join(testDir, "forged.agcp")
+		if err := os.WriteFile(archive, forged, 0644); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		if _, err := core.Verify(archive, true, core.Options{}); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("Verify: expected an error containing %q, got %v", tc.want, err)
+		}
+		outDir := filepath.Join(testDir, "out")
+		extractErr := core.Decompress(context.Background(), archive, outDir, core.Options{})
+		if extractErr == nil || !strings.Contains(extractErr.Error(), tc.want) {
+			t.Fatalf("Decompress: expected an error containing %q, got %v", tc.want, extractErr)
+		}
+		if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+			t.Fatal("expected nothing to be extracted from a corrupt archive")
+		}
+		Success(fmt.Sprintf("Rejected before reading entries: %v", extractErr))
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestEntryResults(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Per-Entry Results")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-entry-results-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	files := map[string][]byte{
+		"a.txt":       []byte("hello"),
+		"sub/b.txt":   bytes.Repeat([]byte("report "), 50000),
+		"sub/c/empty": {},
+	}
+	srcDir := filepath.Join(testDir, "src")
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created")
+	EndSection()
+
+	// collect returns Options reporting entry results into a map keyed by path
+	collect := func(opts core.Options) (core.Options, func() map[string]core.EntryResult) {
+		var mu sync.Mutex
+		results := make(map[string]core.EntryResult)
+		opts.OnEntry = func(r core.EntryResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, dup := results[r.Path]; dup {
+				t.Errorf("%s reported twice", r.Path)
+			}
+			results[r.Path] = r
+		}
+		return opts, func() map[string]core.EntryResult {
+			mu.Lock()
+			defer mu.Unlock()
+			return results
+		}
+	}
+	// check verifies that every file was reported by op with its original size
+	check := func(op string, results map[string]core.EntryResult, skipped bool) {
+		if len(results) != len(files) {
+			t.Fatalf("%s reported %d entries, want %d: %v", op, len(results), len(files), results)
+		}
+		for name, content := range files {
+			r, ok := results[name]
+			if !ok {
+				t.Fatalf("%s did not report %s", op, name)
+			}
+			if r.Op != op || r.OriginalSize != uint64(len(content)) || r.Codec == "" || r.Skipped != skipped || r.Err != nil {
+				t.Fatalf("%s reported %+v for %s (%d bytes)", op, r, name, len(content))
+			}
+		}
+	}
+
+	archive := filepath.Join(testDir, "out.agcp")
+	for _, workers := range []int{1, 4} {
+		StartSection(fmt.Sprintf("Compressing With %d Workers", workers))
+		os.Remove(archive)
+		opts, results := collect(core.Options{Workers: workers})
+		if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		check("compress", results(), false)
+		a, err := core.OpenArchive(archive, core.Options{})
+		if err != nil {
+			t.Fatalf("OpenArchive failed: %v", err)
+		}
+		for _, entry := range a.Entries() {
+			if got := results()[entry.Path].CompressedSize; got != entry.CompressedSize {
+				a.Close()
+				t.Fatalf("%s: reported %d compressed bytes, archive records %d", entry.Path, got, entry.CompressedSize)
+			}
+		}
+		a.Close()
+		Success("Every entry reported once, with the sizes the archive records")
+		EndSection()
+	}
+
+	StartSection("Extracting")
+	outDir := filepath.Join(testDir, "out")
+	opts, results := collect(core.Options{})
+	if err := core.DecompressWithOptions(archive, outDir, opts); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	check("extract", results(), false)
+	opts, results = collect(core.Options{Update: true})
+	if err := core.DecompressWithOptions(archive, outDir, opts); err != nil {
+		t.Fatalf("Decompression with update failed: %v", err)
+	}
+	check("extract", results(), true)
+	Success("Extracted entries reported, and unchanged ones as skipped")
+	EndSection()
+
+	StartSection("Verifying")
+	for _, fast := range []bool{false, true} {
+		opts, results := collect(core.Options{})
+		report, err := core.Verify(archive, fast, opts)
+		if err != nil || len(report.Failures) > 0 {
+			t.Fatalf("Verify(fast=%v) failed: %v %v", fast, err, report)
+		}
+		check("verify", results(), false)
+	}
+	Success("Verified entries reported in full and fast mode")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestTransformHook(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Extraction Transform Hook")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-transform-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	files := map[string][]byte{
+		"lines.txt":    []byte("one\ntwo\nthree\n"),
+		"big/log.txt":  bytes.Repeat([]byte("a line of the log\n"), 200000),
+		"empty.txt":    {},
+		"untouched.db": bytes.Repeat([]byte{0, 1, 2, 3}, 100000),
+	}
+	srcDir := filepath.Join(testDir, "src")
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive created")
+	EndSection()
+
+	StartSection("Rewriting Content")
+	// Text files get CRLF line endings and a header, even when empty; other
+	// files are replaced without reading their content
+	crlf := func(relPath string, r io.Reader, w io.Writer) error {
+		if !strings.HasSuffix(relPath, ".txt") {
+			_, err := io.WriteString(w, "replaced")
+			return err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "# "+relPath+"\r\n"); err != nil {
+			return err
+		}
+		_, err = w.Write(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")))
+		return err
+	}
+	var mu sync.Mutex
+	scanned := make(map[string]int)
+	scan := func(relPath string, r io.Reader) error {
+		n, err := io.Copy(io.Discard, r)
+		mu.Lock()
+		scanned[relPath] = int(n)
+		mu.Unlock()
+		return err
+	}
+	out := filepath.Join(testDir, "out")
+	if err := core.DecompressWithOptions(archive, out, core.Options{Transform: crlf, Scan: scan}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name, content := range files {
+		want := []byte("replaced")
+		if strings.HasSuffix(name, ".txt") {
+			want = append([]byte("# "+name+"\r\n"), bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))...)
+		}
+		got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: got %d bytes, want %d transformed bytes", name, len(got), len(want))
+		}
+		if scanned[name] != len(want) {
+			t.Fatalf("%s: scan saw %d bytes, want the %d transformed bytes", name, scanned[name], len(want))
+		}
+	}
+	Success("Every entry was written as the transform rewrote it, and scanned that way")
+	EndSection()
+
+	StartSection("Failing Transform")
+	errFiltered := errors.New("filtered")
+	fail := func(relPath string, r io.Reader, w io.Writer) error {
+		if relPath == "lines.txt" {
+			return errFiltered
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+	err = core.DecompressWithOptions(archive, filepath.Join(testDir, "failed"), core.Options{Transform: fail})
+	var entryErr *core.EntryError
+	if !errors.Is(err, errFiltered) || !errors.As(err, &entryErr) || entryErr.Path != "lines.txt" {
+		t.Fatalf("Got %v, want an entry error for lines.txt wrapping the transform's error", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "failed", "lines.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Failed entry left on disk: %v", err)
+	}
+	Success(fmt.Sprintf("Extraction failed with the transform's error: %v", err))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestTextConvert(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Line Ending Conversion")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-text-convert-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// big.txt is large enough for CRLF pairs to straddle the writes
+	files := map[string][]byte{
+		"unix.txt":    []byte("one\ntwo\nthree"),
+		"windows.txt": []byte("one\r\ntwo\r\nthree\r\n"),
+		"mixed.txt":   []byte("lf\ncrlf\r\nlone cr\rend\r"),
+		"big.txt":     bytes.Repeat([]byte("a longer line of text\r\n"), 100000),
+		"binary.bin":  append([]byte("header\x00"), bytes.Repeat([]byte("\r\n\n"), 1000)...),
+		"empty.txt":   {},
+	}
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archive created")
+	EndSection()
+
+	toLF := func(b []byte) []byte { return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")) }
+	toCRLF := func(b []byte) []byte { return bytes.ReplaceAll(toLF(b), []byte("\n"), []byte("\r\n")) }
+	for _, tc := range []struct {
+		name    string
+		convert func([]byte) []byte
+	}{
+		{"lf", toLF},
+		{"crlf", toCRLF},
+	} {
+		StartSection("Converting to " + strings.ToUpper(tc.name))
+		ending, err := core.ParseLineEnding(tc.name)
+		if err != nil {
+			t.Fatalf("ParseLineEnding failed: %v", err)
+		}
+		out := filepath.Join(testDir, tc.name)
+		if err := core.DecompressWithOptions(archive, out, core.Options{Transform: core.ConvertLineEndings(ending)}); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		for name, content := range files {
+			want := content
+			if strings.HasSuffix(name, ".txt") {
+				want = tc.convert(content)
+			}
+			got, err := os.ReadFile(filepath.Join(out, name))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: got %q, want %q", name, truncate(got), truncate(want))
+			}
+		}
+		Success("Text files converted; binary files extracted untouched")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// truncate shortens content for error messages
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
+
+func TestArchiveHealth(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Archive Health Scoring")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-health-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("file %d\n", i)), 1000)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	backups := filepath.Join(testDir, "backups")
+	good := filepath.Join(backups, "good.agcp")
+	if err := core.Compress(context.Background(), srcDir, good, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// A single-entry archive, damaged inside its only entry's data, so any
+	// sample catches it
+	single := filepath.Join(testDir, "single.txt")
+	if err := os.WriteFile(single, bytes.Repeat([]byte("single entry\n"), 10000), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	damaged := filepath.Join(backups, "old", "damaged.agcp")
+	if err := core.Compress(context.Background(), single, damaged, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	data, err := os.ReadFile(damaged)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data[len(data)-30] ^= 0xFF
+	if err := os.WriteFile(damaged, data, 0644); err != nil {
+		t.Fatalf("Failed to write damaged archive: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(backups, "notes.txt"), []byte("not an archive"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Backup directory created")
+	EndSection()
+
+	const maxAge = 30 * 24 * time.Hour
+	health := func(sample float64) map[string]*core.ArchiveHealth {
+		report, err := core.CheckHealth(backups, sample, maxAge, core.Options{})
+		if err != nil {
+			t.Fatalf("CheckHealth failed: %v", err)
+		}
+		byPath := make(map[string]*core.ArchiveHealth)
+		for _, h := range report.Archives {
+			byPath[h.Path] = h
+		}
+		if len(byPath) != 2 || byPath[good] == nil || byPath[damaged] == nil {
+			t.Fatalf("Expected the two archives, got %v", byPath)
+		}
+		return byPath
+	}
+	actions := func(h *core.ArchiveHealth) string {
+		var names []string
+		for _, r := range h.Recommendations {
+			names = append(names, r.Action)
+		}
+		return strings.Join(names, ",")
+	}
+
+	StartSection("Sampled Check")
+	byPath := health(0.1)
+	if h := byPath[good]; h.Score != 80 || actions(h) != core.ActionReverify || h.Decoded != 1 || !h.Healthy() {
+		t.Fatalf("Good archive, never fully verified: %+v", h)
+	}
+	if h := byPath[damaged]; h.Healthy() || h.Score > 25 || !strings.Contains(actions(h), core.ActionRecreate) {
+		t.Fatalf("Damaged archive: %+v", h)
+	}
+	Success("Sampling found the damaged archive; the good one awaits full verification")
+	EndSection()
+
+	StartSection("Full Verification")
+	byPath = health(1)
+	if h := byPath[good]; h.Score != 100 || len(h.Recommendations) != 0 || h.LastVerified.IsZero() || h.Decoded != h.Entries {
+		t.Fatalf("Good archive, fully verified: %+v", h)
+	}
+	if h := byPath[damaged]; !h.LastVerified.IsZero() {
+		t.Fatalf("Damaged archive recorded as verified: %+v", h)
+	}
+	byPath = health(0)
+	if h := byPath[good]; h.Score != 100 || h.Decoded != 0 {
+		t.Fatalf("Good archive, checked after full verification: %+v", h)
+	}
+	Success("The catalog remembers the full verification")
+	EndSection()
+
+	StartSection("Aging")
+	catalogPath := filepath.Join(backups, core.HealthCatalogName)
+	catalog, err := os.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatalf("Failed to read catalog: %v", err)
+	}
+	var parsed map[string]map[string]map[string]interface{}
+	if err := json.Unmarshal(catalog, &parsed); err != nil {
+		t.Fatalf("Failed to parse catalog: %v", err)
+	}
+	parsed["archives"]["good.agcp"]["last_verified"] = time.Now().Add(-95 * 24 * time.Hour)
+	if catalog, err = json.Marshal(parsed); err != nil {
+		t.Fatalf("Failed to encode catalog: %v", err)
+	}
+	if err := os.WriteFile(catalogPath, catalog, 0644); err != nil {
+		t.Fatalf("Failed to write catalog: %v", err)
+	}
+	byPath = health(0)
+	if h := byPath[good]; h.Score != 70 || actions(h) != core.ActionReverify {
+		t.Fatalf("Good archive, verified 95 days ago: %+v", h)
+	}
+	Success(fmt.Sprintf("Stale verification scored %d: %s", byPath[good].Score, byPath[good].Recommendations[0].Reason))
+
+	os.Remove(good)
+	if err := core.Compress(context.Background(), srcDir, good, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if err := os.Chtimes(good, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+	byPath = health(0)
+	if h := byPath[good]; h.Score != 80 || !h.LastVerified.IsZero() {
+		t.Fatalf("Good archive, rewritten: %+v", h)
+	}
+	Success("A rewritten archive counts as never verified")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestSkipHidden(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Hidden Files")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-hidden-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string]string{
+		"visible.txt":              "visible",
+		".env":                     "SECRET=1",
+		".git/config":              "[core]",
+		".git/objects/ab/cdef":     "object",
+		"docs/readme.md":           "docs",
+		"docs/.draft.md":           "draft",
+		"project/.cache/build.out": "cache",
+		"project/main.go":          "package main",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success(fmt.Sprintf("Created %d files, 5 of them hidden", len(files)))
+	EndSection()
+
+	warningOf := func(log *core.WarningLog, code core.WarningCode) string {
+		for _, w := range log.Warnings() {
+			if w.Code == code {
+				return w.Message
+			}
+		}
+		return ""
+	}
+	listNames := func(archive string) []string {
+		entries, err := core.ListEntries(archive)
+		if err != nil {
+			t.Fatalf("ListEntries failed: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, filepath.ToSlash(e.Path))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	StartSection("Including Hidden Files")
+	log := &core.WarningLog{}
+	archive := filepath.Join(testDir, "all.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Warn: log.Add}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if got := len(listNames(archive)); got != len(files) {
+		t.Fatalf("archive has %d entries, want %d", got, len(files))
+	}
+	if msg := warningOf(log, core.WarnIncludedHidden); msg != "included 5 hidden files" {
+		t.Fatalf("included-hidden warning = %q", msg)
+	}
+	Success("Hidden files are archived by default and counted in a warning")
+	EndSection()
+
+	StartSection("Skipping Hidden Files")
+	log = &core.WarningLog{}
+	archive = filepath.Join(testDir, "visible.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{SkipHidden: true, Warn: log.Add}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	want := []string{"docs/readme.md", "project/main.go", "visible.txt"}
+	if got := listNames(archive); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("archive entries = %v, want %v", got, want)
+	}
+	if msg := warningOf(log, core.WarnSkippedHidden); msg != "skipped 2 hidden files and 2 hidden directories" {
+		t.Fatalf("skipped-hidden warning = %q", msg)
+	}
+	if msg := warningOf(log, core.WarnIncludedHidden); msg != "" {
+		t.Fatalf("unexpected included-hidden warning %q", msg)
+	}
+	Success("Dotfiles and dot-directories are left out and counted")
+	EndSection()
+
+	StartSection("Splitting by Directory")
+	log = &core.WarningLog{}
+	pattern := filepath.Join(testDir, "split", "%s.agcp")
+	if err := os.MkdirAll(filepath.Dir(pattern), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := core.CompressSplit(srcDir, pattern, core.Options{SkipHidden: true, Warn: log.Add}); err != nil {
+		t.Fatalf("Split compression failed: %v", err)
+	}
+	if _, err := os.Stat(fmt.Sprintf(pattern, ".git")); !os.IsNotExist(err) {
+		t.Fatal("expected no archive for the hidden .git directory")
+	}
+	if got := listNames(fmt.Sprintf(pattern, "project")); len(got) != 1 || got[0] != "main.go" {
+		t.Fatalf("project archive entries = %v", got)
+	}
+	if msg := warningOf(log, core.WarnSkippedHidden); msg != "skipped 2 hidden files and 2 hidden directories" {
+		t.Fatalf("skipped-hidden warning = %q", msg)
+	}
+	Success("Hidden top-level directories get no archive of their own")
+
+	Action("Splitting with a % in the pattern")
+	percent := filepath.Join(testDir, "split", "100%-%s.agcp")
+	if err := core.CompressSplit(srcDir, percent, core.Options{SkipHidden: true}); err != nil {
+		t.Fatalf("Split compression failed: %v", err)
+	}
+	if got := listNames(filepath.Join(testDir, "split", "100%-project.agcp")); len(got) != 1 || got[0] != "main.go" {
+		t.Fatalf("project archive entries = %v", got)
+	}
+	for _, bad := range []string{"out.agcp", "%s-%s.agcp"} {
+		if err := core.CompressSplit(srcDir, filepath.Join(testDir, "split", bad), core.Options{}); err == nil {
+			t.Fatalf("Expected split pattern %q to be refused", bad)
+		}
+	}
+	Success("Other % signs are kept; patterns need exactly one %s")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestCompressFromPipe(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Compressing From a Pipe")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-pipe-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	fifo := filepath.Join(testDir, "dump")
+	if err := exec.Command("mkfifo", fifo).Run(); err != nil {
+		t.Skipf("Named pipes unavailable: %v", err)
+	}
+	content := bytes.Repeat([]byte("INSERT INTO t VALUES (42, 'pipe');\n"), 100000)
+	Success(fmt.Sprintf("Created a named pipe to stream %s through", HumanReadableSize(int64(len(content)))))
+	EndSection()
+
+	compressPipe := func(data []byte, archive string) error {
+		writeErr := make(chan error, 1)
+		go func() {
+			f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+			if err != nil {
+				writeErr <- err
+				return
+			}
+			_, err = f.Write(data)
+			f.Close()
+			writeErr <- err
+		}()
+		if err := core.CompressWithOptions(fifo, archive, core.Options{}); err != nil {
+			return err
+		}
+		return <-writeErr
+	}
+
+	StartSection("Streaming Into an Archive")
+	archive := filepath.Join(testDir, "db.agcp")
+	if err := compressPipe(content, archive); err != nil {
+		t.Fatalf("Compression from a pipe failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "db" || entries[0].OriginalSize != uint64(len(content)) {
+		t.Fatalf("entries = %+v, want one entry db of %d bytes", entries, len(content))
+	}
+	if !entries[0].Mode.IsRegular() {
+		t.Fatalf("entry mode %v, want a regular file", entries[0].Mode)
+	}
+	Success("The entry is named after the archive and its size recorded after reading")
+	EndSection()
+
+	StartSection("Extracting")
+	outPath := filepath.Join(testDir, "restored")
+	if err := core.Decompress(context.Background(), archive, outPath, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("Failed to read extracted file: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("extracted %d bytes, want %d", len(got), len(content))
+	}
+	if _, err := core.Verify(archive, false, core.Options{}); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	Success("The streamed data extracts and verifies intact")
+	EndSection()
+
+	StartSection("Empty Pipe")
+	archive = filepath.Join(testDir, "empty.agcp")
+	if err := compressPipe(nil, archive); err != nil {
+		t.Fatalf("Compression from an empty pipe failed: %v", err)
+	}
+	outPath = filepath.Join(testDir, "empty")
+	if err := core.Decompress(context.Background(), archive, outPath, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if info, err := os.Stat(outPath); err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty file, got %v, %v", info, err)
+	}
+	Success("A pipe that closes without data gives an empty entry")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestEntryOffsets(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Entry Data Offsets")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-offsets-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string][]byte{
+		"index.html":      []byte("<html>tiny</html>"),
+		"assets/app.js":   bytes.Repeat([]byte("console.log('range');\n"), 5000),
+		"assets/logo.svg": bytes.Repeat([]byte("<path d='M0 0'/>"), 800),
+		"empty":           {},
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success(fmt.Sprintf("Created %d files", len(files)))
+	EndSection()
+
+	for _, tc := range []struct {
+		name string
+		opts core.Options
+	}{
+		{"gzip", core.Options{Codec: core.CodecGzip}},
+		{"gzip-aligned", core.Options{Codec: core.CodecGzip, Align: 4096}},
+		{"inline", core.Options{Codec: core.CodecGzip, InlineMax: 64}},
+	} {
+		StartSection("Fetching Ranges from a " + tc.name + " Archive")
+		archive := filepath.Join(testDir, tc.name+".agcp")
+		if err := core.CompressWithOptions(srcDir, archive, tc.opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		data, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		entries, err := core.ListEntries(archive)
+		if err != nil {
+			t.Fatalf("ListEntries failed: %v", err)
+		}
+		for _, e := range entries {
+			want := files[filepath.ToSlash(e.Path)]
+			if e.Codec == "inline" {
+				if e.CompressedSize != 0 {
+					t.Fatalf("%s: inline entry has %d bytes of data", e.Path, e.CompressedSize)
+				}
+				continue
+			}
+			if tc.opts.Align > 0 && e.Offset%tc.opts.Align != 0 {
+				t.Fatalf("%s: offset %d is not aligned to %d", e.Path, e.Offset, tc.opts.Align)
+			}
+			if e.OriginalSize == 0 {
+				continue
+			}
+			// Decode only the entry's byte range, as a client of a remote archive would
+			zr, err := gzip.NewReader(bytes.NewReader(data[e.Offset : e.Offset+int64(e.CompressedSize)]))
+			if err != nil {
+				t.Fatalf("%s: range at %d is not a gzip stream: %v", e.Path, e.Offset, err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("%s: decoding range failed: %v", e.Path, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: range decodes to %d bytes, want %d", e.Path, len(got), len(want))
+			}
+		}
+		Success("Every entry's byte range decodes to its content on its own")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestInvalidUTF8Names(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Invalid UTF-8 Names")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-raw-names-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string]string{
+		"caf\xe9.txt":        "latin-1",
+		"dir\xff\xfe/50%.md": "percent",
+		"plain.txt":          "ascii",
+		"naïve.txt":          "utf-8",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Skipf("File system rejects names that are not valid UTF-8: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Skipf("File system rejects names that are not valid UTF-8: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "raw.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archived files with Latin-1 and stray bytes in their names")
+	EndSection()
+
+	StartSection("Listing")
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	for _, e := range entries {
+		path := filepath.ToSlash(e.Path)
+		if _, ok := files[path]; !ok {
+			t.Fatalf("entry %q is not one of the archived names", path)
+		}
+		if raw := !utf8.ValidString(path); e.RawName != raw {
+			t.Fatalf("entry %q: RawName = %v, want %v", path, e.RawName, raw)
+		}
+	}
+	Success("Names are stored byte for byte and flagged when not valid UTF-8")
+	EndSection()
+
+	StartSection("Extracting Verbatim")
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("%q was not restored under its original bytes: %v", name, err)
+		}
+		if string(got) != content {
+			t.Fatalf("%q: got %q, want %q", name, got, content)
+		}
+	}
+	Success("Every name is restored with its original bytes")
+	EndSection()
+
+	StartSection("Escaping for Windows")
+	for _, tc := range []struct {
+		name string
+		mode core.NameEscape
+		want string
+	}{
+		{"caf\xe9.txt", core.NameEscapeHex, "caf%E9.txt"},
+		{"dir\xff\xfe/50%.md", core.NameEscapeHex, "dir%FF%FE/50%25.md"},
+		{"50%.md", core.NameEscapeHex, "50%.md"},
+		{"naïve.txt", core.NameEscapeHex, "naïve.txt"},
+		{"dir\xff\xfe/50%.md", core.NameEscapeReplace, "dir�/50%.md"},
+	} {
+		got, err := core.EscapeName(tc.name, tc.mode)
+		if err != nil || got != tc.want {
+			t.Fatalf("EscapeName(%q, %d) = %q, %v; want %q", tc.name, tc.mode, got, err, tc.want)
+		}
+	}
+	if _, err := core.EscapeName("caf\xe9", core.NameEscapeFail); err == nil {
+		t.Fatal("expected NameEscapeFail to refuse a name that is not valid UTF-8")
+	}
+	report, err := core.CheckPaths(archive, core.TargetWindows)
+	if err != nil {
+		t.Fatalf("CheckPaths failed: %v", err)
+	}
+	if len(report.Issues) != 2 {
+		t.Fatalf("CheckPaths for Windows reported %d issues, want 2: %v", len(report.Issues), report.Issues)
+	}
+	Success("Invalid bytes are escaped reversibly, and check flags them for Windows")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestExtractionByOffset(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Extraction in Archive Order")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-offset-order-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Many small entries around a few large ones, plus empty files, so the
+	// runs handed to workers differ widely in entry count
+	srcDir := filepath.Join(testDir, "src")
+	for i := 0; i < 300; i++ {
+		size := i % 7 * 100
+		if i%50 == 25 {
+			size = 3 << 20
+		}
+		path := filepath.Join(srcDir, fmt.Sprintf("d%d", i%5), fmt.Sprintf("f%03d", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		content := bytes.Repeat([]byte{byte(i)}, size)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Created 300 files, 6 of them large")
+	EndSection()
+
+	for _, align := range []int64{0, 4096} {
+		StartSection(fmt.Sprintf("Extracting with Alignment %d", align))
+		archive := filepath.Join(testDir, fmt.Sprintf("a%d.agcp", align))
+		if err := core.CompressWithOptions(srcDir, archive, core.Options{Align: align}); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		var mu sync.Mutex
+		seen := make(map[string]int)
+		outDir := filepath.Join(testDir, fmt.Sprintf("out%d", align))
+		opts := core.Options{OnEntry: func(r core.EntryResult) {
+			mu.Lock()
+			seen[r.Path]++
+			mu.Unlock()
+		}}
+		if err := core.DecompressWithOptions(archive, outDir, opts); err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if err := compareTrees(srcDir, outDir); err != nil {
+			t.Fatalf("Restored tree differs: %v", err)
+		}
+		for path, n := range seen {
+			if n != 1 {
+				t.Fatalf("%s was extracted %d times", path, n)
+			}
+		}
+		if len(seen) != 300 {
+			t.Fatalf("%d entries extracted, want 300", len(seen))
+		}
+		if _, err := core.Verify(archive, false, core.Options{}); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		Success("Every entry is extracted exactly once and verifies")
+		EndSection()
+	}
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestPrune(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Pruning Backups")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-prune-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	input := filepath.Join(testDir, "data.txt")
+	if err := os.WriteFile(input, []byte("nightly backup"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	backups := filepath.Join(testDir, "backups")
+	if err := os.MkdirAll(backups, 0755); err != nil {
+		t.Fatalf("Failed to create backup directory: %v", err)
+	}
+	// Reproducible archives record no creation time, so names date them
+	template := filepath.Join(testDir, "template.agcp")
+	if err := core.CompressWithOptions(input, template, core.Options{Reproducible: true}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	archive, err := os.ReadFile(template)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	// A nightly series from January to March, another series and an undated archive
+	var names []string
+	for day := time.Date(2026, 1, 1, 2, 30, 0, 0, time.Local); day.Month() <= time.March; day = day.AddDate(0, 0, 1) {
+		names = append(names, "home-"+day.Format("2006-01-02T15-04")+".agcp")
+	}
+	names = append(names, "db_20260101.agcp", "notes.agcp")
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(backups, name), archive, 0644); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+	}
+	sidecar := core.SidecarPath(filepath.Join(backups, "home-2026-01-15T02-30.agcp"))
+	if err := os.WriteFile(sidecar, []byte("checksum"), 0644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+	Success(fmt.Sprintf("%d archives created", len(names)))
+	EndSection()
+
+	policy := core.RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 2}
+	want := map[string]bool{"db_20260101.agcp": true, "notes.agcp": true}
+	for _, day := range []string{"03-25", "03-26", "03-27", "03-28", "03-29", "03-30", "03-31", "03-22", "03-15", "02-28"} {
+		want["home-2026-"+day+"T02-30.agcp"] = true
+	}
+
+	StartSection("Dry Run")
+	report, err := core.Prune(backups, policy, true)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(report.Archives) != len(names) || report.Removed != len(names)-len(want) {
+		t.Fatalf("dry run decided on %d archives and would remove %d; want %d and %d", len(report.Archives), report.Removed, len(names), len(names)-len(want))
+	}
+	for _, a := range report.Archives {
+		if a.Keep != want[a.Path] {
+			t.Fatalf("%s: keep = %v (%v)", a.Path, a.Keep, a.Reasons)
+		}
+	}
+	if entries, _ := os.ReadDir(backups); len(entries) != len(names)+1 {
+		t.Fatalf("dry run removed files: %d left", len(entries))
+	}
+	Success(fmt.Sprintf("Would keep %d archives and remove %d", len(want), report.Removed))
+	EndSection()
+
+	StartSection("Removing Archives")
+	if _, err := core.Prune(backups, policy, false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	entries, err := os.ReadDir(backups)
+	if err != nil {
+		t.Fatalf("Failed to read backup directory: %v", err)
+	}
+	for _, entry := range entries {
+		if !want[entry.Name()] {
+			t.Fatalf("%s left behind", entry.Name())
+		}
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d files left, want %d", len(entries), len(want))
+	}
+	Success("Only the retained archives are left, and removed archives' sidecars are gone")
+	EndSection()
+
+	StartSection("Rejecting an Empty Policy")
+	if _, err := core.Prune(backups, core.RetentionPolicy{}, true); err == nil {
+		t.Fatalf("expected an empty policy to be rejected")
+	}
+	Success("A policy that keeps nothing is refused")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestChecksumSidecar(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Checksum Sidecar")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-sidecar-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.bin"), bytes.Repeat([]byte("transfer me intact "), 20000), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Sidecar: true}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	EndSection()
+
+	StartSection("Writing the Sidecar")
+	sidecar, err := os.ReadFile(core.SidecarPath(archive))
+	if err != nil {
+		t.Fatalf("Sidecar was not written: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if want := hex.EncodeToString(sum[:]) + "  src.agcp\n"; string(sidecar) != want {
+		t.Fatalf("sidecar = %q, want %q", sidecar, want)
+	}
+	report, err := core.Verify(archive, true, core.Options{})
+	if err != nil || !report.Sidecar {
+		t.Fatalf("Verify = %+v, %v; want a matching sidecar", report, err)
+	}
+	Success("The sidecar holds the archive's SHA-256 in sha256sum format")
+	EndSection()
+
+	StartSection("Refreshing on Update")
+	if err := os.WriteFile(filepath.Join(srcDir, "new.txt"), []byte("added later"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := core.UpdateArchive(archive, srcDir, core.Options{}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := os.Stat(core.SidecarPath(archive + ".update")); !os.IsNotExist(err) {
+		t.Fatal("expected no sidecar for the staged archive")
+	}
+	if found, err := core.CheckSidecar(archive); !found || err != nil {
+		t.Fatalf("CheckSidecar after update = %v, %v", found, err)
+	}
+	Success("Updating an archive with a sidecar rewrites the sidecar")
+	EndSection()
+
+	StartSection("Detecting Damage")
+	data, err = os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatalf("Failed to damage archive: %v", err)
+	}
+	if _, err := core.Verify(archive, true, core.Options{}); !errors.Is(err, core.ErrSidecarMismatch) {
+		t.Fatalf("Verify of a damaged archive: got %v, want ErrSidecarMismatch", err)
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); !errors.Is(err, core.ErrSidecarMismatch) {
+		t.Fatalf("Decompress of a damaged archive: got %v, want ErrSidecarMismatch", err)
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Fatal("expected nothing extracted from a damaged archive")
+	}
+	Success("A damaged archive is refused before anything is extracted")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestTempDir(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Temporary Directory")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-tmpdir-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Incompressible entries larger than the in-memory spill buffer
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		data := make([]byte, 6<<20)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("Failed to generate data: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	scratch := filepath.Join(testDir, "scratch")
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		t.Fatalf("Failed to create scratch directory: %v", err)
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Spilling to the Chosen Directory")
+	archive := filepath.Join(testDir, "out.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Workers: 2, TempDir: scratch}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if left, _ := os.ReadDir(scratch); len(left) != 0 {
+		t.Fatalf("%d temporary files left in the scratch directory", len(left))
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Archive written with spills in the scratch directory, which is left empty")
+
+	missing := filepath.Join(testDir, "missing")
+	err = core.CompressWithOptions(srcDir, filepath.Join(testDir, "fail.agcp"), core.Options{Workers: 2, TempDir: missing})
+	if err == nil || !strings.Contains(err.Error(), "spill") {
+		t.Fatalf("expected spilling into a missing directory to fail, got %v", err)
+	}
+	Success(fmt.Sprintf("Missing scratch directory reported: %v", err))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestDeadline(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Deadline")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-deadline-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("file %d before the deadline ", i)), 5000)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	EndSection()
+
+	StartSection("Stopping Compression")
+	// The first entry finishes well before the deadline; holding up the run
+	// after it makes the second one start past it
+	var stalled sync.Once
+	opts := core.Options{
+		Workers:  1,
+		Deadline: time.Now().Add(500 * time.Millisecond),
+		OnEntry: func(core.EntryResult) {
+			stalled.Do(func() { time.Sleep(600 * time.Millisecond) })
+		},
+	}
+	if err := core.CompressWithOptions(srcDir, archive, opts); !errors.Is(err, core.ErrDeadline) {
+		t.Fatalf("Compression past the deadline: got %v, want ErrDeadline", err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Fatal("expected no finished archive after stopping at the deadline")
+	}
+	if _, err := os.Stat(core.PartialPath(archive)); err != nil {
+		t.Fatalf("Partial archive was not kept: %v", err)
+	}
+	Success("Compression stops at the deadline and keeps the partial archive")
+	EndSection()
+
+	StartSection("Resuming Compression")
+	warnings := &core.WarningLog{}
+	opts = core.Options{Partial: core.PartialResume, Warn: warnings.Add}
+	if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+		t.Fatalf("Resuming failed: %v", err)
+	}
+	if w := warnings.Warnings(); len(w) != 1 || !strings.Contains(w[0].Message, "1 of 4 entries already complete") {
+		t.Fatalf("warnings = %+v, want the first entry resumed", w)
+	}
+	if _, err := core.Verify(archive, false, core.Options{}); err != nil {
+		t.Fatalf("Verify of the resumed archive failed: %v", err)
+	}
+	Success("The resumed archive keeps the entry finished before the deadline")
+	EndSection()
+
+	StartSection("Stopping Extraction")
+	outDir := filepath.Join(testDir, "out")
+	opts = core.Options{Deadline: time.Now()}
+	if err := core.DecompressWithOptions(archive, outDir, opts); !errors.Is(err, core.ErrDeadline) {
+		t.Fatalf("Extraction past the deadline: got %v, want ErrDeadline", err)
+	}
+	if err := core.DecompressWithOptions(archive, outDir, core.Options{Update: true}); err != nil {
+		t.Fatalf("Continuing the extraction failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Continued extraction differs: %v", err)
+	}
+	Success("An extraction stopped at the deadline continues with Update")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestNewerFormatVersion(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Newer Format Version")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-version-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	content := bytes.Repeat([]byte("written by a future agcp "), 1000)
+	input := filepath.Join(testDir, "data.txt")
+	if err := os.WriteFile(input, content, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "data.agcp")
+	if err := core.CompressWithOptions(input, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if data[5] != core.MinReaderVersion || data[6] != 0 {
+		t.Fatalf("unexpected header layout")
+	}
+	EndSection()
+
+	// forge rewrites the version, minimum reader version and feature list of
+	// the archive and re-seals the header, as a newer agcp would write it
+	forge := func(name string, version, minReader byte, features ...core.Feature) string {
+		header := append([]byte(core.Magic), version, minReader, byte(len(features)))
+		for _, f := range features {
+			required := byte(0)
+			if f.Required {
+				required = 1
+			}
+			header = append(header, byte(f.Since), required, byte(len(f.Name)))
+			header = append(header, f.Name...)
+		}
+		trailer := data[len(data)-len(core.TrailerMagic)-12:]
+		headerLen := int(binary.BigEndian.Uint64(trailer[0:8]))
+		header = append(header, data[7:headerLen]...)
+		forged := append(append([]byte(nil), header...), data[headerLen:len(data)-len(trailer)]...)
+		forged = binary.BigEndian.AppendUint64(forged, uint64(len(header)))
+		forged = binary.BigEndian.AppendUint32(forged, crc32.ChecksumIEEE(header))
+		forged = append(forged, core.TrailerMagic...)
+		path := filepath.Join(testDir, name)
+		if err := os.WriteFile(path, forged, 0644); err != nil {
+			t.Fatalf("Failed to write forged archive: %v", err)
+		}
+		return path
+	}
+	brotli := core.Feature{Name: "brotli", Since: core.Version + 1, Required: true}
+	xattrs := core.Feature{Name: "xattrs", Since: core.Version + 2}
+
+	StartSection("Refusing a Newer Minimum Reader Version")
+	newer := forge("newer.agcp", core.Version+2, core.Version+1, brotli, xattrs)
+	err = core.Decompress(context.Background(), newer, filepath.Join(testDir, "newer"), core.Options{})
+	var versionErr *core.VersionError
+	if !errors.As(err, &versionErr) || !errors.Is(err, core.ErrUnsupportedVersion) {
+		t.Fatalf("expected a VersionError, got %v", err)
+	}
+	if versionErr.Version != core.Version+2 || versionErr.MinReader != core.Version+1 || len(versionErr.Missing) != 2 {
+		t.Fatalf("VersionError = %+v", versionErr)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("needs an agcp that reads format v%d or later", core.Version+1),
+		fmt.Sprintf("brotli (required, format v%d)", core.Version+1),
+		fmt.Sprintf("xattrs (optional, format v%d)", core.Version+2),
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q does not mention %q", err, want)
+		}
+	}
+	Success(fmt.Sprintf("Refused: %v", err))
+	EndSection()
+
+	StartSection("Reading Newer Optional Features")
+	optional := forge("optional.agcp", core.Version+1, core.MinReaderVersion, core.Feature{Name: "xattrs", Since: core.Version + 1})
+	warnings := &core.WarningLog{}
+	out := filepath.Join(testDir, "optional.txt")
+	if err := core.DecompressWithOptions(optional, out, core.Options{Warn: warnings.Add}); err != nil {
+		t.Fatalf("Decompression of an archive with optional features failed: %v", err)
+	}
+	if err := compareFiles(input, out); err != nil {
+		t.Fatalf("Extracted content differs: %v", err)
+	}
+	if w := warnings.Warnings(); len(w) != 1 || w[0].Code != core.WarnIgnoredFeature {
+		t.Fatalf("warnings = %+v, want one ignored-feature", w)
+	}
+	Success("An archive adding only optional features is extracted with a warning")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestEntryTypes(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Entry Types")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-entry-type-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"keep.txt", "link.txt", "future.bin"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), bytes.Repeat([]byte(name), 100), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "typed.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	// Mark link.txt a symlink and future.bin a type no version knows yet, as a
+	// newer agcp would: a type attribute (tag 9) at the end of each entry's
+	// attribute block, which follows its path and two 8-byte sizes
+	trailerLen := 12 + len(core.TrailerMagic)
+	headerLen := int(binary.BigEndian.Uint64(data[len(data)-trailerLen:]))
+	header := append([]byte(nil), data[:headerLen]...)
+	for name, kind := range map[string]byte{"link.txt": 1, "future.bin": 200} {
+		at := bytes.Index(header, []byte(name)) + len(name) + 16
+		attrsLen := int(binary.BigEndian.Uint16(header[at:]))
+		end := at + 2 + attrsLen
+		header = append(header[:end], append([]byte{9, 0, 1, kind}, header[end:]...)...)
+		binary.BigEndian.PutUint16(header[at:], uint16(attrsLen+4))
+	}
+	forged := append(header, data[headerLen:len(data)-trailerLen]...)
+	forged = binary.BigEndian.AppendUint64(forged, uint64(len(header)))
+	forged = binary.BigEndian.AppendUint32(forged, crc32.ChecksumIEEE(header))
+	forged = append(forged, core.TrailerMagic...)
+	if err := os.WriteFile(archive, forged, 0644); err != nil {
+		t.Fatalf("Failed to write forged archive: %v", err)
+	}
+	Success("Archive with a symlink and an unknown entry type forged")
+	EndSection()
+
+	StartSection("Listing Entry Types")
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	types := make(map[string]string)
+	for _, entry := range entries {
+		types[entry.Path] = entry.Type
+	}
+	if types["keep.txt"] != "regular" || types["link.txt"] != "symlink" || types["future.bin"] != "type 200" {
+		t.Fatalf("entry types = %v", types)
+	}
+	Success(fmt.Sprintf("Types listed: %v", types))
+	EndSection()
+
+	StartSection("Skipping Entries on Extraction")
+	log := &core.WarningLog{}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.DecompressWithOptions(archive, outDir, core.Options{Warn: log.Add}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "keep.txt")); err != nil {
+		t.Fatalf("regular entry not extracted: %v", err)
+	}
+	for _, name := range []string{"link.txt", "future.bin"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s extracted despite its type: %v", name, err)
+		}
+	}
+	var skipped []string
+	for _, w := range log.Warnings() {
+		if w.Code == core.WarnSkippedEntryType {
+			skipped = append(skipped, w.String())
+		}
+	}
+	if len(skipped) != 2 {
+		t.Fatalf("expected two skipped-entry-type warnings, got %v", log.Warnings())
+	}
+	Success(fmt.Sprintf("Skipped: %v", skipped))
+	EndSection()
+
+	StartSection("Verifying and Reading")
+	report, err := core.Verify(archive, false, core.Options{})
+	if err != nil || len(report.Failures) > 0 || report.Decoded != 1 {
+		t.Fatalf("Verify = %+v, %v; want one entry decoded and no failures", report, err)
+	}
+	a, err := core.OpenArchive(archive, core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	defer a.Close()
+	if _, err := a.ReadEntry("link.txt"); !errors.Is(err, core.ErrEntryType) {
+		t.Fatalf("reading a symlink entry: got %v, want ErrEntryType", err)
+	}
+	if _, err := a.ReadEntry("keep.txt"); err != nil {
+		t.Fatalf("reading a regular entry: %v", err)
+	}
+	Success("Verify checks only the structure of typed entries; reading them fails")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestPreviewExtraction(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Extraction Preview")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-preview-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := map[string]string{
+		"config/app.yaml": "port: 8080\n",
+		"data/a.bin":      strings.Repeat("a", 3000),
+		"data/b.bin":      strings.Repeat("b", 5000),
+		"README":          "restore me\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	EndSection()
+
+	StartSection("Previewing a New Destination")
+	out := filepath.Join(testDir, "out")
+	preview, err := core.PreviewExtraction(archive, out, core.Options{})
+	if err != nil {
+		t.Fatalf("PreviewExtraction failed: %v", err)
+	}
+	if preview.Dest != out || preview.NonEmpty || preview.Entries != 4 || preview.TotalSize != 8000+11+11 || preview.Overwrites != 0 {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if want := []string{"README", "config", "data"}; fmt.Sprint(preview.TopLevel) != fmt.Sprint(want) {
+		t.Fatalf("top-level names = %q, want %q", preview.TopLevel, want)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("expected the preview to write nothing")
+	}
+	Success("An empty destination needs no confirmation and nothing is written")
+	EndSection()
+
+	StartSection("Previewing Overwrites")
+	if err := os.MkdirAll(filepath.Join(out, "data"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"data/a.bin", "unrelated.txt"} {
+		if err := os.WriteFile(filepath.Join(out, filepath.FromSlash(name)), []byte("existing"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	preview, err = core.PreviewExtraction(archive, out, core.Options{})
+	if err != nil {
+		t.Fatalf("PreviewExtraction failed: %v", err)
+	}
+	if !preview.NonEmpty || preview.Overwrites != 1 {
+		t.Fatalf("preview = %+v, want a non-empty destination with 1 overwrite", preview)
+	}
+	Success("A non-empty destination reports the files it would overwrite")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestCopyEntries(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Copy Entries")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-copy-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	write := func(root string, files map[string]string) {
+		for name, content := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+		}
+	}
+	srcA, srcB := filepath.Join(testDir, "a"), filepath.Join(testDir, "b")
+	write(srcA, map[string]string{"logs/1.log": strings.Repeat("log line\n", 500), "keep.txt": "from a"})
+	write(srcB, map[string]string{"data/x.bin": strings.Repeat("xyz", 2000)})
+	archiveA, archiveB := filepath.Join(testDir, "a.agcp"), filepath.Join(testDir, "b.agcp")
+	if err := core.CompressWithOptions(srcA, archiveA, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if err := core.CompressWithOptions(srcB, archiveB, core.Options{Codec: core.CodecGzip}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	a, err := core.OpenArchive(archiveA, core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	defer a.Close()
+	b, err := core.OpenArchive(archiveB, core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	defer b.Close()
+	EndSection()
+
+	StartSection("Merging Without Recompressing")
+	merged := filepath.Join(testDir, "merged.agcp")
+	w, err := core.CreateArchive(merged, "merged", core.Options{})
+	if err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	noLogs := func(e core.EntryInfo) bool { return !strings.HasPrefix(e.Path, "logs/") }
+	if err := core.CopyEntries(w, a, noLogs); err != nil {
+		t.Fatalf("CopyEntries from a failed: %v", err)
+	}
+	if err := core.CopyEntries(w, b, nil); err != nil {
+		t.Fatalf("CopyEntries from b failed: %v", err)
+	}
+	var entryErr *core.EntryError
+	if err := core.CopyEntries(w, b, nil); !errors.As(err, &entryErr) || entryErr.Path != "data/x.bin" {
+		t.Fatalf("copying a path twice: got %v, want an EntryError for data/x.bin", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("expected closing twice to fail")
+	}
+
+	entries, err := core.ListEntries(merged)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	got := make(map[string]core.EntryInfo)
+	for _, e := range entries {
+		got[e.Path] = e
+	}
+	if len(got) != 2 {
+		t.Fatalf("merged entries = %v, want keep.txt and data/x.bin", entries)
+	}
+	want, _ := b.Stat("data/x.bin")
+	if x := got["data/x.bin"]; x.Codec != "gzip" || x.CompressedSize != want.CompressedSize || !bytes.Equal(x.SHA256, want.SHA256) {
+		t.Fatalf("copied entry = %+v, want the gzip data of %+v", x, want)
+	}
+	if _, err := core.Verify(merged, false, core.Options{}); err != nil {
+		t.Fatalf("Verify of the merged archive failed: %v", err)
+	}
+	out := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), merged, out, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name, content := range map[string]string{"keep.txt": "from a", "data/x.bin": strings.Repeat("xyz", 2000)} {
+		if data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name))); err != nil || string(data) != content {
+			t.Fatalf("extracted %s = %q, %v", name, data, err)
+		}
+	}
+	Success("Entries were copied with their codec and compressed data unchanged")
+	EndSection()
+
+	StartSection("Copying From a Format v3 Archive")
+	v3, err := core.OpenArchive(filepath.Join(goldenDir, "v3-dir.agcp"), core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	defer v3.Close()
+	upgraded := filepath.Join(testDir, "upgraded.agcp")
+	if w, err = core.CreateArchive(upgraded, "tree", core.Options{}); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	if err := core.CopyEntries(w, v3, nil); err != nil {
+		t.Fatalf("CopyEntries failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if report, err := core.Verify(upgraded, false, core.Options{}); err != nil || len(report.Failures) > 0 {
+		t.Fatalf("Verify of the copied archive = %+v, %v", report, err)
+	}
+	upgradedOut := filepath.Join(testDir, "upgraded")
+	if err := core.Decompress(context.Background(), upgraded, upgradedOut, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(filepath.Join(goldenDir, "tree"), upgradedOut); err != nil {
+		t.Fatalf("Copied archive differs from the golden tree: %v", err)
+	}
+	Success("Entries without hashes were hashed and copied intact")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix permission bits to restore")
+	}
+	startTime := time.Now()
+	ReportStart("Permissions")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-perms-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	modes := map[string]os.FileMode{
+		"private.txt":  0600,
+		"script.sh":    0755,
+		"readonly.txt": 0444,
+		"empty.txt":    0640,
+		"big.log":      0620,
+	}
+	for name, mode := range modes {
+		var data []byte
+		switch name {
+		case "big.log":
+			data = bytes.Repeat([]byte("a large entry decoded a block per core\n"), 300000)
+		case "empty.txt":
+		default:
+			data = []byte(name)
+		}
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Failed to set mode: %v", err)
+		}
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := Compress(srcDir, archive); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Files with five different modes archived")
+	EndSection()
+
+	StartSection("Restoring Permissions")
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name, mode := range modes {
+		info, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s restored with mode %v, want %v", name, info.Mode().Perm(), mode)
+		}
+	}
+	Success("Every file restored with its archived mode")
+
+	// Extracting again replaces the read-only file
+	if err := core.DecompressWithOptions(archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Extracting over read-only files failed: %v", err)
+	}
+	Success("Extracting again over the restored files succeeds")
+	EndSection()
+
+	StartSection("Extracting With NoPerms")
+	// The default mode is whatever the umask makes of 0644
+	probe := filepath.Join(testDir, "probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatalf("Failed to write probe file: %v", err)
+	}
+	probeInfo, err := os.Stat(probe)
+	if err != nil {
+		t.Fatalf("Failed to stat probe file: %v", err)
+	}
+	plainDir := filepath.Join(testDir, "plain")
+	if err := core.DecompressWithOptions(archive, plainDir, core.Options{NoPerms: true}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	for name := range modes {
+		info, err := os.Stat(filepath.Join(plainDir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if info.Mode().Perm() != probeInfo.Mode().Perm() {
+			t.Errorf("%s extracted with mode %v, want the default %v", name, info.Mode().Perm(), probeInfo.Mode().Perm())
+		}
+	}
+	Success(fmt.Sprintf("Every file extracted with the default mode %v", probeInfo.Mode().Perm()))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestBlockDevice tests imaging a block device and writing an image back over
+// an existing device or file in place, with size checks and zero skipping
+func TestBlockDevice(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Block Devices")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-device-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// A disk image with data around a long run of zeros, in whole sectors
+	image := make([]byte, 4<<20)
+	rand.Read(image[:1<<20])
+	rand.Read(image[3<<20:])
+	imagePath := filepath.Join(testDir, "disk.img")
+	if err := os.WriteFile(imagePath, image, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	archive := filepath.Join(testDir, "disk.agcp")
+	if err := core.Compress(context.Background(), imagePath, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	// filled returns the path of a new target of size bytes, all set to b
+	filled := func(name string, size int, b byte) string {
+		path := filepath.Join(testDir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte{b}, size), 0644); err != nil {
+			t.Fatalf("Failed to write target: %v", err)
+		}
+		return path
+	}
+	Success("4 MiB disk image archived")
+	EndSection()
+
+	StartSection("Writing an Image in Place")
+	target := filled("target", len(image)+4096, 0xff)
+	if err := core.DecompressWithOptions(archive, target, core.Options{ToDevice: true}); err != nil {
+		t.Fatalf("Writing to target failed: %v", err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("Failed to read target: %v", err)
+	}
+	if !bytes.Equal(got[:len(image)], image) {
+		t.Errorf("Target does not hold the image")
+	}
+	if !bytes.Equal(got[len(image):], bytes.Repeat([]byte{0xff}, 4096)) {
+		t.Errorf("Bytes past the image were changed")
+	}
+	Success("Image written over a larger target, leaving the rest as it was")
+
+	small := filled("small", len(image)-512, 0xff)
+	err = core.DecompressWithOptions(archive, small, core.Options{ToDevice: true})
+	if err == nil || !strings.Contains(err.Error(), "the image needs") {
+		t.Errorf("Writing to a small target: got %v, want a size error", err)
+	}
+	if got, _ := os.ReadFile(small); !bytes.Equal(got, bytes.Repeat([]byte{0xff}, len(image)-512)) {
+		t.Errorf("A target too small for the image was written to")
+	}
+	if err := core.DecompressWithOptions(archive, filepath.Join(testDir, "missing"), core.Options{ToDevice: true}); err == nil {
+		t.Errorf("Writing to a missing target succeeded; it should not be created")
+	}
+	Success("Small and missing targets refused before writing")
+
+	dirArchive := filepath.Join(testDir, "dir.agcp")
+	if err := core.Compress(context.Background(), testDir, dirArchive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if err := core.DecompressWithOptions(dirArchive, filled("dir-target", 1<<20, 0), core.Options{ToDevice: true}); err == nil {
+		t.Errorf("Writing a directory archive to a device succeeded")
+	}
+	Success("Directory archives refused")
+	EndSection()
+
+	StartSection("Skipping Zeros")
+	zeroed := filled("zeroed", len(image), 0)
+	if err := core.DecompressWithOptions(archive, zeroed, core.Options{ToDevice: true, SkipZeros: true}); err != nil {
+		t.Fatalf("Writing with SkipZeros failed: %v", err)
+	}
+	if got, _ := os.ReadFile(zeroed); !bytes.Equal(got, image) {
+		t.Errorf("Zeroed target does not hold the image")
+	}
+	// Over stale data the skipped run shows through, which is why the
+	// option is only for targets that read as zeros
+	stale := filled("stale", len(image), 0xff)
+	if err := core.DecompressWithOptions(archive, stale, core.Options{ToDevice: true, SkipZeros: true}); err != nil {
+		t.Fatalf("Writing with SkipZeros failed: %v", err)
+	}
+	got, _ = os.ReadFile(stale)
+	if !bytes.Equal(got[:1<<20], image[:1<<20]) || !bytes.Equal(got[3<<20:], image[3<<20:]) {
+		t.Errorf("Data around the zeros was not written")
+	}
+	if !bytes.Equal(got[1<<20:3<<20], bytes.Repeat([]byte{0xff}, 2<<20)) {
+		t.Errorf("The run of zeros was written rather than skipped")
+	}
+	Success("Zeros skipped, data written")
+
+	// Extracted as a new file, zeros at the end are skipped too, but the file
+	// still ends where the image does, even over an existing longer file
+	trailing := append([]byte("header"), make([]byte, 1<<20-6)...)
+	trailingPath := filepath.Join(testDir, "trailing.img")
+	if err := os.WriteFile(trailingPath, trailing, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	trailingArchive := filepath.Join(testDir, "trailing.agcp")
+	if err := core.Compress(context.Background(), trailingPath, trailingArchive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	for _, out := range []string{filepath.Join(testDir, "new.img"), filled("existing.img", 2<<20, 0xff)} {
+		if err := core.Decompress(context.Background(), trailingArchive, out, core.Options{SkipZeros: true}); err != nil {
+			t.Fatalf("Extracting with SkipZeros failed: %v", err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, trailing) {
+			t.Errorf("%s: extracted %d bytes, want the %d-byte image", filepath.Base(out), len(got), len(trailing))
+		}
+	}
+	Success("Files extracted with SkipZeros keep their trailing zeros")
+	EndSection()
+
+	StartSection("Imaging a Loop Device")
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		Warning("Loop devices need root on Linux; skipping")
+		EndSection()
+		ReportEnd(true, time.Since(startTime))
+		return
+	}
+	attach := func(path string) string {
+		out, err := exec.Command("losetup", "--find", "--show", path).Output()
+		if err != nil {
+			return ""
+		}
+		device := strings.TrimSpace(string(out))
+		t.Cleanup(func() { exec.Command("losetup", "--detach", device).Run() })
+		return device
+	}
+	source := attach(imagePath)
+	if source == "" {
+		Warning("Cannot attach a loop device; skipping")
+		EndSection()
+		ReportEnd(true, time.Since(startTime))
+		return
+	}
+	deviceArchive := filepath.Join(testDir, "device.agcp")
+	if err := core.Compress(context.Background(), source, deviceArchive, core.Options{}); err != nil {
+		t.Fatalf("Compressing %s failed: %v", source, err)
+	}
+	restored := filepath.Join(testDir, "restored.img")
+	if err := core.Decompress(context.Background(), deviceArchive, restored, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if got, _ := os.ReadFile(restored); !bytes.Equal(got, image) {
+		t.Errorf("Image of %s does not match its content (%d bytes, want %d)", source, len(got), len(image))
+	}
+	Success(fmt.Sprintf("%s imaged at its full size", source))
+
+	backing := filled("backing", len(image), 0)
+	dest := attach(backing)
+	if dest == "" {
+		t.Fatalf("Cannot attach a second loop device")
+	}
+	if err := core.DecompressWithOptions(deviceArchive, dest, core.Options{ToDevice: true, Fsync: core.FsyncPerFile}); err != nil {
+		t.Fatalf("Writing to %s failed: %v", dest, err)
+	}
+	exec.Command("losetup", "--detach", dest).Run()
+	if got, _ := os.ReadFile(backing); !bytes.Equal(got, image) {
+		t.Errorf("%s does not hold the image", dest)
+	}
+	Success(fmt.Sprintf("Image written back to %s", dest))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestCompressionCache tests that compressing a tree again with a cache copies
+// the data of unchanged files, byte for byte, and compresses changed ones
+func TestCompressionCache(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Compression Cache")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-cache-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	names := []string{"a.txt", "b.log", "sub/c.txt", "sub/d.bin", "empty.txt"}
+	for i, name := range names {
+		var data []byte
+		if name != "empty.txt" {
+			data = bytes.Repeat([]byte(fmt.Sprintf("line %d of a file that compresses well\n", i)), 20000+i)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	cacheDir := filepath.Join(testDir, "cache")
+	// compress archives srcDir with the cache, returning the entries whose
+	// data came from it
+	compress := func(archive string, level int) map[string]bool {
+		cached := make(map[string]bool)
+		var mu sync.Mutex
+		opts := core.Options{CacheDir: cacheDir, Level: level, Reproducible: true, OnEntry: func(r core.EntryResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Cached {
+				cached[r.Path] = true
+			}
+		}}
+		if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		return cached
+	}
+	first := filepath.Join(testDir, "first.agcp")
+	if cached := compress(first, 0); len(cached) != 0 {
+		t.Errorf("First run copied %d entries from an empty cache", len(cached))
+	}
+	Success("Tree compressed, filling the cache")
+	EndSection()
+
+	StartSection("Reusing Unchanged Files")
+	second := filepath.Join(testDir, "second.agcp")
+	if cached := compress(second, 0); len(cached) != len(names)-1 {
+		t.Errorf("Second run copied %d entries from the cache, want %d", len(cached), len(names)-1)
+	}
+	a, _ := os.ReadFile(first)
+	b, _ := os.ReadFile(second)
+	if !bytes.Equal(a, b) {
+		t.Errorf("Archive built from the cache differs from the one compressed")
+	}
+	Success("Every non-empty file copied from the cache, archive byte-identical")
+	EndSection()
+
+	StartSection("Invalidating Changed Files")
+	changed := filepath.Join(srcDir, "sub", "c.txt")
+	if err := os.WriteFile(changed, []byte("new content"), 0644); err != nil {
+		t.Fatalf("Failed to change test file: %v", err)
+	}
+	// Same size, new modification time
+	touched := filepath.Join(srcDir, "a.txt")
+	data, _ := os.ReadFile(touched)
+	data[0] = 'L'
+	if err := os.WriteFile(touched, data, 0644); err != nil {
+		t.Fatalf("Failed to change test file: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(touched, future, future); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+	third := filepath.Join(testDir, "third.agcp")
+	cached := compress(third, 0)
+	if cached["src/sub/c.txt"] || cached["src/a.txt"] || len(cached) != len(names)-3 {
+		t.Errorf("Changed files copied from the cache: %v", cached)
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), third, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Errorf("Extracted tree differs: %v", err)
+	}
+	if cached := compress(filepath.Join(testDir, "fourth.agcp"), 0); len(cached) != len(names)-1 {
+		t.Errorf("Changed files were not cached again: %d of %d entries copied", len(cached), len(names)-1)
+	}
+	Success("Changed files compressed again and re-cached")
+
+	if cached := compress(filepath.Join(testDir, "level.agcp"), 9); len(cached) != 0 {
+		t.Errorf("%d entries copied from a cache filled at another level", len(cached))
+	}
+	Success("Another compression level misses the cache")
+	EndSection()
+
+	StartSection("Damaged and Unusable Caches")
+	var damaged int
+	filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				data[len(data)-1] ^= 0xff // In the data, past the header
+				os.WriteFile(path, data, 0600)
+				damaged++
+			}
+		}
+		return nil
+	})
+	damagedArchive := filepath.Join(testDir, "damaged.agcp")
+	if cached := compress(damagedArchive, 9); len(cached) != 0 {
+		t.Errorf("%d damaged cache files were used", len(cached))
+	}
+	if _, err := core.Verify(damagedArchive, false, core.Options{}); err != nil {
+		t.Errorf("Archive compressed past a damaged cache fails to verify: %v", err)
+	}
+	Success(fmt.Sprintf("%d damaged cache files ignored", damaged))
+
+	blocked := filepath.Join(testDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	var warnings []core.Warning
+	opts := core.Options{CacheDir: filepath.Join(blocked, "cache"), Warn: func(w core.Warning) { warnings = append(warnings, w) }}
+	if err := core.CompressWithOptions(srcDir, filepath.Join(testDir, "blocked.agcp"), opts); err != nil {
+		t.Fatalf("Compressing with an unusable cache failed: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Code != core.WarnCacheUnavailable {
+		t.Errorf("Unusable cache: got warnings %v, want one %s", warnings, core.WarnCacheUnavailable)
+	}
+	Success("An unusable cache directory is reported and skipped")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestDoctor tests that the self-check passes on a working machine, cleans up
+// after itself, and reports a temporary directory it cannot use
+func TestDoctor(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Self-Check")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-doctor-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	Success("Temporary directory created")
+	EndSection()
+
+	StartSection("Running the Checks")
+	report := core.Doctor(core.Options{TempDir: testDir})
+	statuses := make(map[string]core.FindingStatus)
+	for _, f := range report.Findings {
+		statuses[f.Check] = f.Status
+		if f.Status == core.FindingFail {
+			t.Errorf("%s failed: %s", f.Check, f.Detail)
+		}
+		if f.Status != core.FindingOK && f.Advice == "" {
+			t.Errorf("%s is %s without advice: %s", f.Check, f.Status, f.Detail)
+		}
+	}
+	for _, check := range []string{"build", "cpu", "temp-dir", "temp-space", "open-files", "codecs", "round-trip", "throughput"} {
+		if _, ok := statuses[check]; !ok {
+			t.Errorf("No %s finding", check)
+		}
+	}
+	if statuses["round-trip"] != core.FindingOK {
+		t.Errorf("Round trip is %s, want ok", statuses["round-trip"])
+	}
+	if report.Worst() == core.FindingFail {
+		t.Errorf("Worst status is fail on a working machine")
+	}
+	if left, _ := os.ReadDir(testDir); len(left) != 0 {
+		t.Errorf("Self-check left %d files in the temporary directory", len(left))
+	}
+	Success(fmt.Sprintf("%d checks passed, scratch files removed", len(report.Findings)))
+	EndSection()
+
+	StartSection("Unusable Temporary Directory")
+	blocked := filepath.Join(testDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	report = core.Doctor(core.Options{TempDir: blocked})
+	if report.Worst() != core.FindingFail {
+		t.Errorf("Worst status with a file as the temporary directory is %s, want fail", report.Worst())
+	}
+	for _, f := range report.Findings {
+		if f.Check == "temp-dir" && (f.Status != core.FindingFail || !strings.Contains(f.Advice, "--tmpdir")) {
+			t.Errorf("temp-dir finding: %+v", f)
+		}
+	}
+	Success("Unusable temporary directory reported with advice")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestStreamCompression checks that archives written to a stream, which is
+// never seeked, match those written to a file, and that single-file archives
+// round-trip between readers and writers
+func TestStreamCompression(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Stream Compression")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-stream-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	files := map[string][]byte{
+		"a.txt":     bytes.Repeat([]byte("a line that compresses well\n"), 300000),
+		"sub/b.txt": []byte("small"),
+		"empty.txt": nil,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test tree created")
+	EndSection()
+
+	StartSection("Directory to a Stream")
+	for _, workers := range []int{1, 4} {
+		opts := core.Options{Reproducible: true, Align: 512, Workers: workers, TempDir: testDir}
+		var stream bytes.Buffer
+		if err := core.CompressToWriter(srcDir, &stream, opts); err != nil {
+			t.Fatalf("Stream compression failed: %v", err)
+		}
+		archive := filepath.Join(testDir, "file.agcp")
+		if err := core.CompressWithOptions(srcDir, archive, opts); err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		want, _ := os.ReadFile(archive)
+		if !bytes.Equal(stream.Bytes(), want) {
+			t.Errorf("Archive written to a stream with %d workers differs from the one written to a file", workers)
+		}
+	}
+	streamed := filepath.Join(testDir, "streamed.agcp")
+	var stream bytes.Buffer
+	if err := core.CompressToWriter(srcDir, &stream, core.Options{TempDir: testDir}); err != nil {
+		t.Fatalf("Stream compression failed: %v", err)
+	}
+	if err := os.WriteFile(streamed, stream.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if _, err := core.Verify(streamed, false, core.Options{}); err != nil {
+		t.Errorf("Streamed archive does not verify: %v", err)
+	}
+	extracted := filepath.Join(testDir, "extracted")
+	if err := core.Decompress(context.Background(), streamed, extracted, core.Options{}); err != nil {
+		t.Fatalf("Extraction failed: %v", err)
+	}
+	if err := compareTrees(srcDir, extracted); err != nil {
+		t.Errorf("Extracted tree differs: %v", err)
+	}
+	Success("Streamed archive byte-identical to a file archive, extracts intact")
+	EndSection()
+
+	StartSection("Reader to Stream to Writer")
+	content := files["a.txt"]
+	stream.Reset()
+	if err := core.CompressReader(bytes.NewReader(content), "stdin", &stream, core.Options{}); err != nil {
+		t.Fatalf("Compressing a reader failed: %v", err)
+	}
+	single := filepath.Join(testDir, "single.agcp")
+	if err := os.WriteFile(single, stream.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	entries, err := core.ListEntries(single)
+	if err != nil || len(entries) != 1 || entries[0].Path != "stdin" || entries[0].OriginalSize != uint64(len(content)) {
+		t.Errorf("Entries of the archive of a reader: %+v, %v", entries, err)
+	}
+	var out bytes.Buffer
+	if err := core.DecompressToWriter(single, &out, core.Options{}); err != nil {
+		t.Fatalf("Decompressing to a writer failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("Content written to a stream differs: %d bytes, want %d", out.Len(), len(content))
+	}
+	if err := core.DecompressToWriter(streamed, &out, core.Options{}); err == nil {
+		t.Errorf("Writing a directory archive to a stream succeeded")
+	}
+	Success("Content round-trips from a reader to a writer; directory archives refused")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestLibOptions tests that the options-based API re-exported by the lib
+// package applies codecs and reports progress like core's
+func TestLibOptions(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Lib Options")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-lib-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), bytes.Repeat([]byte(name+"\n"), 10000), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test tree created")
+	EndSection()
+
+	StartSection("Compressing Through lib")
+	var mu sync.Mutex
+	codecs := make(map[string]string)
+	archive := filepath.Join(testDir, "src.agcp")
+	opts := core.Options{Codec: core.CodecGzip, OnEntry: func(r core.EntryResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		codecs[r.Path] = r.Codec
+	}}
+	if err := CompressWithOptions(srcDir, archive, opts); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if len(codecs) != 2 || codecs["a.txt"] != "gzip" || codecs["b.txt"] != "gzip" {
+		t.Errorf("Entries reported: %v, want both compressed with gzip", codecs)
+	}
+	extracted := filepath.Join(testDir, "extracted")
+	if err := DecompressWithOptions(archive, extracted, core.Options{}); err != nil {
+		t.Fatalf("Extraction failed: %v", err)
+	}
+	if err := compareTrees(srcDir, extracted); err != nil {
+		t.Errorf("Extracted tree differs: %v", err)
+	}
+	Success("Codec and entry callback applied, tree round-trips")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestManyPatterns checks that hundreds of tag and policy patterns of every
+// shape match exactly as they would one at a time
+func TestManyPatterns(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Many Patterns")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-patterns-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	exts := []string{"txt", "log", "dat", "tar.gz"}
+	var names []string
+	for d := 0; d < 4; d++ {
+		for s := 0; s < 3; s++ {
+			for f := 0; f < 6; f++ {
+				name := fmt.Sprintf("dir%d/sub%d/file%d.%s", d, s, f, exts[f%len(exts)])
+				if s == 0 {
+					name = fmt.Sprintf("dir%d/file%d.%s", d, f, exts[f%len(exts)])
+				}
+				names = append(names, name)
+			}
+		}
+	}
+	names = append(names, "top.txt", ".hidden.log")
+	for _, name := range names {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success(fmt.Sprintf("%d files created", len(names)))
+	EndSection()
+
+	// matches is the reference: each pattern matched segment by segment
+	var matches func(pattern, name []string) bool
+	matches = func(pattern, name []string) bool {
+		if len(pattern) == 0 {
+			return len(name) == 0
+		}
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matches(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, _ := path.Match(pattern[0], name[0])
+		return ok && matches(pattern[1:], name[1:])
+	}
+	// One rule of every shape the matcher indexes, and of shapes it does not
+	shapes := []func(n int, ext string) string{
+		func(n int, ext string) string { return fmt.Sprintf("dir%d/**", n) },
+		func(n int, ext string) string { return "**/*." + ext },
+		func(n int, ext string) string { return fmt.Sprintf("dir%d/sub1/file%d.txt", n, n) },
+		func(n int, ext string) string { return "*." + ext },
+		func(n int, ext string) string { return fmt.Sprintf("d?r%d/**", n) },
+		func(n int, ext string) string { return fmt.Sprintf("**/file%d*", n) },
+		func(n int, ext string) string { return fmt.Sprintf("dir%d/*/file*.dat", n) },
+		func(n int, ext string) string { return fmt.Sprintf("missing%d/**", n) },
+		func(n int, ext string) string { return fmt.Sprintf("*.none%d", n) },
+		func(n int, ext string) string { return fmt.Sprintf("dir%d", n) },
+		func(n int, ext string) string { return fmt.Sprintf("dir%d/sub%d/**", n, n%3) },
+		func(n int, ext string) string { return fmt.Sprintf("**/sub%d", n) },
+		func(n int, ext string) string { return "top.txt" },
+		func(n int, ext string) string { return "**" },
+		func(n int, ext string) string { return fmt.Sprintf("[d]ir%d/sub2/*", n) },
+	}
+
+	StartSection("Tagging With 450 Rules")
+	var rules []core.TagRule
+	for i := 0; i < 450; i++ {
+		pattern := shapes[i%len(shapes)](i/len(shapes)%5, exts[i%len(exts)])
+		rule, err := core.ParseTagRule(fmt.Sprintf("%s=r%d", pattern, i))
+		if err != nil {
+			t.Fatalf("ParseTagRule(%q) failed: %v", pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	archive := filepath.Join(testDir, "tags.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Tags: rules}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	tagged := 0
+	for _, entry := range entries {
+		name := filepath.ToSlash(entry.Path)
+		want := map[string]string{}
+		for _, rule := range rules {
+			if matches(strings.Split(rule.Pattern, "/"), strings.Split(name, "/")) {
+				want[rule.Key] = ""
+			}
+		}
+		if len(want) == 0 {
+			want = nil
+		}
+		if fmt.Sprint(entry.Tags) != fmt.Sprint(want) {
+			t.Errorf("%s: tags %v, want %v", name, entry.Tags, want)
+		}
+		tagged += len(entry.Tags)
+	}
+	Success(fmt.Sprintf("%d tags on %d entries, as matching each rule alone gives", tagged, len(entries)))
+	EndSection()
+
+	StartSection("Choosing Codecs From 300 Policy Rules")
+	var policyText strings.Builder
+	var patterns []string
+	for i := 0; i < 300; i++ {
+		pattern := fmt.Sprintf("none%d.bin", i)
+		switch {
+		case i == 150:
+			pattern = "dir1/**"
+		case i == 200:
+			pattern = "*.log"
+		case i == 250:
+			pattern = "**/*.dat"
+		case i%3 == 0:
+			pattern = fmt.Sprintf("*.x%d", i)
+		}
+		patterns = append(patterns, pattern)
+		fmt.Fprintf(&policyText, "%q: %s\n", pattern, []string{"store", "gzip", "lz4"}[i%3])
+	}
+	policy, err := core.ParsePolicy(strings.NewReader(policyText.String()))
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	archive = filepath.Join(testDir, "policy.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Policy: policy}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if entries, err = core.ListEntries(archive); err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	for _, entry := range entries {
+		name := filepath.ToSlash(entry.Path)
+		want := "lz4"
+		for i, pattern := range patterns {
+			target := strings.Split(name, "/")
+			if !strings.Contains(pattern, "/") {
+				target = target[len(target)-1:]
+			}
+			if matches(strings.Split(pattern, "/"), target) {
+				want = []string{"store", "gzip", "lz4"}[i%3]
+				break
+			}
+		}
+		if entry.Codec != want {
+			t.Errorf("%s: codec %s, want %s", name, entry.Codec, want)
+		}
+	}
+	Success("Each entry has the codec of the first rule it matches")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestProvenance(t *testing.T) {
+	requireCodec(t, "lz4")
+	startTime := time.Now()
+	ReportStart("Provenance")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-provenance-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), bytes.Repeat([]byte(name), 1000), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	StartSection("Recording Provenance")
+	want := &core.Provenance{
+		Command:  []string{"agcp", "compress", "my src", "out.agcp", "--record-provenance"},
+		Version:  "v1.2.3",
+		Hostname: "backup-host",
+		OS:       "linux/arm64",
+	}
+	archive := filepath.Join(testDir, "prov.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Provenance: want}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	a, err := core.OpenArchive(archive, core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	got := a.Provenance()
+	features := a.Features()
+	a.Close()
+	if got == nil || fmt.Sprintf("%q", *got) != fmt.Sprintf("%q", *want) {
+		t.Fatalf("provenance %+v, want %+v", got, want)
+	}
+	if len(features) != 1 || features[0].Name != "provenance" || !features[0].Required {
+		t.Fatalf("features %v, want provenance alone", features)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if data[4] != core.Version || data[5] != 9 {
+		t.Fatalf("header records version %d, minimum reader %d; want %d and 9", data[4], data[5], core.Version)
+	}
+	if report, err := core.Verify(archive, false, core.Options{}); err != nil || len(report.Failures) != 0 {
+		t.Fatalf("Verify failed: %v %v", err, report)
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), archive, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+	Success("Provenance read back; the archive needs a v9 reader and extracts normally")
+	EndSection()
+
+	StartSection("Leaving Provenance Out")
+	for _, tc := range []struct {
+		name string
+		opts core.Options
+	}{
+		{"plain.agcp", core.Options{}},
+		{"reproducible.agcp", core.Options{Provenance: want, Reproducible: true}},
+	} {
+		path := filepath.Join(testDir, tc.name)
+		if err := core.CompressWithOptions(srcDir, path, tc.opts); err != nil {
+			t.Fatalf("Compression of %s failed: %v", tc.name, err)
+		}
+		a, err := core.OpenArchive(path, core.Options{})
+		if err != nil {
+			t.Fatalf("OpenArchive %s failed: %v", tc.name, err)
+		}
+		prov := a.Provenance()
+		a.Close()
+		if prov != nil {
+			t.Fatalf("%s: provenance %+v, want none", tc.name, prov)
+		}
+		if data, _ := os.ReadFile(path); data[5] != core.MinReaderVersion {
+			t.Fatalf("%s: minimum reader %d, want %d", tc.name, data[5], core.MinReaderVersion)
+		}
+	}
+	Success("Archives without provenance keep the older minimum reader version")
+	EndSection()
+
+	StartSection("Detecting Corruption")
+	corrupt := filepath.Join(testDir, "corrupt.agcp")
+	data[len(data)-30] ^= 0xFF // Inside the provenance block, before the trailer
+	if err := os.WriteFile(corrupt, data, 0644); err != nil {
+		t.Fatalf("Failed to write corrupt archive: %v", err)
+	}
+	if _, err := core.OpenArchive(corrupt, core.Options{}); err == nil || !strings.Contains(err.Error(), "provenance") {
+		t.Fatalf("expected a provenance error for the corrupt archive, got %v", err)
+	}
+	Success("A damaged provenance block is reported")
+
+	golden, err := core.OpenArchive(filepath.Join(goldenDir, "v9-provenance-dir.agcp"), core.Options{})
+	if err != nil {
+		t.Fatalf("OpenArchive of the golden archive failed: %v", err)
+	}
+	defer golden.Close()
+	if prov := golden.Provenance(); prov == nil || strings.Join(prov.Command, " ") != "agcp compress tree v9-provenance-dir.agcp --record-provenance" {
+		t.Fatalf("golden provenance %+v, want the command that wrote it", prov)
+	}
+	Success("Provenance of the golden v9 archive read back")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+func TestExclude(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Exclude Patterns")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-exclude-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	files := []string{
+		"main.go",
+		"build.tmp",
+		"node_modules/left-pad/index.js",
+		"web/node_modules/react/index.js",
+		"web/app.js",
+		"logs/2024/app.log",
+		"logs/keep.txt",
+	}
+	for _, name := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success(fmt.Sprintf("Created %d files", len(files)))
+	EndSection()
+
+	StartSection("Compressing with Exclude Patterns")
+	archive := filepath.Join(testDir, "out.agcp")
+	opts := core.Options{Exclude: []string{"node_modules", "*.tmp", "logs/**/*.log"}}
+	if err := core.Compress(context.Background(), srcDir, archive, opts); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, filepath.ToSlash(e.Path))
+	}
+	sort.Strings(names)
+	want := []string{"logs/keep.txt", "main.go", "web/app.js"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("archive entries = %v, want %v", names, want)
+	}
+	Success("Excluded base names, directories and paths are left out")
+	EndSection()
+
+	StartSection("Rejecting Invalid Patterns")
+	opts = core.Options{Exclude: []string{"[a-"}}
+	if err := core.Compress(context.Background(), srcDir, filepath.Join(testDir, "bad.agcp"), opts); err == nil {
+		t.Fatal("Expected an invalid exclude pattern to fail")
+	} else {
+		Success(fmt.Sprintf("Invalid pattern refused: %v", err))
+	}
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestGrep tests searching archive entries: matches come in entry table order
+// however the workers finish, binary entries match once, lines past the
+// length cap are cut instead of read whole, and a pattern matching nothing
+// reports nothing
+func TestGrep(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Grep")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-grep-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for i := 0; i < 64; i++ {
+		// Sizes vary so the entries take the workers different times
+		content := strings.Repeat("filler line\n", (i%7)*2000) + fmt.Sprintf("needle %d\n", i)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("text%02d.txt", i)), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	binaryData := append([]byte("needle\x00"), []byte("\nneedle again\n")...)
+	if err := os.WriteFile(filepath.Join(srcDir, "zz-binary.bin"), binaryData, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	// One 3 MiB line: a needle at its start and another past the cap
+	long := bytes.Repeat([]byte("x"), 3<<20)
+	copy(long, "needle")
+	copy(long[2<<20:], "hidden")
+	if err := os.WriteFile(filepath.Join(srcDir, "zz-long.txt"), long, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	Success(fmt.Sprintf("Archive of %d entries created", len(entries)))
+	EndSection()
+
+	StartSection("Searching in Order")
+	var got []core.GrepMatch
+	if err := core.Grep(archive, regexp.MustCompile("needle"), nil, func(matches []core.GrepMatch) {
+		got = append(got, matches...)
+	}); err != nil {
+		t.Fatalf("Grep failed: %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("Grep found %d matches, want one per entry (%d)", len(got), len(entries))
+	}
+	for i, m := range got {
+		if m.Path != entries[i].Path {
+			t.Fatalf("match %d is in %s, want %s: matches are not in entry table order", i, m.Path, entries[i].Path)
+		}
+		switch m.Path {
+		case "zz-binary.bin":
+			if !m.Binary {
+				t.Errorf("binary entry reported as %+v", m)
+			}
+		case "zz-long.txt":
+			if m.Binary || m.Line != 1 || len(m.Text) != 1<<20 {
+				t.Errorf("long line reported at line %d with %d bytes, want line 1 cut at 1 MiB", m.Line, len(m.Text))
+			}
+		default:
+			if m.Binary || !strings.HasPrefix(m.Text, "needle ") {
+				t.Errorf("unexpected match %+v", m)
+			}
+		}
+	}
+	Success(fmt.Sprintf("%d matches in entry table order", len(got)))
+	EndSection()
+
+	StartSection("Searching Past the Line Cap")
+	calls := 0
+	if err := core.Grep(archive, regexp.MustCompile("hidden|absent"), nil, func([]core.GrepMatch) { calls++ }); err != nil {
+		t.Fatalf("Grep failed: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("Grep reported %d entries matching past the line cap or not at all", calls)
+	}
+	Success("Text past the cap and missing text match nothing")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
@@ -1,9 +1,11 @@
-module tests
-
-go 1.24.1
-
-require agcp v0.0.0
-
-require github.com/pierrec/lz4/v4 v4.1.22 // indirect
-
-replace agcp => ../
+module tests
+
+go 1.24.1
+
+require agcp v0.0.0
+
+require github.com/pierrec/lz4/v4 v4.1.22
+
+require github.com/klauspost/compress v1.17.11 // indirect
+
+replace agcp => ../
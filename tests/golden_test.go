@@ -0,0 +1,177 @@
+// tests/golden_test.go
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// goldenDir holds archives written by earlier releases of agcp and the tree
+// they were made from; see testdata/golden/README.md
+const goldenDir = "testdata/golden"
+
+// goldenName matches golden archive names: the format version, the kind of
+// input ("dir" for the tree, "file" for tree/hello.txt) and an optional variant
+var goldenName = regexp.MustCompile(`^v(\d+)-(?:([a-z0-9]+)-)?(dir|file)\.agcp$`)
+
+// requireCodec skips a test that needs a codec this build was made without,
+// such as lz4 under the nolz4 tag
+func requireCodec(t *testing.T, name string) {
+	t.Helper()
+	if _, err := core.ParseCodec(name); err != nil {
+		t.Skipf("codec %s is not available in this build: %v", name, err)
+	}
+}
+
+// TestGoldenArchives tests that every golden archive can still be listed,
+// verified and extracted byte-exactly, and that every format version up to the
+// current one has golden archives
+func TestGoldenArchives(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Golden Archive Compatibility")
+
+	StartSection("Loading Golden Corpus")
+	tree := filepath.Join(goldenDir, "tree")
+	treeFiles := 0
+	err := filepath.Walk(tree, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			treeFiles++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk golden tree: %v", err)
+	}
+	archives, err := filepath.Glob(filepath.Join(goldenDir, "*.agcp"))
+	if err != nil || len(archives) == 0 {
+		t.Fatalf("No golden archives found in %s: %v", goldenDir, err)
+	}
+	Success(fmt.Sprintf("%d golden archives of a %d-file tree", len(archives), treeFiles))
+	EndSection()
+
+	testDir, err := os.MkdirTemp("", "agcp-golden-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	versions := make(map[int]bool)
+	for _, archive := range archives {
+		name := filepath.Base(archive)
+		m := goldenName.FindStringSubmatch(name)
+		if m == nil {
+			t.Fatalf("golden archive %s is not named v<version>-[variant-](dir|file).agcp", name)
+		}
+		version, _ := strconv.Atoi(m[1])
+		isDir := m[3] == "dir"
+
+		t.Run(name, func(t *testing.T) {
+			StartSection("Reading " + name)
+			raw, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", name, err)
+			}
+			if len(raw) < 5 || string(raw[:4]) != core.Magic || int(raw[4]) != version {
+				t.Fatalf("%s is not a version %d archive", name, version)
+			}
+			versions[version] = true
+
+			entries, err := core.ListEntries(archive)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			want := 1
+			if isDir {
+				want = treeFiles
+			}
+			if len(entries) != want {
+				t.Fatalf("expected %d entries, got %d", want, len(entries))
+			}
+			for _, entry := range entries {
+				if entry.Codec != "store" {
+					requireCodec(t, entry.Codec)
+				}
+			}
+			for _, entry := range entries {
+				if entry.SHA256 == nil {
+					continue // Not recorded before v4
+				}
+				path := filepath.Join(tree, entry.Path)
+				if !isDir {
+					path = filepath.Join(tree, "hello.txt")
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("Failed to read %s: %v", path, err)
+				}
+				if sum := sha256.Sum256(data); !bytes.Equal(entry.SHA256, sum[:]) {
+					t.Fatalf("stored hash of %s does not match the golden tree", entry.Path)
+				}
+			}
+			Success(fmt.Sprintf("%d entries listed", len(entries)))
+
+			report, err := core.Verify(archive, false, core.Options{})
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if len(report.Failures) > 0 {
+				t.Fatalf("Verify reported failures: %v", report.Failures)
+			}
+			Success("Verified")
+
+			out := filepath.Join(testDir, name)
+			if err := core.Decompress(context.Background(), archive, out, core.Options{}); err != nil {
+				t.Fatalf("Decompression failed: %v", err)
+			}
+			if isDir {
+				err = compareTrees(tree, out)
+			} else {
+				err = compareFiles(filepath.Join(tree, "hello.txt"), out)
+			}
+			if err != nil {
+				t.Fatalf("Extracted content differs from the golden tree: %v", err)
+			}
+			Success("Extracted byte-exactly")
+			EndSection()
+		})
+	}
+
+	StartSection("Checking Version Coverage")
+	for version := 1; version <= core.Version; version++ {
+		if !versions[version] {
+			t.Errorf("no golden archive for format version %d; add one to %s", version, goldenDir)
+		}
+	}
+	Success(fmt.Sprintf("Format versions 1 to %d covered", core.Version))
+	EndSection()
+
+	ReportEnd(!t.Failed(), time.Since(startTime))
+}
+
+// compareFiles checks that two files have the same content
+func compareFiles(want, got string) error {
+	wantData, err := os.ReadFile(want)
+	if err != nil {
+		return err
+	}
+	gotData, err := os.ReadFile(got)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(wantData, gotData) {
+		return fmt.Errorf("content mismatch for %s", filepath.Base(want))
+	}
+	return nil
+}
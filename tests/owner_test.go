@@ -0,0 +1,210 @@
+// tests/owner_test.go
+
+//go:build !windows
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// TestOwnerMap tests that archived ownership is restored through an owner mapping
+func TestOwnerMap(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("restoring arbitrary ownership requires root")
+	}
+
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Owner Mapping")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-owner-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	owned := filepath.Join(srcDir, "owned.txt")
+	if err := os.WriteFile(owned, []byte("owned by 1234"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Chown(owned, 1234, 1234); err != nil {
+		t.Fatalf("Failed to chown test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Archived a file owned by 1234:1234")
+	EndSection()
+
+	// ─── EXTRACT ────────────────────────────────────────────────────
+	StartSection("Extracting With Mapping")
+	mapping, err := core.ParseOwnerMap("uid:1234=4321,gid:1234=4322")
+	if err != nil {
+		t.Fatalf("ParseOwnerMap failed: %v", err)
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.DecompressWithOptions(archive, outDir, core.Options{OwnerMap: mapping}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(outDir, "owned.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat extracted file: %v", err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != 4321 || st.Gid != 4322 {
+		Error(fmt.Sprintf("Extracted file owned by %d:%d", st.Uid, st.Gid))
+		t.Fatalf("Expected owner 4321:4322, got %d:%d", st.Uid, st.Gid)
+	}
+	Success("Ownership restored as 4321:4322")
+
+	Action("Rejecting malformed mappings")
+	for _, bad := range []string{"uid0=1", "user:0=1", "uid:0=x"} {
+		if _, err := core.ParseOwnerMap(bad); err == nil {
+			t.Fatalf("Expected ParseOwnerMap(%q) to fail", bad)
+		}
+	}
+	Success("Malformed mappings rejected")
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestOneFileSystem tests that --one-file-system skips directories on other mounts
+func TestOneFileSystem(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("One File System")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-onefs-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	mount := filepath.Join(srcDir, "mnt")
+	if err := os.MkdirAll(mount, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "local.txt"), []byte("same file system"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if out, err := exec.Command("mount", "-t", "tmpfs", "tmpfs", mount).CombinedOutput(); err != nil {
+		t.Skipf("cannot mount a tmpfs to test with: %v: %s", err, out)
+	}
+	defer exec.Command("umount", mount).Run()
+	if err := os.WriteFile(filepath.Join(mount, "remote.txt"), []byte("other file system"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	Success("Test tree with a tmpfs mount created")
+	EndSection()
+
+	StartSection("Walking Without Crossing Mounts")
+	archive := filepath.Join(testDir, "src.agcp")
+	log := &core.WarningLog{}
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{OneFileSystem: true, Warn: log.Add}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "local.txt" {
+		t.Fatalf("expected only local.txt to be archived, got %v", entries)
+	}
+	warnings := log.Warnings()
+	if len(warnings) != 1 || warnings[0].Code != core.WarnSkippedMountPoint || warnings[0].Path != mount {
+		t.Fatalf("expected a skipped-mount-point warning for %s, got %v", mount, warnings)
+	}
+	Success("Mount point skipped with a warning")
+
+	archive = filepath.Join(testDir, "all.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if entries, err = core.ListEntries(archive); err != nil || len(entries) != 2 {
+		t.Fatalf("expected both files without --one-file-system, got %v (%v)", entries, err)
+	}
+	Success("Mounts are crossed by default")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestRefuseSystemPaths tests that extraction with RefuseSystemPaths refuses
+// system directories, including through a symlink, before writing anything
+func TestRefuseSystemPaths(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Refusing System Paths")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-syspath-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	input := filepath.Join(testDir, "config.txt")
+	if err := os.WriteFile(input, []byte("restored"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "config.agcp")
+	if err := core.Compress(context.Background(), input, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(testDir, "etc-link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	Success("Archive and symlink created successfully")
+	EndSection()
+
+	StartSection("Extracting")
+	opts := core.Options{RefuseSystemPaths: true}
+	for _, dest := range []string{
+		"/etc/agcp-refuse-test.txt",
+		"/usr/local/agcp-refuse-test/config.txt",
+		filepath.Join(testDir, "etc-link", "agcp-refuse-test.txt"),
+	} {
+		err := core.DecompressWithOptions(archive, dest, opts)
+		if !errors.Is(err, core.ErrSystemPath) {
+			os.RemoveAll(dest)
+			t.Fatalf("expected ErrSystemPath extracting to %s, got %v", dest, err)
+		}
+		if _, statErr := os.Lstat(dest); !os.IsNotExist(statErr) {
+			t.Fatalf("%s was written despite the refusal", dest)
+		}
+		Success(fmt.Sprintf("Refused: %v", err))
+	}
+
+	dest := filepath.Join(testDir, "out", "config.txt")
+	if err := core.DecompressWithOptions(archive, dest, opts); err != nil {
+		t.Fatalf("Extraction into a regular directory failed: %v", err)
+	}
+	Success("Extraction into a regular directory is allowed")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
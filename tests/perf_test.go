@@ -0,0 +1,376 @@
+// tests/perf_test.go
+
+//go:build perf
+
+package tests
+
+import (
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// perfBaselineFile records the relative throughput and archive sizes the
+// performance suite compares against; see TestPerformanceBaselines
+const perfBaselineFile = "testdata/perf/baseline.json"
+
+// Corpus shape: each corpus is perfFiles files of perfFileSize bytes, so the
+// parallel compression path is exercised when measuring the archive size
+const (
+	perfFiles    = 8
+	perfFileSize = 4 << 20
+	perfRuns     = 3 // Timed runs per measurement; the fastest counts
+)
+
+// Default tolerances, overridable with AGCP_PERF_TOLERANCE and
+// AGCP_PERF_SIZE_TOLERANCE
+const (
+	defaultThroughputTolerance = 0.25 // Relative throughput may drop by up to 25%
+	defaultSizeTolerance       = 0.01 // Archive sizes may grow or shrink by up to 1%
+)
+
+// perfResult is what one corpus measured. Throughput is recorded relative to
+// the flate reference measured in the same run (see flateCompress), so
+// baselines carry over between machines and a busy machine slows both alike.
+type perfResult struct {
+	CompressSpeedup   float64 `json:"compress_vs_flate"`   // agcp compression speed over the reference's
+	DecompressSpeedup float64 `json:"decompress_vs_flate"` // agcp extraction speed over the reference's
+	ArchiveBytes      int64   `json:"archive_bytes"`
+
+	compressMiBps, decompressMiBps float64 // Absolute, for the report only
+}
+
+// perfBaseline is the content of perfBaselineFile
+type perfBaseline struct {
+	Machine string                `json:"machine"` // Where the baselines were recorded, for reference
+	Corpora map[string]perfResult `json:"corpora"`
+}
+
+// perfCorpora generate the content of each corpus file from a fixed seed, so
+// every run compresses the same bytes
+var perfCorpora = []struct {
+	name     string
+	generate func(rng *rand.Rand, size int) []byte
+}{
+	{"text", perfText},
+	{"binary", perfBinary},
+	{"random", perfRandom},
+}
+
+// TestPerformanceBaselines compresses and extracts fixed corpora of text,
+// binary-like and random data and checks that throughput relative to a
+// reference measured in the same run, and archive sizes, stay within tolerance
+// of the recorded baselines. It only builds with the perf tag. Set
+// AGCP_PERF_UPDATE=1 to record new baselines instead.
+func TestPerformanceBaselines(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Performance Baselines")
+
+	throughputTolerance := perfTolerance(t, "AGCP_PERF_TOLERANCE", defaultThroughputTolerance)
+	sizeTolerance := perfTolerance(t, "AGCP_PERF_SIZE_TOLERANCE", defaultSizeTolerance)
+	update := os.Getenv("AGCP_PERF_UPDATE") != ""
+
+	var baseline perfBaseline
+	if !update {
+		data, err := os.ReadFile(perfBaselineFile)
+		if err != nil {
+			t.Fatalf("Failed to read baselines (record them with AGCP_PERF_UPDATE=1): %v", err)
+		}
+		if err := json.Unmarshal(data, &baseline); err != nil {
+			t.Fatalf("Failed to parse %s: %v", perfBaselineFile, err)
+		}
+		Info(fmt.Sprintf("Baselines recorded on %s", baseline.Machine))
+	}
+
+	testDir, err := os.MkdirTemp("", "agcp-perf-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	results := make(map[string]perfResult)
+	var failures []string
+	for i, corpus := range perfCorpora {
+		StartSection("Corpus: " + corpus.name)
+		srcDir := filepath.Join(testDir, corpus.name)
+		if err := os.MkdirAll(srcDir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		rng := rand.New(rand.NewSource(int64(i + 1)))
+		for n := 0; n < perfFiles; n++ {
+			path := filepath.Join(srcDir, fmt.Sprintf("%s-%d", corpus.name, n))
+			if err := os.WriteFile(path, corpus.generate(rng, perfFileSize), 0644); err != nil {
+				t.Fatalf("Failed to write corpus file: %v", err)
+			}
+		}
+
+		got, err := measureCorpus(srcDir, filepath.Join(testDir, corpus.name+".agcp"), filepath.Join(testDir, corpus.name+"-out"))
+		if err != nil {
+			t.Fatalf("Measuring %s failed: %v", corpus.name, err)
+		}
+		results[corpus.name] = got
+		Info(fmt.Sprintf("Compress %.1f MiB/s (%.2fx flate), decompress %.1f MiB/s (%.2fx flate), archive %s",
+			got.compressMiBps, got.CompressSpeedup, got.decompressMiBps, got.DecompressSpeedup, HumanReadableSize(got.ArchiveBytes)))
+
+		if !update {
+			want, ok := baseline.Corpora[corpus.name]
+			if !ok {
+				t.Fatalf("No baseline for corpus %s; record one with AGCP_PERF_UPDATE=1", corpus.name)
+			}
+			failures = append(failures, comparePerf(corpus.name, got, want, throughputTolerance, sizeTolerance)...)
+		}
+		EndSection()
+	}
+
+	if update {
+		StartSection("Recording Baselines")
+		baseline = perfBaseline{
+			Machine: fmt.Sprintf("%s/%s, %d CPUs, %s", runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.Version()),
+			Corpora: results,
+		}
+		data, err := json.MarshalIndent(baseline, "", "  ")
+		if err != nil {
+			t.Fatalf("Failed to encode baselines: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(perfBaselineFile), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(perfBaselineFile, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("Failed to write baselines: %v", err)
+		}
+		Success("Baselines written to " + perfBaselineFile)
+		EndSection()
+	}
+
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			Error(failure)
+		}
+		ReportEnd(false, time.Since(startTime))
+		t.Fatalf("%d measurements regressed:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	ReportEnd(true, time.Since(startTime))
+}
+
+// measureCorpus compresses srcDir into archive and extracts it into outDir,
+// perfRuns times each on one worker, alternating with the flate reference so
+// both see the same machine load. It returns the fastest throughput of each
+// relative to the fastest reference, and the size of the archive written on
+// the default number of workers.
+func measureCorpus(srcDir, archive, outDir string) (perfResult, error) {
+	size := float64(perfFiles*perfFileSize) / (1 << 20)
+	reference := archive + ".deflate"
+	var compress, decompress, refCompress, refDecompress []time.Duration
+	timed := func(times *[]time.Duration, op func() error) error {
+		start := time.Now()
+		if err := op(); err != nil {
+			return err
+		}
+		*times = append(*times, time.Since(start))
+		return nil
+	}
+	for run := 0; run < perfRuns; run++ {
+		os.Remove(archive)
+		if err := timed(&compress, func() error {
+			return core.Compress(context.Background(), srcDir, archive, core.Options{Workers: 1, Reproducible: true})
+		}); err != nil {
+			return perfResult{}, fmt.Errorf("compress: %w", err)
+		}
+		if err := timed(&refCompress, func() error { return flateCompress(srcDir, reference) }); err != nil {
+			return perfResult{}, fmt.Errorf("reference compress: %w", err)
+		}
+
+		os.RemoveAll(outDir)
+		if err := timed(&decompress, func() error {
+			return core.Decompress(context.Background(), archive, outDir, core.Options{Workers: 1})
+		}); err != nil {
+			return perfResult{}, fmt.Errorf("decompress: %w", err)
+		}
+		if err := timed(&refDecompress, func() error { return flateDecompress(reference, reference+".out") }); err != nil {
+			return perfResult{}, fmt.Errorf("reference decompress: %w", err)
+		}
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		return perfResult{}, fmt.Errorf("restored tree differs: %w", err)
+	}
+
+	os.Remove(archive)
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{Reproducible: true}); err != nil {
+		return perfResult{}, fmt.Errorf("compress: %w", err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return perfResult{}, err
+	}
+
+	fastest := func(times []time.Duration) float64 {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		return times[0].Seconds()
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	return perfResult{
+		CompressSpeedup:   round(fastest(refCompress) / fastest(compress)),
+		DecompressSpeedup: round(fastest(refDecompress) / fastest(decompress)),
+		ArchiveBytes:      info.Size(),
+		compressMiBps:     size / fastest(compress),
+		decompressMiBps:   size / fastest(decompress),
+	}, nil
+}
+
+// flateCompress is the reference for compression throughput: it deflates the
+// files of srcDir at the fastest level into one stream in out, on one goroutine
+func flateCompress(srcDir, out string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw, err := flate.NewWriter(f, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		in, err := os.Open(filepath.Join(srcDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(zw, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// flateDecompress is the reference for extraction throughput: it inflates the
+// stream flateCompress wrote in in to out
+func flateDecompress(in, out string) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, flate.NewReader(src)); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// comparePerf describes each measurement of got outside tolerance of want
+func comparePerf(corpus string, got, want perfResult, throughputTolerance, sizeTolerance float64) []string {
+	var failures []string
+	for _, m := range []struct {
+		what      string
+		got, want float64
+	}{
+		{"compression", got.CompressSpeedup, want.CompressSpeedup},
+		{"decompression", got.DecompressSpeedup, want.DecompressSpeedup},
+	} {
+		if m.got < m.want*(1-throughputTolerance) {
+			failures = append(failures, fmt.Sprintf("%s: %s at %.2fx the reference is more than %.0f%% below the baseline %.2fx",
+				corpus, m.what, m.got, throughputTolerance*100, m.want))
+		}
+	}
+	if drift := math.Abs(float64(got.ArchiveBytes-want.ArchiveBytes)) / float64(want.ArchiveBytes); drift > sizeTolerance {
+		failures = append(failures, fmt.Sprintf("%s: archive is %d bytes, %.1f%% off the baseline %d bytes",
+			corpus, got.ArchiveBytes, drift*100, want.ArchiveBytes))
+	}
+	return failures
+}
+
+// perfTolerance reads a tolerance fraction from an environment variable
+func perfTolerance(t *testing.T, name string, def float64) float64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		t.Fatalf("Invalid %s %q: want a fraction such as 0.2", name, s)
+	}
+	return v
+}
+
+// perfWords is the vocabulary of the text corpus, drawn from with a skewed
+// distribution like natural language
+var perfWords = strings.Fields(`the of and to in is that for it as was with be by on not he this are or
+	his from at which but have an they you were her she there been one all we their has would when if
+	so no will more can out up about into them some could time only other its then may these two any
+	archive compress entry header codec stream buffer offset worker table trailer format version`)
+
+// perfText returns log-like lines of words and numbers
+func perfText(rng *rand.Rand, size int) []byte {
+	buf := make([]byte, 0, size+128)
+	for len(buf) < size {
+		buf = strconv.AppendInt(buf, 1700000000+int64(len(buf)/64), 10)
+		for n := 5 + rng.Intn(12); n > 0; n-- {
+			buf = append(buf, ' ')
+			buf = append(buf, perfWords[int(float64(len(perfWords))*rng.Float64()*rng.Float64())]...)
+		}
+		buf = append(buf, '\n')
+	}
+	return buf[:size]
+}
+
+// perfBinary returns data shaped like an executable: runs of instruction-like
+// records, little-endian address tables, zero padding and some noise
+func perfBinary(rng *rand.Rand, size int) []byte {
+	buf := make([]byte, 0, size+4096)
+	for len(buf) < size {
+		switch rng.Intn(4) {
+		case 0: // Code: a few opcodes with varying operands
+			for n := 256 + rng.Intn(1024); n > 0; n-- {
+				buf = append(buf, []byte{0x48, 0x89, 0xe5, 0x8b, 0x45, 0xe8}[rng.Intn(6)], byte(rng.Intn(16)))
+			}
+		case 1: // Address table
+			base := uint64(0x400000 + rng.Intn(1<<20))
+			for n := 64 + rng.Intn(256); n > 0; n-- {
+				buf = binary.LittleEndian.AppendUint64(buf, base)
+				base += uint64(8 + rng.Intn(64))
+			}
+		case 2: // Section padding
+			buf = append(buf, make([]byte, rng.Intn(4096))...)
+		case 3: // Compressed resources
+			noise := make([]byte, 128+rng.Intn(1024))
+			rng.Read(noise)
+			buf = append(buf, noise...)
+		}
+	}
+	return buf[:size]
+}
+
+// perfRandom returns incompressible data
+func perfRandom(rng *rand.Rand, size int) []byte {
+	buf := make([]byte, size)
+	rng.Read(buf)
+	return buf
+}
@@ -0,0 +1,586 @@
+// tests/progress_test.go
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+	"agcp/pkg/progress"
+)
+
+// TestProgressEvents tests that operations deliver typed progress events with phases
+func TestProgressEvents(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Progress Event Stream")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-events-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	const numFiles = 4
+	for i := 0; i < numFiles; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), []byte("event test data"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	Success("Test files created successfully")
+	EndSection()
+
+	// ─── COMPRESS ───────────────────────────────────────────────────
+	StartSection("Collecting Compression Events")
+	events := make(chan progress.Event, 256)
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.CompressWithOptions(srcDir, archive, core.Options{Events: events}); err != nil {
+		Error(fmt.Sprintf("Compression failed: %v", err))
+		t.Fatalf("Compression failed: %v", err)
+	}
+	close(events)
+
+	var phases []progress.Phase
+	var last progress.Event
+	for ev := range events {
+		if len(phases) == 0 || phases[len(phases)-1] != ev.Phase {
+			phases = append(phases, ev.Phase)
+		}
+		last = ev
+	}
+	Info(fmt.Sprintf("Observed phases: %v", phases))
+
+	want := []progress.Phase{progress.PhaseScanning, progress.PhaseCompressing, progress.PhaseDone}
+	if fmt.Sprint(phases) != fmt.Sprint(want) {
+		Error(fmt.Sprintf("Unexpected phase sequence %v", phases))
+		t.Fatalf("Expected phases %v, got %v", want, phases)
+	}
+	if last.FilesDone != numFiles || last.FilesTotal != numFiles {
+		Error(fmt.Sprintf("Final event reports %d/%d files", last.FilesDone, last.FilesTotal))
+		t.Fatalf("Expected %d/%d files in final event, got %d/%d", numFiles, numFiles, last.FilesDone, last.FilesTotal)
+	}
+	if want := uint64(numFiles * len("event test data")); last.BytesTotal != want {
+		t.Fatalf("Expected BytesTotal %d from the sizes found while scanning, got %d", want, last.BytesTotal)
+	}
+	if last.BytesDone != last.BytesTotal {
+		t.Fatalf("Expected final BytesDone %d to equal BytesTotal %d", last.BytesDone, last.BytesTotal)
+	}
+	Success("Compression emitted scanning, compressing and done phases with final totals")
+	EndSection()
+
+	// ─── DECOMPRESS ─────────────────────────────────────────────────
+	StartSection("Collecting Extraction Events")
+	entries, err := core.ListEntries(archive)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var compressed uint64
+	for _, entry := range entries {
+		compressed += entry.CompressedSize
+	}
+	events = make(chan progress.Event, 256)
+	if err := core.DecompressWithOptions(archive, filepath.Join(testDir, "out"), core.Options{Events: events}); err != nil {
+		Error(fmt.Sprintf("Decompression failed: %v", err))
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	close(events)
+	for ev := range events {
+		last = ev
+	}
+	if last.Phase != progress.PhaseDone || last.BytesDone != last.BytesTotal {
+		t.Fatalf("Expected a final done event with BytesDone equal to BytesTotal, got %+v", last)
+	}
+	if last.ReadTotal != compressed || last.ReadDone != compressed {
+		t.Fatalf("Expected %d compressed bytes read of %d, got %d of %d", compressed, compressed, last.ReadDone, last.ReadTotal)
+	}
+	Success(fmt.Sprintf("Extraction read %d compressed bytes and wrote %d", last.ReadDone, last.BytesDone))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestTrackerManualClock tests that a tracker driven by a manual clock emits
+// events with exact rates, ETAs and elapsed times on each tick
+func TestTrackerManualClock(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Progress With a Manual Clock")
+
+	StartSection("Driving the Tracker")
+	clock := progress.NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	events := make(chan progress.Event, 16)
+	tracker := progress.NewTracker(0)
+	tracker.SetClock(clock)
+	tracker.SetEvents(events)
+	tracker.SetTotals(4000, 4)
+	tracker.SetPhase(progress.PhaseCompressing)
+	if ev := <-events; ev.Phase != progress.PhaseCompressing || ev.Elapsed != 0 {
+		t.Fatalf("unexpected phase event: %+v", ev)
+	}
+	tracker.Start()
+
+	tracker.AddBytes(500)
+	tracker.FinishEntry("a.txt")
+	clock.Advance(250 * time.Millisecond)
+	ev := <-events
+	if ev.BytesDone != 500 || ev.FilesDone != 1 || ev.Rate != 2000 || ev.ETA != 1750*time.Millisecond || ev.Elapsed != 250*time.Millisecond {
+		t.Fatalf("unexpected first tick event: %+v", ev)
+	}
+	Success("First tick reports 2000 B/s with a 1.75s ETA")
+
+	// Two ticks in one advance, with no progress in the second
+	tracker.AddBytes(1000)
+	clock.Advance(500 * time.Millisecond)
+	first, second := <-events, <-events
+	if first.Rate != 4000 || first.ETA != 625*time.Millisecond || first.Elapsed != 500*time.Millisecond {
+		t.Fatalf("unexpected second tick event: %+v", first)
+	}
+	if second.Rate != 0 || second.ETA != 0 || second.Elapsed != 750*time.Millisecond {
+		t.Fatalf("unexpected third tick event: %+v", second)
+	}
+	Success("Each due tick is delivered in order with exact timings")
+
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event before the next tick: %+v", ev)
+	default:
+	}
+	Success("No event before the next tick is due")
+
+	tracker.Stop()
+	if ev := <-events; ev.Phase != progress.PhaseDone || ev.Elapsed != 850*time.Millisecond {
+		t.Fatalf("unexpected final event: %+v", ev)
+	}
+	Success("Final event reports the clock's elapsed time")
+	EndSection()
+
+	StartSection("Tracking Compressed Reads")
+	events = make(chan progress.Event, 16)
+	tracker = progress.NewTracker(0)
+	tracker.SetClock(clock)
+	tracker.SetEvents(events)
+	tracker.SetTotals(4000, 4)
+	tracker.SetReadTotal(2000)
+	tracker.Start()
+
+	// Writing would finish in 0.75s, but reading the rest takes 4.75s
+	tracker.AddBytes(1000)
+	tracker.AddRead(100)
+	clock.Advance(250 * time.Millisecond)
+	ev = <-events
+	if ev.ReadDone != 100 || ev.ReadTotal != 2000 || ev.ReadRate != 400 || ev.Rate != 4000 || ev.ETA != 4750*time.Millisecond {
+		t.Fatalf("unexpected read-bound tick event: %+v", ev)
+	}
+	Success("ETA follows the slower compressed read stream")
+
+	// Reading caught up, so writing bounds the ETA again
+	tracker.AddBytes(1000)
+	tracker.AddRead(1700)
+	clock.Advance(250 * time.Millisecond)
+	ev = <-events
+	if ev.ReadDone != 1800 || ev.ReadRate != 6800 || ev.ETA != 500*time.Millisecond {
+		t.Fatalf("unexpected write-bound tick event: %+v", ev)
+	}
+	Success("ETA follows writing once reads are ahead")
+	tracker.Stop()
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestProgressPublisher tests that progress published to a Unix socket follows
+// the protocol and ends with one done message
+func TestProgressPublisher(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Publishing Progress to a Supervisor")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-publish-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	const numFiles = 3
+	for i := 0; i < numFiles; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), []byte("publish test data"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	socket := filepath.Join(testDir, "progress.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []progress.Message, 1)
+	go func() {
+		var msgs []progress.Message
+		defer func() { received <- msgs }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		for {
+			var msg progress.Message
+			if err := dec.Decode(&msg); err != nil {
+				return
+			}
+			msgs = append(msgs, msg)
+		}
+	}()
+	Success("Supervisor listening")
+	EndSection()
+
+	StartSection("Publishing a Compression")
+	forwarded := make(chan progress.Event, 256)
+	p, err := progress.DialPublisher(socket, "nightly", "compress", forwarded)
+	if err != nil {
+		t.Fatalf("DialPublisher failed: %v", err)
+	}
+	compressErr := core.CompressWithOptions(srcDir, filepath.Join(testDir, "src.agcp"), core.Options{Events: p.Events()})
+	if compressErr != nil {
+		t.Fatalf("Compression failed: %v", compressErr)
+	}
+	if err := p.Close(compressErr); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	msgs := <-received
+	if len(msgs) < 2 {
+		t.Fatalf("expected several messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Version != progress.ProtocolVersion || msg.Job != "nightly" || msg.PID != os.Getpid() || msg.Operation != "compress" {
+			t.Fatalf("message %d does not identify the job: %+v", i, msg)
+		}
+		if (msg.Phase == progress.PhaseDone) != (i == len(msgs)-1) {
+			t.Fatalf("expected only the last message to be done, message %d is %s", i, msg.Phase)
+		}
+	}
+	last := msgs[len(msgs)-1]
+	if last.FilesDone != numFiles || last.BytesDone != last.BytesTotal || last.Error != "" {
+		t.Fatalf("unexpected done message: %+v", last)
+	}
+	if len(forwarded) == 0 {
+		t.Fatal("expected events to be forwarded to the next channel")
+	}
+	Success(fmt.Sprintf("%d messages published, ending with done", len(msgs)))
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestNameEscaping tests that entry names are escaped for terminals unless raw
+// names are asked for
+func TestNameEscaping(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Escaping Entry Names")
+
+	StartSection("Escaping")
+	cases := map[string]string{
+		"plain/name.txt":   "plain/name.txt",
+		"with space":       "with space",
+		"héllo/日本.txt":     "héllo/日本.txt",
+		`quote"d`:          `quote"d`,
+		"line\nbreak":      `line\nbreak`,
+		"\x1b[31mred":      `\x1b[31mred`,
+		`back\slash`:       `back\\slash`,
+		"bad\xffutf8":      `bad\xffutf8`,
+		"tab\there\"quote": `tab\there"quote`,
+	}
+	f := progress.DefaultFormat
+	for name, want := range cases {
+		if got := f.Name(name); got != want {
+			t.Errorf("Name(%q) = %q, want %q", name, got, want)
+		}
+	}
+	Success("Control characters, backslashes and invalid UTF-8 are escaped")
+
+	f.RawNames = true
+	if got := f.Name("line\nbreak"); got != "line\nbreak" {
+		t.Errorf("expected the raw name with RawNames, got %q", got)
+	}
+	Success("RawNames prints names as stored")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// TestFormat tests how sizes, rates, numbers and durations are rendered in each
+// unit system, at the unit boundaries and with a decimal comma
+func TestFormat(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Formatting Sizes, Rates and Durations")
+
+	iec := progress.Format{Units: progress.UnitsIEC}
+	si := progress.Format{Units: progress.UnitsSI}
+	raw := progress.Format{Units: progress.UnitsBytes}
+	comma := progress.Format{Units: progress.UnitsIEC, DecimalSeparator: ","}
+
+	StartSection("Sizes and Rates")
+	for _, tc := range []struct {
+		f     progress.Format
+		bytes uint64
+		want  string
+	}{
+		{iec, 0, "0 B"},
+		{iec, 999, "999 B"},
+		{iec, 1000, "1000 B"},
+		{iec, 1023, "1023 B"},
+		{iec, 1024, "1.0 KiB"},
+		{iec, 1536, "1.5 KiB"},
+		{iec, 1<<20 - 1, "1.0 MiB"},
+		{iec, 1 << 20, "1.0 MiB"},
+		{iec, 5 << 30, "5.0 GiB"},
+		{iec, 1 << 60, "1.0 EiB"},
+		{iec, math.MaxUint64, "16.0 EiB"},
+		{si, 999, "999 B"},
+		{si, 1000, "1.0 kB"},
+		{si, 1023, "1.0 kB"},
+		{si, 1024, "1.0 kB"},
+		{si, 999_999, "1.0 MB"},
+		{si, 1_500_000, "1.5 MB"},
+		{si, 2_000_000_000, "2.0 GB"},
+		{raw, 0, "0"},
+		{raw, 1023, "1023"},
+		{raw, 1024, "1024"},
+		{raw, 5 << 30, "5368709120"},
+		{comma, 1536, "1,5 KiB"},
+		{comma, 1023, "1023 B"},
+	} {
+		if got := tc.f.Size(tc.bytes); got != tc.want {
+			t.Errorf("units %d: Size(%d) = %q, want %q", tc.f.Units, tc.bytes, got, tc.want)
+		}
+		if got := tc.f.Rate(tc.bytes); got != tc.want+"/s" {
+			t.Errorf("units %d: Rate(%d) = %q, want %q", tc.f.Units, tc.bytes, got, tc.want+"/s")
+		}
+	}
+	Success("Sizes and rates switch units at the right boundaries")
+	EndSection()
+
+	StartSection("Numbers")
+	for _, tc := range []struct {
+		f         progress.Format
+		v         float64
+		precision int
+		want      string
+	}{
+		{progress.Format{}, 3.14159, 2, "3.14"},
+		{iec, 3.14159, 0, "3"},
+		{comma, 3.14159, 2, "3,14"},
+		{comma, 1234.5, 1, "1234,5"},
+		{progress.Format{DecimalSeparator: "·"}, 0.5, 1, "0·5"},
+		{comma, -2.25, 1, "-2,2"},
+	} {
+		if got := tc.f.Number(tc.v, tc.precision); got != tc.want {
+			t.Errorf("separator %q: Number(%v, %d) = %q, want %q", tc.f.DecimalSeparator, tc.v, tc.precision, got, tc.want)
+		}
+	}
+	Success("Numbers use the configured decimal separator")
+	EndSection()
+
+	StartSection("Durations")
+	for _, tc := range []struct {
+		f       progress.Format
+		seconds float64
+		want    string
+	}{
+		{iec, 0, "0 seconds"},
+		{iec, -0.4, "0 seconds"},
+		{iec, -90, "0 seconds"},
+		{iec, 59, "59 seconds"},
+		{iec, 60, "1.0 minutes"},
+		{iec, 90, "1.5 minutes"},
+		{iec, 3600, "1.0 hours"},
+		{comma, 5400, "1,5 hours"},
+		{raw, 0, "0s"},
+		{raw, -5, "0s"},
+		{raw, 5400, "5400s"},
+	} {
+		if got := tc.f.Duration(tc.seconds); got != tc.want {
+			t.Errorf("units %d: Duration(%v) = %q, want %q", tc.f.Units, tc.seconds, got, tc.want)
+		}
+	}
+	Success("Durations pick seconds, minutes or hours, and never go negative")
+	EndSection()
+
+	StartSection("Locale Decimal Separator")
+	for _, tc := range []struct {
+		lcAll, lcNumeric, lang string
+		want                   string
+	}{
+		{"", "", "", "."},
+		{"", "", "en_US.UTF-8", "."},
+		{"", "", "de_DE.UTF-8", ","},
+		{"", "", "fr", ","},
+		{"", "", "pt-BR", ","},
+		{"", "", "sr@latin", ","},
+		{"", "", "C", "."},
+		{"", "en_GB.UTF-8", "de_DE.UTF-8", "."},
+		{"", "nl_NL", "en_US", ","},
+		{"C", "de_DE", "de_DE", "."},
+		{"it_IT", "en_US", "en_US", ","},
+	} {
+		t.Setenv("LC_ALL", tc.lcAll)
+		t.Setenv("LC_NUMERIC", tc.lcNumeric)
+		t.Setenv("LANG", tc.lang)
+		if got := progress.LocaleDecimalSeparator(); got != tc.want {
+			t.Errorf("LC_ALL=%q LC_NUMERIC=%q LANG=%q: separator %q, want %q", tc.lcAll, tc.lcNumeric, tc.lang, got, tc.want)
+		}
+	}
+	Success("LC_ALL, LC_NUMERIC and LANG pick the separator in order of precedence")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
+
+// recordingReporter is a progress.Reporter keeping what it receives
+type recordingReporter struct {
+	mu       sync.Mutex
+	starts   []progress.Event
+	ticks    []progress.Event
+	files    []string
+	finishes []progress.Event
+}
+
+func (r *recordingReporter) OnStart(ev progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts = append(r.starts, ev)
+}
+
+func (r *recordingReporter) OnBytes(ev progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ticks = append(r.ticks, ev)
+}
+
+func (r *recordingReporter) OnFileDone(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, path)
+}
+
+func (r *recordingReporter) OnFinish(ev progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finishes = append(r.finishes, ev)
+}
+
+// TestProgressReporter tests that a Reporter set on a tracker or in Options
+// receives the operation's progress in place of the console output
+func TestProgressReporter(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Progress Reporter")
+
+	StartSection("Driving a Tracker")
+	clock := progress.NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rec := &recordingReporter{}
+	tracker := progress.NewTracker(0)
+	tracker.SetClock(clock)
+	tracker.SetReporter(rec)
+	tracker.SetTotals(4000, 2)
+	tracker.SetPhase(progress.PhaseCompressing)
+	events := make(chan progress.Event, 1)
+	tracker.SetEvents(events) // Received once the tick's snapshot is taken
+	tracker.Start()
+	tracker.AddBytes(1000)
+	tracker.FinishEntry("a.txt")
+	clock.Advance(250 * time.Millisecond)
+	<-events
+	tracker.AddBytes(3000)
+	tracker.FinishEntry("b.txt")
+	tracker.Stop()
+	if len(rec.starts) != 1 || rec.starts[0].BytesTotal != 4000 || rec.starts[0].FilesTotal != 2 {
+		t.Fatalf("unexpected start calls: %+v", rec.starts)
+	}
+	if len(rec.ticks) != 1 || rec.ticks[0].BytesDone != 1000 || rec.ticks[0].Rate != 4000 {
+		t.Fatalf("unexpected tick calls: %+v", rec.ticks)
+	}
+	if strings.Join(rec.files, ",") != "a.txt,b.txt" {
+		t.Fatalf("unexpected finished files: %v", rec.files)
+	}
+	if len(rec.finishes) != 1 || rec.finishes[0].Phase != progress.PhaseDone || rec.finishes[0].BytesDone != 4000 || rec.finishes[0].FilesDone != 2 {
+		t.Fatalf("unexpected finish calls: %+v", rec.finishes)
+	}
+	Success("Start, each tick, each file and the finish are reported once")
+	EndSection()
+
+	StartSection("Compressing Without Console Output")
+	testDir, err := os.MkdirTemp("", "agcp-reporter-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d.txt", i)), []byte("reporter test data"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	// Anything the operation prints to standard output lands in the pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	printed := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		printed <- b
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	rec = &recordingReporter{}
+	err = core.Compress(context.Background(), srcDir, filepath.Join(testDir, "out.agcp"), core.Options{Progress: rec})
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if out := <-printed; len(out) > 0 {
+		t.Fatalf("Compression with a Reporter printed %q", out)
+	}
+	sort.Strings(rec.files)
+	if strings.Join(rec.files, ",") != "f0.txt,f1.txt,f2.txt" {
+		t.Fatalf("unexpected finished files: %v", rec.files)
+	}
+	if len(rec.starts) != 1 || len(rec.finishes) != 1 || rec.finishes[0].BytesDone != 3*18 {
+		t.Fatalf("unexpected start and finish calls: %+v, %+v", rec.starts, rec.finishes)
+	}
+	Success("The reporter got every file and the totals; nothing was printed")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
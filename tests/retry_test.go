@@ -0,0 +1,183 @@
+// tests/retry_test.go
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// failingReaderAt fails every read with err, like a handle gone stale
+type failingReaderAt struct {
+	err   error
+	reads atomic.Int32
+}
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.reads.Add(1)
+	return 0, f.err
+}
+
+// meetingReaderAt serves reads only once n of them are in flight at the same
+// time, and fails them if that does not happen within a second
+type meetingReaderAt struct {
+	n       int
+	mu      sync.Mutex
+	waiting int
+	met     chan struct{}
+}
+
+func (m *meetingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	if m.waiting++; m.waiting == m.n {
+		close(m.met)
+	}
+	m.mu.Unlock()
+	select {
+	case <-m.met:
+		return len(p), nil
+	case <-time.After(time.Second):
+		return 0, errors.New("reads did not run concurrently")
+	}
+}
+
+// TestRetryPolicy tests the retry backoff, and that reads of an archive retry
+// transient errors on a reopened handle up to the cap, pass permanent errors
+// through and run concurrently
+func TestRetryPolicy(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Retry Policy")
+
+	StartSection("Backoff")
+	for _, tc := range []struct {
+		policy  core.RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{core.RetryPolicy{}, 1, 100 * time.Millisecond},
+		{core.RetryPolicy{}, 3, 400 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: 10 * time.Millisecond}, 1, 10 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: 10 * time.Millisecond}, 4, 80 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}, 3, 40 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}, 4, 50 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}, 40, 50 * time.Millisecond},
+		{core.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 50 * time.Millisecond}, 1, 50 * time.Millisecond},
+	} {
+		if got := tc.policy.Backoff(tc.attempt); got != tc.want {
+			t.Errorf("Backoff(%d) with initial %v and max %v = %v, want %v", tc.attempt, tc.policy.InitialBackoff, tc.policy.MaxBackoff, got, tc.want)
+		}
+	}
+	Success("Delays double up to the maximum")
+	EndSection()
+
+	StartSection("Reopening a Stale Handle")
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("recovered data"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	stale := &failingReaderAt{err: errTransient}
+	log := &core.RetryLog{}
+	ra := core.NewRetryReaderAt(path, stale, core.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, Log: log})
+	buf := make([]byte, 4)
+	n, err := ra.ReadAt(buf, 10)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Fatalf("ReadAt = %q, %v; want the data read from a reopened handle", buf[:n], err)
+	}
+	if reads := stale.reads.Load(); reads != 1 {
+		t.Errorf("stale handle read %d times, want 1", reads)
+	}
+	if got := log.Retries()[path]; got != 1 {
+		t.Errorf("logged %d retries, want 1", got)
+	}
+	if summary := log.Summary(); !strings.Contains(summary, "1 retry, recovered") {
+		t.Errorf("summary does not report the recovery:\n%s", summary)
+	}
+	if err := ra.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	Success("Read recovered on a reopened handle")
+	EndSection()
+
+	StartSection("Giving Up")
+	// The file cannot be reopened, so every retry reads the failing handle again
+	gone := filepath.Join(t.TempDir(), "gone")
+	failing := &failingReaderAt{err: errTransient}
+	log = &core.RetryLog{}
+	ra = core.NewRetryReaderAt(gone, failing, core.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, Log: log})
+	if _, err := ra.ReadAt(make([]byte, 4), 0); !errors.Is(err, errTransient) {
+		t.Fatalf("ReadAt error = %v, want the transient error once retries are exhausted", err)
+	}
+	if reads := failing.reads.Load(); reads != 4 {
+		t.Errorf("read %d times, want the first read and 3 retries", reads)
+	}
+	if _, err := ra.ReadAt(make([]byte, 4), 0); err == nil || failing.reads.Load() != 5 {
+		t.Errorf("retries are capped per file, but a later read was retried (%d reads)", failing.reads.Load())
+	}
+	if got := log.Retries()[gone]; got != 3 {
+		t.Errorf("logged %d retries, want 3", got)
+	}
+	if summary := log.Summary(); !strings.Contains(summary, "3 retries, gave up") {
+		t.Errorf("summary does not report giving up:\n%s", summary)
+	}
+	Success("Retries stop at the cap per file")
+	EndSection()
+
+	StartSection("Permanent Errors")
+	for _, permanent := range []error{io.ErrUnexpectedEOF, os.ErrPermission, errors.New("corrupt")} {
+		failing := &failingReaderAt{err: permanent}
+		log := &core.RetryLog{}
+		ra := core.NewRetryReaderAt("archive", failing, core.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, Log: log})
+		if _, err := ra.ReadAt(make([]byte, 4), 0); err != permanent {
+			t.Errorf("ReadAt error = %v, want %v unchanged", err, permanent)
+		}
+		if failing.reads.Load() != 1 || log.Summary() != "" {
+			t.Errorf("%v was retried: %d reads, summary %q", permanent, failing.reads.Load(), log.Summary())
+		}
+	}
+	Success("Permanent errors are not retried")
+	EndSection()
+
+	StartSection("Retries Disabled")
+	failing = &failingReaderAt{err: errTransient}
+	log = &core.RetryLog{}
+	ra = core.NewRetryReaderAt("archive", failing, core.RetryPolicy{Log: log})
+	if _, err := ra.ReadAt(make([]byte, 4), 0); err == nil || failing.reads.Load() != 1 {
+		t.Errorf("zero policy retried: %d reads, error %v", failing.reads.Load(), err)
+	}
+	if log.Summary() != "" || len(log.Retries()) != 0 {
+		t.Errorf("zero policy logged retries: %q", log.Summary())
+	}
+	Success("The zero policy reads once")
+	EndSection()
+
+	StartSection("Concurrent Reads")
+	const readers = 4
+	meeting := &meetingReaderAt{n: readers, met: make(chan struct{})}
+	ra = core.NewRetryReaderAt("archive", meeting, core.RetryPolicy{MaxRetries: 3})
+	errCh := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			_, err := ra.ReadAt(make([]byte, 4), 0)
+			errCh <- err
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("ReadAt failed: %v", err)
+		}
+	}
+	Success(fmt.Sprintf("%d reads ran at the same time", readers))
+	EndSection()
+
+	ReportEnd(!t.Failed(), time.Since(startTime))
+}
@@ -0,0 +1,55 @@
+// tests/retry_unix_test.go
+
+//go:build !windows
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// errTransient is a transient read error as a stale NFS handle reports it
+var errTransient error = &os.PathError{Op: "read", Path: "archive", Err: syscall.ESTALE}
+
+// TestTransientErrors tests which errors count as transient network file
+// system failures
+func TestTransientErrors(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Transient Errors")
+
+	StartSection("Classifying Errors")
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EIO, true},
+		{syscall.ESTALE, true},
+		{syscall.EAGAIN, true},
+		{syscall.EINTR, true},
+		{syscall.ETIMEDOUT, true},
+		{&os.PathError{Op: "read", Path: "f", Err: syscall.EIO}, true},
+		{fmt.Errorf("decode: %w", errTransient), true},
+		{syscall.ENOENT, false},
+		{syscall.EACCES, false},
+		{&os.PathError{Op: "open", Path: "f", Err: syscall.ENOENT}, false},
+		{io.EOF, false},
+		{io.ErrUnexpectedEOF, false},
+		{errors.New("EIO"), false},
+	} {
+		if got := core.IsTransientError(tc.err); got != tc.want {
+			t.Errorf("IsTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+	Success("EIO, ESTALE, EAGAIN, EINTR and ETIMEDOUT are transient")
+	EndSection()
+
+	ReportEnd(!t.Failed(), time.Since(startTime))
+}
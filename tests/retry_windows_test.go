@@ -0,0 +1,57 @@
+// tests/retry_windows_test.go
+
+//go:build windows
+
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// Windows error codes reported by SMB shares on transient network failures
+const (
+	errorUnexpNetErr    syscall.Errno = 59  // ERROR_UNEXP_NET_ERR
+	errorNetnameDeleted syscall.Errno = 64  // ERROR_NETNAME_DELETED
+	errorSemTimeout     syscall.Errno = 121 // ERROR_SEM_TIMEOUT
+)
+
+// errTransient is a transient read error as an SMB share reports it
+var errTransient error = &os.PathError{Op: "read", Path: "archive", Err: errorNetnameDeleted}
+
+// TestTransientErrors tests which errors count as transient network file
+// system failures
+func TestTransientErrors(t *testing.T) {
+	startTime := time.Now()
+	ReportStart("Transient Errors")
+
+	StartSection("Classifying Errors")
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errorUnexpNetErr, true},
+		{errorNetnameDeleted, true},
+		{errorSemTimeout, true},
+		{fmt.Errorf("decode: %w", errTransient), true},
+		{syscall.ERROR_FILE_NOT_FOUND, false},
+		{syscall.ERROR_ACCESS_DENIED, false},
+		{io.EOF, false},
+		{errors.New("ERROR_NETNAME_DELETED"), false},
+	} {
+		if got := core.IsTransientError(tc.err); got != tc.want {
+			t.Errorf("IsTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+	Success("SMB network errors are transient")
+	EndSection()
+
+	ReportEnd(!t.Failed(), time.Since(startTime))
+}
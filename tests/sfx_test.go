@@ -0,0 +1,167 @@
+// tests/sfx_test.go
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+	"agcp/pkg/sfx"
+)
+
+// TestSelfExtracting tests building a self-extracting executable, finding and
+// extracting its payload, and rejecting executables whose footer or payload is
+// damaged or missing
+func TestSelfExtracting(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Self-Extracting Archives")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-sfx-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	data := make([]byte, 16<<10)
+	rand.Read(data)
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "random.bin"), data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "notes.txt"), []byte("self-extracting"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	archiveData, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	// Any file serves as the stub; only the bytes after it matter here
+	stub := filepath.Join(testDir, "stub")
+	stubData := []byte(strings.Repeat("#!stub executable\n", 4))
+	if err := os.WriteFile(stub, stubData, 0755); err != nil {
+		t.Fatalf("Failed to write stub: %v", err)
+	}
+	Success("Archive and stub created")
+	EndSection()
+
+	// ─── ROUND TRIP ─────────────────────────────────────────────────
+	StartSection("Round Trip")
+	exe := filepath.Join(testDir, "src.sfx")
+	if err := sfx.Build(stub, archive, exe); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	offset, size, ok, err := sfx.Payload(exe)
+	if err != nil || !ok || offset != int64(len(stubData)) || size != int64(len(archiveData)) {
+		t.Fatalf("Payload = %d, %d, %v, %v; want %d, %d, true", offset, size, ok, err, len(stubData), len(archiveData))
+	}
+	exeData, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("Failed to read executable: %v", err)
+	}
+	footer := exeData[len(exeData)-16:]
+	if string(footer[8:]) != sfx.PayloadMagic || binary.BigEndian.Uint64(footer[:8]) != uint64(len(archiveData)) {
+		t.Fatalf("Unexpected footer %q", footer)
+	}
+
+	extracted, err := sfx.ExtractPayload(exe, testDir)
+	if err != nil {
+		t.Fatalf("ExtractPayload failed: %v", err)
+	}
+	defer os.Remove(extracted)
+	if got, _ := os.ReadFile(extracted); !bytes.Equal(got, archiveData) {
+		t.Fatal("Extracted payload differs from the archive")
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), extracted, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Restored tree differs: %v", err)
+	}
+
+	// A self-extracting executable serves as a stub: its payload is dropped
+	again := filepath.Join(testDir, "again.sfx")
+	if err := sfx.Build(exe, archive, again); err != nil {
+		t.Fatalf("Build from a self-extracting stub failed: %v", err)
+	}
+	if againData, _ := os.ReadFile(again); !bytes.Equal(againData, exeData) {
+		t.Fatal("Rebuilding from a self-extracting stub kept its old payload")
+	}
+	if err := sfx.Build(exe, archive, exe); err == nil {
+		t.Fatal("Expected Build to refuse overwriting its stub")
+	}
+	Success("Payload found, extracted and restored; stubs with a payload are reused")
+	EndSection()
+
+	// ─── DAMAGED EXECUTABLES ────────────────────────────────────────
+	StartSection("Damaged Executables")
+	for _, tc := range []struct {
+		name    string
+		data    func() []byte
+		corrupt bool // Payload reports an error rather than no payload
+	}{
+		{"missing payload", func() []byte { return stubData }, false},
+		{"empty file", func() []byte { return nil }, false},
+		{"truncated footer", func() []byte { return exeData[:len(exeData)-3] }, false},
+		{"corrupt magic", func() []byte {
+			b := bytes.Clone(exeData)
+			b[len(b)-1] ^= 0xff
+			return b
+		}, false},
+		{"zero archive length", func() []byte {
+			b := bytes.Clone(exeData)
+			binary.BigEndian.PutUint64(b[len(b)-16:], 0)
+			return b
+		}, true},
+		{"archive length beyond the file", func() []byte {
+			b := bytes.Clone(exeData)
+			binary.BigEndian.PutUint64(b[len(b)-16:], uint64(len(b)))
+			return b
+		}, true},
+		{"truncated payload", func() []byte {
+			// Drop the end of the archive but keep the footer
+			cut := len(stubData) + len(archiveData) - 1024
+			return append(bytes.Clone(exeData[:len(stubData)]), exeData[cut:]...)
+		}, true},
+	} {
+		path := filepath.Join(testDir, strings.ReplaceAll(tc.name, " ", "-"))
+		if err := os.WriteFile(path, tc.data(), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", tc.name, err)
+		}
+		_, _, ok, err := sfx.Payload(path)
+		if ok || (err != nil) != tc.corrupt {
+			t.Errorf("%s: Payload = %v, %v; want no payload and error %v", tc.name, ok, err, tc.corrupt)
+		}
+		if tc.corrupt && !strings.Contains(err.Error(), "corrupt payload footer") {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if extracted, err := sfx.ExtractPayload(path, testDir); err == nil {
+			os.Remove(extracted)
+			t.Errorf("%s: expected ExtractPayload to fail", tc.name)
+		}
+	}
+	Success("Damaged and plain executables are not taken for self-extracting ones")
+	EndSection()
+
+	ReportEnd(true, time.Since(startTime))
+}
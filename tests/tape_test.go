@@ -0,0 +1,198 @@
+// tests/tape_test.go
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// TestTapeVolumes tests writing an archive across several fixed-size volumes and
+// reading it back, with volumes swapped in through the NextVolume callback
+func TestTapeVolumes(t *testing.T) {
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Multi-Volume Tape Archives")
+
+	StartSection("Preparing Test Environment")
+	testDir, err := os.MkdirTemp("", "agcp-tape-test")
+	if err != nil {
+		Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	data := make([]byte, 200*1024) // Random data so the archive spans several volumes
+	rand.Read(data)
+	if err := os.WriteFile(filepath.Join(srcDir, "random.bin"), data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	archive := filepath.Join(testDir, "src.agcp")
+	if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	Success("Test archive created successfully")
+	EndSection()
+
+	// ─── WRITE ──────────────────────────────────────────────────────
+	StartSection("Writing Volumes")
+	device := filepath.Join(testDir, "device")
+	volumePath := func(n int) string { return filepath.Join(testDir, fmt.Sprintf("volume-%d", n)) }
+	opts := core.TapeOptions{
+		BlockingFactor: 8,
+		VolumeSize:     64 * 1024,
+		NextVolume: func(n int) error {
+			// Eject the full volume so the next one starts empty
+			return os.Rename(device, volumePath(n-1))
+		},
+	}
+	if err := core.WriteTape(archive, device, opts); err != nil {
+		Error(fmt.Sprintf("Writing volumes failed: %v", err))
+		t.Fatalf("WriteTape failed: %v", err)
+	}
+	volumes := 1
+	for ; ; volumes++ {
+		if _, err := os.Stat(volumePath(volumes)); err != nil {
+			break
+		}
+	}
+	if err := os.Rename(device, volumePath(volumes)); err != nil {
+		t.Fatalf("Failed to eject last volume: %v", err)
+	}
+	Info(fmt.Sprintf("Archive written to %d volumes", volumes))
+	if volumes < 3 {
+		t.Fatalf("Expected the archive to span at least 3 volumes, got %d", volumes)
+	}
+	for n := 1; n <= volumes; n++ {
+		info, err := os.Stat(volumePath(n))
+		if err != nil {
+			t.Fatalf("Failed to stat volume %d: %v", n, err)
+		}
+		if info.Size() > opts.VolumeSize || info.Size()%(8*512) != 0 {
+			t.Fatalf("Volume %d has size %d, want whole blocks within %d bytes", n, info.Size(), opts.VolumeSize)
+		}
+	}
+	Success("Volumes consist of whole blocks within the volume size")
+	EndSection()
+
+	// ─── READ ───────────────────────────────────────────────────────
+	StartSection("Reading Volumes")
+	load := func(order []int) core.TapeOptions {
+		return core.TapeOptions{
+			BlockingFactor: 8,
+			NextVolume: func(n int) error {
+				content, err := os.ReadFile(volumePath(order[n-1]))
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(device, content, 0644)
+			},
+		}
+	}
+	inOrder := make([]int, volumes)
+	for i := range inOrder {
+		inOrder[i] = i + 1
+	}
+	readOpts := load(inOrder)
+	readOpts.NextVolume(1)
+	restored := filepath.Join(testDir, "restored.agcp")
+	if err := core.ReadTape(device, restored, readOpts); err != nil {
+		Error(fmt.Sprintf("Reading volumes failed: %v", err))
+		t.Fatalf("ReadTape failed: %v", err)
+	}
+	want, _ := os.ReadFile(archive)
+	got, _ := os.ReadFile(restored)
+	if !bytes.Equal(want, got) {
+		t.Fatalf("Restored archive differs from original (%d vs %d bytes)", len(got), len(want))
+	}
+	outDir := filepath.Join(testDir, "out")
+	if err := core.Decompress(context.Background(), restored, outDir, core.Options{}); err != nil {
+		t.Fatalf("Decompressing restored archive failed: %v", err)
+	}
+	if err := compareTrees(srcDir, outDir); err != nil {
+		t.Fatalf("Extracted tree differs: %v", err)
+	}
+	Success("Archive restored from volumes byte for byte")
+
+	Action("Reading with other blocking factors")
+	for _, factor := range []int{1, 64, 4096} {
+		readOpts = load(inOrder)
+		readOpts.BlockingFactor = factor
+		readOpts.NextVolume(1)
+		other := filepath.Join(testDir, fmt.Sprintf("blocking-%d.agcp", factor))
+		if err := core.ReadTape(device, other, readOpts); err != nil {
+			t.Fatalf("ReadTape with blocking factor %d failed: %v", factor, err)
+		}
+		if got, _ := os.ReadFile(other); !bytes.Equal(want, got) {
+			t.Fatalf("Archive read with blocking factor %d differs from original", factor)
+		}
+	}
+	Success("The block size recorded in the volume header wins")
+
+	Action("Loading volumes out of order")
+	swapped := append([]int{}, inOrder...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	readOpts = load(swapped)
+	readOpts.NextVolume(1)
+	err = core.ReadTape(device, filepath.Join(testDir, "swapped.agcp"), readOpts)
+	if err == nil {
+		t.Fatal("Expected an error when volumes are loaded out of order")
+	}
+	Success(fmt.Sprintf("Out-of-order volume rejected: %v", err))
+	EndSection()
+
+	// ─── DIGESTS ────────────────────────────────────────────────────
+	StartSection("Checking Volume Digests")
+	readOpts = load(inOrder)
+	readOpts.NextVolume(1)
+	report, err := core.VerifyTape(device, readOpts)
+	if err != nil {
+		t.Fatalf("VerifyTape failed: %v", err)
+	}
+	if !report.Digests || report.Volumes != volumes || len(report.Mismatched) != 0 {
+		t.Fatalf("Report for intact volumes: %+v, want %d matching volumes", report, volumes)
+	}
+	Success(fmt.Sprintf("All %d volumes match their recorded digests", volumes))
+
+	Action("Corrupting a middle volume")
+	middle, err := os.ReadFile(volumePath(2))
+	if err != nil {
+		t.Fatalf("Failed to read volume: %v", err)
+	}
+	middle[8*512+100] ^= 0xff // Past the header block
+	if err := os.WriteFile(volumePath(2), middle, 0644); err != nil {
+		t.Fatalf("Failed to write volume: %v", err)
+	}
+	readOpts = load(inOrder)
+	readOpts.NextVolume(1)
+	if report, err = core.VerifyTape(device, readOpts); err != nil {
+		t.Fatalf("VerifyTape failed: %v", err)
+	}
+	if len(report.Mismatched) != 1 || report.Mismatched[0] != 2 {
+		t.Fatalf("Mismatched volumes: %v, want [2]", report.Mismatched)
+	}
+	readOpts = load(inOrder)
+	readOpts.NextVolume(1)
+	err = core.ReadTape(device, filepath.Join(testDir, "corrupt.agcp"), readOpts)
+	if !errors.Is(err, core.ErrVolumeDigest) {
+		t.Fatalf("ReadTape of a corrupt volume: %v, want ErrVolumeDigest", err)
+	}
+	Success(fmt.Sprintf("Corrupt volume named: %v", err))
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(true, time.Since(startTime))
+}
@@ -0,0 +1,302 @@
+// tests/tar_compare_test.go
+
+//go:build !windows
+
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"agcp/pkg/core"
+)
+
+// knownGaps lists the kinds of difference from tar that agcp does not handle
+// yet. They are reported but don't fail the test; remove a kind once agcp
+// preserves it so regressions are caught.
+var knownGaps = map[string]bool{
+	"symlinks":          true,
+	"empty directories": true,
+}
+
+// treeNode is the restored state of one path, as compared between agcp and tar
+type treeNode struct {
+	kind   string // "file", "dir" or "symlink"
+	perm   fs.FileMode
+	digest [sha256.Size]byte // Content of files, or of the target of symlinks
+	target string            // Link target of symlinks
+}
+
+// TestTarComparison round-trips randomized trees through agcp and through tar
+// and diffs the restored trees. Set AGCP_TEST_SEED to reproduce a failing tree.
+func TestTarComparison(t *testing.T) {
+	tarPath, err := exec.LookPath("tar")
+	if err != nil {
+		t.Skip("tar not found in PATH")
+	}
+
+	// ─── SETUP ──────────────────────────────────────────────────────
+	startTime := time.Now()
+	ReportStart("Comparison Against tar")
+
+	seed := time.Now().UnixNano()
+	if s := os.Getenv("AGCP_TEST_SEED"); s != "" {
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			t.Fatalf("Invalid AGCP_TEST_SEED: %v", err)
+		}
+	}
+	Info(fmt.Sprintf("Seed: %d (set AGCP_TEST_SEED to reproduce)", seed))
+	rng := rand.New(rand.NewSource(seed))
+
+	rounds := 3
+	if testing.Short() {
+		rounds = 1
+	}
+	gapsSeen := make(map[string]int)
+	for round := 0; round < rounds; round++ {
+		StartSection(fmt.Sprintf("Round %d", round+1))
+		testDir, err := os.MkdirTemp("", "agcp-tar-test")
+		if err != nil {
+			Error(fmt.Sprintf("Failed to create temp directory: %v", err))
+			t.Fatalf("Failed to create temp directory: %v", err)
+		}
+		defer os.RemoveAll(testDir)
+
+		srcDir := filepath.Join(testDir, "src")
+		n := generateTree(t, rng, srcDir)
+		Info(fmt.Sprintf("Generated %d paths", n))
+
+		// Round-trip through agcp
+		archive := filepath.Join(testDir, "tree.agcp")
+		agcpOut := filepath.Join(testDir, "agcp-out")
+		if err := core.Compress(context.Background(), srcDir, archive, core.Options{}); err != nil {
+			Error(fmt.Sprintf("agcp compression failed: %v", err))
+			t.Fatalf("Compression failed: %v", err)
+		}
+		if err := core.Decompress(context.Background(), archive, agcpOut, core.Options{}); err != nil {
+			Error(fmt.Sprintf("agcp decompression failed: %v", err))
+			t.Fatalf("Decompression failed: %v", err)
+		}
+
+		// Round-trip through tar
+		tarball := filepath.Join(testDir, "tree.tar")
+		tarOut := filepath.Join(testDir, "tar-out")
+		if err := os.MkdirAll(tarOut, 0755); err != nil {
+			t.Fatalf("Failed to create tar output: %v", err)
+		}
+		for _, args := range [][]string{
+			{"-cf", tarball, "-C", srcDir, "."},
+			{"-xpf", tarball, "-C", tarOut},
+		} {
+			if out, err := exec.Command(tarPath, args...).CombinedOutput(); err != nil {
+				t.Fatalf("tar %v failed: %v\n%s", args, err, out)
+			}
+		}
+
+		// Compare
+		want := snapshotTree(t, tarOut)
+		got := snapshotTree(t, agcpOut)
+		for _, diff := range diffTrees(want, got) {
+			if knownGaps[diff.kind] {
+				gapsSeen[diff.kind]++
+				continue
+			}
+			Error(diff.String())
+			t.Errorf("%s", diff)
+		}
+		if !t.Failed() {
+			Success("Restored trees match apart from known gaps")
+		}
+		EndSection()
+	}
+
+	StartSection("Known Gaps")
+	kinds := make([]string, 0, len(knownGaps))
+	for kind := range knownGaps {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if gapsSeen[kind] == 0 {
+			Info(fmt.Sprintf("%s: no differences seen; consider removing it from knownGaps", kind))
+		} else {
+			Info(fmt.Sprintf("%s: %d differences (known gap)", kind, gapsSeen[kind]))
+		}
+	}
+	EndSection()
+
+	// ─── CONCLUSION ─────────────────────────────────────────────────
+	ReportEnd(!t.Failed(), time.Since(startTime))
+}
+
+// generateTree creates a random tree under root with nested and empty
+// directories, unicode names, empty, small, large and sparse files, varied
+// permissions and symlinks to files. It returns the number of paths created.
+func generateTree(t *testing.T, rng *rand.Rand, root string) int {
+	t.Helper()
+	names := []string{"data", "файл", "文件", "ñandú", "emoji-😀", "with space", "UPPER", "a.b.c"}
+	perms := []fs.FileMode{0644, 0600, 0755, 0444, 0640}
+
+	dirs := []string{root}
+	var files []string
+	count := 0
+	mustDo := func(err error) {
+		if err != nil {
+			t.Fatalf("Failed to generate tree: %v", err)
+		}
+	}
+	mustDo(os.MkdirAll(root, 0755))
+
+	for i := 0; i < 10+rng.Intn(20); i++ {
+		parent := dirs[rng.Intn(len(dirs))]
+		name := fmt.Sprintf("%s-%d", names[rng.Intn(len(names))], i)
+		path := filepath.Join(parent, name)
+		count++
+
+		switch r := rng.Intn(10); {
+		case r < 2: // Directory, possibly left empty
+			mustDo(os.Mkdir(path, 0755))
+			dirs = append(dirs, path)
+		case r < 3: // Sparse file: a hole followed by a little data
+			f, err := os.Create(path)
+			mustDo(err)
+			_, err = f.WriteAt([]byte("end of sparse file"), int64(1<<20+rng.Intn(1<<20)))
+			mustDo(err)
+			mustDo(f.Close())
+			files = append(files, path)
+		case r < 4 && len(files) > 0: // Symlink to an existing file, relative like most real links
+			target, err := filepath.Rel(parent, files[rng.Intn(len(files))])
+			mustDo(err)
+			mustDo(os.Symlink(target, path))
+		default: // Regular file: empty, small or large, random or repetitive
+			var data []byte
+			switch rng.Intn(4) {
+			case 0:
+			case 1:
+				data = []byte(strings.Repeat(name+"\n", 1+rng.Intn(100)))
+			case 2:
+				data = make([]byte, rng.Intn(256<<10))
+				rng.Read(data)
+			case 3:
+				data = []byte(strings.Repeat("repetitive ", rng.Intn(1<<16)))
+			}
+			mustDo(os.WriteFile(path, data, 0644))
+			mustDo(os.Chmod(path, perms[rng.Intn(len(perms))]))
+			files = append(files, path)
+		}
+	}
+	return count
+}
+
+// snapshotTree records every path under root
+func snapshotTree(t *testing.T, root string) map[string]treeNode {
+	t.Helper()
+	nodes := make(map[string]treeNode)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		node := treeNode{perm: info.Mode().Perm()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			node.kind = "symlink"
+			if node.target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.IsDir():
+			node.kind = "dir"
+			return addNode(nodes, rel, node)
+		default:
+			node.kind = "file"
+		}
+		data, err := os.ReadFile(path) // Follows symlinks, so links compare by content too
+		if err != nil {
+			return err
+		}
+		node.digest = sha256.Sum256(data)
+		return addNode(nodes, rel, node)
+	})
+	if err != nil {
+		t.Fatalf("Failed to snapshot %s: %v", root, err)
+	}
+	return nodes
+}
+
+// addNode stores a node; a helper so WalkDir callbacks can return its result
+func addNode(nodes map[string]treeNode, rel string, node treeNode) error {
+	nodes[filepath.ToSlash(rel)] = node
+	return nil
+}
+
+// treeDiff is one difference between the tar and agcp restores
+type treeDiff struct {
+	kind   string // Category, matched against knownGaps
+	path   string
+	detail string
+}
+
+// String implements fmt.Stringer
+func (d treeDiff) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.kind, d.path, d.detail)
+}
+
+// diffTrees compares the agcp restore (got) with the tar restore (want)
+func diffTrees(want, got map[string]treeNode) []treeDiff {
+	var diffs []treeDiff
+	paths := make([]string, 0, len(want))
+	for path := range want {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		w := want[path]
+		g, ok := got[path]
+		switch {
+		case !ok && w.kind == "dir":
+			diffs = append(diffs, treeDiff{"empty directories", path, "directory missing"})
+		case !ok:
+			diffs = append(diffs, treeDiff{"missing", path, fmt.Sprintf("%s missing", w.kind)})
+		case w.kind == "symlink" && g.kind == "file":
+			if w.digest != g.digest {
+				diffs = append(diffs, treeDiff{"content", path, "symlink restored as a file with different content"})
+			} else {
+				diffs = append(diffs, treeDiff{"symlinks", path, "symlink restored as a regular file"})
+			}
+		case w.kind != g.kind:
+			diffs = append(diffs, treeDiff{"type", path, fmt.Sprintf("%s restored as %s", w.kind, g.kind)})
+		case w.digest != g.digest:
+			diffs = append(diffs, treeDiff{"content", path, "content differs"})
+		case w.kind == "symlink" && w.target != g.target:
+			diffs = append(diffs, treeDiff{"symlinks", path, fmt.Sprintf("target %q, want %q", g.target, w.target)})
+		case w.perm != g.perm && w.kind != "symlink":
+			diffs = append(diffs, treeDiff{"permissions", path, fmt.Sprintf("mode %v, want %v", g.perm, w.perm)})
+		}
+	}
+	for path, g := range got {
+		if _, ok := want[path]; !ok {
+			diffs = append(diffs, treeDiff{"extra", path, fmt.Sprintf("unexpected %s", g.kind)})
+		}
+	}
+	return diffs
+}